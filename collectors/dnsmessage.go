@@ -8,7 +8,6 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
@@ -31,37 +30,27 @@ type MatchSource struct {
 }
 
 type DNSMessage struct {
-	doneRun     chan bool
-	doneMonitor chan bool
-	stopRun     chan bool
-	stopMonitor chan bool
-	config      *pkgconfig.Config
-	configChan  chan *pkgconfig.Config
-	inputChan   chan dnsutils.DNSMessage
-	logger      *logger.Logger
-	name        string
-	//	RoutingHandler pkgutils.RoutingHandler
-	droppedRoutes []pkgutils.Worker
-	defaultRoutes []pkgutils.Worker
-	dropped       chan string
-	droppedCount  map[string]int
+	doneRun        chan bool
+	stopRun        chan bool
+	config         *pkgconfig.Config
+	configChan     chan *pkgconfig.Config
+	inputChan      chan dnsutils.DNSMessage
+	logger         *logger.Logger
+	name           string
+	RoutingHandler pkgutils.RoutingHandler
 }
 
 func NewDNSMessage(loggers []pkgutils.Worker, config *pkgconfig.Config, logger *logger.Logger, name string) *DNSMessage {
 	logger.Info(pkgutils.PrefixLogCollector+"[%s] dnsmessage - enabled", name)
 	s := &DNSMessage{
-		doneRun:     make(chan bool),
-		doneMonitor: make(chan bool),
-		stopRun:     make(chan bool),
-		stopMonitor: make(chan bool),
-		config:      config,
-		configChan:  make(chan *pkgconfig.Config),
-		inputChan:   make(chan dnsutils.DNSMessage, config.Collectors.DNSMessage.ChannelBufferSize),
-		logger:      logger,
-		name:        name,
-		//  RoutingHandler: pkgutils.NewRoutingHandler(config, logger, name),
-		dropped:      make(chan string),
-		droppedCount: map[string]int{},
+		doneRun:        make(chan bool),
+		stopRun:        make(chan bool),
+		config:         config,
+		configChan:     make(chan *pkgconfig.Config),
+		inputChan:      make(chan dnsutils.DNSMessage, config.Collectors.DNSMessage.ChannelBufferSize),
+		logger:         logger,
+		name:           name,
+		RoutingHandler: pkgutils.NewRoutingHandler(config, logger, name),
 	}
 	s.ReadConfig()
 	return s
@@ -70,13 +59,11 @@ func NewDNSMessage(loggers []pkgutils.Worker, config *pkgconfig.Config, logger *
 func (c *DNSMessage) GetName() string { return c.name }
 
 func (c *DNSMessage) AddDroppedRoute(wrk pkgutils.Worker) {
-	//  c.RoutingHandler.AddDroppedRoute(wrk)
-	c.droppedRoutes = append(c.droppedRoutes, wrk)
+	c.RoutingHandler.AddDroppedRoute(wrk)
 }
 
 func (c *DNSMessage) AddDefaultRoute(wrk pkgutils.Worker) {
-	//  c.RoutingHandler.AddDefaultRoute(wrk)
-	c.defaultRoutes = append(c.defaultRoutes, wrk)
+	c.RoutingHandler.AddDefaultRoute(wrk)
 }
 
 // deprecated function
@@ -227,15 +214,12 @@ func (c *DNSMessage) LogError(msg string, v ...interface{}) {
 
 func (c *DNSMessage) Stop() {
 	c.LogInfo("stopping collector...")
+	c.RoutingHandler.Stop()
 
 	// read done channel and block until run is terminated
 	c.LogInfo("stopping to run...")
 	c.stopRun <- true
 	<-c.doneRun
-
-	c.LogInfo("stopping monitor...")
-	c.stopMonitor <- true
-	<-c.doneMonitor
 }
 
 func (c *DNSMessage) Run() {
@@ -243,17 +227,12 @@ func (c *DNSMessage) Run() {
 	var err error
 
 	// prepare next channels
-	// defaultRoutes, defaultNames := c.RoutingHandler.GetDefaultRoutes()
-	// droppedRoutes, droppedNames := c.RoutingHandler.GetDroppedRoutes()
-	defaultRoutes, defaultNames := pkgutils.GetRoutes(c.defaultRoutes)
-	droppedRoutes, droppedNames := pkgutils.GetRoutes(c.droppedRoutes)
+	defaultRoutes, defaultNames := c.RoutingHandler.GetDefaultRoutes()
+	droppedRoutes, droppedNames := c.RoutingHandler.GetDroppedRoutes()
 
 	// prepare transforms
 	subprocessors := transformers.NewTransforms(&c.config.IngoingTransformers, c.logger, c.name, defaultRoutes, 0)
 
-	// start goroutine to count dropped messsages
-	go c.MonitorNextStanzas()
-
 	// read incoming dns message
 	c.LogInfo("waiting dns message to process...")
 RUN_LOOP:
@@ -308,78 +287,27 @@ RUN_LOOP:
 			// init dns message with additionnals parts if necessary
 			if matched {
 				subprocessors.InitDNSMessageFormat(&dm)
-				if subprocessors.ProcessMessage(&dm) == transformers.ReturnDrop {
-					// c.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
-					for i := range droppedRoutes {
-						select {
-						case droppedRoutes[i] <- dm:
-						default:
-							c.dropped <- droppedNames[i]
-						}
-					}
+				switch subprocessors.ProcessMessage(&dm) {
+				case transformers.ReturnHold:
+					// the message is kept by a transformer and emitted later
+					continue
+				case transformers.ReturnDrop:
+					c.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 					continue
 				}
 			}
 
 			// drop packet ?
 			if !matched {
-				//  c.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
-				for i := range droppedRoutes {
-					select {
-					case droppedRoutes[i] <- dm:
-					default:
-						c.dropped <- droppedNames[i]
-					}
-				}
+				c.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
 
 			// send to next
-			//  c.RoutingHandler.SendTo(defaultRoutes, defaultNames, dm)
-			for i := range defaultRoutes {
-				select {
-				case defaultRoutes[i] <- dm:
-				default:
-					c.dropped <- defaultNames[i]
-				}
-			}
+			c.RoutingHandler.SendTo(defaultRoutes, defaultNames, dm)
 
 		}
 
 	}
 	c.LogInfo("run terminated")
 }
-
-func (c *DNSMessage) MonitorNextStanzas() {
-	watchInterval := 10 * time.Second
-	bufferFull := time.NewTimer(watchInterval)
-FOLLOW_LOOP:
-	for {
-		select {
-		case <-c.stopMonitor:
-			close(c.dropped)
-			bufferFull.Stop()
-			c.doneMonitor <- true
-			break FOLLOW_LOOP
-
-		case loggerName := <-c.dropped:
-			if _, ok := c.droppedCount[loggerName]; !ok {
-				c.droppedCount[loggerName] = 1
-			} else {
-				c.droppedCount[loggerName]++
-			}
-
-		case <-bufferFull.C:
-
-			for v, k := range c.droppedCount {
-				if k > 0 {
-					c.LogError("stanza[%s] buffer is full, %d dnsmessage(s) dropped", v, k)
-					c.droppedCount[v] = 0
-				}
-			}
-			bufferFull.Reset(watchInterval)
-
-		}
-	}
-	c.LogInfo("monitor terminated")
-}
@@ -121,6 +121,9 @@ func (c *Dnstap) HandleConn(conn net.Conn) {
 	// close connection on function exit
 	defer conn.Close()
 
+	pkgutils.Telemetry.ConnectionOpened(c.name)
+	defer pkgutils.Telemetry.ConnectionClosed(c.name)
+
 	var connID int
 	c.Lock()
 	c.connID++
@@ -201,7 +204,15 @@ func (c *Dnstap) HandleConn(conn net.Conn) {
 			break
 		}
 
-		// send payload to the channel
+		// send payload to the channel, or wait when the next stanzas ask for
+		// backpressure to stop reading from the socket
+		if dnstapProcessor.RoutingHandler.IsBlocking() || pkgutils.IsBlocking(c.defaultRoutes) {
+			select {
+			case dnstapProcessor.GetChannel() <- frame.Data():
+			case <-dnstapProcessor.RoutingHandler.DeliveryCancelled():
+			}
+			continue
+		}
 		select {
 		case dnstapProcessor.GetChannel() <- frame.Data(): // Successful send to channel
 		default:
@@ -111,6 +111,9 @@ func (c *DnstapProxifier) HandleConn(conn net.Conn) {
 	// close connection on function exit
 	defer conn.Close()
 
+	pkgutils.Telemetry.ConnectionOpened(c.name)
+	defer pkgutils.Telemetry.ConnectionClosed(c.name)
+
 	// get peer address
 	peer := conn.RemoteAddr().String()
 	c.LogInfo("new connection from %s\n", peer)
@@ -0,0 +1,495 @@
+package collectors
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-dnscollector/processors"
+	"github.com/dmachard/go-logger"
+)
+
+const (
+	dohContentType = "application/dns-message"
+	dnsMinLength   = 12
+)
+
+type DoHProxy struct {
+	doneRun       chan bool
+	stopRun       chan bool
+	listenDoH     net.Listener
+	listenDoT     net.Listener
+	httpServer    *http.Server
+	conns         []net.Conn
+	defaultRoutes []pkgutils.Worker
+	droppedRoutes []pkgutils.Worker
+	config        *pkgconfig.Config
+	configChan    chan *pkgconfig.Config
+	logger        *logger.Logger
+	name          string
+	identity      string
+	dnsProcessor  processors.DNSProcessor
+	stopCalled    bool
+	sync.RWMutex
+}
+
+func NewDoHProxy(loggers []pkgutils.Worker, config *pkgconfig.Config, logger *logger.Logger, name string) *DoHProxy {
+	logger.Info(pkgutils.PrefixLogCollector+"[%s] doh-proxy - enabled", name)
+	s := &DoHProxy{
+		doneRun:       make(chan bool),
+		stopRun:       make(chan bool),
+		config:        config,
+		configChan:    make(chan *pkgconfig.Config),
+		defaultRoutes: loggers,
+		logger:        logger,
+		name:          name,
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *DoHProxy) GetName() string { return c.name }
+
+func (c *DoHProxy) AddDroppedRoute(wrk pkgutils.Worker) {
+	c.droppedRoutes = append(c.droppedRoutes, wrk)
+}
+
+func (c *DoHProxy) AddDefaultRoute(wrk pkgutils.Worker) {
+	c.defaultRoutes = append(c.defaultRoutes, wrk)
+}
+
+func (c *DoHProxy) SetLoggers(loggers []pkgutils.Worker) {
+	c.defaultRoutes = loggers
+}
+
+func (c *DoHProxy) Loggers() ([]chan dnsutils.DNSMessage, []string) {
+	return pkgutils.GetRoutes(c.defaultRoutes)
+}
+
+func (c *DoHProxy) ReadConfig() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.identity = c.config.GetServerIdentity()
+}
+
+func (c *DoHProxy) ReloadConfig(config *pkgconfig.Config) {
+	c.LogInfo("reload configuration...")
+	c.configChan <- config
+}
+
+func (c *DoHProxy) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info(pkgutils.PrefixLogCollector+"["+c.name+"] doh-proxy - "+msg, v...)
+}
+
+func (c *DoHProxy) LogError(msg string, v ...interface{}) {
+	c.logger.Error(pkgutils.PrefixLogCollector+"["+c.name+"] doh-proxy - "+msg, v...)
+}
+
+func (c *DoHProxy) GetInputChannel() chan dnsutils.DNSMessage {
+	return nil
+}
+
+func (c *DoHProxy) Stop() {
+	c.Lock()
+	c.stopCalled = true
+	c.LogInfo("stopping collector...")
+
+	// stop the http server and close the dot listener to unblock accept
+	c.LogInfo("stop listening...")
+	if c.httpServer != nil {
+		c.httpServer.Close()
+	} else if c.listenDoH != nil {
+		c.listenDoH.Close()
+	}
+	if c.listenDoT != nil {
+		c.listenDoT.Close()
+	}
+
+	// closing properly current connections if exists
+	c.LogInfo("closing connected peers...")
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+	c.Unlock()
+
+	// read done channel and block until run is terminated
+	c.LogInfo("stopping run...")
+	c.stopRun <- true
+	<-c.doneRun
+}
+
+func (c *DoHProxy) loadTLSConfig() (*tls.Config, error) {
+	cer, err := tls.LoadX509KeyPair(c.config.Collectors.DoHProxy.CertFile, c.config.Collectors.DoHProxy.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cer},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	// update tls min version according to the user config
+	tlsConfig.MinVersion = pkgconfig.TLSVersion[c.config.Collectors.DoHProxy.TLSMinVersion]
+	return tlsConfig, nil
+}
+
+func (c *DoHProxy) Listen() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.LogInfo("running in background...")
+
+	var tlsConfig *tls.Config
+	if c.config.Collectors.DoHProxy.TLSSupport || c.config.Collectors.DoHProxy.DoTListenPort > 0 {
+		var err error
+		tlsConfig, err = c.loadTLSConfig()
+		if err != nil {
+			return err
+		}
+	}
+
+	// DNS over HTTPS, the tls can be disabled when the proxy is behind a tls terminator
+	if c.config.Collectors.DoHProxy.DoHListenPort > 0 {
+		addrlisten := c.config.Collectors.DoHProxy.ListenIP + ":" + strconv.Itoa(c.config.Collectors.DoHProxy.DoHListenPort)
+
+		var err error
+		var listener net.Listener
+		if c.config.Collectors.DoHProxy.TLSSupport {
+			c.LogInfo("tls support enabled for doh")
+			listener, err = tls.Listen(netlib.SocketTCP, addrlisten, tlsConfig)
+		} else {
+			listener, err = net.Listen(netlib.SocketTCP, addrlisten)
+		}
+		if err != nil {
+			return err
+		}
+		c.LogInfo("doh is listening on %s", listener.Addr())
+		c.listenDoH = listener
+	}
+
+	// DNS over TLS
+	if c.config.Collectors.DoHProxy.DoTListenPort > 0 {
+		addrlisten := c.config.Collectors.DoHProxy.ListenIP + ":" + strconv.Itoa(c.config.Collectors.DoHProxy.DoTListenPort)
+		listener, err := tls.Listen(netlib.SocketTCP, addrlisten, tlsConfig)
+		if err != nil {
+			if c.listenDoH != nil {
+				c.listenDoH.Close()
+			}
+			return err
+		}
+		c.LogInfo("dot is listening on %s", listener.Addr())
+		c.listenDoT = listener
+	}
+
+	if c.listenDoH == nil && c.listenDoT == nil {
+		return errors.New("no listener enabled, doh and dot ports are both disabled")
+	}
+	return nil
+}
+
+// ForwardQuery sends the raw dns query to the upstream resolver and returns the raw reply
+func (c *DoHProxy) ForwardQuery(query []byte) ([]byte, error) {
+	c.RLock()
+	cfg := c.config.Collectors.DoHProxy
+	c.RUnlock()
+
+	address := net.JoinHostPort(cfg.UpstreamAddress, strconv.Itoa(cfg.UpstreamPort))
+	timeout := time.Duration(cfg.UpstreamTimeout) * time.Second
+
+	conn, err := net.DialTimeout(cfg.UpstreamTransport, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	// udp: one datagram for the query, one for the reply
+	if cfg.UpstreamTransport == netlib.SocketUDP {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	// tcp: messages are prefixed with a two bytes length field
+	return exchangeStream(conn, query)
+}
+
+func exchangeStream(conn net.Conn, query []byte) ([]byte, error) {
+	if err := writeStreamMsg(conn, query); err != nil {
+		return nil, err
+	}
+	return readStreamMsg(conn)
+}
+
+func writeStreamMsg(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readStreamMsg(r io.Reader) ([]byte, error) {
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(lenBuf))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// emit sends the query and the reply to the dns processor,
+// ip addresses and ports are provided from the packet perspective.
+func (c *DoHProxy) emit(query, reply []byte, clientAddr, localAddr, protocol string, tsQuery, tsReply time.Time) {
+	c.RLock()
+	defer c.RUnlock()
+
+	// the collector is stopping, the processor is not available anymore
+	if c.stopCalled {
+		return
+	}
+
+	c.dnsProcessor.GetChannel() <- c.newDNSMessage(query, clientAddr, localAddr, protocol, tsQuery)
+	if reply != nil {
+		c.dnsProcessor.GetChannel() <- c.newDNSMessage(reply, localAddr, clientAddr, protocol, tsReply)
+	}
+}
+
+func (c *DoHProxy) newDNSMessage(payload []byte, srcAddr, dstAddr, protocol string, ts time.Time) dnsutils.DNSMessage {
+	dm := dnsutils.DNSMessage{}
+	dm.Init()
+
+	srcIP, srcPort, _ := net.SplitHostPort(srcAddr)
+	dstIP, dstPort, _ := net.SplitHostPort(dstAddr)
+
+	dm.NetworkInfo.Family = netlib.ProtoIPv4
+	if ip := net.ParseIP(srcIP); ip != nil && ip.To4() == nil {
+		dm.NetworkInfo.Family = netlib.ProtoIPv6
+	}
+	dm.NetworkInfo.Protocol = protocol
+	dm.NetworkInfo.QueryIP = srcIP
+	dm.NetworkInfo.QueryPort = srcPort
+	dm.NetworkInfo.ResponseIP = dstIP
+	dm.NetworkInfo.ResponsePort = dstPort
+
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+
+	dm.DNSTap.Identity = c.identity
+	dm.DNSTap.TimeSec = int(ts.Unix())
+	dm.DNSTap.TimeNsec = ts.Nanosecond()
+	return dm
+}
+
+// ServeHTTP handles DNS over HTTPS requests as described in the RFC 8484
+func (c *DoHProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tsQuery := time.Now()
+
+	var query []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if len(param) == 0 {
+			http.Error(w, "missing dns parameter", http.StatusBadRequest)
+			return
+		}
+		query, err = base64.RawURLEncoding.DecodeString(param)
+		if err != nil {
+			http.Error(w, "invalid dns parameter", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		query, err = io.ReadAll(io.LimitReader(r.Body, 65535))
+		if err != nil {
+			http.Error(w, "unable to read body", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(query) < dnsMinLength {
+		http.Error(w, "dns query too short", http.StatusBadRequest)
+		return
+	}
+
+	localAddr := ""
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		localAddr = addr.String()
+	}
+
+	reply, err := c.ForwardQuery(query)
+	if err != nil {
+		c.LogError("doh - upstream error: %s", err)
+		c.emit(query, nil, r.RemoteAddr, localAddr, dnsutils.ProtoDoH, tsQuery, time.Time{})
+		http.Error(w, "upstream error", http.StatusBadGateway)
+		return
+	}
+	c.emit(query, reply, r.RemoteAddr, localAddr, dnsutils.ProtoDoH, tsQuery, time.Now())
+
+	w.Header().Set("Content-Type", dohContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(reply)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(reply)
+}
+
+// HandleDoTConn reads dns queries from a DNS over TLS connection as described in the RFC 7858
+func (c *DoHProxy) HandleDoTConn(conn net.Conn) {
+	// close connection on function exit
+	defer conn.Close()
+
+	peer := conn.RemoteAddr().String()
+	local := conn.LocalAddr().String()
+	c.LogInfo("dot - new connection from %s", peer)
+
+	for {
+		query, err := readStreamMsg(conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				c.LogInfo("dot - connection closed with peer %s", peer)
+			} else {
+				c.LogError("dot - read error with peer %s: %s", peer, err)
+			}
+			break
+		}
+		tsQuery := time.Now()
+
+		if len(query) < dnsMinLength {
+			c.LogError("dot - dns query too short from peer %s", peer)
+			break
+		}
+
+		reply, err := c.ForwardQuery(query)
+		if err != nil {
+			c.LogError("dot - upstream error: %s", err)
+			c.emit(query, nil, peer, local, dnsutils.ProtoDoT, tsQuery, time.Time{})
+			continue
+		}
+		c.emit(query, reply, peer, local, dnsutils.ProtoDoT, tsQuery, time.Now())
+
+		if err := writeStreamMsg(conn, reply); err != nil {
+			c.LogError("dot - write error with peer %s: %s", peer, err)
+			break
+		}
+	}
+
+	// removes the current connection from the list
+	c.Lock()
+	for j, cn := range c.conns {
+		if cn == conn {
+			c.conns = append(c.conns[:j], c.conns[j+1:]...)
+			break
+		}
+	}
+	c.Unlock()
+}
+
+func (c *DoHProxy) Run() {
+	c.LogInfo("starting collector...")
+	if c.listenDoH == nil && c.listenDoT == nil {
+		if err := c.Listen(); err != nil {
+			c.logger.Fatal(pkgutils.PrefixLogCollector+"["+c.name+"] doh-proxy listening failed: ", err)
+		}
+	}
+
+	// start the dns processor
+	c.Lock()
+	c.dnsProcessor = processors.NewDNSProcessor(c.config, c.logger, c.name, c.config.Collectors.DoHProxy.ChannelBufferSize)
+	c.Unlock()
+	go c.dnsProcessor.Run(c.defaultRoutes, c.droppedRoutes)
+
+	// start the http server
+	if c.listenDoH != nil {
+		mux := http.NewServeMux()
+		mux.Handle(c.config.Collectors.DoHProxy.DoHPath, c)
+
+		c.Lock()
+		c.httpServer = &http.Server{Handler: mux, ErrorLog: c.logger.ErrorLogger()}
+		c.Unlock()
+
+		go func() {
+			if err := c.httpServer.Serve(c.listenDoH); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				c.LogError("doh - http server error: %s", err)
+			}
+		}()
+	}
+
+	// goroutine to Accept() blocks waiting for new dot connection.
+	acceptChan := make(chan net.Conn)
+	if c.listenDoT != nil {
+		go func() {
+			for {
+				conn, err := c.listenDoT.Accept()
+				if err != nil {
+					return
+				}
+				acceptChan <- conn
+			}
+		}()
+	}
+
+RUN_LOOP:
+	for {
+		select {
+		case <-c.stopRun:
+			// stop dns processor
+			c.dnsProcessor.Stop()
+
+			c.doneRun <- true
+			break RUN_LOOP
+
+		case cfg := <-c.configChan:
+			// save the new config
+			c.Lock()
+			c.config = cfg
+			c.Unlock()
+			c.ReadConfig()
+
+			// refresh config for the processor
+			c.dnsProcessor.ConfigChan <- cfg
+
+		case conn := <-acceptChan:
+			c.Lock()
+			if c.stopCalled {
+				c.Unlock()
+				conn.Close()
+				continue
+			}
+			c.conns = append(c.conns, conn)
+			c.Unlock()
+			go c.HandleDoTConn(conn)
+		}
+	}
+	c.LogInfo("run terminated")
+}
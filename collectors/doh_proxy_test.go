@@ -0,0 +1,192 @@
+package collectors
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-dnscollector/processors"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+func startFakeUpstream(t *testing.T, address string) *dns.Server {
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A 127.0.0.1")
+		m.Answer = append(m.Answer, rr)
+		w.WriteMsg(m)
+	})
+
+	started := make(chan bool)
+	server := &dns.Server{Addr: address, Net: netlib.SocketUDP, Handler: handler, NotifyStartedFunc: func() { started <- true }}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			t.Errorf("fake upstream error: %s", err)
+		}
+	}()
+	<-started
+	return server
+}
+
+func checkDoHProxyMessages(t *testing.T, g *pkgutils.FakeLogger, protocol string) {
+	for _, operation := range []string{dnsutils.DNSTapClientQuery, dnsutils.DNSTapClientResponse} {
+		msg := <-g.GetInputChannel()
+		if msg.DNSTap.Operation != operation {
+			t.Errorf("want %s, got %s", operation, msg.DNSTap.Operation)
+		}
+		if msg.NetworkInfo.Protocol != protocol {
+			t.Errorf("want protocol %s, got %s", protocol, msg.NetworkInfo.Protocol)
+		}
+		if msg.DNS.Qname != "dns.collector" {
+			t.Errorf("want qname dns.collector, got %s", msg.DNS.Qname)
+		}
+		if msg.NetworkInfo.QueryIP != "127.0.0.1" {
+			t.Errorf("want query ip 127.0.0.1, got %s", msg.NetworkInfo.QueryIP)
+		}
+	}
+}
+
+func Test_DoHProxy_DoH(t *testing.T) {
+	upstream := startFakeUpstream(t, "127.0.0.1:15353")
+	defer upstream.Shutdown()
+
+	config := pkgconfig.GetFakeConfig()
+	config.Collectors.DoHProxy.ListenIP = pkgconfig.LocalhostIP
+	config.Collectors.DoHProxy.DoHListenPort = 18443
+	config.Collectors.DoHProxy.DoTListenPort = 0
+	config.Collectors.DoHProxy.TLSSupport = false
+	config.Collectors.DoHProxy.UpstreamPort = 15353
+
+	g := pkgutils.NewFakeLogger()
+	c := NewDoHProxy([]pkgutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		t.Fatalf("collector listening error: %s", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	query, err := processors.GetFakeDNS()
+	if err != nil {
+		t.Fatalf("dns question pack error")
+	}
+
+	url := "http://127.0.0.1:18443/dns-query"
+	requests := map[string]func() (*http.Response, error){
+		"get": func() (*http.Response, error) {
+			return http.Get(url + "?dns=" + base64.RawURLEncoding.EncodeToString(query))
+		},
+		"post": func() (*http.Response, error) {
+			return http.Post(url, "application/dns-message", bytes.NewReader(query))
+		},
+	}
+
+	for method, doRequest := range requests {
+		t.Run(method, func(t *testing.T) {
+			resp, err := doRequest()
+			if err != nil {
+				t.Fatalf("http request error: %s", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("want status 200, got %d", resp.StatusCode)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			reply := new(dns.Msg)
+			if err := reply.Unpack(body); err != nil {
+				t.Fatalf("invalid dns reply: %s", err)
+			}
+			if len(reply.Answer) != 1 {
+				t.Errorf("want one answer, got %d", len(reply.Answer))
+			}
+
+			checkDoHProxyMessages(t, g, dnsutils.ProtoDoH)
+		})
+	}
+}
+
+func Test_DoHProxy_DoHBadRequest(t *testing.T) {
+	config := pkgconfig.GetFakeConfig()
+	config.Collectors.DoHProxy.ListenIP = pkgconfig.LocalhostIP
+	config.Collectors.DoHProxy.DoHListenPort = 18444
+	config.Collectors.DoHProxy.DoTListenPort = 0
+	config.Collectors.DoHProxy.TLSSupport = false
+
+	g := pkgutils.NewFakeLogger()
+	c := NewDoHProxy([]pkgutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		t.Fatalf("collector listening error: %s", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	resp, err := http.Get("http://127.0.0.1:18444/dns-query?dns=$$$")
+	if err != nil {
+		t.Fatalf("http request error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("want status 400, got %d", resp.StatusCode)
+	}
+}
+
+func Test_DoHProxy_DoT(t *testing.T) {
+	upstream := startFakeUpstream(t, "127.0.0.1:15354")
+	defer upstream.Shutdown()
+
+	config := pkgconfig.GetFakeConfig()
+	config.Collectors.DoHProxy.ListenIP = pkgconfig.LocalhostIP
+	config.Collectors.DoHProxy.DoHListenPort = 0
+	config.Collectors.DoHProxy.DoTListenPort = 18853
+	config.Collectors.DoHProxy.TLSSupport = false
+	config.Collectors.DoHProxy.CertFile = "./../testsdata/certs/server.crt"
+	config.Collectors.DoHProxy.KeyFile = "./../testsdata/certs/server.key"
+	config.Collectors.DoHProxy.UpstreamPort = 15354
+
+	g := pkgutils.NewFakeLogger()
+	c := NewDoHProxy([]pkgutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		t.Fatalf("collector listening error: %s", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	conn, err := tls.Dial(netlib.SocketTCP, "127.0.0.1:18853", &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	query, err := processors.GetFakeDNS()
+	if err != nil {
+		t.Fatalf("dns question pack error")
+	}
+
+	reply, err := exchangeStream(conn, query)
+	if err != nil {
+		t.Fatalf("dot exchange error: %s", err)
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(reply); err != nil {
+		t.Fatalf("invalid dns reply: %s", err)
+	}
+
+	checkDoHProxyMessages(t, g, dnsutils.ProtoDoT)
+
+	// check that the connection can be reused
+	if _, err := exchangeStream(conn, query); err != nil {
+		t.Fatalf("dot second exchange error: %s", err)
+	}
+	checkDoHProxyMessages(t, g, dnsutils.ProtoDoT)
+}
@@ -294,7 +294,7 @@ RUN_LOOP:
 			dm.DNS.Length = len(dm.DNS.Payload)
 
 			// apply all enabled transformers
-			if subprocessors.ProcessMessage(&dm) == transformers.ReturnDrop {
+			if rCode := subprocessors.ProcessMessage(&dm); rCode == transformers.ReturnDrop || rCode == transformers.ReturnHold {
 				continue
 			}
 
@@ -0,0 +1,446 @@
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+)
+
+type JSONListener struct {
+	doneRun        chan bool
+	stopRun        chan bool
+	doneMonitor    chan bool
+	stopMonitor    chan bool
+	stopCalled     bool
+	listen         net.Listener
+	packetConn     net.PacketConn
+	connID         int
+	conns          []net.Conn
+	droppedRoutes  []pkgutils.Worker
+	defaultRoutes  []pkgutils.Worker
+	config         *pkgconfig.Config
+	configChan     chan *pkgconfig.Config
+	inputChan      chan dnsutils.DNSMessage
+	logger         *logger.Logger
+	name           string
+	droppedCount   atomic.Uint64
+	RoutingHandler pkgutils.RoutingHandler
+	sync.RWMutex
+}
+
+func NewJSONListener(loggers []pkgutils.Worker, config *pkgconfig.Config, logger *logger.Logger, name string) *JSONListener {
+	logger.Info(pkgutils.PrefixLogCollector+"[%s] json-listener - enabled", name)
+	s := &JSONListener{
+		doneRun:        make(chan bool),
+		stopRun:        make(chan bool),
+		doneMonitor:    make(chan bool),
+		stopMonitor:    make(chan bool),
+		config:         config,
+		configChan:     make(chan *pkgconfig.Config),
+		inputChan:      make(chan dnsutils.DNSMessage, config.Collectors.JSONListener.ChannelBufferSize),
+		defaultRoutes:  loggers,
+		logger:         logger,
+		name:           name,
+		RoutingHandler: pkgutils.NewRoutingHandler(config, logger, name),
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *JSONListener) GetName() string { return c.name }
+
+func (c *JSONListener) AddDroppedRoute(wrk pkgutils.Worker) {
+	c.droppedRoutes = append(c.droppedRoutes, wrk)
+}
+
+func (c *JSONListener) AddDefaultRoute(wrk pkgutils.Worker) {
+	c.defaultRoutes = append(c.defaultRoutes, wrk)
+}
+
+func (c *JSONListener) SetLoggers(loggers []pkgutils.Worker) {
+	c.defaultRoutes = loggers
+}
+
+func (c *JSONListener) ReadConfig() {
+	switch c.config.Collectors.JSONListener.Transport {
+	case netlib.SocketTCP, netlib.SocketTLS, netlib.SocketUDP:
+	case netlib.SocketUnix:
+		if len(c.config.Collectors.JSONListener.SockPath) == 0 {
+			c.logger.Fatal(pkgutils.PrefixLogCollector + "[" + c.name + "] json-listener - sock-path is required with unix transport")
+		}
+	default:
+		c.logger.Fatal(pkgutils.PrefixLogCollector+"["+c.name+"] json-listener - invalid transport: ", c.config.Collectors.JSONListener.Transport)
+	}
+
+	switch c.config.Collectors.JSONListener.Mode {
+	case pkgconfig.ModeJSON, pkgconfig.ModeFlatJSON:
+	default:
+		c.logger.Fatal(pkgutils.PrefixLogCollector+"["+c.name+"] json-listener - invalid mode: ", c.config.Collectors.JSONListener.Mode)
+	}
+
+	if !pkgconfig.IsValidTLS(c.config.Collectors.JSONListener.TLSMinVersion) {
+		c.logger.Fatal(pkgutils.PrefixLogCollector + "[" + c.name + "] json-listener - invalid tls min version")
+	}
+}
+
+func (c *JSONListener) ReloadConfig(config *pkgconfig.Config) {
+	c.LogInfo("reload configuration...")
+	c.configChan <- config
+}
+
+func (c *JSONListener) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info(pkgutils.PrefixLogCollector+"["+c.name+"] json-listener - "+msg, v...)
+}
+
+func (c *JSONListener) LogError(msg string, v ...interface{}) {
+	c.logger.Error(pkgutils.PrefixLogCollector+"["+c.name+"] json-listener - "+msg, v...)
+}
+
+func (c *JSONListener) GetInputChannel() chan dnsutils.DNSMessage {
+	return nil
+}
+
+// Decode rebuilds the dns message, with the transformers sections, from one json line
+func (c *JSONListener) Decode(line []byte, dm *dnsutils.DNSMessage) error {
+	c.RLock()
+	mode := c.config.Collectors.JSONListener.Mode
+	c.RUnlock()
+
+	if mode == pkgconfig.ModeFlatJSON {
+		var flatDm map[string]interface{}
+		if err := json.Unmarshal(line, &flatDm); err != nil {
+			return err
+		}
+		return dm.Unflatten(flatDm)
+	}
+	return json.Unmarshal(line, dm)
+}
+
+// ProcessLine decodes the line and sends the dns message to the run loop,
+// empty lines are ignored
+func (c *JSONListener) ProcessLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	pkgutils.Telemetry.RecordReceived(c.name)
+
+	dm := dnsutils.DNSMessage{}
+	dm.Init()
+	if err := c.Decode(line, &dm); err != nil {
+		pkgutils.Telemetry.RecordDecoderError(c.name, err)
+		c.LogError("unable to decode json message: %s", err)
+		return
+	}
+
+	// wait when the next stanzas ask for backpressure to stop reading from the socket
+	if c.RoutingHandler.IsBlocking() || pkgutils.IsBlocking(c.defaultRoutes) {
+		select {
+		case c.inputChan <- dm:
+		case <-c.RoutingHandler.DeliveryCancelled():
+		}
+		return
+	}
+	select {
+	case c.inputChan <- dm: // Successful send to channel
+	default:
+		c.droppedCount.Add(1)
+	}
+}
+
+func (c *JSONListener) HandleConn(conn net.Conn) {
+	// close connection on function exit
+	defer conn.Close()
+
+	pkgutils.Telemetry.ConnectionOpened(c.name)
+	defer pkgutils.Telemetry.ConnectionClosed(c.name)
+
+	var connID int
+	c.Lock()
+	c.connID++
+	connID = c.connID
+	c.Unlock()
+
+	// get peer address
+	peer := conn.RemoteAddr().String()
+	c.LogInfo("new connection #%d from %s", connID, peer)
+
+	// one dns message per line
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), c.config.Collectors.JSONListener.MaxLineSize)
+	for scanner.Scan() {
+		c.ProcessLine(scanner.Bytes())
+	}
+
+	err := scanner.Err()
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		c.LogInfo("conn #%d - connection closed with peer %s", connID, peer)
+	} else {
+		c.LogError("conn #%d - json reader error: %s", connID, err)
+	}
+
+	// to avoid lock if the Stop function is already called
+	if c.stopCalled {
+		c.LogInfo("conn #%d - connection handler exited", connID)
+		return
+	}
+
+	// here the connection is closed,
+	// then removes the current connection from the list
+	c.Lock()
+	for j, cn := range c.conns {
+		if cn == conn {
+			c.conns = append(c.conns[:j], c.conns[j+1:]...)
+			conn = nil
+		}
+	}
+	c.Unlock()
+
+	c.LogInfo("conn #%d - connection handler terminated", connID)
+}
+
+// HandlePacketConn reads the datagrams, each one can contain several lines
+func (c *JSONListener) HandlePacketConn(conn net.PacketConn) {
+	buffer := make([]byte, 65535)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				c.LogError("json reader error: %s", err)
+			}
+			break
+		}
+
+		for _, line := range bytes.Split(buffer[:n], []byte("\n")) {
+			c.ProcessLine(line)
+		}
+	}
+	c.LogInfo("packet handler terminated")
+}
+
+func (c *JSONListener) Stop() {
+	c.Lock()
+
+	// to avoid some lock situations when the remose side closes
+	// the connection at the same time of this Stop function
+	c.stopCalled = true
+	c.LogInfo("stopping collector...")
+
+	// unblock the connections waiting the next stanzas
+	c.RoutingHandler.Stop()
+
+	// closing properly current connections if exists
+	c.LogInfo("closing connected peers...")
+	for _, conn := range c.conns {
+		peer := conn.RemoteAddr().String()
+		c.LogInfo("%s - closing connection...", peer)
+		netlib.Close(conn, c.config.Collectors.JSONListener.ResetConn)
+	}
+
+	// Finally close the listener to unblock accept
+	c.LogInfo("stop listening...")
+	if c.listen != nil {
+		c.listen.Close()
+	}
+	if c.packetConn != nil {
+		c.packetConn.Close()
+	}
+
+	// release the lock before the handshakes, the run loop takes it on reload
+	c.Unlock()
+
+	// stop monitor goroutine
+	c.LogInfo("stopping monitor...")
+	c.stopMonitor <- true
+	<-c.doneMonitor
+
+	// read done channel and block until run is terminated
+	c.LogInfo("stopping run...")
+	c.stopRun <- true
+	<-c.doneRun
+}
+
+func (c *JSONListener) Listen() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.LogInfo("running in background...")
+
+	var err error
+	var listener net.Listener
+	addrlisten := c.config.Collectors.JSONListener.ListenIP + ":" + strconv.Itoa(c.config.Collectors.JSONListener.ListenPort)
+
+	switch c.config.Collectors.JSONListener.Transport {
+	case netlib.SocketUDP:
+		var packetConn net.PacketConn
+		packetConn, err = net.ListenPacket(netlib.SocketUDP, addrlisten)
+		if err != nil {
+			return err
+		}
+		c.LogInfo("is listening on %s://%s", netlib.SocketUDP, packetConn.LocalAddr())
+		c.packetConn = packetConn
+		return nil
+
+	case netlib.SocketTLS:
+		c.LogInfo("tls support enabled")
+		var cer tls.Certificate
+		cer, err = tls.LoadX509KeyPair(c.config.Collectors.JSONListener.CertFile, c.config.Collectors.JSONListener.KeyFile)
+		if err != nil {
+			c.logger.Fatal("loading certificate failed:", err)
+		}
+
+		// prepare tls configuration
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cer},
+			MinVersion:   tls.VersionTLS12,
+		}
+
+		// update tls min version according to the user config
+		tlsConfig.MinVersion = pkgconfig.TLSVersion[c.config.Collectors.JSONListener.TLSMinVersion]
+
+		listener, err = tls.Listen(netlib.SocketTCP, addrlisten, tlsConfig)
+
+	case netlib.SocketUnix:
+		_ = os.Remove(c.config.Collectors.JSONListener.SockPath)
+		listener, err = net.Listen(netlib.SocketUnix, c.config.Collectors.JSONListener.SockPath)
+
+	default:
+		listener, err = net.Listen(netlib.SocketTCP, addrlisten)
+	}
+
+	// something is wrong ?
+	if err != nil {
+		return err
+	}
+	c.LogInfo("is listening on %s://%s", c.config.Collectors.JSONListener.Transport, listener.Addr())
+	c.listen = listener
+	return nil
+}
+
+func (c *JSONListener) MonitorCollector() {
+	watchInterval := 10 * time.Second
+	bufferFull := time.NewTimer(watchInterval)
+MONITOR_LOOP:
+	for {
+		select {
+		case <-c.stopMonitor:
+			bufferFull.Stop()
+			c.doneMonitor <- true
+			break MONITOR_LOOP
+
+		case <-bufferFull.C:
+			if count := c.droppedCount.Swap(0); count > 0 {
+				c.LogError("recv buffer is full, %d message(s) dropped", count)
+			}
+			bufferFull.Reset(watchInterval)
+		}
+	}
+	c.LogInfo("monitor terminated")
+}
+
+func (c *JSONListener) Run() {
+	c.LogInfo("starting collector...")
+	if c.listen == nil && c.packetConn == nil {
+		if err := c.Listen(); err != nil {
+			c.logger.Fatal(pkgutils.PrefixLogCollector+"["+c.name+"] json-listener listening failed: ", err)
+		}
+	}
+
+	// prepare next channels, the delivery depends on the next workers
+	c.RoutingHandler.SetDefaultRoutes(c.defaultRoutes)
+	c.RoutingHandler.SetDroppedRoutes(c.droppedRoutes)
+	defaultRoutes, defaultNames := c.RoutingHandler.GetDefaultRoutes()
+	droppedRoutes, droppedNames := c.RoutingHandler.GetDroppedRoutes()
+
+	// prepare transforms
+	subprocessors := transformers.NewTransforms(&c.config.IngoingTransformers, c.logger, c.name, defaultRoutes, 0)
+
+	// start goroutine to count dropped messsages
+	go c.MonitorCollector()
+
+	// goroutine to Accept() blocks waiting for new connection.
+	acceptChan := make(chan net.Conn)
+	if c.packetConn != nil {
+		go c.HandlePacketConn(c.packetConn)
+	} else {
+		go func() {
+			for {
+				conn, err := c.listen.Accept()
+				if err != nil {
+					return
+				}
+				acceptChan <- conn
+			}
+		}()
+	}
+
+RUN_LOOP:
+	for {
+		select {
+		case <-c.stopRun:
+			// cleanup transformers
+			subprocessors.Reset()
+
+			close(acceptChan)
+			c.doneRun <- true
+			break RUN_LOOP
+
+		case cfg := <-c.configChan:
+			// save the new config
+			c.Lock()
+			c.config = cfg
+			c.Unlock()
+			c.ReadConfig()
+
+			subprocessors.ReloadConfig(&cfg.IngoingTransformers)
+
+		case conn, opened := <-acceptChan:
+			if !opened {
+				return
+			}
+
+			// to avoid lock if the Stop function is already called
+			if c.stopCalled {
+				continue
+			}
+
+			c.Lock()
+			c.conns = append(c.conns, conn)
+			c.Unlock()
+			go c.HandleConn(conn)
+
+		case dm := <-c.inputChan:
+			// apply tranforms, init dns message with additionnals parts if necessary
+			subprocessors.InitDNSMessageFormat(&dm)
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
+				c.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
+				continue
+			}
+
+			// send to next
+			c.RoutingHandler.SendTo(defaultRoutes, defaultNames, dm)
+		}
+	}
+
+	c.LogInfo("run terminated")
+}
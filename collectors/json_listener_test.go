@@ -0,0 +1,96 @@
+package collectors
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestJSONListener_Run(t *testing.T) {
+	sockPath := filepath.Join(os.TempDir(), "dnscollector_json.sock")
+
+	testcases := []struct {
+		name      string
+		transport string
+		address   string
+		mode      string
+	}{
+		{name: "tcp_json", transport: netlib.SocketTCP, address: ":6002", mode: pkgconfig.ModeJSON},
+		{name: "tcp_flat_json", transport: netlib.SocketTCP, address: ":6002", mode: pkgconfig.ModeFlatJSON},
+		{name: "udp_json", transport: netlib.SocketUDP, address: "127.0.0.1:6002", mode: pkgconfig.ModeJSON},
+		{name: "unix_json", transport: netlib.SocketUnix, address: sockPath, mode: pkgconfig.ModeJSON},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := pkgutils.NewFakeLogger()
+
+			config := pkgconfig.GetFakeConfig()
+			config.Collectors.JSONListener.Transport = tc.transport
+			config.Collectors.JSONListener.SockPath = sockPath
+			config.Collectors.JSONListener.Mode = tc.mode
+
+			c := NewJSONListener([]pkgutils.Worker{g}, config, logger.New(false), "test")
+			if err := c.Listen(); err != nil {
+				t.Fatal("collector json listening error: ", err)
+			}
+			go c.Run()
+
+			// dns message already enriched by the transformers of the remote instance
+			dmIn := dnsutils.GetFakeDNSMessage()
+			dmIn.ATags = &dnsutils.TransformATags{Tags: []string{"edge", "paris"}}
+			dmIn.Geo = &dnsutils.TransformDNSGeo{CountryIsoCode: "FR", AutonomousSystemNumber: "1234"}
+
+			var line []byte
+			var err error
+			if tc.mode == pkgconfig.ModeFlatJSON {
+				flat, errFlat := dmIn.Flatten()
+				if errFlat != nil {
+					t.Fatal(errFlat)
+				}
+				line, err = json.Marshal(flat)
+			} else {
+				line, err = json.Marshal(dmIn)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conn, err := net.Dial(tc.transport, tc.address)
+			if err != nil {
+				t.Fatal("could not connect: ", err)
+			}
+
+			// invalid and empty lines are ignored
+			conn.Write([]byte("{invalid\n\n"))
+			conn.Write(append(line, '\n'))
+
+			select {
+			case dmOut := <-g.GetInputChannel():
+				if dmOut.DNS.Qname != dmIn.DNS.Qname {
+					t.Errorf("invalid qname: %s", dmOut.DNS.Qname)
+				}
+				if dmOut.ATags == nil || len(dmOut.ATags.Tags) != 2 || dmOut.ATags.Tags[1] != "paris" {
+					t.Errorf("atags not decoded: %v", dmOut.ATags)
+				}
+				if dmOut.Geo == nil || dmOut.Geo.CountryIsoCode != "FR" {
+					t.Errorf("geoip not decoded: %v", dmOut.Geo)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("no dns message received")
+			}
+
+			conn.Close()
+			c.Stop()
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-dnscollector/processors"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+type KafkaConsumer struct {
+	doneRun        chan bool
+	stopRun        chan bool
+	defaultRoutes  []pkgutils.Worker
+	droppedRoutes  []pkgutils.Worker
+	config         *pkgconfig.Config
+	configChan     chan *pkgconfig.Config
+	logger         *logger.Logger
+	name           string
+	RoutingHandler pkgutils.RoutingHandler
+}
+
+func NewKafkaConsumer(loggers []pkgutils.Worker, config *pkgconfig.Config, logger *logger.Logger, name string) *KafkaConsumer {
+	logger.Info(pkgutils.PrefixLogCollector+"[%s] kafkaconsumer - enabled", name)
+	s := &KafkaConsumer{
+		doneRun:        make(chan bool),
+		stopRun:        make(chan bool),
+		config:         config,
+		configChan:     make(chan *pkgconfig.Config),
+		defaultRoutes:  loggers,
+		logger:         logger,
+		name:           name,
+		RoutingHandler: pkgutils.NewRoutingHandler(config, logger, name),
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *KafkaConsumer) GetName() string { return c.name }
+
+func (c *KafkaConsumer) AddDroppedRoute(wrk pkgutils.Worker) {
+	c.droppedRoutes = append(c.droppedRoutes, wrk)
+}
+
+func (c *KafkaConsumer) AddDefaultRoute(wrk pkgutils.Worker) {
+	c.defaultRoutes = append(c.defaultRoutes, wrk)
+}
+
+func (c *KafkaConsumer) SetLoggers(loggers []pkgutils.Worker) {
+	c.defaultRoutes = loggers
+}
+
+// CheckConfig validates the mode and the reader settings of the config
+func (c *KafkaConsumer) CheckConfig(config *pkgconfig.Config) error {
+	switch config.Collectors.KafkaConsumer.Mode {
+	case pkgconfig.ModeJSON, pkgconfig.ModeFlatJSON, pkgconfig.ModeDNSTap:
+	default:
+		return errors.New("invalid mode: " + config.Collectors.KafkaConsumer.Mode)
+	}
+	if config.Collectors.KafkaConsumer.CommitInterval <= 0 {
+		return errors.New("invalid commit interval: " + strconv.Itoa(config.Collectors.KafkaConsumer.CommitInterval))
+	}
+	_, err := NewKafkaReaderConfig(config)
+	return err
+}
+
+func (c *KafkaConsumer) ReadConfig() {
+	if err := c.CheckConfig(c.config); err != nil {
+		c.logger.Fatal(pkgutils.PrefixLogCollector+"["+c.name+"] kafkaconsumer - ", err)
+	}
+}
+
+func (c *KafkaConsumer) ReloadConfig(config *pkgconfig.Config) {
+	c.LogInfo("reload configuration...")
+	c.configChan <- config
+}
+
+func (c *KafkaConsumer) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info(pkgutils.PrefixLogCollector+"["+c.name+"] kafkaconsumer - "+msg, v...)
+}
+
+func (c *KafkaConsumer) LogError(msg string, v ...interface{}) {
+	c.logger.Error(pkgutils.PrefixLogCollector+"["+c.name+"] kafkaconsumer - "+msg, v...)
+}
+
+func (c *KafkaConsumer) GetInputChannel() chan dnsutils.DNSMessage {
+	return nil
+}
+
+func (c *KafkaConsumer) Stop() {
+	c.LogInfo("stopping collector...")
+	c.RoutingHandler.Stop()
+
+	// read done channel and block until run is terminated
+	c.LogInfo("stopping run...")
+	c.stopRun <- true
+	<-c.doneRun
+}
+
+// ReaderConfig returns the config of the kafka reader for the current config
+func (c *KafkaConsumer) ReaderConfig() (kafka.ReaderConfig, error) {
+	return NewKafkaReaderConfig(c.config)
+}
+
+// NewKafkaReaderConfig returns the config of the kafka reader, with a consumer group
+// the partitions are assigned by the brokers otherwise the partition is read
+func NewKafkaReaderConfig(config *pkgconfig.Config) (kafka.ReaderConfig, error) {
+	cfg := config.Collectors.KafkaConsumer
+
+	dialer := &kafka.Dialer{
+		Timeout:   time.Duration(cfg.ConnectTimeout) * time.Second,
+		DualStack: true,
+	}
+
+	// enable TLS
+	if cfg.TLSSupport {
+		tlsOptions := pkgconfig.TLSOptions{
+			InsecureSkipVerify: cfg.TLSInsecure,
+			MinVersion:         cfg.TLSMinVersion,
+			CAFile:             cfg.CAFile,
+			CertFile:           cfg.CertFile,
+			KeyFile:            cfg.KeyFile,
+		}
+
+		tlsConfig, err := pkgconfig.TLSClientConfig(tlsOptions)
+		if err != nil {
+			return kafka.ReaderConfig{}, err
+		}
+		dialer.TLS = tlsConfig
+	}
+
+	// SASL Support
+	if cfg.SaslSupport {
+		switch cfg.SaslMechanism {
+		case pkgconfig.SASLMechanismPlain:
+			dialer.SASLMechanism = plain.Mechanism{
+				Username: cfg.SaslUsername,
+				Password: cfg.SaslPassword,
+			}
+		case pkgconfig.SASLMechanismScram:
+			mechanism, err := scram.Mechanism(scram.SHA512, cfg.SaslUsername, cfg.SaslPassword)
+			if err != nil {
+				return kafka.ReaderConfig{}, err
+			}
+			dialer.SASLMechanism = mechanism
+		default:
+			return kafka.ReaderConfig{}, errors.New("invalid sasl mechanism: " + cfg.SaslMechanism)
+		}
+	}
+
+	readerConfig := kafka.ReaderConfig{
+		Brokers: []string{cfg.RemoteAddress + ":" + strconv.Itoa(cfg.RemotePort)},
+		Topic:   cfg.Topic,
+		GroupID: cfg.GroupID,
+		Dialer:  dialer,
+		MaxWait: time.Second,
+	}
+	if len(cfg.GroupID) == 0 {
+		readerConfig.Partition = cfg.Partition
+	}
+	return readerConfig, readerConfig.Validate()
+}
+
+// Decode rebuilds the dns message from the json or flat-json payload
+func (c *KafkaConsumer) Decode(payload []byte, dm *dnsutils.DNSMessage) error {
+	if c.config.Collectors.KafkaConsumer.Mode == pkgconfig.ModeFlatJSON {
+		var flatDm map[string]interface{}
+		if err := json.Unmarshal(payload, &flatDm); err != nil {
+			return err
+		}
+		return dm.Unflatten(flatDm)
+	}
+	return json.Unmarshal(payload, dm)
+}
+
+// Fetch reads the messages from kafka until the context is cancelled
+func (c *KafkaConsumer) Fetch(ctx context.Context, reader *kafka.Reader, messages chan<- kafka.Message) {
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.LogError("unable to fetch message: %s", err)
+			c.LogInfo("retry to fetch in %d seconds", c.config.Collectors.KafkaConsumer.RetryInterval)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(c.config.Collectors.KafkaConsumer.RetryInterval) * time.Second):
+			}
+			continue
+		}
+
+		select {
+		case messages <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// OpenReader connects to kafka, the messages are fetched in background until the reader is closed
+func (c *KafkaConsumer) OpenReader(readerConfig kafka.ReaderConfig) (*kafka.Reader, context.CancelFunc, chan kafka.Message) {
+	reader := kafka.NewReader(readerConfig)
+	ctx, cancel := context.WithCancel(context.Background())
+	messages := make(chan kafka.Message)
+	go c.Fetch(ctx, reader, messages)
+	return reader, cancel, messages
+}
+
+// CloseReader stops to fetch, commits the offsets of the routed messages and closes the reader
+func (c *KafkaConsumer) CloseReader(reader *kafka.Reader, cancel context.CancelFunc, offsets map[int]kafka.Message) {
+	cancel()
+	c.CommitOffsets(reader, offsets)
+	if err := reader.Close(); err != nil {
+		c.LogError("unable to close the reader: %s", err)
+	}
+}
+
+func (c *KafkaConsumer) Run() {
+	c.LogInfo("starting collector...")
+
+	// prepare next channels, the delivery depends on the next workers
+	c.RoutingHandler.SetDefaultRoutes(c.defaultRoutes)
+	c.RoutingHandler.SetDroppedRoutes(c.droppedRoutes)
+	defaultRoutes, defaultNames := c.RoutingHandler.GetDefaultRoutes()
+	droppedRoutes, droppedNames := c.RoutingHandler.GetDroppedRoutes()
+
+	// prepare transforms
+	subprocessors := transformers.NewTransforms(&c.config.IngoingTransformers, c.logger, c.name, defaultRoutes, 0)
+
+	// dnstap payloads are decoded by the dnstap processor, which reports the delivery of each payload
+	dnstapProcessor := processors.NewDNSTapProcessor(0, c.config, c.logger, c.name, c.config.Collectors.KafkaConsumer.ChannelBufferSize)
+	dnstapProcessor.Delivered = make(chan bool, 1)
+	go dnstapProcessor.Run(c.defaultRoutes, c.droppedRoutes)
+
+	// connect to kafka
+	readerConfig, err := c.ReaderConfig()
+	if err != nil {
+		c.LogError("invalid reader config, nothing is consumed: %s", err)
+		<-c.stopRun
+		dnstapProcessor.Stop()
+		subprocessors.Reset()
+		c.doneRun <- true
+		return
+	}
+	reader, cancelKafka, messages := c.OpenReader(readerConfig)
+	commit := len(readerConfig.GroupID) > 0
+	c.LogInfo("consuming topic=%s from kafka=%s group=%s", readerConfig.Topic, readerConfig.Brokers[0], readerConfig.GroupID)
+
+	// offsets of the routed messages to commit by partition. When a message is dropped by
+	// the next stanzas, the reader is closed and opened again after the retry interval
+	// to consume the messages from the last committed offsets
+	commitInterval := time.Duration(c.config.Collectors.KafkaConsumer.CommitInterval) * time.Second
+	commitTimer := time.NewTimer(commitInterval)
+	offsets := make(map[int]kafka.Message)
+	var reopen <-chan time.Time
+
+RUN_LOOP:
+	for {
+		select {
+		case <-c.stopRun:
+			break RUN_LOOP
+
+		case cfg := <-c.configChan:
+			if err := c.CheckConfig(cfg); err != nil {
+				c.LogError("invalid config, the current one is kept: %s", err)
+				continue
+			}
+
+			// save the new config
+			c.config = cfg
+			commitInterval = time.Duration(cfg.Collectors.KafkaConsumer.CommitInterval) * time.Second
+
+			subprocessors.ReloadConfig(&cfg.IngoingTransformers)
+			dnstapProcessor.ConfigChan <- cfg
+
+		case <-commitTimer.C:
+			c.CommitOffsets(reader, offsets)
+			commitTimer.Reset(commitInterval)
+
+		case <-reopen:
+			reopen = nil
+			reader, cancelKafka, messages = c.OpenReader(readerConfig)
+			c.LogInfo("consuming again topic=%s from the last committed offsets", readerConfig.Topic)
+
+		case msg := <-messages:
+			delivered := true
+			if c.config.Collectors.KafkaConsumer.Mode == pkgconfig.ModeDNSTap {
+				dnstapProcessor.GetChannel() <- msg.Value
+				select {
+				case delivered = <-dnstapProcessor.Delivered:
+				case <-c.stopRun:
+					break RUN_LOOP
+				}
+			} else {
+				pkgutils.Telemetry.RecordReceived(c.name)
+
+				dm := dnsutils.DNSMessage{}
+				dm.Init()
+				if err := c.Decode(msg.Value, &dm); err != nil {
+					pkgutils.Telemetry.RecordDecoderError(c.name, err)
+					c.LogError("unable to decode message at offset %d: %s", msg.Offset, err)
+				} else {
+					// apply tranforms, init dns message with additionnals parts if necessary
+					subprocessors.InitDNSMessageFormat(&dm)
+					switch subprocessors.ProcessMessage(&dm) {
+					case transformers.ReturnHold:
+						// the message is kept by a transformer and emitted later
+					case transformers.ReturnDrop:
+						delivered = c.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
+					default:
+						delivered = c.RoutingHandler.SendTo(defaultRoutes, defaultNames, dm)
+					}
+				}
+			}
+
+			// invalid messages are also committed
+			if !commit {
+				continue
+			}
+			if !delivered {
+				c.LogError("message dropped at partition %d offset %d, consume again in %d seconds", msg.Partition, msg.Offset, c.config.Collectors.KafkaConsumer.RetryInterval)
+				c.CloseReader(reader, cancelKafka, offsets)
+				reader, messages = nil, nil
+				reopen = time.After(time.Duration(c.config.Collectors.KafkaConsumer.RetryInterval) * time.Second)
+				continue
+			}
+			offsets[msg.Partition] = msg
+		}
+	}
+
+	commitTimer.Stop()
+	if reader != nil {
+		c.CloseReader(reader, cancelKafka, offsets)
+	}
+	dnstapProcessor.Stop()
+
+	// cleanup transformers
+	subprocessors.Reset()
+
+	c.doneRun <- true
+	c.LogInfo("run terminated")
+}
+
+// CommitOffsets commits the last offset of each partition and resets them
+func (c *KafkaConsumer) CommitOffsets(reader *kafka.Reader, offsets map[int]kafka.Message) {
+	if len(offsets) == 0 {
+		return
+	}
+	msgs := make([]kafka.Message, 0, len(offsets))
+	for partition, msg := range offsets {
+		msgs = append(msgs, msg)
+		delete(offsets, partition)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.config.Collectors.KafkaConsumer.ConnectTimeout)*time.Second)
+	defer cancel()
+	if err := reader.CommitMessages(ctx, msgs...); err != nil {
+		c.LogError("unable to commit offsets: %s", err)
+	}
+}
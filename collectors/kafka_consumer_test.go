@@ -0,0 +1,231 @@
+package collectors
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-dnscollector/processors"
+	"github.com/dmachard/go-logger"
+	"google.golang.org/protobuf/proto"
+
+	sarama "github.com/Shopify/sarama"
+)
+
+func Test_KafkaConsumer_ReaderConfig(t *testing.T) {
+	config := pkgconfig.GetFakeConfig()
+	c := NewKafkaConsumer(nil, config, logger.New(false), "test")
+
+	// with a consumer group, the partition is assigned by the brokers
+	config.Collectors.KafkaConsumer.Partition = 2
+	readerConfig, err := c.ReaderConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readerConfig.GroupID != "dnscollector" || readerConfig.Partition != 0 {
+		t.Errorf("invalid reader config, group=%s partition=%d", readerConfig.GroupID, readerConfig.Partition)
+	}
+
+	// without consumer group
+	config.Collectors.KafkaConsumer.GroupID = ""
+	readerConfig, err = c.ReaderConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readerConfig.Partition != 2 {
+		t.Errorf("invalid partition: %d", readerConfig.Partition)
+	}
+
+	// invalid sasl mechanism
+	config.Collectors.KafkaConsumer.SaslSupport = true
+	config.Collectors.KafkaConsumer.SaslMechanism = "invalid"
+	if _, err = c.ReaderConfig(); err == nil {
+		t.Errorf("error expected with invalid sasl mechanism")
+	}
+}
+
+func Test_KafkaConsumer_CheckConfig(t *testing.T) {
+	config := pkgconfig.GetFakeConfig()
+	c := NewKafkaConsumer(nil, config, logger.New(false), "test")
+
+	// the current config is kept on reload with an invalid mode or reader config
+	newConfig := pkgconfig.GetFakeConfig()
+	newConfig.Collectors.KafkaConsumer.Mode = "invalid"
+	if err := c.CheckConfig(newConfig); err == nil {
+		t.Errorf("error expected with invalid mode")
+	}
+
+	newConfig = pkgconfig.GetFakeConfig()
+	newConfig.Collectors.KafkaConsumer.Topic = ""
+	if err := c.CheckConfig(newConfig); err == nil {
+		t.Errorf("error expected without topic")
+	}
+
+	newConfig = pkgconfig.GetFakeConfig()
+	newConfig.Collectors.KafkaConsumer.CommitInterval = 0
+	if err := c.CheckConfig(newConfig); err == nil {
+		t.Errorf("error expected with invalid commit interval")
+	}
+
+	if err := c.CheckConfig(pkgconfig.GetFakeConfig()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func Test_KafkaConsumer_Decode(t *testing.T) {
+	dmRef := dnsutils.GetFakeDNSMessage()
+	dmRef.PublicSuffix = &dnsutils.TransformPublicSuffix{QnamePublicSuffix: "com", QnameEffectiveTLDPlusOne: "dns.collector"}
+
+	payloadJSON, err := json.Marshal(dmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flatDm, err := dmRef.Flatten()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloadFlat, err := json.Marshal(flatDm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name    string
+		mode    string
+		payload []byte
+		valid   bool
+	}{
+		{name: "json", mode: pkgconfig.ModeJSON, payload: payloadJSON, valid: true},
+		{name: "flat_json", mode: pkgconfig.ModeFlatJSON, payload: payloadFlat, valid: true},
+		{name: "invalid", mode: pkgconfig.ModeFlatJSON, payload: []byte("{invalid"), valid: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := pkgconfig.GetFakeConfig()
+			config.Collectors.KafkaConsumer.Mode = tc.mode
+			c := NewKafkaConsumer(nil, config, logger.New(false), "test")
+
+			dm := dnsutils.DNSMessage{}
+			dm.Init()
+			err := c.Decode(tc.payload, &dm)
+			if !tc.valid {
+				if err == nil {
+					t.Errorf("decoding error expected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dm.DNS.Qname != dmRef.DNS.Qname {
+				t.Errorf("invalid qname: %s", dm.DNS.Qname)
+			}
+			if dm.PublicSuffix == nil || dm.PublicSuffix.QnamePublicSuffix != "com" {
+				t.Errorf("public suffix transformer not decoded")
+			}
+		})
+	}
+}
+
+func Test_KafkaConsumer_Run(t *testing.T) {
+	jsonPayload, err := json.Marshal(dnsutils.GetFakeDNSMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsquery, err := processors.GetFakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnstapPayload, err := proto.Marshal(processors.GetFakeDNSTap(dnsquery))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		mode    string
+		payload []byte
+	}{
+		{mode: pkgconfig.ModeJSON, payload: jsonPayload},
+		{mode: pkgconfig.ModeDNSTap, payload: dnstapPayload},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.mode, func(t *testing.T) {
+			runKafkaConsumer(t, tc.mode, tc.payload)
+		})
+	}
+}
+
+// runKafkaConsumer consumes the payload from a mock broker and checks the routed message
+func runKafkaConsumer(t *testing.T, mode string, payload []byte) {
+	topic := "dnscollector"
+
+	mockListener, err := net.Listen("tcp", "127.0.0.1:9093")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockListener.Close()
+
+	mockBroker := sarama.NewMockBrokerListener(t, 1, mockListener)
+	defer mockBroker.Close()
+
+	mockBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t).SetApiKeys(
+			[]sarama.ApiVersionsResponseKey{
+				{
+					ApiKey:     3, // Metadata
+					MinVersion: 0,
+					MaxVersion: 6,
+				},
+				{
+					ApiKey:     2, // ListOffsets
+					MinVersion: 0,
+					MaxVersion: 1,
+				},
+				{
+					ApiKey:     1, // Fetch
+					MinVersion: 0,
+					MaxVersion: 2,
+				},
+			},
+		),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(mockBroker.Addr(), mockBroker.BrokerID()).
+			SetController(mockBroker.BrokerID()).
+			SetLeader(topic, 0, mockBroker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(topic, 0, sarama.OffsetOldest, 0).
+			SetOffset(topic, 0, sarama.OffsetNewest, 1),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetMessage(topic, 0, 0, sarama.ByteEncoder(payload)).
+			SetHighWaterMark(topic, 0, 1),
+	})
+
+	// init the collector without consumer group
+	config := pkgconfig.GetFakeConfig()
+	config.Collectors.KafkaConsumer.RemotePort = 9093
+	config.Collectors.KafkaConsumer.Mode = mode
+	config.Collectors.KafkaConsumer.GroupID = ""
+	c := NewKafkaConsumer(nil, config, logger.New(false), "test")
+
+	g := pkgutils.NewFakeLogger()
+	c.AddDefaultRoute(g)
+
+	go c.Run()
+
+	select {
+	case dm := <-g.GetInputChannel():
+		if dm.DNS.Qname != processors.ExpectedQname2 {
+			t.Errorf("invalid qname: %s", dm.DNS.Qname)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("no dns message consumed")
+	}
+
+	c.Stop()
+}
@@ -112,6 +112,9 @@ func (c *ProtobufPowerDNS) HandleConn(conn net.Conn) {
 	// close connection on function exit
 	defer conn.Close()
 
+	pkgutils.Telemetry.ConnectionOpened(c.name)
+	defer pkgutils.Telemetry.ConnectionClosed(c.name)
+
 	var connID int
 	c.Lock()
 	c.connID++
@@ -6,8 +6,11 @@ package collectors
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
@@ -19,7 +22,6 @@ import (
 	"github.com/dmachard/go-dnscollector/processors"
 	"github.com/dmachard/go-logger"
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
 	"golang.org/x/net/bpf"
 	"golang.org/x/sys/unix"
 )
@@ -76,61 +78,6 @@ func GetBPFFilterIngress(port int) []bpf.Instruction {
 	return filter
 }
 
-func GetBpfFilter(port int) []bpf.Instruction {
-	// bpf filter: (ip  or ip6 ) and (udp or tcp) and port 53
-	// fragmented packets are ignored
-	var filter = []bpf.Instruction{
-		// Load eth.type (2 bytes at offset 12) and push-it in register A
-		bpf.LoadAbsolute{Off: 12, Size: 2},
-		// if eth.type == IPv4 continue with the next instruction
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x0800, SkipTrue: 0, SkipFalse: 10},
-		// Load ip.proto (1 byte at offset 23) and push-it in register A
-		bpf.LoadAbsolute{Off: 23, Size: 1},
-		// ip.proto == UDP ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x11, SkipTrue: 1, SkipFalse: 0},
-		// ip.proto == TCP ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x6, SkipTrue: 0, SkipFalse: 16},
-		// load flags and fragment offset (2 bytes at offset 20) to ignore fragmented packet
-		bpf.LoadAbsolute{Off: 20, Size: 2},
-		// Only look at the last 13 bits of the data saved in regiter A
-		//  0x1fff == 0001 1111 1111 1111 (fragment offset)
-		// If any of the data in fragment offset is true, ignore the packet
-		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x1fff, SkipTrue: 14, SkipFalse: 0},
-		// Load ip.length
-		// Register X = ip header len * 4
-		bpf.LoadMemShift{Off: 14},
-		// Load source port in tcp or udp (2 bytes at offset x+14)
-		bpf.LoadIndirect{Off: 14, Size: 2},
-		// source port equal to 53 ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(port), SkipTrue: 10, SkipFalse: 0},
-		// Load destination port in tcp or udp  (2 bytes at offset x+16)
-		bpf.LoadIndirect{Off: 16, Size: 2},
-		// destination port equal to 53 ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(port), SkipTrue: 8, SkipFalse: 9},
-		// if eth.type == IPv6 continue with the next instruction
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x86dd, SkipTrue: 0, SkipFalse: 8},
-		// Load ipv6.nxt (2 bytes at offset 12) and push-it in register A
-		bpf.LoadAbsolute{Off: 20, Size: 1},
-		// ip.proto == UDP ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x11, SkipTrue: 1, SkipFalse: 0},
-		// ip.proto == TCP ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x6, SkipTrue: 0, SkipFalse: 5},
-		// Load source port tcp or udp (2 bytes at offset 54)
-		bpf.LoadAbsolute{Off: 54, Size: 2},
-		// source port equal to 53 ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(port), SkipTrue: 2, SkipFalse: 0},
-		// Load destination port tcp or udp (2 bytes at offset 56)
-		bpf.LoadAbsolute{Off: 56, Size: 2},
-		// destination port equal to 53 ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(port), SkipTrue: 0, SkipFalse: 1},
-		// Keep the packet and send up to 65k of the packet to userspace
-		bpf.RetConstant{Val: 0xFFFF},
-		// Ignore packet
-		bpf.RetConstant{Val: 0},
-	}
-	return filter
-}
-
 func ApplyBpfFilter(filter []bpf.Instruction, fd int) (err error) {
 	var assembled []bpf.RawInstruction
 	if assembled, err = bpf.Assemble(filter); err != nil {
@@ -149,10 +96,21 @@ func RemoveBpfFilter(fd int) (err error) {
 	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_DETACH_FILTER, 0)
 }
 
+// timeout in milliseconds before the kernel retires a partially filled block of the ring
+const afpacketBlockTimeout = 100
+
+// raw socket bound to a device, with the mmap ring buffer when TPACKET_V3 is enabled
+type afpacketSocket struct {
+	fd     int
+	device string
+	ring   []byte
+}
+
 type AfpacketSniffer struct {
 	done          chan bool
 	exit          chan bool
-	fd            int
+	stopCapture   chan bool
+	sockets       []*afpacketSocket
 	identity      string
 	defaultRoutes []pkgutils.Worker
 	droppedRoutes []pkgutils.Worker
@@ -167,6 +125,7 @@ func NewAfpacketSniffer(loggers []pkgutils.Worker, config *pkgconfig.Config, log
 	s := &AfpacketSniffer{
 		done:          make(chan bool),
 		exit:          make(chan bool),
+		stopCapture:   make(chan bool),
 		config:        config,
 		configChan:    make(chan *pkgconfig.Config),
 		defaultRoutes: loggers,
@@ -216,6 +175,27 @@ func (c *AfpacketSniffer) GetInputChannel() chan dnsutils.DNSMessage {
 	return nil
 }
 
+// GetDevices returns the devices to bind, an empty name means all interfaces
+func (c *AfpacketSniffer) GetDevices() []string {
+	devices := []string{}
+	if c.config.Collectors.AfpacketLiveCapture.Device != "" {
+		devices = append(devices, c.config.Collectors.AfpacketLiveCapture.Device)
+	}
+	devices = append(devices, c.config.Collectors.AfpacketLiveCapture.Devices...)
+	if len(devices) == 0 {
+		devices = append(devices, "")
+	}
+	return devices
+}
+
+// GetBpfExpression returns the filter expression, built from the port if not provided
+func (c *AfpacketSniffer) GetBpfExpression() string {
+	if c.config.Collectors.AfpacketLiveCapture.BpfFilter != "" {
+		return c.config.Collectors.AfpacketLiveCapture.BpfFilter
+	}
+	return fmt.Sprintf("port %d", c.config.Collectors.AfpacketLiveCapture.Port)
+}
+
 func (c *AfpacketSniffer) Stop() {
 	c.LogInfo("stopping collector...")
 
@@ -228,17 +208,39 @@ func (c *AfpacketSniffer) Stop() {
 }
 
 func (c *AfpacketSniffer) Listen() error {
+	expr := c.GetBpfExpression()
+	filter, err := netlib.CompileBpfFilter(expr)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range c.GetDevices() {
+		sock, err := c.openSocket(device, filter)
+		if err != nil {
+			c.closeSockets()
+			return err
+		}
+		c.sockets = append(c.sockets, sock)
+	}
+
+	c.LogInfo("BPF filter applied: %s", expr)
+	return nil
+}
+
+func (c *AfpacketSniffer) openSocket(device string, filter []bpf.Instruction) (*afpacketSocket, error) {
 	// raw socket
 	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, Htons(syscall.ETH_P_ALL))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	sock := &afpacketSocket{fd: fd, device: device}
 
 	// bind to device ?
-	if c.config.Collectors.AfpacketLiveCapture.Device != "" {
-		iface, err := net.InterfaceByName(c.config.Collectors.AfpacketLiveCapture.Device)
+	if device != "" {
+		iface, err := net.InterfaceByName(device)
 		if err != nil {
-			return err
+			syscall.Close(fd)
+			return nil, err
 		}
 
 		ll := syscall.SockaddrLinklayer{
@@ -246,36 +248,190 @@ func (c *AfpacketSniffer) Listen() error {
 		}
 
 		if err := syscall.Bind(fd, &ll); err != nil {
-			return err
+			syscall.Close(fd)
+			return nil, err
 		}
 
 		c.LogInfo("binding with success to iface %q (index %d)", iface.Name, iface.Index)
 	}
 
+	if err := ApplyBpfFilter(filter, fd); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	if c.config.Collectors.AfpacketLiveCapture.TpacketV3 {
+		if err := c.setupRing(sock); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+		return sock, nil
+	}
+
 	// set nano timestamp
-	err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
-	if err != nil {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// read timeout to check periodically if the capture is stopped
+	tv := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return sock, nil
+}
+
+// setupRing enables the TPACKET_V3 ring buffer, the packets are read from memory without a syscall per packet
+func (c *AfpacketSniffer) setupRing(sock *afpacketSocket) error {
+	pageSize := os.Getpagesize()
+	blockSize := c.config.Collectors.AfpacketLiveCapture.BlockSize
+	blockCount := c.config.Collectors.AfpacketLiveCapture.BlockCount
+	if blockSize <= 0 || blockSize%pageSize != 0 {
+		return fmt.Errorf("invalid block-size %d, must be a multiple of the page size %d", blockSize, pageSize)
+	}
+	if blockCount <= 0 {
+		return fmt.Errorf("invalid block-count %d", blockCount)
+	}
+
+	if err := unix.SetsockoptInt(sock.fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
 		return err
 	}
 
-	filter := GetBpfFilter(c.config.Collectors.AfpacketLiveCapture.Port)
-	err = ApplyBpfFilter(filter, fd)
+	req := unix.TpacketReq3{
+		Block_size:     uint32(blockSize),
+		Block_nr:       uint32(blockCount),
+		Frame_size:     uint32(pageSize),
+		Frame_nr:       uint32(blockSize / pageSize * blockCount),
+		Retire_blk_tov: afpacketBlockTimeout,
+	}
+	if err := unix.SetsockoptTpacketReq3(sock.fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &req); err != nil {
+		return err
+	}
+
+	ring, err := unix.Mmap(sock.fd, 0, blockSize*blockCount, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
 	if err != nil {
 		return err
 	}
+	sock.ring = ring
+
+	c.LogInfo("TPACKET_V3 ring enabled on iface %q (%d blocks of %d bytes)", sock.device, blockCount, blockSize)
+	return nil
+}
+
+func (c *AfpacketSniffer) closeSockets() {
+	for _, sock := range c.sockets {
+		if sock.ring != nil {
+			unix.Munmap(sock.ring)
+		}
+		RemoveBpfFilter(sock.fd)
+		syscall.Close(sock.fd)
+	}
+	c.sockets = nil
+}
+
+func (c *AfpacketSniffer) capturing() bool {
+	select {
+	case <-c.stopCapture:
+		return false
+	default:
+		return true
+	}
+}
+
+// readSocket reads the packets one by one with the timestamp provided in the control message,
+// returns on error to stop the capture of the device only
+func (c *AfpacketSniffer) readSocket(sock *afpacketSocket, handler func(data []byte, timestamp time.Time)) error {
+	buf := make([]byte, 65536)
+	oob := make([]byte, 100)
+
+	for c.capturing() {
+		// flags, from
+		bufN, oobn, _, _, err := syscall.Recvmsg(sock.fd, buf, oob, 0)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) {
+				continue
+			}
+			return fmt.Errorf("read error: %w", err)
+		}
+		if bufN == 0 {
+			return errors.New("buf empty")
+		}
+		if bufN > len(buf) {
+			return errors.New("buf overflow")
+		}
+		if oobn == 0 {
+			return errors.New("oob missing")
+		}
+
+		scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return fmt.Errorf("invalid control message: %w", err)
+		}
+		if len(scms) != 1 {
+			continue
+		}
+		scm := scms[0]
+		if scm.Header.Type != syscall.SCM_TIMESTAMPNS {
+			return errors.New("scm timestampns missing")
+		}
+		tsec := binary.LittleEndian.Uint32(scm.Data[:4])
+		nsec := binary.LittleEndian.Uint32(scm.Data[8:12])
+
+		// copy packet data from buffer
+		pkt := make([]byte, bufN)
+		copy(pkt, buf[:bufN])
+
+		handler(pkt, time.Unix(int64(tsec), int64(nsec)))
+	}
+	return nil
+}
 
-	c.LogInfo("BPF filter applied")
+// readRing walks the blocks of the TPACKET_V3 ring, each block is given back to the kernel when all
+// the packets are read. Returns on error to stop the capture of the device only
+func (c *AfpacketSniffer) readRing(sock *afpacketSocket, handler func(data []byte, timestamp time.Time)) error {
+	blockSize := c.config.Collectors.AfpacketLiveCapture.BlockSize
+	blockCount := len(sock.ring) / blockSize
+	pollFds := []unix.PollFd{{Fd: int32(sock.fd), Events: unix.POLLIN | unix.POLLERR}}
+
+	for block := 0; c.capturing(); {
+		base := block * blockSize
+		desc := (*unix.TpacketBlockDesc)(unsafe.Pointer(&sock.ring[base]))
+		hdr := (*unix.TpacketHdrV1)(unsafe.Pointer(&desc.Hdr[0]))
+
+		// block still owned by the kernel, wait for packets
+		if atomic.LoadUint32(&hdr.Block_status)&unix.TP_STATUS_USER == 0 {
+			if _, err := unix.Poll(pollFds, afpacketBlockTimeout); err != nil && !errors.Is(err, unix.EINTR) {
+				return fmt.Errorf("poll error: %w", err)
+			}
+			continue
+		}
+
+		offset := int(hdr.Offset_to_first_pkt)
+		for i := uint32(0); i < hdr.Num_pkts; i++ {
+			frame := (*unix.Tpacket3Hdr)(unsafe.Pointer(&sock.ring[base+offset]))
+			start := base + offset + int(frame.Mac)
+
+			// copy packet data from the ring
+			pkt := make([]byte, frame.Snaplen)
+			copy(pkt, sock.ring[start:start+int(frame.Snaplen)])
+
+			handler(pkt, time.Unix(int64(frame.Sec), int64(frame.Nsec)))
+			offset += int(frame.Next_offset)
+		}
 
-	c.fd = fd
+		// release the block
+		atomic.StoreUint32(&hdr.Block_status, unix.TP_STATUS_KERNEL)
+		block = (block + 1) % blockCount
+	}
 	return nil
 }
 
 func (c *AfpacketSniffer) Run() {
 	c.LogInfo("starting collector...")
-	defer RemoveBpfFilter(c.fd)
-	defer syscall.Close(c.fd)
 
-	if c.fd == 0 {
+	if len(c.sockets) == 0 {
 		if err := c.Listen(); err != nil {
 			c.LogError("init raw socket failed: %v\n", err)
 			os.Exit(1) // nolint
@@ -353,95 +509,41 @@ func (c *AfpacketSniffer) Run() {
 		}
 	}()
 
-	go func() {
-		buf := make([]byte, 65536)
-		oob := make([]byte, 100)
-
-		for {
-			// flags, from
-			bufN, oobn, _, _, err := syscall.Recvmsg(c.fd, buf, oob, 0)
-			if err != nil {
-				if errors.Is(err, syscall.EINTR) {
-					continue
-				} else {
-					panic(err)
-				}
-			}
-			if bufN == 0 {
-				panic("buf empty")
-			}
-			if bufN > len(buf) {
-				panic("buf overflow")
-			}
-			if oobn == 0 {
-				panic("oob missing")
-			}
-
-			scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
-			if err != nil {
-				panic(err)
-			}
-			if len(scms) != 1 {
-				continue
-			}
-			scm := scms[0]
-			if scm.Header.Type != syscall.SCM_TIMESTAMPNS {
-				panic("scm timestampns missing")
-			}
-			tsec := binary.LittleEndian.Uint32(scm.Data[:4])
-			nsec := binary.LittleEndian.Uint32(scm.Data[8:12])
-			timestamp := time.Unix(int64(tsec), int64(nsec))
-
-			// copy packet data from buffer
-			pkt := make([]byte, bufN)
-			copy(pkt, buf[:bufN])
-
-			// decode minimal layers
-			packet := gopacket.NewPacket(pkt, netDecoder, gopacket.NoCopy)
-			packet.Metadata().CaptureLength = len(packet.Data())
-			packet.Metadata().Length = len(packet.Data())
-			packet.Metadata().Timestamp = timestamp
-
-			// some security checks
-			if packet.NetworkLayer() == nil {
-				continue
-			}
-			if packet.TransportLayer() == nil {
-				continue
-			}
+	// decode the packets captured on all devices
+	handler := func(pkt []byte, timestamp time.Time) {
+		// decode minimal layers
+		packet := gopacket.NewPacket(pkt, netDecoder, gopacket.NoCopy)
+		packet.Metadata().CaptureLength = len(packet.Data())
+		packet.Metadata().Length = len(packet.Data())
+		packet.Metadata().Timestamp = timestamp
 
-			// ipv4 fragmented packet ?
-			if packet.NetworkLayer().LayerType() == layers.LayerTypeIPv4 {
-				ip4 := packet.NetworkLayer().(*layers.IPv4)
-				if ip4.Flags&layers.IPv4MoreFragments == 1 || ip4.FragOffset > 0 {
-					fragIP4Chan <- packet
-					continue
-				}
-			}
+		netlib.PacketDispatcher(packet, udpChan, tcpChan, fragIP4Chan, fragIP6Chan)
+	}
 
-			// ipv6 fragmented packet ?
-			if packet.NetworkLayer().LayerType() == layers.LayerTypeIPv6 {
-				v6frag := packet.Layer(layers.LayerTypeIPv6Fragment)
-				if v6frag != nil {
-					fragIP6Chan <- packet
-					continue
-				}
+	var wg sync.WaitGroup
+	for _, sock := range c.sockets {
+		wg.Add(1)
+		go func(sock *afpacketSocket) {
+			defer wg.Done()
+			var err error
+			if sock.ring != nil {
+				err = c.readRing(sock, handler)
+			} else {
+				err = c.readSocket(sock, handler)
 			}
-
-			// tcp or udp packets ?
-			if packet.TransportLayer().LayerType() == layers.LayerTypeUDP {
-				udpChan <- packet
+			if err != nil {
+				c.LogError("capture stopped on iface %q: %v", sock.device, err)
 			}
+		}(sock)
+	}
 
-			if packet.TransportLayer().LayerType() == layers.LayerTypeTCP {
-				tcpChan <- packet
-			}
-		}
+	<-c.exit
 
-	}()
+	// stop the capture before to release the sockets and rings
+	close(c.stopCapture)
+	wg.Wait()
+	c.closeSockets()
 
-	<-c.exit
-	close(dnsChan)
 	close(c.configChan)
 
 	// stop dns processor
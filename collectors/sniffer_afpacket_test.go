@@ -6,12 +6,15 @@ package collectors
 import (
 	"log"
 	"net"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 	"github.com/dmachard/go-dnscollector/pkgutils"
 	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
 )
 
 func TestAfpacketSnifferRun(t *testing.T) {
@@ -33,3 +36,74 @@ func TestAfpacketSnifferRun(t *testing.T) {
 		}
 	}
 }
+
+func TestAfpacketSnifferRunTpacketV3(t *testing.T) {
+	g := pkgutils.NewFakeLogger()
+	config := pkgconfig.GetFakeConfig()
+	config.Collectors.AfpacketLiveCapture.Devices = []string{"lo"}
+	config.Collectors.AfpacketLiveCapture.BpfFilter = "udp port 53 or 5353"
+	config.Collectors.AfpacketLiveCapture.TpacketV3 = true
+
+	c := NewAfpacketSniffer([]pkgutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		log.Fatal("collector sniffer listening error: ", err)
+	}
+	go c.Run()
+
+	// send dns query on a non-standard port
+	dnsquery := new(dns.Msg)
+	dnsquery.SetQuestion("dns.collector.", dns.TypeA)
+	payload, _ := dnsquery.Pack()
+	conn, err := net.Dial("udp", "127.0.0.1:5353")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(payload)
+
+	// waiting message in channel
+	for {
+		msg := <-g.GetInputChannel()
+		if msg.DNSTap.Operation == dnsutils.DNSTapClientQuery && msg.DNS.Qname == "dns.collector" {
+			break
+		}
+	}
+	c.Stop()
+}
+
+func TestAfpacketSnifferInvalidFilter(t *testing.T) {
+	config := pkgconfig.GetFakeConfig()
+	config.Collectors.AfpacketLiveCapture.BpfFilter = "port dns"
+
+	c := NewAfpacketSniffer([]pkgutils.Worker{}, config, logger.New(false), "test")
+	if err := c.Listen(); err == nil {
+		t.Errorf("error expected with an invalid bpf filter")
+	}
+}
+
+func TestAfpacketSnifferReadSocketError(t *testing.T) {
+	c := NewAfpacketSniffer(nil, pkgconfig.GetFakeConfig(), logger.New(false), "test")
+
+	// packet without timestamp in the control message
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	if _, err := syscall.Write(fds[1], []byte{0x01}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the error is returned to stop the capture of the device only
+	sock := &afpacketSocket{fd: fds[0], device: "test"}
+	if err := c.readSocket(sock, func(data []byte, timestamp time.Time) {}); err == nil {
+		t.Errorf("error expected without control message")
+	}
+
+	// invalid socket
+	sock = &afpacketSocket{fd: -1, device: "test"}
+	if err := c.readSocket(sock, func(data []byte, timestamp time.Time) {}); err == nil {
+		t.Errorf("read error expected")
+	}
+}
@@ -6,13 +6,13 @@ package collectors
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
+	"errors"
 	"net"
 	"os"
 	"time"
 
 	"github.com/cilium/ebpf/link"
-	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/ringbuf"
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/netlib"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
@@ -20,28 +20,10 @@ import (
 	"github.com/dmachard/go-dnscollector/processors"
 	"github.com/dmachard/go-dnscollector/xdp"
 	"github.com/dmachard/go-logger"
+	"github.com/google/gopacket"
 	"golang.org/x/sys/unix"
 )
 
-func GetIPAddress[T uint32 | [4]uint32](ip T, mapper func(T) net.IP) net.IP {
-	return mapper(ip)
-}
-
-func ConvertIP4(ip uint32) net.IP {
-	addr := make(net.IP, net.IPv4len)
-	binary.BigEndian.PutUint32(addr, ip)
-	return addr
-}
-
-func ConvertIP6(ip [4]uint32) net.IP {
-	addr := make(net.IP, net.IPv6len)
-	binary.LittleEndian.PutUint32(addr[0:], ip[0])
-	binary.LittleEndian.PutUint32(addr[4:], ip[1])
-	binary.LittleEndian.PutUint32(addr[8:], ip[2])
-	binary.LittleEndian.PutUint32(addr[12:], ip[3])
-	return addr
-}
-
 type XDPSniffer struct {
 	done          chan bool
 	exit          chan bool
@@ -119,6 +101,15 @@ func (c *XDPSniffer) Stop() {
 	close(c.done)
 }
 
+// GetPorts returns the dns ports to capture
+func (c *XDPSniffer) GetPorts() []int {
+	ports := []int{}
+	if c.config.Collectors.XdpLiveCapture.Port > 0 {
+		ports = append(ports, c.config.Collectors.XdpLiveCapture.Port)
+	}
+	return append(ports, c.config.Collectors.XdpLiveCapture.Ports...)
+}
+
 func (c *XDPSniffer) Run() {
 	c.LogInfo("starting collector...")
 
@@ -144,6 +135,18 @@ func (c *XDPSniffer) Run() {
 	}
 	defer objs.Close()
 
+	// Set the dns ports to filter in the kernel
+	for _, port := range c.GetPorts() {
+		if port <= 0 || port > 65535 {
+			c.LogError("invalid port %d", port)
+			os.Exit(1)
+		}
+		if err := objs.DnsPorts.Put(uint16(port), uint8(1)); err != nil {
+			c.LogError("could not set the dns ports: %s", err)
+			os.Exit(1)
+		}
+	}
+
 	// Attach the program.
 	l, err := link.AttachXDP(link.XDPOptions{
 		Program:   objs.XdpSniffer,
@@ -155,17 +158,36 @@ func (c *XDPSniffer) Run() {
 	}
 	defer l.Close()
 
-	c.LogInfo("XDP program attached to iface %q (index %d)", iface.Name, iface.Index)
+	c.LogInfo("XDP program attached to iface %q (index %d) for ports %v", iface.Name, iface.Index, c.GetPorts())
 
-	perfEvent, err := perf.NewReader(objs.Pkts, 1<<24)
+	ringReader, err := ringbuf.NewReader(objs.Pkts)
 	if err != nil {
 		panic(err)
 	}
+	defer ringReader.Close()
+
+	dnsChan := make(chan netlib.DNSPacket)
+	udpChan := make(chan gopacket.Packet)
+	tcpChan := make(chan gopacket.Packet)
+	fragIP4Chan := make(chan gopacket.Packet)
+	fragIP6Chan := make(chan gopacket.Packet)
 
-	dnsChan := make(chan dnsutils.DNSMessage)
+	netDecoder := &netlib.NetDecoder{}
+
+	// defrag ipv4
+	go netlib.IPDefragger(fragIP4Chan, udpChan, tcpChan)
+	// defrag ipv6
+	go netlib.IPDefragger(fragIP6Chan, udpChan, tcpChan)
+	// tcp assembly
+	go netlib.TCPAssembler(tcpChan, dnsChan, 0)
+	// udp processor
+	go netlib.UDPProcessor(udpChan, dnsChan, 0)
 
 	// goroutine to read all packets reassembled
 	go func() {
+		// prepare dns message
+		dm := dnsutils.DNSMessage{}
+
 		for {
 			select {
 			// new config provided?
@@ -180,37 +202,59 @@ func (c *XDPSniffer) Run() {
 				dnsProcessor.ConfigChan <- cfg
 
 			// dns message to read ?
-			case dm := <-dnsChan:
+			case dnsPacket := <-dnsChan:
+				// reset
+				dm.Init()
+
+				dm.NetworkInfo.Family = dnsPacket.IPLayer.EndpointType().String()
+				dm.NetworkInfo.QueryIP = dnsPacket.IPLayer.Src().String()
+				dm.NetworkInfo.ResponseIP = dnsPacket.IPLayer.Dst().String()
+				dm.NetworkInfo.QueryPort = dnsPacket.TransportLayer.Src().String()
+				dm.NetworkInfo.ResponsePort = dnsPacket.TransportLayer.Dst().String()
+				dm.NetworkInfo.Protocol = dnsPacket.TransportLayer.EndpointType().String()
+				dm.NetworkInfo.IPDefragmented = dnsPacket.IPDefragmented
+				dm.NetworkInfo.TCPReassembled = dnsPacket.TCPReassembled
+
+				dm.DNS.Payload = dnsPacket.Payload
+				dm.DNS.Length = len(dnsPacket.Payload)
 
 				// update identity with config ?
 				dm.DNSTap.Identity = c.identity
 
-				dnsProcessor.GetChannel() <- dm
+				timestamp := dnsPacket.Timestamp.UnixNano()
+				seconds := timestamp / int64(time.Second)
+				dm.DNSTap.TimeSec = int(seconds)
+				dm.DNSTap.TimeNsec = int(timestamp - seconds*int64(time.Second)*int64(time.Nanosecond))
 
+				// send DNS message to DNS processor
+				dnsProcessor.GetChannel() <- dm
 			}
 		}
 	}()
 
 	go func() {
 		var pkt xdp.BpfPktEvent
+		hdrLen := binary.Size(pkt)
 		for {
-			// The data submitted via bpf_perf_event_output.
-			record, err := perfEvent.Read()
+			// The data submitted via bpf_ringbuf_output.
+			record, err := ringReader.Read()
 			if err != nil {
+				if errors.Is(err, ringbuf.ErrClosed) {
+					return
+				}
 				c.LogError("BPF reading map: %s", err)
 				break
 			}
 
-			if record.LostSamples != 0 {
-				c.LogError("BPF dump: Dropped %d samples from kernel perf buffer", record.LostSamples)
-				continue
-			}
-
 			reader := bytes.NewReader(record.RawSample)
-			if err := binary.Read(reader, binary.LittleEndian, &pkt); err != nil {
+			if err := binary.Read(reader, binary.NativeEndian, &pkt); err != nil {
 				c.LogError("BPF reading sample: %s", err)
 				break
 			}
+			if len(record.RawSample) < hdrLen+int(pkt.CapLen) {
+				c.LogError("BPF dump: truncated sample")
+				continue
+			}
 
 			// adjust arrival time
 			timenow := time.Now().UTC()
@@ -220,56 +264,18 @@ func (c *XDPSniffer) Run() {
 			delta3 := time.Duration(uint64(unix.TimespecToNsec(ts))-pkt.Timestamp) * time.Nanosecond
 			tsAdjusted := timenow.Add(-(delta3 + elapsed))
 
-			// convert ip
-			var saddr, daddr net.IP
-			if pkt.IpVersion == 0x0800 {
-				saddr = GetIPAddress(pkt.SrcAddr, ConvertIP4)
-				daddr = GetIPAddress(pkt.DstAddr, ConvertIP4)
-			} else {
-				saddr = GetIPAddress(pkt.SrcAddr6, ConvertIP6)
-				daddr = GetIPAddress(pkt.DstAddr6, ConvertIP6)
-			}
-
-			// prepare DnsMessage
-			dm := dnsutils.DNSMessage{}
-			dm.Init()
-
-			dm.DNSTap.TimeSec = int(tsAdjusted.Unix())
-			dm.DNSTap.TimeNsec = int(tsAdjusted.UnixNano() - tsAdjusted.Unix()*1e9)
-
-			if pkt.SrcPort == 53 {
-				dm.DNSTap.Operation = dnsutils.DNSTapClientResponse
-			} else {
-				dm.DNSTap.Operation = dnsutils.DNSTapClientQuery
-			}
-
-			dm.NetworkInfo.QueryIP = saddr.String()
-			dm.NetworkInfo.QueryPort = fmt.Sprint(pkt.SrcPort)
-			dm.NetworkInfo.ResponseIP = daddr.String()
-			dm.NetworkInfo.ResponsePort = fmt.Sprint(pkt.DstPort)
-
-			if pkt.IpVersion == 0x0800 {
-				dm.NetworkInfo.Family = netlib.ProtoIPv4
-			} else {
-				dm.NetworkInfo.Family = netlib.ProtoIPv6
-			}
-
-			if pkt.IpProto == 0x11 {
-				dm.NetworkInfo.Protocol = netlib.ProtoUDP
-				dm.DNS.Payload = record.RawSample[int(pkt.PktOffset)+int(pkt.PayloadOffset):]
-				dm.DNS.Length = len(dm.DNS.Payload)
-			} else {
-				dm.NetworkInfo.Protocol = netlib.ProtoTCP
-				dm.DNS.Payload = record.RawSample[int(pkt.PktOffset)+int(pkt.PayloadOffset)+2:]
-				dm.DNS.Length = len(dm.DNS.Payload)
-			}
+			// decode minimal layers
+			data := record.RawSample[hdrLen : hdrLen+int(pkt.CapLen)]
+			packet := gopacket.NewPacket(data, netDecoder, gopacket.NoCopy)
+			packet.Metadata().CaptureLength = len(data)
+			packet.Metadata().Length = int(pkt.PktLen)
+			packet.Metadata().Timestamp = tsAdjusted
 
-			dnsChan <- dm
+			netlib.PacketDispatcher(packet, udpChan, tcpChan, fragIP4Chan, fragIP6Chan)
 		}
 	}()
 
 	<-c.exit
-	close(dnsChan)
 	close(c.configChan)
 
 	// stop dns processor
@@ -0,0 +1,162 @@
+//go:build linux
+// +build linux
+
+package collectors
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-logger"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/miekg/dns"
+	"golang.org/x/sys/unix"
+)
+
+func TestXdpSnifferRun(t *testing.T) {
+	// tcp server to receive the query
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			buf := make([]byte, 512)
+			conn.Read(buf)
+			conn.Close()
+		}
+	}()
+	tcpPort := listener.Addr().(*net.TCPAddr).Port
+
+	g := pkgutils.NewFakeLogger()
+	config := pkgconfig.GetFakeConfig()
+	config.Collectors.XdpLiveCapture.Device = "lo"
+	config.Collectors.XdpLiveCapture.Ports = []int{5353, tcpPort}
+
+	c := NewXDPSniffer([]pkgutils.Worker{g}, config, logger.New(false), "test")
+	go c.Run()
+	time.Sleep(time.Second)
+
+	dnsquery := new(dns.Msg)
+	dnsquery.SetQuestion("dns.collector.", dns.TypeA)
+	payload, _ := dnsquery.Pack()
+
+	// send dns query over udp
+	udpConn, err := net.Dial("udp", "127.0.0.1:5353")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	udpConn.Write(payload)
+
+	// send dns query over tcp
+	tcpConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	frame := make([]byte, 2)
+	binary.BigEndian.PutUint16(frame, uint16(len(payload)))
+	tcpConn.Write(append(frame, payload...))
+
+	// waiting messages in channel
+	protocols := map[string]bool{}
+	timeout := time.After(5 * time.Second)
+	for len(protocols) < 2 {
+		select {
+		case msg := <-g.GetInputChannel():
+			if msg.DNSTap.Operation == dnsutils.DNSTapClientQuery && msg.DNS.Qname == "dns.collector" {
+				protocols[msg.NetworkInfo.Protocol] = true
+			}
+		case <-timeout:
+			t.Fatalf("dns queries not captured, got %v", protocols)
+		}
+	}
+	if !protocols[netlib.ProtoUDP] || !protocols[netlib.ProtoTCP] {
+		t.Errorf("udp and tcp queries expected, got %v", protocols)
+	}
+	c.Stop()
+}
+
+func TestXdpSnifferRun_Fragments(t *testing.T) {
+	g := pkgutils.NewFakeLogger()
+	config := pkgconfig.GetFakeConfig()
+	config.Collectors.XdpLiveCapture.Device = "lo"
+	config.Collectors.XdpLiveCapture.Ports = []int{5353}
+
+	c := NewXDPSniffer([]pkgutils.Worker{g}, config, logger.New(false), "test")
+	go c.Run()
+	time.Sleep(time.Second)
+
+	// dns query larger than the first fragment
+	dnsquery := new(dns.Msg)
+	dnsquery.SetQuestion("dns.collector.", dns.TypeA)
+	dnsquery.SetEdns0(4096, false)
+	opt := dnsquery.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, 600)})
+	payload, _ := dnsquery.Pack()
+
+	// udp datagram with the checksum
+	ip := &layers.IPv4{Version: 4, TTL: 64, Id: 4242, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.IPv4(127, 0, 0, 1), DstIP: net.IPv4(127, 0, 0, 1)}
+	udp := &layers.UDP{SrcPort: 45000, DstPort: 5353}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, udp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+	datagram := buf.Bytes()
+
+	// send two ipv4 fragments with a raw socket
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_RAW, unix.IPPROTO_RAW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fd)
+	for _, frag := range []struct {
+		offset int
+		data   []byte
+	}{{0, datagram[:512]}, {512, datagram[512:]}} {
+		ip.Flags = 0
+		if frag.offset == 0 {
+			ip.Flags = layers.IPv4MoreFragments
+		}
+		ip.FragOffset = uint16(frag.offset / 8)
+
+		fragBuf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(fragBuf, opts, ip, gopacket.Payload(frag.data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := unix.Sendto(fd, fragBuf.Bytes(), 0, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the dns query is reassembled
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-g.GetInputChannel():
+			if msg.DNS.Qname != "dns.collector" {
+				continue
+			}
+			if !msg.NetworkInfo.IPDefragmented || msg.NetworkInfo.Protocol != netlib.ProtoUDP {
+				t.Errorf("defragmented udp query expected, got %v", msg.NetworkInfo)
+			}
+			c.Stop()
+			return
+		case <-timeout:
+			t.Fatalf("fragmented dns query not captured")
+		}
+	}
+}
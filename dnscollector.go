@@ -104,6 +104,7 @@ func main() {
 	// init active collectors and loggers
 	mapLoggers := make(map[string]pkgutils.Worker)
 	mapCollectors := make(map[string]pkgutils.Worker)
+	mapLinks := make(map[string]*pkglinker.PipelineLinks)
 
 	// running mode,
 	// multiplexer ?
@@ -115,13 +116,36 @@ func main() {
 	// or pipeline ?
 	if len(config.Pipelines) > 0 {
 		logger.Info("main - pipelines mode enabled")
-		err := pkglinker.InitPipelines(mapLoggers, mapCollectors, config, logger)
+		err := pkglinker.InitPipelines(mapLoggers, mapCollectors, mapLinks, config, logger)
 		if err != nil {
 			logger.Error("main - %s", err.Error())
 			os.Exit(1)
 		}
 	}
 
+	// reload the config, on SIGHUP or from the admin api
+	reload := func() error {
+		// read config
+		if err := pkgutils.ReloadConfig(configPath, config, dmRef); err != nil {
+			return fmt.Errorf("reload config error: %v", err)
+		}
+
+		// reload logger and multiplexer
+		InitLogger(logger, config)
+		if pkglinker.IsMuxEnabled(config) {
+			pkglinker.ReloadMultiplexer(mapLoggers, mapCollectors, config, logger)
+		}
+		if len(config.Pipelines) > 0 {
+			if err := pkglinker.ReloadPipelines(mapLoggers, mapCollectors, mapLinks, config, logger); err != nil {
+				return fmt.Errorf("reload pipelines error: %v", err)
+			}
+		}
+		return nil
+	}
+	admin := pkglinker.NewAdminAPI(config, configPath, dmRef, mapLoggers, mapCollectors, mapLinks, reload, logger)
+	adminEnabled := config.Global.Admin.Enable
+	telemetryEnabled := config.Global.Telemetry.Enable
+
 	// Handle Ctrl-C with SIG TERM and SIGHUP
 	sigTerm := make(chan os.Signal, 1)
 	sigHUP := make(chan os.Signal, 1)
@@ -134,17 +158,8 @@ func main() {
 			select {
 			case <-sigHUP:
 				logger.Info("main - SIGHUP received")
-
-				// read config
-				err := pkgutils.ReloadConfig(configPath, config, dmRef)
-				if err != nil {
-					panic(fmt.Sprintf("main - reload config error:  %v", err))
-				}
-
-				// reload logger and multiplexer
-				InitLogger(logger, config)
-				if pkglinker.IsMuxEnabled(config) {
-					pkglinker.ReloadMultiplexer(mapLoggers, mapCollectors, config, logger)
+				if err := admin.Reload(); err != nil {
+					logger.Error("main - %s", err.Error())
 				}
 
 			case <-sigTerm:
@@ -153,6 +168,13 @@ func main() {
 				// stop all workers
 				logger.Info("main - stopping...")
 
+				if adminEnabled {
+					admin.Stop()
+				}
+				if telemetryEnabled {
+					pkgutils.Telemetry.Stop()
+				}
+
 				for _, c := range mapCollectors {
 					c.Stop()
 				}
@@ -161,6 +183,10 @@ func main() {
 					l.Stop()
 				}
 
+				for _, r := range mapLinks {
+					r.Stop()
+				}
+
 				// unblock main function
 				done <- true
 
@@ -185,6 +211,23 @@ func main() {
 		go c.Run()
 	}
 
+	// admin api, ready when all workers are running
+	admin.SetReady(true)
+	if adminEnabled {
+		if err := admin.Listen(); err != nil {
+			logger.Fatal(pkgutils.PrefixLogAdmin, err)
+		}
+		go admin.ListenAndServe()
+	}
+
+	// internal metrics
+	if telemetryEnabled {
+		if err := pkgutils.Telemetry.Listen(config, logger); err != nil {
+			logger.Fatal(pkgutils.PrefixLogTelemetry, err)
+		}
+		go pkgutils.Telemetry.ListenAndServe(config, logger)
+	}
+
 	// block main
 	<-done
 
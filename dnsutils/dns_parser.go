@@ -1,11 +1,15 @@
 package dnsutils
 
 import (
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 )
@@ -63,7 +67,7 @@ var (
 		49:    "DHCID",
 		50:    "NSEC3",
 		51:    "NSEC3PARAM",
-		52:    "TSLA",
+		52:    "TLSA",
 		53:    "SMIMEA",
 		55:    "HIP",
 		56:    "NINFO",
@@ -71,6 +75,7 @@ var (
 		60:    "CDNSKEY",
 		61:    "OPENPGPKEY",
 		62:    "CSYNC",
+		63:    "ZONEMD",
 		64:    "SVCB",
 		65:    "HTTPS",
 		99:    "SPF",
@@ -124,6 +129,7 @@ var ErrDecodeDNSLabelTooShort = errors.New("malformed pkt, dns payload too short
 var ErrDecodeQuestionQtypeTooShort = errors.New("malformed pkt, not enough data to decode qtype")
 var ErrDecodeDNSAnswerTooShort = errors.New("malformed pkt, not enough data to decode answer")
 var ErrDecodeDNSAnswerRdataTooShort = errors.New("malformed pkt, not enough data to decode rdata answer")
+var ErrDecodeDNSAnswerRdataInvalid = errors.New("malformed pkt, invalid rdata answer")
 
 func RdatatypeToString(rrtype int) string {
 	if value, ok := Rdatatypes[rrtype]; ok {
@@ -153,6 +159,15 @@ func (e *decodingError) Unwrap() error {
 	return e.err
 }
 
+// GetDecodingErrorPart returns the part of the DNS packet which can not be decoded
+func GetDecodingErrorPart(err error) string {
+	var decodingErr *decodingError
+	if errors.As(err, &decodingErr) {
+		return decodingErr.part
+	}
+	return ""
+}
+
 type DNSHeader struct {
 	ID      int
 	Qr      int
@@ -568,6 +583,32 @@ func ParseRdata(rdatatype string, rdata []byte, payload []byte, rdataOffset int)
 		ret, err = ParseSOA(rdataOffset, payload)
 	case "HTTPS", "SVCB":
 		ret, err = ParseSVCB(rdata)
+	case "DNSKEY", "CDNSKEY":
+		ret, err = ParseDNSKEY(rdata)
+	case "DS", "CDS":
+		ret, err = ParseDS(rdata)
+	case "RRSIG":
+		ret, err = ParseRRSIG(rdataOffset, payload)
+	case "NSEC":
+		ret, err = ParseNSEC(rdataOffset, payload)
+	case "NSEC3":
+		ret, err = ParseNSEC3(rdata)
+	case "CAA":
+		ret, err = ParseCAA(rdata)
+	case "NAPTR":
+		ret, err = ParseNAPTR(rdataOffset, payload)
+	case "TLSA":
+		ret, err = ParseTLSA(rdata)
+	case "SSHFP":
+		ret, err = ParseSSHFP(rdata)
+	case "DNAME":
+		ret, err = ParseDNAME(rdataOffset, payload)
+	case "LOC":
+		ret, err = ParseLOC(rdata)
+	case "HINFO":
+		ret, err = ParseHINFO(rdata)
+	case "ZONEMD":
+		ret, err = ParseZONEMD(rdata)
 	default:
 		ret = "-"
 		err = nil
@@ -783,6 +824,447 @@ func ParsePTR(rdataOffset int, payload []byte) (string, error) {
 	return ptr, err
 }
 
+/*
+DNSKEY, CDNSKEY
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|              Flags            |    Protocol   |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   Algorithm   |                               /
++--+--+--+--+--+--+--+--+                       /
+/                   Public Key                  /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDNSKEY(rdata []byte) (string, error) {
+	if len(rdata) < 4 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	flags := binary.BigEndian.Uint16(rdata[0:2])
+	publicKey := base64.StdEncoding.EncodeToString(rdata[4:])
+	return fmt.Sprintf("%d %d %d %s", flags, rdata[2], rdata[3], publicKey), nil
+}
+
+/*
+DS, CDS
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|           Key Tag             |  Algorithm    |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  Digest Type  |                               /
++--+--+--+--+--+--+--+--+                       /
+/                    Digest                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDS(rdata []byte) (string, error) {
+	if len(rdata) < 4 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	keyTag := binary.BigEndian.Uint16(rdata[0:2])
+	digest := strings.ToUpper(hex.EncodeToString(rdata[4:]))
+	return fmt.Sprintf("%d %d %d %s", keyTag, rdata[2], rdata[3], digest), nil
+}
+
+/*
+RRSIG
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|        Type Covered           |  Algorithm    |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|     Labels    |         Original TTL          /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/               |    Signature Expiration       /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/               |    Signature Inception        /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/               |            Key Tag            |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                Signer's Name                  /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                   Signature                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseRRSIG(rdataOffset int, payload []byte) (string, error) {
+	// fixed part and at least one byte for the signer
+	if len(payload) < rdataOffset+19 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	rdata := payload[rdataOffset:]
+	typeCovered := rdatatypeToPresentation(int(binary.BigEndian.Uint16(rdata[0:2])))
+	originalTTL := binary.BigEndian.Uint32(rdata[4:8])
+	expiration := dnssecTimeToString(binary.BigEndian.Uint32(rdata[8:12]))
+	inception := dnssecTimeToString(binary.BigEndian.Uint32(rdata[12:16]))
+	keyTag := binary.BigEndian.Uint16(rdata[16:18])
+
+	signer, offset, err := ParseLabels(rdataOffset+18, payload)
+	if err != nil {
+		return "", err
+	}
+	signature := base64.StdEncoding.EncodeToString(payload[offset:])
+
+	rrsig := fmt.Sprintf("%s %d %d %d %s %s %d %s %s", typeCovered, rdata[2], rdata[3], originalTTL,
+		expiration, inception, keyTag, labelsToPresentation(signer), signature)
+	return rrsig, nil
+}
+
+/*
+NSEC
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                      Next Domain Name         /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                       Type Bit Maps           /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC(rdataOffset int, payload []byte) (string, error) {
+	nextDomain, offset, err := ParseLabels(rdataOffset, payload)
+	if err != nil {
+		return "", err
+	}
+	types, err := ParseTypeBitMaps(payload[offset:])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(append([]string{labelsToPresentation(nextDomain)}, types...), " "), nil
+}
+
+/*
+NSEC3
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   Hash Alg.   |     Flags     |   Iterations  |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|               |  Salt Length  |     Salt      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  Hash Length  |             Next Hashed Owner /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                         Type Bit Maps         /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC3(rdata []byte) (string, error) {
+	if len(rdata) < 5 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	iterations := binary.BigEndian.Uint16(rdata[2:4])
+
+	offset := 4
+	saltLength := int(rdata[offset])
+	offset++
+	if len(rdata) < offset+saltLength+1 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	salt := "-"
+	if saltLength > 0 {
+		salt = strings.ToUpper(hex.EncodeToString(rdata[offset : offset+saltLength]))
+	}
+	offset += saltLength
+
+	hashLength := int(rdata[offset])
+	offset++
+	if len(rdata) < offset+hashLength {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	nextHashed := base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(rdata[offset : offset+hashLength])
+	offset += hashLength
+
+	types, err := ParseTypeBitMaps(rdata[offset:])
+	if err != nil {
+		return "", err
+	}
+
+	nsec3 := fmt.Sprintf("%d %d %d %s %s", rdata[0], rdata[1], iterations, salt, nextHashed)
+	return strings.Join(append([]string{nsec3}, types...), " "), nil
+}
+
+// ParseTypeBitMaps decodes the list of types of the NSEC and NSEC3 records
+func ParseTypeBitMaps(rdata []byte) ([]string, error) {
+	types := []string{}
+	offset := 0
+	lastWindow := -1
+	for offset < len(rdata) {
+		if len(rdata) < offset+2 {
+			return nil, ErrDecodeDNSAnswerRdataTooShort
+		}
+		window := int(rdata[offset])
+		length := int(rdata[offset+1])
+		offset += 2
+
+		// windows are sorted and have a bitmap from 1 to 32 bytes
+		if window <= lastWindow || length == 0 || length > 32 {
+			return nil, ErrDecodeDNSAnswerRdataInvalid
+		}
+		if len(rdata) < offset+length {
+			return nil, ErrDecodeDNSAnswerRdataTooShort
+		}
+
+		for i, b := range rdata[offset : offset+length] {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					types = append(types, rdatatypeToPresentation(window*256+i*8+bit))
+				}
+			}
+		}
+		offset += length
+		lastWindow = window
+	}
+	return types, nil
+}
+
+/*
+CAA
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|     Flags     |   Tag Length  |      Tag      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                     Value                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseCAA(rdata []byte) (string, error) {
+	if len(rdata) < 2 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	tagLength := int(rdata[1])
+	if len(rdata) < 2+tagLength {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	tag := string(rdata[2 : 2+tagLength])
+	value := txtToStr(rdata[2+tagLength:])
+	return fmt.Sprintf("%d %s %s", rdata[0], tag, value), nil
+}
+
+/*
+NAPTR
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                     ORDER                     |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   PREFERENCE                  |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                     FLAGS                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                   SERVICES                    /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    REGEXP                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  REPLACEMENT                  /
+/                                               /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNAPTR(rdataOffset int, payload []byte) (string, error) {
+	if len(payload) < rdataOffset+4 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	order := binary.BigEndian.Uint16(payload[rdataOffset : rdataOffset+2])
+	preference := binary.BigEndian.Uint16(payload[rdataOffset+2 : rdataOffset+4])
+
+	offset := rdataOffset + 4
+	fields := []string{}
+	for i := 0; i < 3; i++ {
+		field, next, err := parseCharacterString(payload, offset)
+		if err != nil {
+			return "", err
+		}
+		fields = append(fields, txtToStr(field))
+		offset = next
+	}
+
+	replacement, _, err := ParseLabels(offset, payload)
+	if err != nil {
+		return "", err
+	}
+
+	naptr := fmt.Sprintf("%d %d %s %s", order, preference, strings.Join(fields, " "), labelsToPresentation(replacement))
+	return naptr, nil
+}
+
+/*
+TLSA
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  Cert. Usage  |   Selector    | Matching Type |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/       Certificate Association Data            /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseTLSA(rdata []byte) (string, error) {
+	if len(rdata) < 3 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	certificate := hex.EncodeToString(rdata[3:])
+	return fmt.Sprintf("%d %d %d %s", rdata[0], rdata[1], rdata[2], certificate), nil
+}
+
+/*
+SSHFP
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   algorithm   |    fp type    |               /
++--+--+--+--+--+--+--+--+--+--+--+--+           /
+/                 fingerprint                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseSSHFP(rdata []byte) (string, error) {
+	if len(rdata) < 2 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	fingerprint := strings.ToUpper(hex.EncodeToString(rdata[2:]))
+	return fmt.Sprintf("%d %d %s", rdata[0], rdata[1], fingerprint), nil
+}
+
+/*
+DNAME
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    TARGET                     /
+/                                               /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDNAME(rdataOffset int, payload []byte) (string, error) {
+	target, _, err := ParseLabels(rdataOffset, payload)
+	if err != nil {
+		return "", err
+	}
+	return labelsToPresentation(target), nil
+}
+
+/*
+LOC
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|        VERSION        |         SIZE          |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|       HORIZ PRE       |       VERT PRE        |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   LATITUDE                    |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   LONGITUDE                   |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   ALTITUDE                    |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseLOC(rdata []byte) (string, error) {
+	if len(rdata) < 16 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	// only the version 0 is defined
+	if rdata[0] != 0 {
+		return "", ErrDecodeDNSAnswerRdataInvalid
+	}
+
+	latitude := locCoordinateToString(binary.BigEndian.Uint32(rdata[4:8]), "N", "S")
+	longitude := locCoordinateToString(binary.BigEndian.Uint32(rdata[8:12]), "E", "W")
+
+	altitude := binary.BigEndian.Uint32(rdata[12:16])
+	alt := fmt.Sprintf("%.0fm", float64(altitude)/100-100000)
+	if altitude%100 != 0 {
+		alt = fmt.Sprintf("%.2fm", float64(altitude)/100-100000)
+	}
+
+	loc := fmt.Sprintf("%s %s %s %sm %sm %sm", latitude, longitude, alt,
+		locPrecisionToString(rdata[1]), locPrecisionToString(rdata[2]), locPrecisionToString(rdata[3]))
+	return loc, nil
+}
+
+// locCoordinateToString returns the degrees, minutes and seconds of the latitude or longitude
+func locCoordinateToString(value uint32, positive string, negative string) string {
+	const origin = 1 << 31
+	const minutes = 60 * 1000
+	const degrees = 60 * minutes
+
+	hemisphere := positive
+	if value > origin {
+		value -= origin
+	} else {
+		hemisphere = negative
+		value = origin - value
+	}
+	return fmt.Sprintf("%02d %02d %0.3f %s", value/degrees, value%degrees/minutes,
+		float64(value%degrees%minutes)/1000, hemisphere)
+}
+
+// locPrecisionToString returns the size or the precision in meters, encoded with a mantissa and a power of ten in cm
+func locPrecisionToString(value uint8) string {
+	mantissa := value & 0xf0 >> 4
+	exponent := value & 0x0f
+	if exponent < 2 {
+		if exponent == 1 {
+			mantissa *= 10
+		}
+		return fmt.Sprintf("0.%02d", mantissa)
+	}
+	return fmt.Sprintf("%d", mantissa) + strings.Repeat("0", int(exponent)-2)
+}
+
+/*
+HINFO
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                      CPU                      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                       OS                      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseHINFO(rdata []byte) (string, error) {
+	cpu, offset, err := parseCharacterString(rdata, 0)
+	if err != nil {
+		return "", err
+	}
+	system, _, err := parseCharacterString(rdata, offset)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s", txtToStr(cpu), txtToStr(system)), nil
+}
+
+/*
+ZONEMD
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                     Serial                    |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|    Scheme     |Hash Algorithm |               /
++--+--+--+--+--+--+--+--+--+--+--+--+           /
+/                    Digest                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseZONEMD(rdata []byte) (string, error) {
+	if len(rdata) < 6 {
+		return "", ErrDecodeDNSAnswerRdataTooShort
+	}
+	serial := binary.BigEndian.Uint32(rdata[0:4])
+	digest := hex.EncodeToString(rdata[6:])
+	return fmt.Sprintf("%d %d %d %s", serial, rdata[4], rdata[5], digest), nil
+}
+
+// parseCharacterString reads a <character-string>, a length byte followed by the data
+func parseCharacterString(data []byte, offset int) ([]byte, int, error) {
+	if len(data) < offset+1 {
+		return nil, 0, ErrDecodeDNSAnswerRdataTooShort
+	}
+	length := int(data[offset])
+	if len(data) < offset+1+length {
+		return nil, 0, ErrDecodeDNSAnswerRdataTooShort
+	}
+	return data[offset+1 : offset+1+length], offset + 1 + length, nil
+}
+
+// rdatatypeToPresentation returns the name of the type, or the generic TYPEnnn notation if unknown
+func rdatatypeToPresentation(rrtype int) string {
+	if name, ok := Rdatatypes[rrtype]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", rrtype)
+}
+
+// labelsToPresentation returns the root label as a dot
+func labelsToPresentation(name string) string {
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// dnssecTimeToString returns the time of the signature in the YYYYMMDDHHmmSS format,
+// the 32 bits value is a serial number (RFC 4034, section 3.2)
+func dnssecTimeToString(t uint32) string {
+	const year68 = 1 << 31
+	mod := (int64(t)-time.Now().Unix())/year68 - 1
+	if mod < 0 {
+		mod = 0
+	}
+	return time.Unix(int64(t)-mod*year68, 0).UTC().Format("20060102150405")
+}
+
 /*
 SVCB
 +--+--+
@@ -993,4 +1475,25 @@ func svcbParamToStr(s []byte) string {
 	return str.String()
 }
 
+func txtToStr(s []byte) string {
+	var str strings.Builder
+	str.Grow(2 + 4*len(s))
+	str.WriteByte('"')
+	for _, e := range s {
+		if ' ' <= e && e <= '~' {
+			switch e {
+			case '"', '\\':
+				str.WriteByte('\\')
+				str.WriteByte(e)
+			default:
+				str.WriteByte(e)
+			}
+		} else {
+			str.WriteString(escapeByte(e))
+		}
+	}
+	str.WriteByte('"')
+	return str.String()
+}
+
 // END These functions and consts have been taken from miekg/dns
@@ -220,6 +220,109 @@ func TestDecodeRdataSVCB_params(t *testing.T) {
 	}
 }
 
+func TestDecodeRdata_RoundTrip(t *testing.T) {
+	fqdn := TestQName
+
+	vectors := []struct {
+		rrtype string
+		rdata  string
+	}{
+		{"DNSKEY", "257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ=="},
+		{"CDNSKEY", "256 3 8 AwEAAb+VzmF2fDWbIDXyYDDvQpdDcS8vQsLfDObcJ2oM9lrbW/lYVDrzuW5ZzHbJf1YtS5lC7IlEkGUt7ZPO3ZuA3ll0RZ+5XeFOp7hyd4eLX1AP/CXgwFSvvmMrXN1l4LPaqAB0nqMvzJyWiQm4cKy0C/cIoAnZz3+pDGKLPfOUjJ9X"},
+		{"DS", "2371 13 2 C988EC423E3880EB8DD8A46FE06CA230EE23F35B578F64E77AE90A0512F8D1FE"},
+		{"CDS", "20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"},
+		{"RRSIG", "A 13 2 300 20240501000000 20240401000000 2371 dnscollector.test ZG5zY29sbGVjdG9y"},
+		{"RRSIG", "TYPE4000 8 0 86400 20240501000000 20240401000000 20326 . ZG5zY29sbGVjdG9y"},
+		{"NSEC", "host.dnscollector.test A NS SOA MX RRSIG NSEC DNSKEY CAA"},
+		{"NSEC", ". NS SOA RRSIG NSEC DNSKEY"},
+		{"NSEC3", "1 0 0 - 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR NS SOA RRSIG DNSKEY NSEC3PARAM"},
+		{"NSEC3", "1 1 10 AABBCCDD 2VPTU5TIMAMQTTGL4LUU9KG21E0AOR3S A RRSIG"},
+		{"CAA", `0 issue "letsencrypt.org"`},
+		{"CAA", `128 iodef "mailto:security@dnscollector.test"`},
+		{"NAPTR", `100 10 "U" "E2U+sip" "!^.*$!sip:info@dnscollector.test!" .`},
+		{"NAPTR", `100 50 "s" "http+I2L+I2C+I2R" "" _http._tcp.dnscollector.test`},
+		{"TLSA", "3 1 1 0c72ac70b745ac19998811b131d662c9ac69dbdbe7cb23e5b514b56664c5d3d6"},
+		{"SSHFP", "4 2 123456789ABCDEF67890123456789ABCDEF67890123456789ABCDEF123456789"},
+		{"DNAME", "dnscollector.test"},
+		{"LOC", "51 30 12.748 N 00 07 39.611 W 0m 0.00m 0.00m 0.00m"},
+		{"LOC", "52 22 23.000 N 04 53 32.000 E -2m 1m 10000m 10m"},
+		{"HINFO", `"INTEL-386" "Linux"`},
+		{"HINFO", `"RFC8482" ""`},
+		{"ZONEMD", "2018031500 1 1 fbdcf96ff4c1cc0cf2ce63aafdb6b5ca6f06db1e7e39fa2d5a6f3daeb0e6e9fb9bb8ba3d1c2fbeccc1c5bef6a88c26ea"},
+	}
+
+	for _, tc := range vectors {
+		t.Run(tc.rrtype, func(t *testing.T) {
+			rr1, err := dns.NewRR(fmt.Sprintf("%s %s %s", fqdn, tc.rrtype, tc.rdata))
+			if err != nil {
+				t.Fatalf("invalid test vector: %v", err)
+			}
+
+			dm := new(dns.Msg)
+			dm.SetQuestion(fqdn, dns.TypeA)
+			dm.Answer = append(dm.Answer, rr1)
+			payload, _ := dm.Pack()
+
+			_, _, offsetRR, _ := DecodeQuestion(1, payload)
+			answer, _, err := DecodeAnswer(len(dm.Answer), offsetRR, payload)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if answer[0].Rdata != tc.rdata {
+				t.Errorf("invalid decode for rdata %s, want %s, got: %s", tc.rrtype, tc.rdata, answer[0].Rdata)
+			}
+
+			// the decoded rdata must be parsed by miekg/dns to the same record
+			rr2, err := dns.NewRR(fmt.Sprintf("%s %s %s", fqdn, tc.rrtype, answer[0].Rdata))
+			if err != nil {
+				t.Fatalf("decoded rdata not parsed by miekg/dns: %v", err)
+			}
+			if !dns.IsDuplicate(rr1, rr2) {
+				t.Errorf("round-trip error, want %s, got: %s", rr1.String(), rr2.String())
+			}
+		})
+	}
+}
+
+func TestDecodeRdata_Malformed(t *testing.T) {
+	testcases := []struct {
+		rrtype string
+		rdata  []byte
+		err    error
+	}{
+		{"DNSKEY", []byte{0x01, 0x01, 0x03}, ErrDecodeDNSAnswerRdataTooShort},
+		{"DS", []byte{0x09, 0x43, 0x0d}, ErrDecodeDNSAnswerRdataTooShort},
+		{"RRSIG", []byte{0x00, 0x01, 0x0d, 0x02, 0x00, 0x00, 0x01, 0x2c}, ErrDecodeDNSAnswerRdataTooShort},
+		{"NSEC", []byte{0x00, 0x00, 0x06}, ErrDecodeDNSAnswerRdataTooShort},
+		{"NSEC", []byte{0x00, 0x00, 0x00}, ErrDecodeDNSAnswerRdataInvalid},
+		{"NSEC", []byte{0x00, 0x00, 0x21, 0x40}, ErrDecodeDNSAnswerRdataInvalid},
+		{"NSEC", []byte{0x00, 0x01, 0x01, 0x40, 0x00, 0x01, 0x40}, ErrDecodeDNSAnswerRdataInvalid},
+		{"NSEC3", []byte{0x01, 0x00, 0x00, 0x00, 0x04, 0xaa}, ErrDecodeDNSAnswerRdataTooShort},
+		{"NSEC3", []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x14, 0xaa}, ErrDecodeDNSAnswerRdataTooShort},
+		{"CAA", []byte{0x00, 0x05, 0x69, 0x73}, ErrDecodeDNSAnswerRdataTooShort},
+		{"NAPTR", []byte{0x00, 0x64, 0x00, 0x0a, 0x01}, ErrDecodeDNSAnswerRdataTooShort},
+		{"NAPTR", []byte{0x00, 0x64, 0x00, 0x0a, 0x00, 0x00, 0x00}, ErrDecodeDNSLabelTooShort},
+		{"TLSA", []byte{0x03, 0x01}, ErrDecodeDNSAnswerRdataTooShort},
+		{"SSHFP", []byte{0x04}, ErrDecodeDNSAnswerRdataTooShort},
+		{"DNAME", []byte{0x03, 0x61}, ErrDecodeDNSLabelTooShort},
+		{"LOC", []byte{0x00, 0x12, 0x16, 0x13}, ErrDecodeDNSAnswerRdataTooShort},
+		{"LOC", make([]byte, 16), nil},
+		{"LOC", append([]byte{0x01}, make([]byte, 15)...), ErrDecodeDNSAnswerRdataInvalid},
+		{"HINFO", []byte{0x03, 0x61, 0x62, 0x63}, ErrDecodeDNSAnswerRdataTooShort},
+		{"HINFO", []byte{0x04, 0x61}, ErrDecodeDNSAnswerRdataTooShort},
+		{"ZONEMD", []byte{0x00, 0x00, 0x00, 0x01, 0x01}, ErrDecodeDNSAnswerRdataTooShort},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.rrtype, func(t *testing.T) {
+			_, err := ParseRdata(tc.rrtype, tc.rdata, tc.rdata, 0)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("invalid error for rdata %s, want %v, got: %v", tc.rrtype, tc.err, err)
+			}
+		})
+	}
+}
+
 func TestDecodeAnswer_QnameMinimized(t *testing.T) {
 	payload := []byte{0x8d, 0xda, 0x81, 0x80, 0x00, 0x01, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01, 0x05, 0x74,
 		0x65, 0x61, 0x6d, 0x73, 0x09, 0x6d, 0x69, 0x63, 0x72, 0x6f, 0x73, 0x6f, 0x66, 0x74,
@@ -2,9 +2,13 @@ package dnsutils
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/pkgconfig"
 )
@@ -13,17 +17,24 @@ var ErrDecodeEdnsBadRootDomain = errors.New("edns, name MUST be 0 (root domain)"
 var ErrDecodeEdnsDataTooShort = errors.New("edns, not enough data to decode rdata answer")
 var ErrDecodeEdnsOptionTooShort = errors.New("edns, not enough data to decode option answer")
 var ErrDecodeEdnsOptionCsubnetBadFamily = errors.New("edns, csubnet option bad family")
+var ErrDecodeEdnsOptionBadLength = errors.New("edns, option with invalid length")
 var ErrDecodeEdnsTooManyOpts = errors.New("edns, packet contained too many OPT RRs")
 
 var (
 	OptCodes = map[int]string{
 		3:  "NSID",
+		5:  "DAU",
+		6:  "DHU",
+		7:  "N3U",
 		8:  "CSUBNET",
 		9:  "EXPIRE",
 		10: "COOKIE",
 		11: "KEEPALIVE",
 		12: "PADDING",
+		13: "CHAIN",
 		15: "ERRORS",
+		18: "REPORT-CHANNEL",
+		19: "ZONEVERSION",
 	}
 	ErrorCodeToString = map[int]string{
 		0:  "Other",
@@ -185,13 +196,43 @@ func ParseOption(optName string, optData []byte) (string, error) {
 		ret, err = ParseErrors(optData)
 	case "CSUBNET":
 		ret, err = ParseCsubnet(optData)
+	case "COOKIE":
+		ret, err = ParseCookie(optData)
+	case "NSID":
+		ret, err = ParseNsid(optData)
+	case "PADDING":
+		ret, err = ParsePadding(optData)
+	case "KEEPALIVE":
+		ret, err = ParseKeepalive(optData)
+	case "EXPIRE":
+		ret, err = ParseExpire(optData)
+	case "CHAIN", "REPORT-CHANNEL":
+		ret, err = ParseOptionDomain(optData)
+	case "DAU", "DHU", "N3U":
+		ret, err = ParseAlgorithms(optData)
+	case "ZONEVERSION":
+		ret, err = ParseZoneVersion(optData)
 	default:
 		ret = "-"
 		err = nil
 	}
+
+	// an option not conforming to its rfc is kept with the raw value
+	// instead of marking the whole packet as malformed
+	if err != nil && optName != "ERRORS" && optName != "CSUBNET" {
+		ret, err = ParseRawOption(optData), nil
+	}
 	return ret, err
 }
 
+// ParseRawOption returns the data of the option in hexadecimal
+func ParseRawOption(d []byte) string {
+	if len(d) == 0 {
+		return "-"
+	}
+	return hex.EncodeToString(d)
+}
+
 /*
 https://datatracker.ietf.org/doc/html/rfc8914
 
@@ -262,3 +303,182 @@ func ParseCsubnet(d []byte) (string, error) {
 		return "-", ErrDecodeEdnsOptionCsubnetBadFamily
 	}
 }
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7873
+
+Cookie EDNS0 option format, the server cookie is absent in the first query
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                                                               |
++-+    Client Cookie (fixed size, 8 bytes)                    +-+
+|                                                               |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                                                               |
+/       Server Cookie  (variable size, 8 to 32 bytes)           /
+/                                                               /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseCookie(d []byte) (string, error) {
+	if len(d) < 8 {
+		return "", ErrDecodeEdnsOptionTooShort
+	}
+	if len(d) != 8 && (len(d) < 16 || len(d) > 40) {
+		return "", ErrDecodeEdnsOptionBadLength
+	}
+
+	serverCookie := "-"
+	if len(d) > 8 {
+		serverCookie = hex.EncodeToString(d[8:])
+	}
+	opt := fmt.Sprintf("%s %s", hex.EncodeToString(d[:8]), serverCookie)
+	return opt, nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc5001
+
+NSID EDNS0 option, the identifier of the name server is empty in the queries
+and displayed in hexadecimal followed by the printable characters
+*/
+func ParseNsid(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+
+	printable := make([]byte, len(d))
+	for i, c := range d {
+		if c < ' ' || c > '~' {
+			c = '.'
+		}
+		printable[i] = c
+	}
+	opt := fmt.Sprintf("%s (%s)", hex.EncodeToString(d), printable)
+	return opt, nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7830
+
+Padding EDNS0 option, only the number of padding bytes is displayed
+*/
+func ParsePadding(d []byte) (string, error) {
+	return strconv.Itoa(len(d)), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7828
+
+Keepalive EDNS0 option format, the timeout is empty in the queries
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                           TIMEOUT                             |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseKeepalive(d []byte) (string, error) {
+	switch len(d) {
+	case 0:
+		return "-", nil
+	case 2:
+		// timeout in units of 100 milliseconds
+		timeout := time.Duration(binary.BigEndian.Uint16(d)) * 100 * time.Millisecond
+		return timeout.String(), nil
+	default:
+		return "", ErrDecodeEdnsOptionBadLength
+	}
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7314
+
+Expire EDNS0 option format, the expire timer is empty in the queries
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                            EXPIRE                             |
+|                                                               |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseExpire(d []byte) (string, error) {
+	switch len(d) {
+	case 0:
+		return "-", nil
+	case 4:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(d)), 10), nil
+	default:
+		return "", ErrDecodeEdnsOptionBadLength
+	}
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7901
+https://datatracker.ietf.org/doc/html/rfc9567
+
+Chain and Report-Channel EDNS0 options, a domain name in uncompressed wire format
+(closest trust point or agent domain)
+*/
+func ParseOptionDomain(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "", ErrDecodeEdnsOptionTooShort
+	}
+	name, offset, err := ParseLabels(0, d)
+	if err != nil {
+		return "", err
+	}
+	if offset != len(d) {
+		return "", ErrDecodeEdnsOptionBadLength
+	}
+	if name == "" {
+		name = "."
+	}
+	return name, nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc6975
+
+DAU, DHU and N3U EDNS0 options, list of algorithms understood by the client
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|     ALG-CODE  |     ...                                       /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseAlgorithms(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+	algs := make([]string, len(d))
+	for i, alg := range d {
+		algs[i] = strconv.Itoa(int(alg))
+	}
+	return strings.Join(algs, ","), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc9660
+
+Zone Version EDNS0 option format, empty in the queries
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|      LABELCOUNT               |            TYPE               |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                            VERSION                            |
+/                                                               /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseZoneVersion(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+	if len(d) < 2 {
+		return "", ErrDecodeEdnsOptionTooShort
+	}
+
+	labelCount, versionType, version := d[0], d[1], d[2:]
+
+	// type 0, the serial of the SOA
+	if versionType == 0 {
+		if len(version) != 4 {
+			return "", ErrDecodeEdnsOptionBadLength
+		}
+		opt := fmt.Sprintf("%d SOA-SERIAL %d", labelCount, binary.BigEndian.Uint32(version))
+		return opt, nil
+	}
+
+	opt := fmt.Sprintf("%d %d %s", labelCount, versionType, hex.EncodeToString(version))
+	return opt, nil
+}
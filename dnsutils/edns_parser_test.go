@@ -864,3 +864,84 @@ func TestDecodeEdns_invalidRRFollows(t *testing.T) {
 		t.Errorf("bad error received: %v", err)
 	}
 }
+
+func TestDecodeEdns_Options(t *testing.T) {
+	testcases := []struct {
+		name     string
+		option   dns.EDNS0
+		expected string
+	}{
+		{"COOKIE", &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "24a5ac1223a3ba6c"}, "24a5ac1223a3ba6c -"},
+		{"COOKIE", &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "24a5ac1223a3ba6c0100000065f2d9a5b2a1c3d4e5f60718"},
+			"24a5ac1223a3ba6c 0100000065f2d9a5b2a1c3d4e5f60718"},
+		{"NSID", &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: ""}, "-"},
+		{"NSID", &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "6e73312e0a"}, "6e73312e0a (ns1..)"},
+		{"PADDING", &dns.EDNS0_PADDING{Padding: make([]byte, 42)}, "42"},
+		{"KEEPALIVE", &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE}, "-"},
+		{"KEEPALIVE", &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Length: 2, Timeout: 1205}, "2m0.5s"},
+		{"EXPIRE", &dns.EDNS0_EXPIRE{Code: dns.EDNS0EXPIRE, Empty: true}, "-"},
+		{"EXPIRE", &dns.EDNS0_EXPIRE{Code: dns.EDNS0EXPIRE, Expire: 604800}, "604800"},
+		{"CHAIN", &dns.EDNS0_LOCAL{Code: 13, Data: []byte{0x00}}, "."},
+		{"CHAIN", &dns.EDNS0_LOCAL{Code: 13, Data: []byte{0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x00}}, "example"},
+		{"DAU", &dns.EDNS0_DAU{Code: dns.EDNS0DAU, AlgCode: []uint8{8, 13, 15}}, "8,13,15"},
+		{"DHU", &dns.EDNS0_DHU{Code: dns.EDNS0DHU, AlgCode: []uint8{1, 2}}, "1,2"},
+		{"N3U", &dns.EDNS0_N3U{Code: dns.EDNS0N3U, AlgCode: []uint8{1}}, "1"},
+		{"REPORT-CHANNEL", &dns.EDNS0_LOCAL{Code: 18, Data: []byte{0x05, 'a', 'g', 'e', 'n', 't', 0x02, 'f', 'r', 0x00}}, "agent.fr"},
+		{"ZONEVERSION", &dns.EDNS0_LOCAL{Code: 19}, "-"},
+		{"ZONEVERSION", &dns.EDNS0_LOCAL{Code: 19, Data: []byte{0x02, 0x00, 0x78, 0x91, 0x32, 0x04}}, "2 SOA-SERIAL 2022781444"},
+		{"ZONEVERSION", &dns.EDNS0_LOCAL{Code: 19, Data: []byte{0x01, 0xf0, 0xca, 0xfe}}, "1 240 cafe"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+			e.SetUDPSize(1232)
+			e.Option = append(e.Option, tc.option)
+
+			payload := make([]byte, dns.Len(e))
+			if _, err := dns.PackRR(e, payload, 0, nil, false); err != nil {
+				t.Fatalf("unable to pack the opt record: %v", err)
+			}
+
+			edns, _, err := DecodeEDNS(1, 0, payload)
+			if err != nil {
+				t.Fatalf("edns error returned: %v", err)
+			}
+			if len(edns.Options) != 1 || edns.Options[0].Name != tc.name {
+				t.Fatalf("invalid options decoded: %v", edns.Options)
+			}
+			if edns.Options[0].Data != tc.expected {
+				t.Errorf("want %q, got %q", tc.expected, edns.Options[0].Data)
+			}
+		})
+	}
+}
+
+func TestDecodeEdns_OptionsInvalid(t *testing.T) {
+	testcases := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"COOKIE", []byte{0x01, 0x02, 0x03}, "010203"},
+		{"COOKIE", []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09}, "010203040506070809"},
+		{"KEEPALIVE", []byte{0x01}, "01"},
+		{"EXPIRE", []byte{0x01, 0x02}, "0102"},
+		{"CHAIN", []byte{}, "-"},
+		{"REPORT-CHANNEL", []byte{0x05, 'a', 'g'}, "056167"},
+		{"ZONEVERSION", []byte{0x01, 0x00, 0x01}, "010001"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			// the raw value is kept, the packet is not malformed
+			opt, err := ParseOption(tc.name, tc.data)
+			if err != nil {
+				t.Errorf("unexpected error for %v: %v", tc.data, err)
+			}
+			if opt != tc.expected {
+				t.Errorf("want %q, got %q", tc.expected, opt)
+			}
+		})
+	}
+}
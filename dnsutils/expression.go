@@ -0,0 +1,403 @@
+package dnsutils
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expression is a boolean expression evaluated against a DNS message, for example:
+//
+//	dns.qtype == "ANY" && network.query-ip in 10.0.0.0/8
+//
+// Fields are resolved with the JSON keys of the DNS message, like with the matching.
+// Supported operators are ==, !=, <, <=, >, >=, =~ (regexp), !~, in (list or subnet),
+// && , || and ! with parenthesis for grouping.
+type Expression struct {
+	text string
+	root exprNode
+}
+
+// CompileExpression parses the expression, the returned expression can be evaluated
+// many times without additional parsing.
+func CompileExpression(text string) (*Expression, error) {
+	p := &exprParser{}
+	if err := p.tokenize(text); err != nil {
+		return nil, err
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != exprTokEOF {
+		return nil, fmt.Errorf("unexpected token %q at position %d", tok.text, tok.pos)
+	}
+	return &Expression{text: text, root: root}, nil
+}
+
+func (e *Expression) String() string { return e.text }
+
+// Evaluate returns true if the DNS message matches the expression
+func (e *Expression) Evaluate(dm *DNSMessage) bool {
+	return e.root.eval(reflect.ValueOf(dm).Elem())
+}
+
+type exprNode interface {
+	eval(dm reflect.Value) bool
+}
+
+type exprAnd struct{ left, right exprNode }
+
+func (n *exprAnd) eval(dm reflect.Value) bool { return n.left.eval(dm) && n.right.eval(dm) }
+
+type exprOr struct{ left, right exprNode }
+
+func (n *exprOr) eval(dm reflect.Value) bool { return n.left.eval(dm) || n.right.eval(dm) }
+
+type exprNot struct{ node exprNode }
+
+func (n *exprNot) eval(dm reflect.Value) bool { return !n.node.eval(dm) }
+
+type exprCompare struct {
+	field  string
+	op     string
+	values []interface{} // string, float64, bool, netip.Prefix or *regexp.Regexp
+}
+
+func (n *exprCompare) eval(dm reflect.Value) bool {
+	realValue, found := getFieldByJSONTag(dm, n.field)
+	if !found {
+		return false
+	}
+
+	// negative operators are evaluated as the opposite of the positive ones
+	switch n.op {
+	case "!=":
+		return !matchExprValue(realValue, "==", n.values)
+	case "!~":
+		return !matchExprValue(realValue, "=~", n.values)
+	}
+	return matchExprValue(realValue, n.op, n.values)
+}
+
+// matchExprValue compares the field with the values, a list matches if one of its elements matches
+func matchExprValue(realValue reflect.Value, op string, values []interface{}) bool {
+	if realValue.Kind() == reflect.Interface {
+		realValue = realValue.Elem()
+	}
+
+	if realValue.Kind() == reflect.Slice {
+		for i := 0; i < realValue.Len(); i++ {
+			if matchExprValue(realValue.Index(i), op, values) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, value := range values {
+		if compareExprValue(realValue, op, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func compareExprValue(realValue reflect.Value, op string, value interface{}) bool {
+	var number float64
+	isNumber := false
+
+	switch realValue.Kind() {
+	case reflect.String:
+		str := realValue.String()
+		switch v := value.(type) {
+		case *regexp.Regexp:
+			return v.MatchString(str)
+		case string:
+			return str == v
+		case netip.Prefix:
+			addr, err := netip.ParseAddr(str)
+			return err == nil && v.Contains(addr.Unmap())
+		case float64:
+			// numbers stored as string, like ports
+			f, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				return false
+			}
+			number, isNumber = f, true
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, isNumber = float64(realValue.Int()), true
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, isNumber = float64(realValue.Uint()), true
+
+	case reflect.Float32, reflect.Float64:
+		number, isNumber = realValue.Float(), true
+
+	case reflect.Bool:
+		if v, ok := value.(bool); ok {
+			return realValue.Bool() == v
+		}
+	}
+
+	expected, ok := value.(float64)
+	if !isNumber || !ok {
+		return false
+	}
+
+	switch op {
+	case "==", "in":
+		return number == expected
+	case "<":
+		return number < expected
+	case "<=":
+		return number <= expected
+	case ">":
+		return number > expected
+	case ">=":
+		return number >= expected
+	}
+	return false
+}
+
+const (
+	exprTokEOF = iota
+	exprTokWord
+	exprTokString
+	exprTokOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	tokens []exprToken
+	index  int
+}
+
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func (p *exprParser) tokenize(text string) error {
+	i := 0
+	for i < len(text) {
+		c := text[i]
+
+		// skip whitespaces
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			i++
+			continue
+		}
+
+		// quoted string, only the quote and the backslash can be escaped
+		if c == '"' || c == '\'' {
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(text) && text[j] != c; j++ {
+				if text[j] == '\\' && j+1 < len(text) && (text[j+1] == c || text[j+1] == '\\') {
+					j++
+				}
+				sb.WriteByte(text[j])
+			}
+			if j >= len(text) {
+				return fmt.Errorf("unterminated string at position %d", i)
+			}
+			p.tokens = append(p.tokens, exprToken{kind: exprTokString, text: sb.String(), pos: i})
+			i = j + 1
+			continue
+		}
+
+		// operators
+		matched := false
+		for _, op := range exprOperators {
+			if strings.HasPrefix(text[i:], op) {
+				p.tokens = append(p.tokens, exprToken{kind: exprTokOp, text: op, pos: i})
+				i += len(op)
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		// words: fields, numbers, booleans, ip addresses and subnets
+		j := i
+		for j < len(text) && !strings.ContainsRune(" \t\n\r\"'()[],&|=!<>~", rune(text[j])) {
+			j++
+		}
+		if j == i {
+			return fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+		p.tokens = append(p.tokens, exprToken{kind: exprTokWord, text: text[i:j], pos: i})
+		i = j
+	}
+	p.tokens = append(p.tokens, exprToken{kind: exprTokEOF, text: "end of expression", pos: len(text)})
+	return nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.index]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.index]
+	if tok.kind != exprTokEOF {
+		p.index++
+	}
+	return tok
+}
+
+func (p *exprParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == exprTokOp && tok.text == op {
+		p.index++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("!") {
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNot{node: node}, nil
+	}
+
+	if p.accept("(") {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			tok := p.peek()
+			return nil, fmt.Errorf("missing closing parenthesis at position %d", tok.pos)
+		}
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	field := p.next()
+	if field.kind != exprTokWord {
+		return nil, fmt.Errorf("field expected at position %d, got %q", field.pos, field.text)
+	}
+
+	op := p.next()
+	switch {
+	case op.kind == exprTokOp && (op.text == "==" || op.text == "!=" || op.text == "<" ||
+		op.text == "<=" || op.text == ">" || op.text == ">=" || op.text == "=~" || op.text == "!~"):
+	case op.kind == exprTokWord && op.text == "in":
+	default:
+		return nil, fmt.Errorf("operator expected after field %s at position %d, got %q", field.text, op.pos, op.text)
+	}
+
+	node := &exprCompare{field: field.text, op: op.text}
+
+	// list of values
+	if op.text == "in" && p.accept("[") {
+		for {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			node.values = append(node.values, value)
+			if p.accept("]") {
+				break
+			}
+			if !p.accept(",") {
+				tok := p.peek()
+				return nil, fmt.Errorf("comma or closing bracket expected at position %d, got %q", tok.pos, tok.text)
+			}
+		}
+		return node, nil
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.text {
+	case "=~", "!~":
+		pattern, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("string pattern expected for operator %s on field %s", op.text, field.text)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for field %s: %w", field.text, err)
+		}
+		value = re
+	case "<", "<=", ">", ">=":
+		if _, ok := value.(float64); !ok {
+			return nil, fmt.Errorf("number expected for operator %s on field %s", op.text, field.text)
+		}
+	}
+	node.values = append(node.values, value)
+	return node, nil
+}
+
+func (p *exprParser) parseValue() (interface{}, error) {
+	tok := p.next()
+	switch tok.kind {
+	case exprTokString:
+		return tok.text, nil
+	case exprTokWord:
+		if tok.text == "true" || tok.text == "false" {
+			return tok.text == "true", nil
+		}
+		if number, err := strconv.ParseFloat(tok.text, 64); err == nil {
+			return number, nil
+		}
+		if prefix, err := netip.ParsePrefix(tok.text); err == nil {
+			return prefix.Masked(), nil
+		}
+		if addr, err := netip.ParseAddr(tok.text); err == nil {
+			return netip.PrefixFrom(addr, addr.BitLen()), nil
+		}
+		return nil, fmt.Errorf("invalid value %q at position %d, strings must be quoted", tok.text, tok.pos)
+	}
+	return nil, fmt.Errorf("value expected at position %d, got %q", tok.pos, tok.text)
+}
@@ -0,0 +1,75 @@
+package dnsutils
+
+import (
+	"testing"
+)
+
+func TestExpression_Evaluate(t *testing.T) {
+	dm := GetFakeDNSMessage()
+	dm.DNS.Rcode = "NXDOMAIN"
+	dm.DNS.Length = 100
+	dm.NetworkInfo.QueryIP = "10.1.2.3"
+	dm.DNS.DNSRRs.Answers = []DNSAnswer{{Name: "dns.collector", Rdatatype: "A", Rdata: "192.168.1.1"}}
+
+	testcases := []struct {
+		expression string
+		match      bool
+	}{
+		{`dns.qtype == "A"`, true},
+		{`dns.qtype == 'AAAA'`, false},
+		{`dns.qtype != "AAAA"`, true},
+		{`dns.length > 50`, true},
+		{`dns.length <= 50`, false},
+		{`network.query-port == 1234`, true},
+		{`dns.flags.qr == false`, true},
+		{`dns.qname =~ "\.collector$"`, true},
+		{`dns.qname !~ "^dns\\."`, false},
+		{`network.query-ip in 10.0.0.0/8`, true},
+		{`network.query-ip in 192.168.0.0/16`, false},
+		{`network.query-ip == 10.1.2.3`, true},
+		{`dns.qtype in ["A", "AAAA"]`, true},
+		{`dns.resource-records.an.*.rdata in 192.168.0.0/16`, true},
+		{`dns.resource-records.an.0.rdatatype == "A"`, true},
+		{`dns.rcode == "NXDOMAIN" && network.query-ip in 10.0.0.0/8`, true},
+		{`dns.rcode == "NXDOMAIN" && network.query-ip in 172.16.0.0/12`, false},
+		{`dns.qtype == "ANY" || (dns.rcode == "NXDOMAIN" && !(dns.length < 10))`, true},
+		{`!dns.qtype == "A"`, false},
+		{`geoip.country-isocode == "FR"`, false},
+		{`unknown.field == "A"`, false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.expression, func(t *testing.T) {
+			expr, err := CompileExpression(tc.expression)
+			if err != nil {
+				t.Fatalf("unexpected compile error: %v", err)
+			}
+			if match := expr.Evaluate(&dm); match != tc.match {
+				t.Errorf("want %v, got %v", tc.match, match)
+			}
+		})
+	}
+}
+
+func TestExpression_CompileError(t *testing.T) {
+	testcases := []string{
+		``,
+		`dns.qtype`,
+		`dns.qtype == A`,
+		`dns.qtype == "A`,
+		`dns.length > "A"`,
+		`dns.qname =~ "("`,
+		`(dns.qtype == "A"`,
+		`dns.qtype in ["A" "AAAA"]`,
+		`dns.qtype == "A" &&`,
+		`dns.qtype == "A" dns.rcode == "NOERROR"`,
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc, func(t *testing.T) {
+			if _, err := CompileExpression(tc); err == nil {
+				t.Errorf("compile error expected")
+			}
+		})
+	}
+}
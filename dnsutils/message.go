@@ -7,17 +7,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"math"
 	"net"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/netlib"
 	"github.com/dmachard/go-dnstap-protobuf"
+	powerdns_protobuf "github.com/dmachard/go-powerdns-protobuf"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/miekg/dns"
@@ -38,6 +41,10 @@ var (
 	ReducerDirectives         = regexp.MustCompile(`^reducer-*`)
 	MachineLearningDirectives = regexp.MustCompile(`^ml-*`)
 	FilteringDirectives       = regexp.MustCompile(`^filtering-*`)
+	TransactionDirectives     = regexp.MustCompile(`^transaction-*`)
+	TunnelingDirectives       = regexp.MustCompile(`^tunneling-*`)
+	IntelDirectives           = regexp.MustCompile(`^intel-*`)
+	HostnamesDirectives       = regexp.MustCompile(`^hostnames-*`)
 )
 
 func GetIPPort(dm *DNSMessage) (string, int, string, int) {
@@ -131,22 +138,31 @@ type DNSExtended struct {
 }
 
 type DNSTap struct {
-	Operation        string  `json:"operation" msgpack:"operation"`
-	Identity         string  `json:"identity" msgpack:"identity"`
-	Version          string  `json:"version" msgpack:"version"`
-	TimestampRFC3339 string  `json:"timestamp-rfc3339ns" msgpack:"timestamp-rfc3339ns"`
-	Timestamp        int64   `json:"-" msgpack:"-"`
-	TimeSec          int     `json:"-" msgpack:"-"`
-	TimeNsec         int     `json:"-" msgpack:"-"`
-	Latency          float64 `json:"-" msgpack:"-"`
-	LatencySec       string  `json:"latency" msgpack:"latency"`
-	Payload          []byte  `json:"-" msgpack:"-"`
-	Extra            string  `json:"extra" msgpack:"extra"`
-	PolicyRule       string  `json:"policy-rule" msgpack:"policy-rule"`
-	PolicyType       string  `json:"policy-type" msgpack:"policy-type"`
-	PolicyMatch      string  `json:"policy-match" msgpack:"policy-match"`
-	PolicyAction     string  `json:"policy-action" msgpack:"policy-action"`
-	PolicyValue      string  `json:"policy-value" msgpack:"policy-value"`
+	Operation         string  `json:"operation" msgpack:"operation"`
+	Identity          string  `json:"identity" msgpack:"identity"`
+	Version           string  `json:"version" msgpack:"version"`
+	TimestampRFC3339  string  `json:"timestamp-rfc3339ns" msgpack:"timestamp-rfc3339ns"`
+	Timestamp         int64   `json:"-" msgpack:"-"`
+	TimeSec           int     `json:"-" msgpack:"-"`
+	TimeNsec          int     `json:"-" msgpack:"-"`
+	Latency           float64 `json:"-" msgpack:"-"`
+	LatencySec        string  `json:"latency" msgpack:"latency"`
+	Payload           []byte  `json:"-" msgpack:"-"`
+	Extra             string  `json:"extra" msgpack:"extra"`
+	PolicyRule        string  `json:"policy-rule" msgpack:"policy-rule"`
+	PolicyType        string  `json:"policy-type" msgpack:"policy-type"`
+	PolicyMatch       string  `json:"policy-match" msgpack:"policy-match"`
+	PolicyAction      string  `json:"policy-action" msgpack:"policy-action"`
+	PolicyValue       string  `json:"policy-value" msgpack:"policy-value"`
+	QueryZone         string  `json:"query-zone" msgpack:"query-zone"`
+	QueryTimeSec      int     `json:"-" msgpack:"-"`
+	QueryTimeNsec     int     `json:"-" msgpack:"-"`
+	ResponseTimeSec   int     `json:"-" msgpack:"-"`
+	ResponseTimeNsec  int     `json:"-" msgpack:"-"`
+	QueryTimestamp    string  `json:"query-timestamp-rfc3339ns" msgpack:"query-timestamp-rfc3339ns"`
+	ResponseTimestamp string  `json:"response-timestamp-rfc3339ns" msgpack:"response-timestamp-rfc3339ns"`
+	ResolverIP        string  `json:"resolver-ip" msgpack:"resolver-ip"`
+	ResolverPort      string  `json:"resolver-port" msgpack:"resolver-port"`
 }
 
 type PowerDNS struct {
@@ -224,6 +240,48 @@ type TransformATags struct {
 	Tags []string `json:"tags" msgpack:"tags"`
 }
 
+type TransformTransaction struct {
+	Status         string      `json:"status" msgpack:"status"`
+	QueryTimestamp string      `json:"query-timestamp" msgpack:"query-timestamp"`
+	QueryLength    int         `json:"query-length" msgpack:"query-length"`
+	QueryFlags     DNSFlags    `json:"query-flags" msgpack:"query-flags"`
+	QueryEDNS      DNSExtended `json:"query-edns" msgpack:"query-edns"`
+	ReplyLength    int         `json:"reply-length" msgpack:"reply-length"`
+}
+
+type TunnelingStats struct {
+	Queries          int     `json:"queries" msgpack:"queries"`
+	UniqueSubdomains int     `json:"unique-subdomains" msgpack:"unique-subdomains"`
+	QnameBytes       int     `json:"qname-bytes" msgpack:"qname-bytes"`
+	TxtNullRatio     float64 `json:"txt-null-ratio" msgpack:"txt-null-ratio"`
+	Entropy          float64 `json:"entropy" msgpack:"entropy"`
+}
+
+type TransformTunneling struct {
+	Score       float64        `json:"score" msgpack:"score"`
+	Reasons     []string       `json:"reasons" msgpack:"reasons"`
+	EtldPlusOne string         `json:"etld+1" msgpack:"etld+1"`
+	ModelScore  float64        `json:"model-score" msgpack:"model-score"`
+	Client      TunnelingStats `json:"client" msgpack:"client"`
+	Domain      TunnelingStats `json:"domain" msgpack:"domain"`
+}
+
+type IntelMatch struct {
+	Feed      string `json:"feed" msgpack:"feed"`
+	Category  string `json:"category" msgpack:"category"`
+	Indicator string `json:"indicator" msgpack:"indicator"`
+	Kind      string `json:"kind" msgpack:"kind"`
+}
+
+type TransformHostnames struct {
+	QueryHostname    string `json:"query-hostname" msgpack:"query-hostname"`
+	ResponseHostname string `json:"response-hostname" msgpack:"response-hostname"`
+}
+
+type TransformIntel struct {
+	Matches []IntelMatch `json:"matches" msgpack:"matches"`
+}
+
 type DNSMessage struct {
 	NetworkInfo     DNSNetInfo             `json:"network" msgpack:"network"`
 	DNS             DNS                    `json:"dns" msgpack:"dns"`
@@ -238,6 +296,10 @@ type DNSMessage struct {
 	MachineLearning *TransformML           `json:"ml,omitempty" msgpack:"ml"`
 	Filtering       *TransformFiltering    `json:"filtering,omitempty" msgpack:"filtering"`
 	ATags           *TransformATags        `json:"atags,omitempty" msgpack:"atags"`
+	Transaction     *TransformTransaction  `json:"transaction,omitempty" msgpack:"transaction"`
+	Tunneling       *TransformTunneling    `json:"tunneling,omitempty" msgpack:"tunneling"`
+	Intel           *TransformIntel        `json:"intel,omitempty" msgpack:"intel"`
+	Hostnames       *TransformHostnames    `json:"hostnames,omitempty" msgpack:"hostnames"`
 }
 
 func (dm *DNSMessage) Init() {
@@ -253,17 +315,22 @@ func (dm *DNSMessage) Init() {
 	}
 
 	dm.DNSTap = DNSTap{
-		Operation:        "-",
-		Identity:         "-",
-		Version:          "-",
-		TimestampRFC3339: "-",
-		LatencySec:       "-",
-		Extra:            "-",
-		PolicyRule:       "-",
-		PolicyType:       "-",
-		PolicyMatch:      "-",
-		PolicyAction:     "-",
-		PolicyValue:      "-",
+		Operation:         "-",
+		Identity:          "-",
+		Version:           "-",
+		TimestampRFC3339:  "-",
+		LatencySec:        "-",
+		Extra:             "-",
+		PolicyRule:        "-",
+		PolicyType:        "-",
+		PolicyMatch:       "-",
+		PolicyAction:      "-",
+		PolicyValue:       "-",
+		QueryZone:         "-",
+		QueryTimestamp:    "-",
+		ResponseTimestamp: "-",
+		ResolverIP:        "-",
+		ResolverPort:      "-",
 	}
 
 	dm.DNS = DNS{
@@ -288,6 +355,10 @@ func (dm *DNSMessage) Init() {
 
 func (dm *DNSMessage) InitTransforms() {
 	dm.ATags = &TransformATags{}
+	dm.Transaction = &TransformTransaction{}
+	dm.Tunneling = &TransformTunneling{}
+	dm.Intel = &TransformIntel{}
+	dm.Hostnames = &TransformHostnames{}
 	dm.Filtering = &TransformFiltering{}
 	dm.MachineLearning = &TransformML{}
 	dm.Reducer = &TransformReducer{}
@@ -478,6 +549,118 @@ func (dm *DNSMessage) handleFilteringDirectives(directives []string, s *strings.
 	return nil
 }
 
+func (dm *DNSMessage) handleTransactionDirectives(directives []string, s *strings.Builder) error {
+	if dm.Transaction == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "transaction-status":
+			s.WriteString(dm.Transaction.Status)
+		case directive == "transaction-query-timestamp":
+			s.WriteString(dm.Transaction.QueryTimestamp)
+		case directive == "transaction-query-length":
+			s.WriteString(strconv.Itoa(dm.Transaction.QueryLength))
+		case directive == "transaction-reply-length":
+			s.WriteString(strconv.Itoa(dm.Transaction.ReplyLength))
+		default:
+			return errors.New(ErrorUnexpectedDirective + directive)
+		}
+	}
+	return nil
+}
+
+func (dm *DNSMessage) handleTunnelingDirectives(directives []string, s *strings.Builder) error {
+	if dm.Tunneling == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "tunneling-score":
+			s.WriteString(strconv.Itoa(int(dm.Tunneling.Score)))
+		case directive == "tunneling-reasons":
+			if len(dm.Tunneling.Reasons) > 0 {
+				s.WriteString(strings.Join(dm.Tunneling.Reasons, ","))
+			} else {
+				s.WriteString("-")
+			}
+		case directive == "tunneling-etld+1":
+			s.WriteString(dm.Tunneling.EtldPlusOne)
+		case directive == "tunneling-model-score":
+			s.WriteString(strconv.FormatFloat(dm.Tunneling.ModelScore, 'f', 3, 64))
+		case directive == "tunneling-client-queries":
+			s.WriteString(strconv.Itoa(dm.Tunneling.Client.Queries))
+		case directive == "tunneling-client-unique-subdomains":
+			s.WriteString(strconv.Itoa(dm.Tunneling.Client.UniqueSubdomains))
+		case directive == "tunneling-client-qname-bytes":
+			s.WriteString(strconv.Itoa(dm.Tunneling.Client.QnameBytes))
+		case directive == "tunneling-client-txt-null-ratio":
+			s.WriteString(strconv.FormatFloat(dm.Tunneling.Client.TxtNullRatio, 'f', 3, 64))
+		case directive == "tunneling-client-entropy":
+			s.WriteString(strconv.FormatFloat(dm.Tunneling.Client.Entropy, 'f', -1, 64))
+		case directive == "tunneling-domain-queries":
+			s.WriteString(strconv.Itoa(dm.Tunneling.Domain.Queries))
+		case directive == "tunneling-domain-unique-subdomains":
+			s.WriteString(strconv.Itoa(dm.Tunneling.Domain.UniqueSubdomains))
+		case directive == "tunneling-domain-qname-bytes":
+			s.WriteString(strconv.Itoa(dm.Tunneling.Domain.QnameBytes))
+		case directive == "tunneling-domain-txt-null-ratio":
+			s.WriteString(strconv.FormatFloat(dm.Tunneling.Domain.TxtNullRatio, 'f', 3, 64))
+		case directive == "tunneling-domain-entropy":
+			s.WriteString(strconv.FormatFloat(dm.Tunneling.Domain.Entropy, 'f', -1, 64))
+		default:
+			return errors.New(ErrorUnexpectedDirective + directive)
+		}
+	}
+	return nil
+}
+
+func (dm *DNSMessage) handleIntelDirectives(directives []string, s *strings.Builder) error {
+	if dm.Intel == nil {
+		s.WriteString("-")
+		return nil
+	}
+
+	directive := directives[0]
+	if directive == "intel-matches" {
+		s.WriteString(strconv.Itoa(len(dm.Intel.Matches)))
+		return nil
+	}
+
+	// only the first match is written, prefer to use the JSON format if you want all matches
+	match := IntelMatch{Feed: "-", Category: "-", Indicator: "-", Kind: "-"}
+	if len(dm.Intel.Matches) > 0 {
+		match = dm.Intel.Matches[0]
+	}
+	switch directive {
+	case "intel-feed":
+		s.WriteString(match.Feed)
+	case "intel-category":
+		s.WriteString(match.Category)
+	case "intel-indicator":
+		s.WriteString(match.Indicator)
+	case "intel-kind":
+		s.WriteString(match.Kind)
+	default:
+		return errors.New(ErrorUnexpectedDirective + directive)
+	}
+	return nil
+}
+
+func (dm *DNSMessage) handleHostnamesDirectives(directives []string, s *strings.Builder) error {
+	if dm.Hostnames == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "hostnames-query":
+			s.WriteString(dm.Hostnames.QueryHostname)
+		case directive == "hostnames-response":
+			s.WriteString(dm.Hostnames.ResponseHostname)
+		default:
+			return errors.New(ErrorUnexpectedDirective + directive)
+		}
+	}
+	return nil
+}
+
 func (dm *DNSMessage) handleReducerDirectives(directives []string, s *strings.Builder) error {
 	if dm.Reducer == nil {
 		s.WriteString("-")
@@ -556,6 +739,16 @@ func (dm *DNSMessage) String(format []string, fieldDelimiter string, fieldBounda
 	return string(dm.Bytes(format, fieldDelimiter, fieldBoundary))
 }
 
+// getEDNSOption returns the decoded data of the EDNS option or "-" if absent
+func (dm *DNSMessage) getEDNSOption(name string) string {
+	for _, opt := range dm.EDNS.Options {
+		if opt.Name == name {
+			return opt.Data
+		}
+	}
+	return "-"
+}
+
 func (dm *DNSMessage) ToTextLine(format []string, fieldDelimiter string, fieldBoundary string) ([]byte, error) {
 	var s strings.Builder
 
@@ -576,15 +769,28 @@ func (dm *DNSMessage) ToTextLine(format []string, fieldDelimiter string, fieldBo
 				s.WriteByte('-')
 			}
 		case directive == "edns-csubnet":
-			if len(dm.EDNS.Options) > 0 {
-				for _, opt := range dm.EDNS.Options {
-					if opt.Name == "CSUBNET" {
-						s.WriteString(opt.Data)
-						break
-					}
+			s.WriteString(dm.getEDNSOption("CSUBNET"))
+		case directive == "edns-nsid", directive == "edns-cookie", directive == "edns-padding",
+			directive == "edns-keepalive", directive == "edns-expire", directive == "edns-chain",
+			directive == "edns-dau", directive == "edns-dhu", directive == "edns-n3u",
+			directive == "edns-report-channel", directive == "edns-zoneversion":
+			optData := dm.getEDNSOption(strings.ToUpper(strings.TrimPrefix(directive, "edns-")))
+			if strings.Contains(optData, fieldDelimiter) {
+				if strings.Contains(optData, fieldBoundary) {
+					optData = strings.ReplaceAll(optData, fieldBoundary, "\\"+fieldBoundary)
 				}
-			} else {
-				s.WriteByte('-')
+				optData = fieldBoundary + optData + fieldBoundary
+			}
+			s.WriteString(optData)
+		case directive == "edns-cookie-client", directive == "edns-cookie-server":
+			cookies := strings.Fields(dm.getEDNSOption("COOKIE"))
+			switch {
+			case len(cookies) == 0:
+				s.WriteString("-")
+			case len(cookies) == 2 && directive == "edns-cookie-server":
+				s.WriteString(cookies[1])
+			default:
+				s.WriteString(cookies[0])
 			}
 		case directive == "answercount":
 			s.WriteString(strconv.Itoa(len(dm.DNS.DNSRRs.Answers)))
@@ -617,6 +823,16 @@ func (dm *DNSMessage) ToTextLine(format []string, fieldDelimiter string, fieldBo
 			s.WriteString(dm.DNSTap.PolicyMatch)
 		case directive == "policy-value":
 			s.WriteString(dm.DNSTap.PolicyValue)
+		case directive == "query-zone":
+			s.WriteString(dm.DNSTap.QueryZone)
+		case directive == "query-timestamp":
+			s.WriteString(dm.DNSTap.QueryTimestamp)
+		case directive == "response-timestamp":
+			s.WriteString(dm.DNSTap.ResponseTimestamp)
+		case directive == "resolverip":
+			s.WriteString(dm.DNSTap.ResolverIP)
+		case directive == "resolverport":
+			s.WriteString(dm.DNSTap.ResolverPort)
 		case directive == "operation":
 			s.WriteString(dm.DNSTap.Operation)
 		case directive == "rcode":
@@ -744,6 +960,26 @@ func (dm *DNSMessage) ToTextLine(format []string, fieldDelimiter string, fieldBo
 			if err != nil {
 				return nil, err
 			}
+		case TransactionDirectives.MatchString(directive):
+			err := dm.handleTransactionDirectives(directives, &s)
+			if err != nil {
+				return nil, err
+			}
+		case TunnelingDirectives.MatchString(directive):
+			err := dm.handleTunnelingDirectives(directives, &s)
+			if err != nil {
+				return nil, err
+			}
+		case IntelDirectives.MatchString(directive):
+			err := dm.handleIntelDirectives(directives, &s)
+			if err != nil {
+				return nil, err
+			}
+		case HostnamesDirectives.MatchString(directive):
+			err := dm.handleHostnamesDirectives(directives, &s)
+			if err != nil {
+				return nil, err
+			}
 
 		// error unsupport directive for text format
 		default:
@@ -774,6 +1010,39 @@ func (dm *DNSMessage) ToFlatJSON() (string, error) {
 	return buffer.String(), nil
 }
 
+func (dm *DNSMessage) toDNSTapPolicy() *dnstap.Policy {
+	policy := &dnstap.Policy{}
+	isSet := false
+
+	if len(dm.DNSTap.PolicyType) > 0 && dm.DNSTap.PolicyType != "-" {
+		policy.Type = &dm.DNSTap.PolicyType
+		isSet = true
+	}
+	if len(dm.DNSTap.PolicyRule) > 0 && dm.DNSTap.PolicyRule != "-" {
+		policy.Rule = []byte(dm.DNSTap.PolicyRule)
+		isSet = true
+	}
+	if action, valid := dnstap.Policy_Action_value[dm.DNSTap.PolicyAction]; valid {
+		policyAction := dnstap.Policy_Action(action)
+		policy.Action = &policyAction
+		isSet = true
+	}
+	if match, valid := dnstap.Policy_Match_value[dm.DNSTap.PolicyMatch]; valid {
+		policyMatch := dnstap.Policy_Match(match)
+		policy.Match = &policyMatch
+		isSet = true
+	}
+	if len(dm.DNSTap.PolicyValue) > 0 && dm.DNSTap.PolicyValue != "-" {
+		policy.Value = []byte(dm.DNSTap.PolicyValue)
+		isSet = true
+	}
+
+	if !isSet {
+		return nil
+	}
+	return policy
+}
+
 func (dm *DNSMessage) ToDNSTap(extended bool) ([]byte, error) {
 	if len(dm.DNSTap.Payload) > 0 {
 		return dm.DNSTap.Payload, nil
@@ -846,6 +1115,29 @@ func (dm *DNSMessage) ToDNSTap(extended bool) ([]byte, error) {
 		msg.ResponseTimeSec = &tsec
 		msg.ResponseTimeNsec = &tnsec
 		msg.ResponseMessage = dm.DNS.Payload
+
+		// keep the time of the query if provided with the response
+		if dm.DNSTap.QueryTimeSec > 0 {
+			qsec := uint64(dm.DNSTap.QueryTimeSec)
+			qnsec := uint32(dm.DNSTap.QueryTimeNsec)
+			msg.QueryTimeSec = &qsec
+			msg.QueryTimeNsec = &qnsec
+		}
+	}
+
+	// add the zone in wire format
+	if len(dm.DNSTap.QueryZone) > 0 && dm.DNSTap.QueryZone != "-" {
+		zone := make([]byte, 256)
+		offset, err := dns.PackDomainName(dns.Fqdn(dm.DNSTap.QueryZone), zone, 0, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		msg.QueryZone = zone[:offset]
+	}
+
+	// add the policy
+	if policy := dm.toDNSTapPolicy(); policy != nil {
+		msg.Policy = policy
 	}
 
 	dt.Message = msg
@@ -912,6 +1204,183 @@ func (dm *DNSMessage) ToDNSTap(extended bool) ([]byte, error) {
 	return data, nil
 }
 
+// ToPowerDNS encodes the dns message to the PowerDNS protobuf format (dnsmessage.proto)
+func (dm *DNSMessage) ToPowerDNS() ([]byte, error) {
+	pbdm := &powerdns_protobuf.PBDNSMessage{}
+
+	// type of message according to the dnstap operation
+	isQuery := dm.DNS.Type == DNSQuery || dm.DNS.Type == DNSQueryQuiet
+	pbType := powerdns_protobuf.PBDNSMessage_DNSResponseType
+	switch {
+	case isQuery && (strings.HasPrefix(dm.DNSTap.Operation, "RESOLVER_") || strings.HasPrefix(dm.DNSTap.Operation, "FORWARDER_")):
+		pbType = powerdns_protobuf.PBDNSMessage_DNSOutgoingQueryType
+	case isQuery:
+		pbType = powerdns_protobuf.PBDNSMessage_DNSQueryType
+	case strings.HasPrefix(dm.DNSTap.Operation, "RESOLVER_") || strings.HasPrefix(dm.DNSTap.Operation, "FORWARDER_"):
+		pbType = powerdns_protobuf.PBDNSMessage_DNSIncomingResponseType
+	}
+	pbdm.Type = &pbType
+
+	// the message id is shared by the query and the response
+	hashfnv := fnv.New128a()
+	hashfnv.Write([]byte(strings.Join([]string{dm.NetworkInfo.QueryIP, dm.NetworkInfo.QueryPort, strconv.Itoa(dm.DNS.ID)}, "+")))
+	pbdm.MessageId = hashfnv.Sum(nil)
+
+	pbdm.ServerIdentity = []byte(dm.DNSTap.Identity)
+
+	if ipNet, valid := netlib.IPToInet[dm.NetworkInfo.Family]; valid {
+		sf := powerdns_protobuf.PBDNSMessage_SocketFamily(powerdns_protobuf.PBDNSMessage_SocketFamily_value[ipNet])
+		pbdm.SocketFamily = &sf
+	}
+	if protocol, valid := powerdns_protobuf.PBDNSMessage_SocketProtocol_value[dm.NetworkInfo.Protocol]; valid {
+		sp := powerdns_protobuf.PBDNSMessage_SocketProtocol(protocol)
+		pbdm.SocketProtocol = &sp
+	}
+
+	// query and response addresses
+	pbdm.From = ipToBytes(dm.NetworkInfo.QueryIP)
+	pbdm.To = ipToBytes(dm.NetworkInfo.ResponseIP)
+	if port, err := strconv.ParseUint(dm.NetworkInfo.QueryPort, 10, 16); err == nil {
+		fromPort := uint32(port)
+		pbdm.FromPort = &fromPort
+	}
+	if port, err := strconv.ParseUint(dm.NetworkInfo.ResponsePort, 10, 16); err == nil {
+		toPort := uint32(port)
+		pbdm.ToPort = &toPort
+	}
+
+	inBytes := uint64(dm.DNS.Length)
+	pbdm.InBytes = &inBytes
+	timeSec := uint32(dm.DNSTap.TimeSec)
+	timeUsec := uint32(dm.DNSTap.TimeNsec / 1000)
+	pbdm.TimeSec = &timeSec
+	pbdm.TimeUsec = &timeUsec
+	id := uint32(dm.DNS.ID)
+	pbdm.Id = &id
+
+	// question
+	qname := "."
+	if dm.DNS.Qname != "-" && len(dm.DNS.Qname) > 0 {
+		qname = dns.Fqdn(dm.DNS.Qname)
+	}
+	qtype := uint32(dns.StringToType[dm.DNS.Qtype])
+	qclass := uint32(dns.ClassINET)
+	pbdm.Question = &powerdns_protobuf.PBDNSMessage_DNSQuestion{QName: &qname, QType: &qtype, QClass: &qclass}
+
+	// edns client subnet
+	if dm.PowerDNS != nil {
+		pbdm.OriginalRequestorSubnet = ipToBytes(dm.PowerDNS.OriginalRequestSubnet)
+	}
+
+	// response with answers and policies
+	if !isQuery {
+		pbdm.Response = dm.toPowerDNSResponse()
+	}
+
+	// metadata, sorted by key to always produce the same message
+	if dm.PowerDNS != nil && len(dm.PowerDNS.Metadata) > 0 {
+		keys := make([]string, 0, len(dm.PowerDNS.Metadata))
+		for key := range dm.PowerDNS.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			metaKey := key
+			pbdm.Meta = append(pbdm.Meta, &powerdns_protobuf.PBDNSMessage_Meta{
+				Key:   &metaKey,
+				Value: &powerdns_protobuf.PBDNSMessage_MetaValue{StringVal: strings.Split(dm.PowerDNS.Metadata[key], " ")},
+			})
+		}
+	}
+
+	return proto.Marshal(pbdm)
+}
+
+func (dm *DNSMessage) toPowerDNSResponse() *powerdns_protobuf.PBDNSMessage_DNSResponse {
+	resp := &powerdns_protobuf.PBDNSMessage_DNSResponse{}
+
+	// 65536 is used for network errors, including timeouts
+	var rcode uint32
+	if dm.DNS.Rcode == "TIMEOUT" {
+		rcode = 65536
+	} else {
+		rcode = uint32(dns.StringToRcode[dm.DNS.Rcode])
+	}
+	resp.Rcode = &rcode
+
+	// time of the query
+	if dm.DNSTap.QueryTimeSec > 0 {
+		querySec := uint32(dm.DNSTap.QueryTimeSec)
+		queryUsec := uint32(dm.DNSTap.QueryTimeNsec / 1000)
+		resp.QueryTimeSec = &querySec
+		resp.QueryTimeUsec = &queryUsec
+	} else if dm.DNSTap.Latency > 0 {
+		ts := time.Unix(int64(dm.DNSTap.TimeSec), int64(dm.DNSTap.TimeNsec))
+		ts = ts.Add(-time.Duration(dm.DNSTap.Latency * float64(time.Second)))
+		querySec := uint32(ts.Unix())
+		queryUsec := uint32(ts.Nanosecond() / 1000)
+		resp.QueryTimeSec = &querySec
+		resp.QueryTimeUsec = &queryUsec
+	}
+
+	// answers, the rdata is in raw bytes for A and AAAA, text representation for others
+	for _, answer := range dm.DNS.DNSRRs.Answers {
+		name := dns.Fqdn(answer.Name)
+		rrtype := uint32(dns.StringToType[answer.Rdatatype])
+		class := uint32(answer.Class)
+		ttl := uint32(answer.TTL)
+
+		var rdata []byte
+		switch answer.Rdatatype {
+		case "A", "AAAA":
+			rdata = ipToBytes(answer.Rdata)
+		case "CNAME", "NS", "PTR", "DNAME":
+			rdata = []byte(dns.Fqdn(answer.Rdata))
+		default:
+			rdata = []byte(answer.Rdata)
+		}
+		resp.Rrs = append(resp.Rrs, &powerdns_protobuf.PBDNSMessage_DNSResponse_DNSRR{
+			Name: &name, Type: &rrtype, Class: &class, Ttl: &ttl, Rdata: rdata,
+		})
+	}
+
+	if dm.PowerDNS == nil {
+		return resp
+	}
+
+	resp.Tags = dm.PowerDNS.Tags
+	if len(dm.PowerDNS.AppliedPolicy) > 0 {
+		resp.AppliedPolicy = &dm.PowerDNS.AppliedPolicy
+	}
+	if len(dm.PowerDNS.AppliedPolicyHit) > 0 {
+		resp.AppliedPolicyHit = &dm.PowerDNS.AppliedPolicyHit
+	}
+	if len(dm.PowerDNS.AppliedPolicyTrigger) > 0 {
+		resp.AppliedPolicyTrigger = &dm.PowerDNS.AppliedPolicyTrigger
+	}
+	if kind, valid := powerdns_protobuf.PBDNSMessage_PolicyKind_value[dm.PowerDNS.AppliedPolicyKind]; valid {
+		policyKind := powerdns_protobuf.PBDNSMessage_PolicyKind(kind)
+		resp.AppliedPolicyKind = &policyKind
+	}
+	if ptype, valid := powerdns_protobuf.PBDNSMessage_PolicyType_value[dm.PowerDNS.AppliedPolicyType]; valid {
+		policyType := powerdns_protobuf.PBDNSMessage_PolicyType(ptype)
+		resp.AppliedPolicyType = &policyType
+	}
+	return resp
+}
+
+// ipToBytes returns the ip address in 4 or 16 bytes, nil if invalid
+func ipToBytes(ip string) []byte {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil
+	}
+	if ipv4 := addr.To4(); ipv4 != nil {
+		return ipv4
+	}
+	return addr.To16()
+}
+
 func (dm *DNSMessage) ToPacketLayer() ([]gopacket.SerializableLayer, error) {
 	if len(dm.DNS.Payload) == 0 {
 		return nil, errors.New("payload is empty")
@@ -1032,6 +1501,43 @@ func (dm *DNSMessage) Flatten() (ret map[string]interface{}, err error) {
 	return
 }
 
+// Unflatten rebuilds the dns message from the flat format, the lists
+// are restored from the keys with an index (dns.resource-records.an.0.name)
+func (dm *DNSMessage) Unflatten(flatDm map[string]interface{}) error {
+	nested, err := flat.Unflatten(flatDm, nil)
+	if err != nil {
+		return err
+	}
+	tmp, err := json.Marshal(unflattenLists(nested))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(tmp, dm)
+}
+
+// maps with the keys 0 to n-1 are converted to lists
+func unflattenLists(value interface{}) interface{} {
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	for k, v := range nested {
+		nested[k] = unflattenLists(v)
+	}
+	if len(nested) == 0 {
+		return nested
+	}
+	list := make([]interface{}, len(nested))
+	for k, v := range nested {
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 || i >= len(nested) {
+			return nested
+		}
+		list[i] = v
+	}
+	return list
+}
+
 func (dm *DNSMessage) Matching(matching map[string]interface{}) (error, bool) {
 	if len(matching) == 0 {
 		return nil, false
@@ -1129,6 +1635,11 @@ func matchUserMap(realValue, expectedValue reflect.Value) (bool, error) {
 				return false, nil
 			}
 
+			// float value like the suspicious score
+			if realValue.Kind() == reflect.Float64 {
+				return realValue.Float() > float64(opValue.Interface().(int)), nil
+			}
+
 			if realValue.Kind() != reflect.Int {
 				return false, nil
 			}
@@ -1161,6 +1672,11 @@ func matchUserMap(realValue, expectedValue reflect.Value) (bool, error) {
 				return false, nil
 			}
 
+			// float value like the suspicious score
+			if realValue.Kind() == reflect.Float64 {
+				return realValue.Float() < float64(opValue.Interface().(int)), nil
+			}
+
 			if realValue.Kind() != reflect.Int {
 				return false, nil
 			}
@@ -1406,6 +1922,11 @@ func matchUserPattern(realValue, expectedValue reflect.Value) (bool, error) {
 }
 
 func getFieldByJSONTag(value reflect.Value, nestedKeys string) (reflect.Value, bool) {
+	// nil pointer or not a structure
+	if value.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+
 	listKeys := strings.SplitN(nestedKeys, ".", 2)
 
 	for j, jsonKey := range listKeys {
@@ -1419,6 +1940,11 @@ func getFieldByJSONTag(value reflect.Value, nestedKeys string) (reflect.Value, b
 
 			// Check if the JSON tag matches
 			if tagClean == jsonKey {
+				// no more nested keys
+				if j+1 >= len(listKeys) {
+					return value.Field(i), true
+				}
+
 				// ptr
 				switch field.Type.Kind() {
 				// integer
@@ -1436,7 +1962,7 @@ func getFieldByJSONTag(value reflect.Value, nestedKeys string) (reflect.Value, b
 				// slice if a list
 				case reflect.Slice:
 					if fieldValue, leftKey, found := getSliceElement(value.Field(i), listKeys[j+1]); found {
-						switch field.Type.Kind() {
+						switch fieldValue.Kind() {
 						case reflect.Struct:
 							if fieldValue, found := getFieldByJSONTag(fieldValue, leftKey); found {
 								return fieldValue, true
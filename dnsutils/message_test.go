@@ -146,6 +146,58 @@ func TestDnsMessage_ToDNSTap(t *testing.T) {
 	}
 }
 
+func TestDnsMessage_ToDNSTap_ResolverFields(t *testing.T) {
+	dm := GetFakeDNSMessageWithPayload()
+	dm.DNS.Type = DNSReply
+	dm.DNSTap.Operation = "RESOLVER_RESPONSE"
+	dm.DNSTap.TimeSec, dm.DNSTap.TimeNsec = 1704486841, 227961611
+	dm.DNSTap.QueryTimeSec, dm.DNSTap.QueryTimeNsec = 1704486841, 216166066
+	dm.DNSTap.QueryZone = "dnscollector.dev"
+	dm.DNSTap.PolicyType = "RPZ"
+	dm.DNSTap.PolicyAction = "NXDOMAIN"
+	dm.DNSTap.PolicyMatch = "CLIENT_IP"
+
+	// encode to dnstap
+	tapMsg, err := dm.ToDNSTap(false)
+	if err != nil {
+		t.Fatalf("could not encode to dnstap: %v\n", err)
+	}
+
+	// decode dnstap message
+	dt := &dnstap.Dnstap{}
+	err = proto.Unmarshal(tapMsg, dt)
+	if err != nil {
+		t.Fatalf("error to decode dnstap: %v", err)
+	}
+
+	msg := dt.GetMessage()
+	if msg.GetQueryTimeSec() != 1704486841 || msg.GetQueryTimeNsec() != 216166066 {
+		t.Errorf("query time should be kept, got %d.%d", msg.GetQueryTimeSec(), msg.GetQueryTimeNsec())
+	}
+	if msg.GetResponseTimeNsec() != 227961611 {
+		t.Errorf("invalid response time, got %d", msg.GetResponseTimeNsec())
+	}
+	zone, _, err := ParseLabels(0, msg.GetQueryZone())
+	if err != nil || zone != dm.DNSTap.QueryZone {
+		t.Errorf("invalid query zone, got %s (%v)", zone, err)
+	}
+	if msg.GetPolicy().GetType() != "RPZ" || msg.GetPolicy().GetAction() != dnstap.Policy_NXDOMAIN ||
+		msg.GetPolicy().GetMatch() != dnstap.Policy_CLIENT_IP {
+		t.Errorf("invalid policy, got %v", msg.GetPolicy())
+	}
+	if msg.GetPolicy().Rule != nil || msg.GetPolicy().Value != nil {
+		t.Errorf("unset policy fields should not be encoded")
+	}
+
+	// without policy
+	dm = GetFakeDNSMessageWithPayload()
+	tapMsg, _ = dm.ToDNSTap(false)
+	proto.Unmarshal(tapMsg, dt)
+	if dt.GetMessage().GetPolicy() != nil || dt.GetMessage().GetQueryZone() != nil {
+		t.Errorf("policy and query zone should not be encoded")
+	}
+}
+
 // Tests for JSON format
 func TestDnsMessage_Json_Reference(t *testing.T) {
 	dm := DNSMessage{}
@@ -204,7 +256,12 @@ func TestDnsMessage_Json_Reference(t *testing.T) {
 				  "policy-action": "-",
 				  "policy-match": "-",
 				  "policy-value": "-",
-				  "policy-rule": "-"
+				  "policy-rule": "-",
+				  "query-zone": "-",
+				  "query-timestamp-rfc3339ns": "-",
+				  "response-timestamp-rfc3339ns": "-",
+				  "resolver-ip": "-",
+				  "resolver-port": "-"
 				}
 			}
 			`
@@ -260,6 +317,11 @@ func TestDnsMessage_JsonFlatten_Reference(t *testing.T) {
 					"dnstap.policy-action": "-",
 					"dnstap.policy-match": "-",
 					"dnstap.policy-value": "-",
+					"dnstap.query-zone": "-",
+					"dnstap.query-timestamp-rfc3339ns": "-",
+					"dnstap.response-timestamp-rfc3339ns": "-",
+					"dnstap.resolver-ip": "-",
+					"dnstap.resolver-port": "-",
 					"edns.dnssec-ok": 0,
 					"edns.options": [],
 					"edns.rcode": 0,
@@ -590,6 +652,24 @@ func TestDnsMessage_TextFormat_DefaultDirectives(t *testing.T) {
 				PolicyValue: "value"}},
 			expected: "rule type action match value",
 		},
+		{
+			format:   "edns-csubnet edns-nsid edns-cookie-client edns-cookie-server edns-expire",
+			dm:       DNSMessage{EDNS: DNSExtended{Options: []DNSOption{{Code: 8, Name: "CSUBNET", Data: "10.0.0.0/24"}}}},
+			expected: "10.0.0.0/24 - - - -",
+		},
+		{
+			format: "edns-nsid edns-cookie edns-cookie-client edns-cookie-server edns-keepalive",
+			dm: DNSMessage{EDNS: DNSExtended{Options: []DNSOption{
+				{Code: 3, Name: "NSID", Data: "6e7331 (ns1)"},
+				{Code: 10, Name: "COOKIE", Data: "24a5ac1223a3ba6c -"},
+				{Code: 11, Name: "KEEPALIVE", Data: "2m0s"}}}},
+			expected: "\"6e7331 (ns1)\" \"24a5ac1223a3ba6c -\" 24a5ac1223a3ba6c - 2m0s",
+		},
+		{
+			format:   "edns-cookie-client edns-cookie-server",
+			dm:       DNSMessage{EDNS: DNSExtended{Options: []DNSOption{{Code: 10, Name: "COOKIE", Data: ""}}}},
+			expected: "- -",
+		},
 	}
 
 	for _, tc := range testcases {
@@ -906,6 +986,171 @@ func TestDnsMessage_TextFormat_Directives_Reducer(t *testing.T) {
 	}
 }
 
+func TestDnsMessage_TextFormat_Directives_Transaction(t *testing.T) {
+	config := pkgconfig.GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DNSMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "transaction-status",
+			dm:       DNSMessage{},
+			expected: "-",
+		},
+		{
+			name:   "default",
+			format: "transaction-status transaction-query-timestamp transaction-query-length transaction-reply-length",
+			dm: DNSMessage{Transaction: &TransformTransaction{Status: "ANSWERED",
+				QueryTimestamp: "2024-01-05T20:34:01.216166066Z", QueryLength: 42, ReplyLength: 100}},
+			expected: "ANSWERED 2024-01-05T20:34:01.216166066Z 42 100",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Tunneling(t *testing.T) {
+	config := pkgconfig.GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DNSMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "tunneling-score",
+			dm:       DNSMessage{},
+			expected: "-",
+		},
+		{
+			name:   "default",
+			format: "tunneling-score tunneling-reasons tunneling-etld+1 tunneling-model-score",
+			dm: DNSMessage{Tunneling: &TransformTunneling{Score: 50, Reasons: []string{"domain-unique-subdomains", "domain-entropy"},
+				EtldPlusOne: "dnscollector.dev", ModelScore: 0.25}},
+			expected: "50 domain-unique-subdomains,domain-entropy dnscollector.dev 0.250",
+		},
+		{
+			name:   "stats",
+			format: "tunneling-client-queries tunneling-client-txt-null-ratio tunneling-domain-unique-subdomains tunneling-domain-entropy",
+			dm: DNSMessage{Tunneling: &TransformTunneling{Reasons: []string{},
+				Client: TunnelingStats{Queries: 10, TxtNullRatio: 0.5}, Domain: TunnelingStats{UniqueSubdomains: 60, Entropy: 3.75}}},
+			expected: "10 0.500 60 3.75",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Intel(t *testing.T) {
+	config := pkgconfig.GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DNSMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "intel-feed",
+			dm:       DNSMessage{},
+			expected: "-",
+		},
+		{
+			name:     "no match",
+			format:   "intel-feed intel-matches",
+			dm:       DNSMessage{Intel: &TransformIntel{Matches: []IntelMatch{}}},
+			expected: "- 0",
+		},
+		{
+			name:   "default",
+			format: "intel-feed intel-category intel-indicator intel-kind intel-matches",
+			dm: DNSMessage{Intel: &TransformIntel{Matches: []IntelMatch{
+				{Feed: "urlhaus", Category: "malware", Indicator: "malware.example.com", Kind: "cname"},
+				{Feed: "ioc", Category: "botnet", Indicator: "192.0.2.1", Kind: "rdata-ip"},
+			}}},
+			expected: "urlhaus malware malware.example.com cname 2",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Hostnames(t *testing.T) {
+	config := pkgconfig.GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DNSMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "hostnames-query",
+			dm:       DNSMessage{},
+			expected: "-",
+		},
+		{
+			name:     "default",
+			format:   "hostnames-query hostnames-response",
+			dm:       DNSMessage{Hostnames: &TransformHostnames{QueryHostname: "laptop.lan", ResponseHostname: "resolver.lan"}},
+			expected: "laptop.lan resolver.lan",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
 func TestDnsMessage_TextFormat_Directives_Extracted(t *testing.T) {
 	config := pkgconfig.GetFakeConfig()
 
@@ -994,3 +1239,51 @@ func TestDnsMessage_TextFormat_Directives_Filtering(t *testing.T) {
 		})
 	}
 }
+
+func TestDnsMessage_Unflatten(t *testing.T) {
+	dm := GetFakeDNSMessage()
+	dm.DNS.DNSRRs.Answers = append(dm.DNS.DNSRRs.Answers,
+		DNSAnswer{Name: "dnscollector.dev", Rdatatype: "A", Rdata: "1.2.3.4"},
+		DNSAnswer{Name: "dnscollector.dev", Rdatatype: "A", Rdata: "5.6.7.8"},
+	)
+	dm.ATags = &TransformATags{Tags: []string{"tag1", "tag2"}}
+	dm.Suspicious = &TransformSuspicious{Score: 1.5, MalformedPacket: true}
+
+	flatDm, err := dm.Flatten()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rebuild the dns message from the flat format decoded from json
+	var tmp []byte
+	if tmp, err = json.Marshal(flatDm); err != nil {
+		t.Fatal(err)
+	}
+	var flatJSON map[string]interface{}
+	if err = json.Unmarshal(tmp, &flatJSON); err != nil {
+		t.Fatal(err)
+	}
+	dmOut := DNSMessage{}
+	dmOut.Init()
+	if err := dmOut.Unflatten(flatJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dmOut.DNS.DNSRRs.Answers) != 2 || dmOut.DNS.DNSRRs.Answers[1].Rdata != "5.6.7.8" {
+		t.Errorf("invalid answers: %v", dmOut.DNS.DNSRRs.Answers)
+	}
+	if dmOut.ATags == nil || !reflect.DeepEqual(dmOut.ATags.Tags, dm.ATags.Tags) {
+		t.Errorf("invalid atags: %v", dmOut.ATags)
+	}
+	if dmOut.Suspicious == nil || dmOut.Suspicious.Score != 1.5 || !dmOut.Suspicious.MalformedPacket {
+		t.Errorf("invalid suspicious: %v", dmOut.Suspicious)
+	}
+
+	flatOut, err := dmOut.Flatten()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flatDm, flatOut) {
+		t.Errorf("flat dns message different after unflatten")
+	}
+}
@@ -0,0 +1,399 @@
+package loggers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	"github.com/grafana/dskit/backoff"
+)
+
+// ClickhouseColumns returns the columns definition of the table, built from the
+// flattened reference dns message. Lists and maps are not supported as columns
+// and are ignored.
+func ClickhouseColumns() []string {
+	dm := dnsutils.GetReferenceDNSMessage()
+	flat, err := dm.Flatten()
+	if err != nil {
+		return nil
+	}
+
+	columns := []string{}
+	for key, value := range flat {
+		var columnType string
+		switch value.(type) {
+		case string:
+			columnType = "String"
+		case float64:
+			columnType = "Float64"
+		case bool:
+			columnType = "Bool"
+		default:
+			continue
+		}
+		columns = append(columns, fmt.Sprintf("`%s` %s", key, columnType))
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+type ClickhouseClient struct {
+	stopProcess    chan bool
+	doneProcess    chan bool
+	stopRun        chan bool
+	doneRun        chan bool
+	inputChan      chan dnsutils.DNSMessage
+	outputChan     chan dnsutils.DNSMessage
+	config         *pkgconfig.Config
+	configChan     chan *pkgconfig.Config
+	logger         *logger.Logger
+	httpclient     *http.Client
+	name           string
+	insertURL      string
+	ctx            context.Context
+	cancel         context.CancelFunc
+	RoutingHandler pkgutils.RoutingHandler
+}
+
+func NewClickhouseClient(config *pkgconfig.Config, console *logger.Logger, name string) *ClickhouseClient {
+	console.Info(pkgutils.PrefixLogLogger+"[%s] clickhouse - enabled", name)
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &ClickhouseClient{
+		stopProcess:    make(chan bool),
+		doneProcess:    make(chan bool),
+		stopRun:        make(chan bool),
+		doneRun:        make(chan bool),
+		inputChan:      make(chan dnsutils.DNSMessage, config.Loggers.ClickhouseClient.ChannelBufferSize),
+		outputChan:     make(chan dnsutils.DNSMessage, config.Loggers.ClickhouseClient.ChannelBufferSize),
+		logger:         console,
+		config:         config,
+		configChan:     make(chan *pkgconfig.Config),
+		name:           name,
+		ctx:            ctx,
+		cancel:         cancel,
+		RoutingHandler: pkgutils.NewRoutingHandler(config, console, name),
+	}
+	c.ReadConfig()
+	return c
+}
+
+func (c *ClickhouseClient) GetName() string { return c.name }
+
+func (c *ClickhouseClient) AddDroppedRoute(wrk pkgutils.Worker) {
+	c.RoutingHandler.AddDroppedRoute(wrk)
+}
+
+func (c *ClickhouseClient) AddDefaultRoute(wrk pkgutils.Worker) {
+	c.RoutingHandler.AddDefaultRoute(wrk)
+}
+
+func (c *ClickhouseClient) SetLoggers(loggers []pkgutils.Worker) {}
+
+func (c *ClickhouseClient) ReadConfig() {
+	// tls client config
+	tlsOptions := pkgconfig.TLSOptions{
+		InsecureSkipVerify: c.config.Loggers.ClickhouseClient.TLSInsecure,
+		MinVersion:         c.config.Loggers.ClickhouseClient.TLSMinVersion,
+		CAFile:             c.config.Loggers.ClickhouseClient.CAFile,
+		CertFile:           c.config.Loggers.ClickhouseClient.CertFile,
+		KeyFile:            c.config.Loggers.ClickhouseClient.KeyFile,
+	}
+
+	tlsConfig, err := pkgconfig.TLSClientConfig(tlsOptions)
+	if err != nil {
+		c.logger.Fatal(pkgutils.PrefixLogLogger+"["+c.name+"] clickhouse - tls config failed:", err)
+	}
+
+	// prepare http client
+	tr := &http.Transport{
+		MaxIdleConns:    10,
+		IdleConnTimeout: 30 * time.Second,
+		TLSClientConfig: tlsConfig,
+	}
+	c.httpclient = &http.Client{
+		Transport: tr,
+		Timeout:   time.Duration(c.config.Loggers.ClickhouseClient.Timeout) * time.Second,
+	}
+
+	// prepare insert query, unknown fields are ignored by the server
+	query := fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", c.GetTableName())
+	c.insertURL = c.BuildURL(query, url.Values{"input_format_skip_unknown_fields": []string{"1"}})
+}
+
+func (c *ClickhouseClient) ReloadConfig(config *pkgconfig.Config) {
+	c.LogInfo("reload configuration!")
+	c.configChan <- config
+}
+
+func (c *ClickhouseClient) GetInputChannel() chan dnsutils.DNSMessage {
+	return c.inputChan
+}
+
+func (c *ClickhouseClient) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info(pkgutils.PrefixLogLogger+"["+c.name+"] clickhouse - "+msg, v...)
+}
+
+func (c *ClickhouseClient) LogError(msg string, v ...interface{}) {
+	c.logger.Error(pkgutils.PrefixLogLogger+"["+c.name+"] clickhouse - "+msg, v...)
+}
+
+func (c *ClickhouseClient) Stop() {
+	c.LogInfo("stopping logger...")
+	c.RoutingHandler.Stop()
+
+	c.LogInfo("stopping to run...")
+	c.stopRun <- true
+	<-c.doneRun
+
+	// the buffered messages are flushed before to stop
+	c.LogInfo("stopping to process...")
+	c.stopProcess <- true
+	<-c.doneProcess
+
+	// abort pending requests
+	c.cancel()
+}
+
+func (c *ClickhouseClient) GetTableName() string {
+	return fmt.Sprintf("`%s`.`%s`", c.config.Loggers.ClickhouseClient.Database, c.config.Loggers.ClickhouseClient.Table)
+}
+
+func (c *ClickhouseClient) BuildURL(query string, params url.Values) string {
+	u, err := url.Parse(c.config.Loggers.ClickhouseClient.URL)
+	if err != nil {
+		c.LogError("invalid url: %s", err)
+		return c.config.Loggers.ClickhouseClient.URL
+	}
+	values := u.Query()
+	for k, v := range params {
+		values[k] = v
+	}
+	if len(query) > 0 {
+		values.Set("query", query)
+	}
+	u.RawQuery = values.Encode()
+	return u.String()
+}
+
+// Execute sends the request to the clickhouse server and retries with backoff on failure
+func (c *ClickhouseClient) Execute(reqURL string, body []byte) error {
+	retries := backoff.New(c.ctx, backoff.Config{
+		MinBackoff: time.Duration(c.config.Loggers.ClickhouseClient.MinBackoff) * time.Second,
+		MaxBackoff: time.Duration(c.config.Loggers.ClickhouseClient.MaxBackoff) * time.Second,
+		MaxRetries: c.config.Loggers.ClickhouseClient.MaxRetries,
+	})
+
+	var lastErr error
+	for {
+		lastErr = c.send(reqURL, body)
+		if lastErr == nil {
+			return nil
+		}
+		c.LogError("%s", lastErr)
+
+		// wait before retry
+		retries.Wait()
+
+		// Make sure it sends at least once before checking for retry.
+		if !retries.Ongoing() {
+			break
+		}
+	}
+	return lastErr
+}
+
+func (c *ClickhouseClient) send(reqURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(c.ctx, "POST", reqURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-ClickHouse-User", c.config.Loggers.ClickhouseClient.User)
+	if len(c.config.Loggers.ClickhouseClient.Password) > 0 {
+		req.Header.Set("X-ClickHouse-Key", c.config.Loggers.ClickhouseClient.Password)
+	}
+
+	resp, err := c.httpclient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		scanner := bufio.NewScanner(io.LimitReader(resp.Body, 1024))
+		line := ""
+		if scanner.Scan() {
+			line = scanner.Text()
+		}
+		return fmt.Errorf("server returned HTTP status %s: %s", resp.Status, line)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (c *ClickhouseClient) CreateTable() error {
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) ENGINE = %s",
+		c.GetTableName(),
+		strings.Join(ClickhouseColumns(), ", "),
+		c.config.Loggers.ClickhouseClient.TableEngine,
+	)
+	return c.Execute(c.BuildURL("", nil), []byte(query))
+}
+
+func (c *ClickhouseClient) Run() {
+	c.LogInfo("running in background...")
+
+	// prepare next channels
+	defaultRoutes, defaultNames := c.RoutingHandler.GetDefaultRoutes()
+	droppedRoutes, droppedNames := c.RoutingHandler.GetDroppedRoutes()
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DNSMessage{}
+	listChannel = append(listChannel, c.outputChan)
+	subprocessors := transformers.NewTransforms(&c.config.OutgoingTransformers, c.logger, c.name, listChannel, 0)
+
+	// goroutine to process transformed dns messages
+	go c.Process()
+
+	// loop to process incoming messages
+RUN_LOOP:
+	for {
+		select {
+		case <-c.stopRun:
+			// cleanup transformers
+			subprocessors.Reset()
+
+			c.doneRun <- true
+			break RUN_LOOP
+
+		case cfg, opened := <-c.configChan:
+			if !opened {
+				return
+			}
+			c.config = cfg
+			c.ReadConfig()
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-c.inputChan:
+			if !opened {
+				c.LogInfo("input channel closed!")
+				return
+			}
+
+			// apply tranforms, init dns message with additionnals parts if necessary
+			subprocessors.InitDNSMessageFormat(&dm)
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
+				c.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
+				continue
+			}
+
+			// send to next ?
+			c.RoutingHandler.SendTo(defaultRoutes, defaultNames, dm)
+
+			// send to output channel
+			c.outputChan <- dm
+		}
+	}
+	c.LogInfo("run terminated")
+}
+
+func (c *ClickhouseClient) FlushBuffer(buf *[]dnsutils.DNSMessage) {
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+
+	for _, dm := range *buf {
+		flat, err := dm.Flatten()
+		if err != nil {
+			c.LogError("flattening DNS message failed: %e", err)
+			continue
+		}
+		encoder.Encode(flat)
+	}
+
+	if err := c.Execute(c.insertURL, buffer.Bytes()); err != nil {
+		c.LogError("insert failed, %d message(s) dropped", len(*buf))
+	}
+
+	*buf = nil
+}
+
+func (c *ClickhouseClient) Process() {
+	bufferDm := []dnsutils.DNSMessage{}
+
+	// create the table from the reference schema
+	if c.config.Loggers.ClickhouseClient.CreateTable {
+		if err := c.CreateTable(); err != nil {
+			c.LogError("unable to create table %s: %s", c.GetTableName(), err)
+		}
+	}
+
+	c.LogInfo("ready to process")
+
+	flushInterval := time.Duration(c.config.Loggers.ClickhouseClient.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+
+PROCESS_LOOP:
+	for {
+		select {
+		case <-c.stopProcess:
+			// flush the messages not yet inserted
+		DRAIN_LOOP:
+			for {
+				select {
+				case dm := <-c.outputChan:
+					bufferDm = append(bufferDm, dm)
+				default:
+					break DRAIN_LOOP
+				}
+			}
+			if len(bufferDm) > 0 {
+				c.FlushBuffer(&bufferDm)
+			}
+
+			flushTimer.Stop()
+			c.doneProcess <- true
+			break PROCESS_LOOP
+
+		// incoming dns message to process
+		case dm, opened := <-c.outputChan:
+			if !opened {
+				c.LogInfo("output channel closed!")
+				return
+			}
+
+			// append dns message to buffer
+			bufferDm = append(bufferDm, dm)
+
+			// buffer is full ?
+			if len(bufferDm) >= c.config.Loggers.ClickhouseClient.BatchSize {
+				c.FlushBuffer(&bufferDm)
+			}
+
+		// flush the buffer
+		case <-flushTimer.C:
+			if len(bufferDm) > 0 {
+				c.FlushBuffer(&bufferDm)
+			}
+
+			// restart timer
+			flushTimer.Reset(flushInterval)
+		}
+	}
+	c.LogInfo("processing terminated")
+}
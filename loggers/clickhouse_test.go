@@ -0,0 +1,138 @@
+package loggers
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClickhouseRequest struct {
+	query string
+	body  string
+}
+
+func newFakeClickhouse(requests chan fakeClickhouseRequest, failures int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		requests <- fakeClickhouseRequest{query: r.URL.Query().Get("query"), body: string(body)}
+	}))
+}
+
+func Test_ClickhouseClient_Insert(t *testing.T) {
+	testcases := []struct {
+		name      string
+		batchSize int
+		inputSize int
+		failures  int
+	}{
+		{name: "batch", batchSize: 10, inputSize: 50, failures: 0},
+		{name: "retry", batchSize: 10, inputSize: 10, failures: 2},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := make(chan fakeClickhouseRequest, 100)
+			srv := newFakeClickhouse(requests, tc.failures)
+			defer srv.Close()
+
+			conf := pkgconfig.GetFakeConfig()
+			conf.Loggers.ClickhouseClient.URL = srv.URL
+			conf.Loggers.ClickhouseClient.BatchSize = tc.batchSize
+			conf.Loggers.ClickhouseClient.MinBackoff = 0
+			conf.Loggers.ClickhouseClient.MaxBackoff = 0
+			g := NewClickhouseClient(conf, logger.New(false), "test")
+
+			go g.Run()
+
+			dm := dnsutils.GetFakeDNSMessage()
+			for i := 0; i < tc.inputSize; i++ {
+				g.GetInputChannel() <- dm
+			}
+
+			for i := 0; i < tc.inputSize/tc.batchSize; i++ {
+				req := <-requests
+				assert.Equal(t, "INSERT INTO `default`.`dnscollector` FORMAT JSONEachRow", req.query)
+
+				scanner := bufio.NewScanner(strings.NewReader(req.body))
+				cnt := 0
+				for scanner.Scan() {
+					var row map[string]interface{}
+					assert.NoError(t, json.Unmarshal(scanner.Bytes(), &row))
+					assert.Equal(t, "dns.collector", row["dns.qname"])
+					cnt++
+				}
+				assert.Equal(t, tc.batchSize, cnt)
+			}
+
+			g.Stop()
+		})
+	}
+}
+
+func Test_ClickhouseClient_FlushOnStop(t *testing.T) {
+	requests := make(chan fakeClickhouseRequest, 10)
+	srv := newFakeClickhouse(requests, 0)
+	defer srv.Close()
+
+	conf := pkgconfig.GetFakeConfig()
+	conf.Loggers.ClickhouseClient.URL = srv.URL
+	conf.Loggers.ClickhouseClient.BatchSize = 100
+	conf.Loggers.ClickhouseClient.FlushInterval = 3600
+	g := NewClickhouseClient(conf, logger.New(false), "test")
+
+	go g.Run()
+
+	dm := dnsutils.GetFakeDNSMessage()
+	for i := 0; i < 5; i++ {
+		g.GetInputChannel() <- dm
+	}
+	time.Sleep(time.Second)
+
+	// the buffer is not full, messages are inserted on stop
+	g.Stop()
+
+	select {
+	case req := <-requests:
+		assert.Equal(t, 5, strings.Count(req.body, "\n"))
+	default:
+		t.Errorf("buffered messages not flushed on stop")
+	}
+}
+
+func Test_ClickhouseClient_CreateTable(t *testing.T) {
+	requests := make(chan fakeClickhouseRequest, 10)
+	srv := newFakeClickhouse(requests, 0)
+	defer srv.Close()
+
+	conf := pkgconfig.GetFakeConfig()
+	conf.Loggers.ClickhouseClient.URL = srv.URL
+	conf.Loggers.ClickhouseClient.CreateTable = true
+	g := NewClickhouseClient(conf, logger.New(false), "test")
+
+	go g.Run()
+
+	req := <-requests
+	assert.True(t, strings.HasPrefix(req.body, "CREATE TABLE IF NOT EXISTS `default`.`dnscollector` ("))
+	assert.Contains(t, req.body, "`dns.qname` String")
+	assert.Contains(t, req.body, "`dns.flags.qr` Bool")
+	assert.Contains(t, req.body, "`dns.length` Float64")
+	assert.NotContains(t, req.body, "dns.resource-records.an")
+	assert.True(t, strings.HasSuffix(req.body, "ENGINE = MergeTree ORDER BY tuple()"))
+
+	g.Stop()
+}
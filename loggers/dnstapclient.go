@@ -33,6 +33,7 @@ type DnstapSender struct {
 	transportReady     chan bool
 	transportReconnect chan bool
 	name               string
+	spool              *pkgutils.SpoolHandler
 	RoutingHandler     pkgutils.RoutingHandler
 }
 
@@ -55,6 +56,14 @@ func NewDnstapSender(config *pkgconfig.Config, logger *logger.Logger, name strin
 	}
 
 	ds.ReadConfig()
+
+	// init the disk spool
+	spool, err := pkgutils.NewSpoolHandler(config.Loggers.DNSTap.Spool, name, logger,
+		ds.FlushBuffer, func() bool { return ds.fsReady })
+	if err != nil {
+		logger.Fatal(pkgutils.PrefixLogLogger+"["+name+"] dnstap - unable to open the spool:", err)
+	}
+	ds.spool = spool
 	return ds
 }
 
@@ -198,20 +207,24 @@ func (ds *DnstapSender) ConnectToRemote() {
 		ds.transportConn = conn
 
 		// block until framestream is ready
+		pkgutils.Telemetry.ConnectionOpened(ds.name)
 		ds.transportReady <- true
 
 		// block until an error occurred, need to reconnect
 		ds.transportReconnect <- true
+		pkgutils.Telemetry.ConnectionLost(ds.name)
 	}
 }
 
-func (ds *DnstapSender) FlushBuffer(buf *[]dnsutils.DNSMessage) {
+// FlushBuffer sends the buffer, returns the number of messages sent
+func (ds *DnstapSender) FlushBuffer(buf *[]dnsutils.DNSMessage) int {
+	sent := len(*buf)
 
 	var data []byte
 	var err error
 	frame := &framestream.Frame{}
 
-	for _, dm := range *buf {
+	for i, dm := range *buf {
 		// update identity ?
 		if ds.config.Loggers.DNSTap.OverwriteIdentity {
 			dm.DNSTap.Identity = ds.config.Loggers.DNSTap.ServerID
@@ -229,6 +242,7 @@ func (ds *DnstapSender) FlushBuffer(buf *[]dnsutils.DNSMessage) {
 		if err := ds.fs.SendFrame(frame); err != nil {
 			ds.LogError("send frame error %s", err)
 			ds.fsReady = false
+			sent = i
 			<-ds.transportReconnect
 			break
 		}
@@ -236,6 +250,7 @@ func (ds *DnstapSender) FlushBuffer(buf *[]dnsutils.DNSMessage) {
 
 	// reset buffer
 	*buf = nil
+	return sent
 }
 
 func (ds *DnstapSender) Run() {
@@ -283,7 +298,11 @@ RUN_LOOP:
 
 			// apply tranforms, init dns message with additionnals parts if necessary
 			subprocessors.InitDNSMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.ReturnDrop {
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
 				ds.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
@@ -317,6 +336,7 @@ PROCESS_LOOP:
 			// closing remote connection if exist
 			ds.Disconnect()
 
+			ds.spool.Close()
 			ds.doneProcess <- true
 			break PROCESS_LOOP
 
@@ -352,6 +372,12 @@ PROCESS_LOOP:
 				return
 			}
 
+			// keep the dns message on disk if the connection is not ready
+			// or if older messages are waiting to be replayed
+			if ds.spool.Keep(&bufferDm, dm) {
+				continue
+			}
+
 			// drop dns message if the connection is not ready to avoid memory leak or
 			// to block the channel
 			if !ds.fsReady {
@@ -363,19 +389,23 @@ PROCESS_LOOP:
 
 			// buffer is full ?
 			if len(bufferDm) >= ds.config.Loggers.DNSTap.BufferSize {
-				ds.FlushBuffer(&bufferDm)
+				ds.spool.FlushOrSpool(&bufferDm)
 			}
 
 		// flush the buffer
 		case <-flushTimer.C:
 			// force to flush the buffer
 			if len(bufferDm) > 0 {
-				ds.FlushBuffer(&bufferDm)
+				ds.spool.FlushOrSpool(&bufferDm)
 			}
 
 			// restart timer
 			flushTimer.Reset(flushInterval)
 
+		// replay the messages kept on disk
+		case <-ds.spool.ReplayChan():
+			ds.spool.Replay(ds.config.Loggers.DNSTap.BufferSize)
+
 			// case <-nextStanzaBufferFull.C:
 			// 	for v, k := range ds.droppedCount {
 			// 		if k > 0 {
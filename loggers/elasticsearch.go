@@ -148,7 +148,11 @@ RUN_LOOP:
 
 			// apply tranforms, init dns message with additionnals parts if necessary
 			subprocessors.InitDNSMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.ReturnDrop {
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
 				ec.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
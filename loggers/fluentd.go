@@ -35,6 +35,7 @@ type FluentdClient struct {
 	transportReconnect chan bool
 	writerReady        bool
 	name               string
+	spool              *pkgutils.SpoolHandler
 	RoutingHandler     pkgutils.RoutingHandler
 }
 
@@ -59,6 +60,14 @@ func NewFluentdClient(config *pkgconfig.Config, logger *logger.Logger, name stri
 	}
 
 	fc.ReadConfig()
+
+	// init the disk spool
+	spool, err := pkgutils.NewSpoolHandler(config.Loggers.Fluentd.Spool, name, logger,
+		fc.FlushBuffer, func() bool { return fc.writerReady })
+	if err != nil {
+		logger.Fatal(pkgutils.PrefixLogLogger+"["+name+"] fluentd - unable to open the spool:", err)
+	}
+	fc.spool = spool
 	return fc
 }
 
@@ -211,18 +220,22 @@ func (fc *FluentdClient) ConnectToRemote() {
 		fc.transportConn = conn
 
 		// block until framestream is ready
+		pkgutils.Telemetry.ConnectionOpened(fc.name)
 		fc.transportReady <- true
 
 		// block until an error occurred, need to reconnect
 		fc.transportReconnect <- true
+		pkgutils.Telemetry.ConnectionLost(fc.name)
 	}
 }
 
-func (fc *FluentdClient) FlushBuffer(buf *[]dnsutils.DNSMessage) {
+// FlushBuffer sends the buffer, returns the number of messages sent
+func (fc *FluentdClient) FlushBuffer(buf *[]dnsutils.DNSMessage) int {
+	sent := len(*buf)
 
 	tag, _ := msgpack.Marshal(fc.config.Loggers.Fluentd.Tag)
 
-	for _, dm := range *buf {
+	for i, dm := range *buf {
 		// prepare event
 		tm, _ := msgpack.Marshal(dm.DNSTap.TimeSec)
 		record, err := msgpack.Marshal(dm)
@@ -247,10 +260,15 @@ func (fc *FluentdClient) FlushBuffer(buf *[]dnsutils.DNSMessage) {
 		if err != nil {
 			fc.LogError("send transport error", err.Error())
 			fc.writerReady = false
+			sent = i
 			<-fc.transportReconnect
 			break
 		}
 	}
+
+	// reset buffer
+	*buf = nil
+	return sent
 }
 
 func (fc *FluentdClient) Run() {
@@ -298,7 +316,11 @@ RUN_LOOP:
 
 			// apply tranforms, init dns message with additionnals parts if necessary
 			subprocessors.InitDNSMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.ReturnDrop {
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
 				fc.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
@@ -327,6 +349,7 @@ PROCESS_LOOP:
 	for {
 		select {
 		case <-fc.stopProcess:
+			fc.spool.Close()
 			fc.doneProcess <- true
 			break PROCESS_LOOP
 
@@ -344,6 +367,12 @@ PROCESS_LOOP:
 				return
 			}
 
+			// keep the dns message on disk if the connection is not ready
+			// or if older messages are waiting to be replayed
+			if fc.spool.Keep(&bufferDm, dm) {
+				continue
+			}
+
 			// drop dns message if the connection is not ready to avoid memory leak or
 			// to block the channel
 			if !fc.writerReady {
@@ -355,7 +384,7 @@ PROCESS_LOOP:
 
 			// buffer is full ?
 			if len(bufferDm) >= fc.config.Loggers.Fluentd.BufferSize {
-				fc.FlushBuffer(&bufferDm)
+				fc.spool.FlushOrSpool(&bufferDm)
 			}
 
 		// flush the buffer
@@ -365,11 +394,15 @@ PROCESS_LOOP:
 			}
 
 			if len(bufferDm) > 0 {
-				fc.FlushBuffer(&bufferDm)
+				fc.spool.FlushOrSpool(&bufferDm)
 			}
 
 			// restart timer
 			flushTimer.Reset(flushInterval)
+
+		// replay the messages kept on disk
+		case <-fc.spool.ReplayChan():
+			fc.spool.Replay(fc.config.Loggers.Fluentd.BufferSize)
 		}
 	}
 	fc.LogInfo("processing terminated")
@@ -37,6 +37,7 @@ type KafkaProducer struct {
 	kafkaReconnect chan bool
 	kafkaConnected bool
 	compressCodec  compress.Codec
+	spool          *pkgutils.SpoolHandler
 	RoutingHandler pkgutils.RoutingHandler
 }
 
@@ -59,6 +60,14 @@ func NewKafkaProducer(config *pkgconfig.Config, logger *logger.Logger, name stri
 	}
 
 	k.ReadConfig()
+
+	// init the disk spool
+	spool, err := pkgutils.NewSpoolHandler(config.Loggers.KafkaProducer.Spool, name, logger,
+		k.FlushBuffer, func() bool { return k.kafkaConnected })
+	if err != nil {
+		logger.Fatal(pkgutils.PrefixLogLogger+"["+name+"] kafka - unable to open the spool:", err)
+	}
+	k.spool = spool
 	return k
 }
 
@@ -214,7 +223,9 @@ func (k *KafkaProducer) ConnectToKafka(ctx context.Context, readyTimer *time.Tim
 	}
 }
 
-func (k *KafkaProducer) FlushBuffer(buf *[]dnsutils.DNSMessage) {
+// FlushBuffer sends the buffer, returns the number of messages sent
+func (k *KafkaProducer) FlushBuffer(buf *[]dnsutils.DNSMessage) int {
+	sent := len(*buf)
 	msgs := []kafka.Message{}
 	buffer := new(bytes.Buffer)
 	strDm := ""
@@ -256,11 +267,13 @@ func (k *KafkaProducer) FlushBuffer(buf *[]dnsutils.DNSMessage) {
 	if err != nil {
 		k.LogError("unable to write message", err.Error())
 		k.kafkaConnected = false
+		sent = 0
 		<-k.kafkaReconnect
 	}
 
 	// reset buffer
 	*buf = nil
+	return sent
 }
 
 func (k *KafkaProducer) Run() {
@@ -305,7 +318,11 @@ RUN_LOOP:
 
 			// apply tranforms, init dns message with additionnals parts if necessary
 			subprocessors.InitDNSMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.ReturnDrop {
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
 				k.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
@@ -344,6 +361,7 @@ PROCESS_LOOP:
 		case <-k.stopProcess:
 			// closing kafka connection if exist
 			k.Disconnect()
+			k.spool.Close()
 			k.doneProcess <- true
 			break PROCESS_LOOP
 
@@ -363,6 +381,12 @@ PROCESS_LOOP:
 				return
 			}
 
+			// keep the dns message on disk if the connection is not ready
+			// or if older messages are waiting to be replayed
+			if k.spool.Keep(&bufferDm, dm) {
+				continue
+			}
+
 			// drop dns message if the connection is not ready to avoid memory leak or
 			// to block the channel
 			if !k.kafkaConnected {
@@ -374,7 +398,7 @@ PROCESS_LOOP:
 
 			// buffer is full ?
 			if len(bufferDm) >= k.config.Loggers.KafkaProducer.BufferSize {
-				k.FlushBuffer(&bufferDm)
+				k.spool.FlushOrSpool(&bufferDm)
 			}
 
 		// flush the buffer
@@ -384,11 +408,15 @@ PROCESS_LOOP:
 			}
 
 			if len(bufferDm) > 0 {
-				k.FlushBuffer(&bufferDm)
+				k.spool.FlushOrSpool(&bufferDm)
 			}
 
 			// restart timer
 			flushTimer.Reset(flushInterval)
+
+		// replay the messages kept on disk
+		case <-k.spool.ReplayChan():
+			k.spool.Replay(k.config.Loggers.KafkaProducer.BufferSize)
 		}
 	}
 	k.LogInfo("processing terminated")
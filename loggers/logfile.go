@@ -32,6 +32,145 @@ const (
 	compressSuffix = ".gz"
 )
 
+// fields of the file template, like {identity}
+var templateFieldRegex = regexp.MustCompile(`\{[a-z0-9\-+:]+\}`)
+
+// the values of the fields can't change the folders
+func sanitizePathValue(value string) string {
+	if value == "." || value == ".." {
+		return "_"
+	}
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(value)
+}
+
+// strftime expands the %Y %m %d %H %M %S %j %y and %s tokens of the format
+func strftime(format string, t time.Time) string {
+	if !strings.Contains(format, "%") {
+		return format
+	}
+
+	var s strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i == len(format)-1 {
+			s.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&s, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&s, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&s, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&s, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&s, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&s, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&s, "%02d", t.Second())
+		case 'j':
+			fmt.Fprintf(&s, "%03d", t.YearDay())
+		case 's':
+			s.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case '%':
+			s.WriteByte('%')
+		default:
+			s.WriteByte('%')
+			s.WriteByte(format[i])
+		}
+	}
+	return s.String()
+}
+
+// columns of the parquet mode, named like the flat-json keys.
+// The schema is stable: the lists and the transformers are encoded in json,
+// empty when the transformer is disabled.
+type parquetField struct {
+	pkgutils.ParquetColumn
+	value func(dm *dnsutils.DNSMessage) interface{}
+}
+
+func parquetJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return ""
+	}
+	return string(data)
+}
+
+var parquetFields = []parquetField{
+	{pkgutils.ParquetColumn{Name: "network.family", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.NetworkInfo.Family }},
+	{pkgutils.ParquetColumn{Name: "network.protocol", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.NetworkInfo.Protocol }},
+	{pkgutils.ParquetColumn{Name: "network.query-ip", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.NetworkInfo.QueryIP }},
+	{pkgutils.ParquetColumn{Name: "network.query-port", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.NetworkInfo.QueryPort }},
+	{pkgutils.ParquetColumn{Name: "network.response-ip", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.NetworkInfo.ResponseIP }},
+	{pkgutils.ParquetColumn{Name: "network.response-port", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.NetworkInfo.ResponsePort }},
+	{pkgutils.ParquetColumn{Name: "network.ip-defragmented", Type: pkgutils.ParquetBoolean}, func(dm *dnsutils.DNSMessage) interface{} { return dm.NetworkInfo.IPDefragmented }},
+	{pkgutils.ParquetColumn{Name: "network.tcp-reassembled", Type: pkgutils.ParquetBoolean}, func(dm *dnsutils.DNSMessage) interface{} { return dm.NetworkInfo.TCPReassembled }},
+	{pkgutils.ParquetColumn{Name: "dns.length", Type: pkgutils.ParquetInt64}, func(dm *dnsutils.DNSMessage) interface{} { return int64(dm.DNS.Length) }},
+	{pkgutils.ParquetColumn{Name: "dns.id", Type: pkgutils.ParquetInt64}, func(dm *dnsutils.DNSMessage) interface{} { return int64(dm.DNS.ID) }},
+	{pkgutils.ParquetColumn{Name: "dns.opcode", Type: pkgutils.ParquetInt64}, func(dm *dnsutils.DNSMessage) interface{} { return int64(dm.DNS.Opcode) }},
+	{pkgutils.ParquetColumn{Name: "dns.rcode", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNS.Rcode }},
+	{pkgutils.ParquetColumn{Name: "dns.qname", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNS.Qname }},
+	{pkgutils.ParquetColumn{Name: "dns.qtype", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNS.Qtype }},
+	{pkgutils.ParquetColumn{Name: "dns.flags.qr", Type: pkgutils.ParquetBoolean}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNS.Flags.QR }},
+	{pkgutils.ParquetColumn{Name: "dns.flags.tc", Type: pkgutils.ParquetBoolean}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNS.Flags.TC }},
+	{pkgutils.ParquetColumn{Name: "dns.flags.aa", Type: pkgutils.ParquetBoolean}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNS.Flags.AA }},
+	{pkgutils.ParquetColumn{Name: "dns.flags.ra", Type: pkgutils.ParquetBoolean}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNS.Flags.RA }},
+	{pkgutils.ParquetColumn{Name: "dns.flags.ad", Type: pkgutils.ParquetBoolean}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNS.Flags.AD }},
+	{pkgutils.ParquetColumn{Name: "dns.flags.rd", Type: pkgutils.ParquetBoolean}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNS.Flags.RD }},
+	{pkgutils.ParquetColumn{Name: "dns.flags.cd", Type: pkgutils.ParquetBoolean}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNS.Flags.CD }},
+	{pkgutils.ParquetColumn{Name: "dns.malformed-packet", Type: pkgutils.ParquetBoolean}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNS.MalformedPacket }},
+	{pkgutils.ParquetColumn{Name: "dns.resource-records.an", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.DNS.DNSRRs.Answers) }},
+	{pkgutils.ParquetColumn{Name: "dns.resource-records.ns", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.DNS.DNSRRs.Nameservers) }},
+	{pkgutils.ParquetColumn{Name: "dns.resource-records.ar", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.DNS.DNSRRs.Records) }},
+	{pkgutils.ParquetColumn{Name: "edns.udp-size", Type: pkgutils.ParquetInt64}, func(dm *dnsutils.DNSMessage) interface{} { return int64(dm.EDNS.UDPSize) }},
+	{pkgutils.ParquetColumn{Name: "edns.rcode", Type: pkgutils.ParquetInt64}, func(dm *dnsutils.DNSMessage) interface{} { return int64(dm.EDNS.ExtendedRcode) }},
+	{pkgutils.ParquetColumn{Name: "edns.version", Type: pkgutils.ParquetInt64}, func(dm *dnsutils.DNSMessage) interface{} { return int64(dm.EDNS.Version) }},
+	{pkgutils.ParquetColumn{Name: "edns.dnssec-ok", Type: pkgutils.ParquetInt64}, func(dm *dnsutils.DNSMessage) interface{} { return int64(dm.EDNS.Do) }},
+	{pkgutils.ParquetColumn{Name: "edns.options", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.EDNS.Options) }},
+	{pkgutils.ParquetColumn{Name: "dnstap.operation", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.Operation }},
+	{pkgutils.ParquetColumn{Name: "dnstap.identity", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.Identity }},
+	{pkgutils.ParquetColumn{Name: "dnstap.version", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.Version }},
+	{pkgutils.ParquetColumn{Name: "dnstap.timestamp-rfc3339ns", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.TimestampRFC3339 }},
+	{pkgutils.ParquetColumn{Name: "dnstap.latency", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.LatencySec }},
+	{pkgutils.ParquetColumn{Name: "dnstap.extra", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.Extra }},
+	{pkgutils.ParquetColumn{Name: "dnstap.policy-rule", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.PolicyRule }},
+	{pkgutils.ParquetColumn{Name: "dnstap.policy-type", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.PolicyType }},
+	{pkgutils.ParquetColumn{Name: "dnstap.policy-match", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.PolicyMatch }},
+	{pkgutils.ParquetColumn{Name: "dnstap.policy-action", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.PolicyAction }},
+	{pkgutils.ParquetColumn{Name: "dnstap.policy-value", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.PolicyValue }},
+	{pkgutils.ParquetColumn{Name: "dnstap.query-zone", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.QueryZone }},
+	{pkgutils.ParquetColumn{Name: "dnstap.query-timestamp-rfc3339ns", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.QueryTimestamp }},
+	{pkgutils.ParquetColumn{Name: "dnstap.response-timestamp-rfc3339ns", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.ResponseTimestamp }},
+	{pkgutils.ParquetColumn{Name: "dnstap.resolver-ip", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.ResolverIP }},
+	{pkgutils.ParquetColumn{Name: "dnstap.resolver-port", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return dm.DNSTap.ResolverPort }},
+	{pkgutils.ParquetColumn{Name: "geoip", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.Geo) }},
+	{pkgutils.ParquetColumn{Name: "powerdns", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.PowerDNS) }},
+	{pkgutils.ParquetColumn{Name: "suspicious", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.Suspicious) }},
+	{pkgutils.ParquetColumn{Name: "publicsuffix", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.PublicSuffix) }},
+	{pkgutils.ParquetColumn{Name: "extracted", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.Extracted) }},
+	{pkgutils.ParquetColumn{Name: "reducer", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.Reducer) }},
+	{pkgutils.ParquetColumn{Name: "ml", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.MachineLearning) }},
+	{pkgutils.ParquetColumn{Name: "filtering", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.Filtering) }},
+	{pkgutils.ParquetColumn{Name: "atags", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.ATags) }},
+	{pkgutils.ParquetColumn{Name: "transaction", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.Transaction) }},
+	{pkgutils.ParquetColumn{Name: "tunneling", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.Tunneling) }},
+	{pkgutils.ParquetColumn{Name: "intel", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.Intel) }},
+	{pkgutils.ParquetColumn{Name: "hostnames", Type: pkgutils.ParquetString}, func(dm *dnsutils.DNSMessage) interface{} { return parquetJSON(dm.Hostnames) }},
+}
+
+var parquetColumns = func() []pkgutils.ParquetColumn {
+	columns := make([]pkgutils.ParquetColumn, len(parquetFields))
+	for i := range parquetFields {
+		columns[i] = parquetFields[i].ParquetColumn
+	}
+	return columns
+}()
+
 func IsValidMode(mode string) bool {
 	switch mode {
 	case
@@ -39,12 +178,36 @@ func IsValidMode(mode string) bool {
 		pkgconfig.ModeJSON,
 		pkgconfig.ModeFlatJSON,
 		pkgconfig.ModePCAP,
-		pkgconfig.ModeDNSTap:
+		pkgconfig.ModeDNSTap,
+		pkgconfig.ModeParquet:
 		return true
 	}
 	return false
 }
 
+// one opened file, several files are opened at the same time with a file template
+type logFileOutput struct {
+	key           string
+	path          string
+	period        string
+	lastUse       time.Time
+	fd            *os.File
+	size          int64
+	writerPlain   *bufio.Writer
+	writerPcap    *pcapgo.Writer
+	writerDnstap  *framestream.Encoder
+	writerParquet *pkgutils.ParquetWriter
+}
+
+// IsEmpty returns true when no message is written to the file, the header of
+// a parquet file is written on open
+func (out *logFileOutput) IsEmpty() bool {
+	if out.writerParquet != nil {
+		return out.writerParquet.NumRows() == 0
+	}
+	return out.size == 0
+}
+
 type LogFile struct {
 	stopProcess    chan bool
 	doneProcess    chan bool
@@ -52,18 +215,12 @@ type LogFile struct {
 	doneRun        chan bool
 	inputChan      chan dnsutils.DNSMessage
 	outputChan     chan dnsutils.DNSMessage
-	writerPlain    *bufio.Writer
-	writerPcap     *pcapgo.Writer
-	writerDnstap   *framestream.Encoder
 	config         *pkgconfig.Config
 	configChan     chan *pkgconfig.Config
 	logger         *logger.Logger
-	fileFd         *os.File
-	fileSize       int64
-	fileDir        string
-	fileName       string
-	fileExt        string
-	filePrefix     string
+	outputs        map[string]*logFileOutput
+	closedFiles    []string
+	fileTemplate   string
 	commpressTimer *time.Timer
 	textFormat     []string
 	name           string
@@ -82,14 +239,18 @@ func NewLogFile(config *pkgconfig.Config, logger *logger.Logger, name string) *L
 		config:         config,
 		configChan:     make(chan *pkgconfig.Config),
 		logger:         logger,
+		outputs:        make(map[string]*logFileOutput),
 		name:           name,
 		RoutingHandler: pkgutils.NewRoutingHandler(config, logger, name),
 	}
 
 	lf.ReadConfig()
 
-	if err := lf.OpenFile(); err != nil {
-		lf.logger.Fatal(pkgutils.PrefixLogLogger+"["+name+"] file - unable to open output file:", err)
+	// with a template, the files are opened on the first message
+	if len(lf.fileTemplate) == 0 {
+		if _, err := lf.GetOutput(nil); err != nil {
+			lf.logger.Fatal(pkgutils.PrefixLogLogger+"["+name+"] file - unable to open output file:", err)
+		}
 	}
 
 	return lf
@@ -115,10 +276,14 @@ func (lf *LogFile) ReadConfig() {
 	if !IsValidMode(lf.config.Loggers.LogFile.Mode) {
 		lf.logger.Fatal("["+lf.name+"] logger=file - invalid mode: ", lf.config.Loggers.LogFile.Mode)
 	}
-	lf.fileDir = filepath.Dir(lf.config.Loggers.LogFile.FilePath)
-	lf.fileName = filepath.Base(lf.config.Loggers.LogFile.FilePath)
-	lf.fileExt = filepath.Ext(lf.fileName)
-	lf.filePrefix = strings.TrimSuffix(lf.fileName, lf.fileExt)
+	// the fields of the template must be valid text directives
+	lf.fileTemplate = lf.config.Loggers.LogFile.FileTemplate
+	if len(lf.fileTemplate) > 0 {
+		dm := dnsutils.GetFakeDNSMessage()
+		if _, err := lf.ExpandFields(lf.fileTemplate, &dm); err != nil {
+			lf.logger.Fatal("["+lf.name+"] logger=file - invalid file template: ", err)
+		}
+	}
 
 	if len(lf.config.Loggers.LogFile.TextFormat) > 0 {
 		lf.textFormat = strings.Fields(lf.config.Loggers.LogFile.TextFormat)
@@ -155,33 +320,138 @@ func (lf *LogFile) Stop() {
 	<-lf.doneProcess
 }
 
-func (lf *LogFile) Cleanup() error {
+// ExpandFields replaces the {field} of the template by the text directives of
+// the dns message, {name} is the name of the logger
+func (lf *LogFile) ExpandFields(template string, dm *dnsutils.DNSMessage) (string, error) {
+	var err error
+	path := templateFieldRegex.ReplaceAllStringFunc(template, func(field string) string {
+		field = strings.Trim(field, "{}")
+		if field == "name" {
+			return sanitizePathValue(lf.name)
+		}
+		value, e := dm.ToTextLine([]string{field}, " ", "")
+		if e != nil {
+			err = e
+		}
+		return sanitizePathValue(string(value))
+	})
+	return path, err
+}
+
+// GetOutput returns the file of the dns message, the file of the previous period is closed
+// when the strftime tokens of the template are changing
+func (lf *LogFile) GetOutput(dm *dnsutils.DNSMessage) (*logFileOutput, error) {
+	key := lf.config.Loggers.LogFile.FilePath
+	path := key
+	period := ""
+	if len(lf.fileTemplate) > 0 {
+		// the time is expanded before the fields, a % in the values is kept as is
+		period = strftime(lf.fileTemplate, time.Now())
+		key, _ = lf.ExpandFields(lf.fileTemplate, dm)
+	}
+
+	out, exists := lf.outputs[key]
+	if exists && out.period == period {
+		out.lastUse = time.Now()
+		return out, nil
+	}
+	if exists {
+		lf.CloseOutput(out, true)
+	} else if len(lf.outputs) >= lf.config.Loggers.LogFile.MaxOpenFiles {
+		lf.EvictOutput()
+	}
+
+	if len(lf.fileTemplate) > 0 {
+		path, _ = lf.ExpandFields(period, dm)
+	}
+	out = &logFileOutput{key: key, path: path, period: period, lastUse: time.Now()}
+	if err := lf.OpenFile(out); err != nil {
+		return nil, err
+	}
+	lf.outputs[key] = out
+	return out, nil
+}
+
+// EvictOutput closes the least recently used file, the file is opened again on the next message
+func (lf *LogFile) EvictOutput() {
+	var oldest *logFileOutput
+	for _, out := range lf.outputs {
+		if oldest == nil || out.lastUse.Before(oldest.lastUse) {
+			oldest = out
+		}
+	}
+	if oldest == nil {
+		return
+	}
+
+	lf.LogInfo("too many opened files, closing %s", oldest.path)
+	lf.CloseOutput(oldest, false)
+
+	// the rotated files are no more listed with the opened ones
+	if lf.config.Loggers.LogFile.Compress {
+		rotated, err := rotatedFiles(oldest.path)
+		if err != nil {
+			lf.LogError("unable to list all files: %s", err)
+			return
+		}
+		lf.closedFiles = append(lf.closedFiles, rotated...)
+	}
+}
+
+// CloseOutput closes the file, the finished files of the template are processed like the rotated ones
+func (lf *LogFile) CloseOutput(out *logFileOutput, finished bool) {
+	lf.FlushWriters(out)
+	lf.CloseWriters(out)
+	out.fd.Close()
+	delete(lf.outputs, out.key)
+
+	if finished && len(lf.fileTemplate) > 0 && !out.IsEmpty() {
+		lf.LogInfo("file closed: %s", out.path)
+		lf.PostRotateCommand(out.path)
+		if lf.config.Loggers.LogFile.Compress {
+			lf.closedFiles = append(lf.closedFiles, out.path)
+		}
+	}
+}
+
+// rotated files are renamed with a timestamp suffix
+func rotatedFilePath(path string) string {
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(filepath.Base(path), ext)
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf("%s-%d%s", prefix, time.Now().UnixNano(), ext))
+}
+
+func (lf *LogFile) Cleanup(path string) error {
 	if lf.config.Loggers.LogFile.MaxFiles == 0 {
 		return nil
 	}
 
+	fileDir := filepath.Dir(path)
+	fileExt := filepath.Ext(path)
+	filePrefix := strings.TrimSuffix(filepath.Base(path), fileExt)
+
 	// remove old files ? keep only max files number
-	entries, err := os.ReadDir(lf.fileDir)
+	entries, err := os.ReadDir(fileDir)
 	if err != nil {
 		return err
 	}
 
+	// extract timestamp from filename
+	re := regexp.MustCompile(`^` + regexp.QuoteMeta(filePrefix) + `-(?P<ts>\d+)` + regexp.QuoteMeta(fileExt))
+	tsIndex := re.SubexpIndex("ts")
+
 	logFiles := []int{}
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
 
-		// extract timestamp from filename
-		re := regexp.MustCompile(`^` + lf.filePrefix + `-(?P<ts>\d+)` + lf.fileExt)
 		matches := re.FindStringSubmatch(entry.Name())
-
 		if len(matches) == 0 {
 			continue
 		}
 
 		// convert timestamp to int
-		tsIndex := re.SubexpIndex("ts")
 		i, err := strconv.Atoi(matches[tsIndex])
 		if err != nil {
 			continue
@@ -194,10 +464,10 @@ func (lf *LogFile) Cleanup() error {
 	diffNB := len(logFiles) - lf.config.Loggers.LogFile.MaxFiles
 	if diffNB > 0 {
 		for i := 0; i < diffNB; i++ {
-			filename := fmt.Sprintf("%s-%d%s", lf.filePrefix, logFiles[i], lf.fileExt)
-			f := filepath.Join(lf.fileDir, filename)
+			filename := fmt.Sprintf("%s-%d%s", filePrefix, logFiles[i], fileExt)
+			f := filepath.Join(fileDir, filename)
 			if _, err := os.Stat(f); os.IsNotExist(err) {
-				f = filepath.Join(lf.fileDir, filename+compressSuffix)
+				f = filepath.Join(fileDir, filename+compressSuffix)
 			}
 
 			// ignore errors on deletion
@@ -208,44 +478,65 @@ func (lf *LogFile) Cleanup() error {
 	return nil
 }
 
-func (lf *LogFile) OpenFile() error {
+func (lf *LogFile) OpenFile(out *logFileOutput) error {
+	// directories of the template are created as needed
+	if err := os.MkdirAll(filepath.Dir(out.path), 0755); err != nil {
+		return err
+	}
 
-	fd, err := os.OpenFile(lf.config.Loggers.LogFile.FilePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	flag := os.O_RDWR | os.O_CREATE | os.O_APPEND
+
+	// parquet files can't be appended, the previous one is renamed
+	if lf.config.Loggers.LogFile.Mode == pkgconfig.ModeParquet {
+		if fileinfo, err := os.Stat(out.path); err == nil && fileinfo.Size() > 0 {
+			if err := os.Rename(out.path, rotatedFilePath(out.path)); err != nil {
+				return err
+			}
+		}
+		flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	}
+
+	fd, err := os.OpenFile(out.path, flag, 0644)
 	if err != nil {
 		return err
 	}
-	lf.fileFd = fd
+	out.fd = fd
 
-	fileinfo, err := os.Stat(lf.config.Loggers.LogFile.FilePath)
+	fileinfo, err := os.Stat(out.path)
 	if err != nil {
 		return err
 	}
 
-	lf.fileSize = fileinfo.Size()
+	out.size = fileinfo.Size()
 
 	switch lf.config.Loggers.LogFile.Mode {
 	case pkgconfig.ModeText, pkgconfig.ModeJSON, pkgconfig.ModeFlatJSON:
 		bufferSize := 4096
-		lf.writerPlain = bufio.NewWriterSize(fd, bufferSize)
+		out.writerPlain = bufio.NewWriterSize(fd, bufferSize)
 
 	case pkgconfig.ModePCAP:
-		lf.writerPcap = pcapgo.NewWriter(fd)
-		if lf.fileSize == 0 {
-			if err := lf.writerPcap.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		out.writerPcap = pcapgo.NewWriter(fd)
+		if out.size == 0 {
+			if err := out.writerPcap.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
 				return err
 			}
 		}
 
 	case pkgconfig.ModeDNSTap:
 		fsOptions := &framestream.EncoderOptions{ContentType: []byte("protobuf:dnstap.Dnstap"), Bidirectional: false}
-		lf.writerDnstap, err = framestream.NewEncoder(fd, fsOptions)
+		out.writerDnstap, err = framestream.NewEncoder(fd, fsOptions)
 		if err != nil {
 			return err
 		}
 
+	case pkgconfig.ModeParquet:
+		out.writerParquet, err = pkgutils.NewParquetWriter(fd, parquetColumns, pkgutils.ParquetRowGroupSize)
+		if err != nil {
+			return err
+		}
 	}
 
-	lf.LogInfo("file opened with success: %s", lf.config.Loggers.LogFile.FilePath)
+	lf.LogInfo("file opened with success: %s", out.path)
 	return nil
 }
 
@@ -253,79 +544,106 @@ func (lf *LogFile) GetMaxSize() int64 {
 	return int64(1024*1024) * int64(lf.config.Loggers.LogFile.MaxSize)
 }
 
-func (lf *LogFile) CompressFile() {
-	entries, err := os.ReadDir(lf.fileDir)
+// rotatedFiles returns the rotated files of a file, named with a timestamp suffix
+func rotatedFiles(path string) ([]string, error) {
+	fileDir := filepath.Dir(path)
+	fileExt := filepath.Ext(path)
+	filePrefix := strings.TrimSuffix(filepath.Base(path), fileExt)
+
+	entries, err := os.ReadDir(fileDir)
 	if err != nil {
-		lf.LogError("unable to list all files: %s", err)
-		return
+		return nil, err
 	}
 
+	files := []string{}
+	re := regexp.MustCompile(`^` + regexp.QuoteMeta(filePrefix) + `-\d+` + regexp.QuoteMeta(fileExt) + `$`)
 	for _, entry := range entries {
 		// ignore folder
 		if entry.IsDir() {
 			continue
 		}
+		if re.MatchString(entry.Name()) {
+			files = append(files, filepath.Join(fileDir, entry.Name()))
+		}
+	}
+	return files, nil
+}
 
-		matched, _ := regexp.MatchString(`^`+lf.filePrefix+`-\d+`+lf.fileExt+`$`, entry.Name())
-		if matched {
-			src := filepath.Join(lf.fileDir, entry.Name())
-			dst := filepath.Join(lf.fileDir, entry.Name()+compressSuffix)
+func (lf *LogFile) CompressFile() {
+	// files closed at the end of a template period
+	files := lf.closedFiles
+	lf.closedFiles = nil
 
-			fd, err := os.Open(src)
-			if err != nil {
-				lf.LogError("compress - failed to open file: ", err)
-				continue
-			}
-			defer fd.Close()
+	for _, out := range lf.outputs {
+		rotated, err := rotatedFiles(out.path)
+		if err != nil {
+			lf.LogError("unable to list all files: %s", err)
+			continue
+		}
+		files = append(files, rotated...)
+	}
 
-			fi, err := os.Stat(src)
-			if err != nil {
-				lf.LogError("compress - failed to stat file: ", err)
-				continue
-			}
+	for _, src := range files {
+		lf.compressFile(src)
+	}
 
-			gzf, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode())
-			if err != nil {
-				lf.LogError("compress - failed to open compressed file: ", err)
-				continue
-			}
-			defer gzf.Close()
+	lf.commpressTimer.Reset(time.Duration(lf.config.Loggers.LogFile.CompressInterval) * time.Second)
+}
 
-			gz := gzip.NewWriter(gzf)
+func (lf *LogFile) compressFile(src string) {
+	dst := src + compressSuffix
 
-			if _, err := io.Copy(gz, fd); err != nil {
-				lf.LogError("compress - failed to compress file: ", err)
-				os.Remove(dst)
-				continue
-			}
-			if err := gz.Close(); err != nil {
-				lf.LogError("compress - failed to close gz writer: ", err)
-				os.Remove(dst)
-				continue
-			}
-			if err := gzf.Close(); err != nil {
-				lf.LogError("compress - failed to close gz file: ", err)
-				os.Remove(dst)
-				continue
-			}
+	fd, err := os.Open(src)
+	if err != nil {
+		lf.LogError("compress - failed to open file: ", err)
+		return
+	}
+	defer fd.Close()
 
-			if err := fd.Close(); err != nil {
-				lf.LogError("compress - failed to close log file: ", err)
-				os.Remove(dst)
-				continue
-			}
-			if err := os.Remove(src); err != nil {
-				lf.LogError("compress - failed to remove log file: ", err)
-				os.Remove(dst)
-				continue
-			}
+	fi, err := os.Stat(src)
+	if err != nil {
+		lf.LogError("compress - failed to stat file: ", err)
+		return
+	}
 
-			// post rotate command?
-			lf.CompressPostRotateCommand(dst)
-		}
+	gzf, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode())
+	if err != nil {
+		lf.LogError("compress - failed to open compressed file: ", err)
+		return
 	}
+	defer gzf.Close()
 
-	lf.commpressTimer.Reset(time.Duration(lf.config.Loggers.LogFile.CompressInterval) * time.Second)
+	gz := gzip.NewWriter(gzf)
+
+	if _, err := io.Copy(gz, fd); err != nil {
+		lf.LogError("compress - failed to compress file: ", err)
+		os.Remove(dst)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		lf.LogError("compress - failed to close gz writer: ", err)
+		os.Remove(dst)
+		return
+	}
+	if err := gzf.Close(); err != nil {
+		lf.LogError("compress - failed to close gz file: ", err)
+		os.Remove(dst)
+		return
+	}
+
+	if err := fd.Close(); err != nil {
+		lf.LogError("compress - failed to close log file: ", err)
+		os.Remove(dst)
+		return
+	}
+	if err := os.Remove(src); err != nil {
+		lf.LogError("compress - failed to remove log file: ", err)
+		os.Remove(dst)
+		return
+	}
+
+	// post rotate command?
+	lf.CompressPostRotateCommand(dst)
 }
 
 func (lf *LogFile) PostRotateCommand(filename string) {
@@ -351,30 +669,39 @@ func (lf *LogFile) CompressPostRotateCommand(filename string) {
 	}
 }
 
-func (lf *LogFile) FlushWriters() {
+func (lf *LogFile) FlushWriters(out *logFileOutput) {
 	switch lf.config.Loggers.LogFile.Mode {
 	case pkgconfig.ModeText, pkgconfig.ModeJSON, pkgconfig.ModeFlatJSON:
-		lf.writerPlain.Flush()
+		out.writerPlain.Flush()
 	case pkgconfig.ModeDNSTap:
-		lf.writerDnstap.Flush()
+		out.writerDnstap.Flush()
 	}
 }
 
-func (lf *LogFile) RotateFile() error {
-	// close writer and existing file
-	lf.FlushWriters()
-
-	if lf.config.Loggers.LogFile.Mode == pkgconfig.ModeDNSTap {
-		lf.writerDnstap.Close()
+func (lf *LogFile) CloseWriters(out *logFileOutput) {
+	switch lf.config.Loggers.LogFile.Mode {
+	case pkgconfig.ModeDNSTap:
+		out.writerDnstap.Close()
+	case pkgconfig.ModeParquet:
+		// write the footer, the file is unreadable without it
+		if err := out.writerParquet.Close(); err != nil {
+			lf.LogError("unable to close parquet file: %s", err)
+		}
 	}
+}
 
-	if err := lf.fileFd.Close(); err != nil {
+func (lf *LogFile) RotateFile(out *logFileOutput) error {
+	// close writer and existing file
+	lf.FlushWriters(out)
+	lf.CloseWriters(out)
+
+	if err := out.fd.Close(); err != nil {
 		return err
 	}
 
 	// Rename current log file
-	bfpath := filepath.Join(lf.fileDir, fmt.Sprintf("%s-%d%s", lf.filePrefix, time.Now().UnixNano(), lf.fileExt))
-	err := os.Rename(lf.config.Loggers.LogFile.FilePath, bfpath)
+	bfpath := rotatedFilePath(out.path)
+	err := os.Rename(out.path, bfpath)
 	if err != nil {
 		return err
 	}
@@ -383,14 +710,14 @@ func (lf *LogFile) RotateFile() error {
 	lf.PostRotateCommand(bfpath)
 
 	// keep only max files
-	err = lf.Cleanup()
+	err = lf.Cleanup(out.path)
 	if err != nil {
 		lf.LogError("unable to cleanup log files: %s", err)
 		return err
 	}
 
 	// re-create new one
-	if err := lf.OpenFile(); err != nil {
+	if err := lf.OpenFile(out); err != nil {
 		lf.LogError("unable to re-create file: %s", err)
 		return err
 	}
@@ -398,7 +725,37 @@ func (lf *LogFile) RotateFile() error {
 	return nil
 }
 
-func (lf *LogFile) WriteToPcap(dm dnsutils.DNSMessage, pkt []gopacket.SerializableLayer) {
+// RotateOutputs is called on the rotate interval, the files of a finished template period
+// are closed and the other ones are rotated
+func (lf *LogFile) RotateOutputs() {
+	for _, out := range lf.outputs {
+		if len(lf.fileTemplate) > 0 && strftime(lf.fileTemplate, time.Now()) != out.period {
+			lf.CloseOutput(out, true)
+			continue
+		}
+
+		// empty files are not rotated
+		if !out.IsEmpty() {
+			if err := lf.RotateFile(out); err != nil {
+				lf.LogError("failed to rotate file: %s", err)
+			}
+		}
+	}
+}
+
+// CloseFinishedOutputs closes the files of the previous template period without new messages
+func (lf *LogFile) CloseFinishedOutputs() {
+	if len(lf.fileTemplate) == 0 {
+		return
+	}
+	for _, out := range lf.outputs {
+		if strftime(lf.fileTemplate, time.Now()) != out.period {
+			lf.CloseOutput(out, true)
+		}
+	}
+}
+
+func (lf *LogFile) WriteToPcap(out *logFileOutput, dm dnsutils.DNSMessage, pkt []gopacket.SerializableLayer) {
 	// create the packet with the layers
 	buf := gopacket.NewSerializeBuffer()
 	opts := gopacket.SerializeOptions{
@@ -412,8 +769,8 @@ func (lf *LogFile) WriteToPcap(dm dnsutils.DNSMessage, pkt []gopacket.Serializab
 	// rotate pcap file ?
 	bufSize := len(buf.Bytes())
 
-	if (lf.fileSize + int64(bufSize)) > lf.GetMaxSize() {
-		if err := lf.RotateFile(); err != nil {
+	if (out.size + int64(bufSize)) > lf.GetMaxSize() {
+		if err := lf.RotateFile(out); err != nil {
 			lf.LogError("failed to rotate file: %s", err)
 			return
 		}
@@ -425,46 +782,68 @@ func (lf *LogFile) WriteToPcap(dm dnsutils.DNSMessage, pkt []gopacket.Serializab
 		Length:        bufSize,
 	}
 
-	lf.writerPcap.WritePacket(ci, buf.Bytes())
+	out.writerPcap.WritePacket(ci, buf.Bytes())
 
 	// increase size file
-	lf.fileSize += int64(bufSize)
+	out.size += int64(bufSize)
 }
 
-func (lf *LogFile) WriteToPlain(data []byte) {
+func (lf *LogFile) WriteToPlain(out *logFileOutput, data []byte) {
 	dataSize := int64(len(data))
 
 	// rotate file ?
-	if (lf.fileSize + dataSize) > lf.GetMaxSize() {
-		if err := lf.RotateFile(); err != nil {
+	if (out.size + dataSize) > lf.GetMaxSize() {
+		if err := lf.RotateFile(out); err != nil {
 			lf.LogError("failed to rotate file: %s", err)
 			return
 		}
 	}
 
 	// write log to file
-	n, _ := lf.writerPlain.Write(data)
+	n, _ := out.writerPlain.Write(data)
 
 	// increase size file
-	lf.fileSize += int64(n)
+	out.size += int64(n)
 }
 
-func (lf *LogFile) WriteToDnstap(data []byte) {
+func (lf *LogFile) WriteToDnstap(out *logFileOutput, data []byte) {
 	dataSize := int64(len(data))
 
 	// rotate file ?
-	if (lf.fileSize + dataSize) > lf.GetMaxSize() {
-		if err := lf.RotateFile(); err != nil {
+	if (out.size + dataSize) > lf.GetMaxSize() {
+		if err := lf.RotateFile(out); err != nil {
 			lf.LogError("failed to rotate file: %s", err)
 			return
 		}
 	}
 
 	// write log to file
-	n, _ := lf.writerDnstap.Write(data)
+	n, _ := out.writerDnstap.Write(data)
 
 	// increase size file
-	lf.fileSize += int64(n)
+	out.size += int64(n)
+}
+
+func (lf *LogFile) WriteToParquet(out *logFileOutput, dm dnsutils.DNSMessage) {
+	// rotate file ? the size of the buffered rows is estimated before compression
+	if out.writerParquet.Size() > lf.GetMaxSize() {
+		if err := lf.RotateFile(out); err != nil {
+			lf.LogError("failed to rotate file: %s", err)
+			return
+		}
+	}
+
+	row := make([]interface{}, len(parquetFields))
+	for i := range parquetFields {
+		row[i] = parquetFields[i].value(&dm)
+	}
+	if err := out.writerParquet.WriteRow(row); err != nil {
+		lf.LogError("failed to write parquet row: %s", err)
+		return
+	}
+
+	// update size file
+	out.size = out.writerParquet.Size()
 }
 
 func (lf *LogFile) Run() {
@@ -508,7 +887,11 @@ RUN_LOOP:
 
 			// apply tranforms, init dns message with additionnals parts if necessary
 			subprocessors.InitDNSMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.ReturnDrop {
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
 				lf.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
@@ -523,15 +906,30 @@ RUN_LOOP:
 	lf.LogInfo("run terminated")
 }
 
+// nextRotation returns the duration until the next rotation, aligned on the local clock
+func nextRotation(interval time.Duration) time.Duration {
+	now := time.Now()
+	_, offset := now.Zone()
+	local := now.Add(time.Duration(offset) * time.Second)
+	return local.Truncate(interval).Add(interval).Sub(local)
+}
+
 func (lf *LogFile) Process() {
 	// prepare some timers
 	flushInterval := time.Duration(lf.config.Loggers.LogFile.FlushInterval) * time.Second
 	flushTimer := time.NewTimer(flushInterval)
 	lf.commpressTimer = time.NewTimer(time.Duration(lf.config.Loggers.LogFile.CompressInterval) * time.Second)
 
+	// rotate on interval, in addition to the max size
+	rotateInterval := time.Duration(lf.config.Loggers.LogFile.RotateInterval) * time.Second
+	var rotateTimer *time.Timer
+	var rotateChan <-chan time.Time
+	if rotateInterval > 0 {
+		rotateTimer = time.NewTimer(nextRotation(rotateInterval))
+		rotateChan = rotateTimer.C
+	}
+
 	buffer := new(bytes.Buffer)
-	var data []byte
-	var err error
 
 	lf.LogInfo("ready to process")
 PROCESS_LOOP:
@@ -541,16 +939,15 @@ PROCESS_LOOP:
 			// stop timer
 			flushTimer.Stop()
 			lf.commpressTimer.Stop()
+			if rotateTimer != nil {
+				rotateTimer.Stop()
+			}
 
-			// flush writer
-			lf.FlushWriters()
-
-			// closing file
+			// flush writers and closing files
 			lf.LogInfo("closing log file")
-			if lf.config.Loggers.LogFile.Mode == pkgconfig.ModeDNSTap {
-				lf.writerDnstap.Close()
+			for _, out := range lf.outputs {
+				lf.CloseOutput(out, false)
 			}
-			lf.fileFd.Close()
 
 			lf.doneProcess <- true
 			break PROCESS_LOOP
@@ -561,18 +958,25 @@ PROCESS_LOOP:
 				return
 			}
 
+			// get the file, according to the template
+			out, err := lf.GetOutput(&dm)
+			if err != nil {
+				lf.LogError("unable to open output file: %s", err)
+				continue
+			}
+
 			// write to file
 			switch lf.config.Loggers.LogFile.Mode {
 
 			// with basic text mode
 			case pkgconfig.ModeText:
-				lf.WriteToPlain(dm.Bytes(lf.textFormat,
+				lf.WriteToPlain(out, dm.Bytes(lf.textFormat,
 					lf.config.Global.TextFormatDelimiter,
 					lf.config.Global.TextFormatBoundary))
 
 				var delimiter bytes.Buffer
 				delimiter.WriteString("\n")
-				lf.WriteToPlain(delimiter.Bytes())
+				lf.WriteToPlain(out, delimiter.Bytes())
 
 			// with json mode
 			case pkgconfig.ModeFlatJSON:
@@ -581,23 +985,23 @@ PROCESS_LOOP:
 					lf.LogError("flattening DNS message failed: %e", err)
 				}
 				json.NewEncoder(buffer).Encode(flat)
-				lf.WriteToPlain(buffer.Bytes())
+				lf.WriteToPlain(out, buffer.Bytes())
 				buffer.Reset()
 
 			// with json mode
 			case pkgconfig.ModeJSON:
 				json.NewEncoder(buffer).Encode(dm)
-				lf.WriteToPlain(buffer.Bytes())
+				lf.WriteToPlain(out, buffer.Bytes())
 				buffer.Reset()
 
 			// with dnstap mode
 			case pkgconfig.ModeDNSTap:
-				data, err = dm.ToDNSTap(lf.config.Loggers.LogFile.ExtendedSupport)
+				data, err := dm.ToDNSTap(lf.config.Loggers.LogFile.ExtendedSupport)
 				if err != nil {
 					lf.LogError("failed to encode to DNStap protobuf: %s", err)
 					continue
 				}
-				lf.WriteToDnstap(data)
+				lf.WriteToDnstap(out, data)
 
 			// with pcap mode
 			case pkgconfig.ModePCAP:
@@ -608,12 +1012,19 @@ PROCESS_LOOP:
 				}
 
 				// write the packet
-				lf.WriteToPcap(dm, pkt)
+				lf.WriteToPcap(out, dm, pkt)
+
+			// with parquet mode
+			case pkgconfig.ModeParquet:
+				lf.WriteToParquet(out, dm)
 			}
 
 		case <-flushTimer.C:
-			// flush writer
-			lf.FlushWriters()
+			// flush writers
+			for _, out := range lf.outputs {
+				lf.FlushWriters(out)
+			}
+			lf.CloseFinishedOutputs()
 
 			// reset flush timer and buffer
 			buffer.Reset()
@@ -624,6 +1035,9 @@ PROCESS_LOOP:
 				lf.CompressFile()
 			}
 
+		case <-rotateChan:
+			lf.RotateOutputs()
+			rotateTimer.Reset(nextRotation(rotateInterval))
 		}
 	}
 	lf.LogInfo("processing terminated")
@@ -1,11 +1,14 @@
 package loggers
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -119,7 +122,11 @@ func Test_LogFileWrite_PcapMode(t *testing.T) {
 	pkt = append(pkt, gopacket.Payload(dm.DNS.Payload), udp, ip4, eth)
 
 	// write fake dns message and network packet
-	g.WriteToPcap(dm, pkt)
+	out, err := g.GetOutput(&dm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.WriteToPcap(out, dm, pkt)
 
 	// read temp file and check content
 	data := make([]byte, 100)
@@ -132,3 +139,198 @@ func Test_LogFileWrite_PcapMode(t *testing.T) {
 		t.Errorf("no data in pcap file")
 	}
 }
+
+func Test_LogFileWrite_ParquetMode(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "dnstap.parquet")
+
+	// a previous file is renamed, parquet files can't be appended
+	if err := os.WriteFile(filePath, []byte("PAR1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// config
+	config := pkgconfig.GetFakeConfig()
+	config.Loggers.LogFile.FilePath = filePath
+	config.Loggers.LogFile.Mode = pkgconfig.ModeParquet
+	config.Loggers.LogFile.MaxFiles = 0
+	config.Loggers.LogFile.RotateInterval = 1
+
+	// init generator in testing mode
+	g := NewLogFile(config, logger.New(false), "test")
+
+	// start the logger
+	go g.Run()
+
+	// send fake dns message to logger, before and after the rotation
+	// aligned on the second
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(1100 * time.Millisecond)))
+	dm := dnsutils.GetFakeDNSMessage()
+	g.GetInputChannel() <- dm
+	time.Sleep(1500 * time.Millisecond)
+	g.GetInputChannel() <- dm
+	time.Sleep(200 * time.Millisecond)
+	g.Stop()
+
+	// the previous file, the rotated one and the current one
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("3 files expected, got %d", len(entries))
+	}
+
+	withMessage := 0
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) == 4 {
+			continue
+		}
+
+		// the footer is written on rotation and on stop
+		if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+			t.Errorf("invalid parquet file %s", entry.Name())
+		}
+		if bytes.Contains(data, []byte("dns.collector")) {
+			withMessage++
+		}
+	}
+	if withMessage != 2 {
+		t.Errorf("dns message expected in 2 files, got %d", withMessage)
+	}
+}
+
+func Test_LogFileParquet_EmptyRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	// config
+	config := pkgconfig.GetFakeConfig()
+	config.Loggers.LogFile.FilePath = filepath.Join(dir, "dnstap.parquet")
+	config.Loggers.LogFile.Mode = pkgconfig.ModeParquet
+
+	// the file without message contains only the header, it is not rotated
+	g := NewLogFile(config, logger.New(false), "test")
+	g.RotateOutputs()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("1 file expected, got %d", len(entries))
+	}
+
+	go g.Run()
+	g.Stop()
+}
+
+func Test_LogFileTemplate(t *testing.T) {
+	dir := t.TempDir()
+
+	// config, a new file each second
+	config := pkgconfig.GetFakeConfig()
+	config.Loggers.LogFile.FileTemplate = filepath.Join(dir, "%Y", "%H%M%S-{identity}-{name}.log")
+	config.Loggers.LogFile.Mode = pkgconfig.ModeText
+	config.Loggers.LogFile.FlushInterval = 1
+	config.Loggers.LogFile.Compress = true
+	config.Loggers.LogFile.CompressInterval = 1
+
+	// init generator in testing mode
+	g := NewLogFile(config, logger.New(false), "test")
+
+	// start the logger
+	go g.Run()
+
+	// the identity can't change the folder
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNSTap.Identity = "../dnsdist"
+	g.GetInputChannel() <- dm
+	time.Sleep(1100 * time.Millisecond)
+
+	// the previous file is closed then compressed
+	dm.DNSTap.Identity = "unbound"
+	g.GetInputChannel() <- dm
+	time.Sleep(1500 * time.Millisecond)
+	g.Stop()
+
+	entries, err := os.ReadDir(filepath.Join(dir, time.Now().Format("2006")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{}
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	if len(files) != 2 {
+		t.Fatalf("2 files expected, got %v", files)
+	}
+
+	// the current file can be closed too if the second has changed on flush
+	patterns := []string{`^\d{6}-.._dnsdist-test\.log\.gz$`, `^\d{6}-unbound-test\.log(\.gz)?$`}
+	for _, pattern := range patterns {
+		found := false
+		for _, file := range files {
+			if regexp.MustCompile(pattern).MatchString(file) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no file matching %s: %v", pattern, files)
+		}
+	}
+}
+
+func Test_LogFileTemplate_MaxOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	// config, the time is expanded before the fields
+	config := pkgconfig.GetFakeConfig()
+	config.Loggers.LogFile.FileTemplate = filepath.Join(dir, "%Y-{qname}.log")
+	config.Loggers.LogFile.MaxOpenFiles = 2
+
+	g := NewLogFile(config, logger.New(false), "test")
+
+	year := time.Now().Format("2006")
+	for _, qname := range []string{"a%Y.com", "b.com", "c.com"} {
+		dm := dnsutils.GetFakeDNSMessage()
+		dm.DNS.Qname = qname
+		out, err := g.GetOutput(&dm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := filepath.Join(dir, year+"-"+qname+".log"); out.path != expected {
+			t.Errorf("want %s, got %s", expected, out.path)
+		}
+	}
+
+	// the least recently used file is closed
+	if len(g.outputs) != 2 {
+		t.Errorf("2 opened files expected, got %d", len(g.outputs))
+	}
+	for _, out := range g.outputs {
+		if strings.Contains(out.path, "a%Y.com") {
+			t.Errorf("the first file should be closed")
+		}
+	}
+
+	go g.Run()
+	g.Stop()
+}
+
+func Test_LogFileStrftime(t *testing.T) {
+	ts := time.Date(2024, time.March, 5, 7, 8, 9, 0, time.UTC)
+	for format, expected := range map[string]string{
+		"/data/dns/%Y/%m/%d/%H-{identity}.log": "/data/dns/2024/03/05/07-{identity}.log",
+		"%y%j-%M%S.log":                        "24065-0809.log",
+		"%s-100%%-%q%":                         "1709622489-100%-%q%",
+		"/tmp/test.log":                        "/tmp/test.log",
+	} {
+		if got := strftime(format, ts); got != expected {
+			t.Errorf("want %s, got %s", expected, got)
+		}
+	}
+}
@@ -0,0 +1,629 @@
+package loggers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	dto "github.com/prometheus/client_model/go"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const otlpScopeName = "github.com/dmachard/go-dnscollector"
+
+// OTLPAttributes converts the flattened dns message to a list of OTLP attributes
+func OTLPAttributes(dm *dnsutils.DNSMessage) ([]*commonpb.KeyValue, error) {
+	flat, err := dm.Flatten()
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := []*commonpb.KeyValue{}
+	for key, value := range flat {
+		var anyValue *commonpb.AnyValue
+		switch v := value.(type) {
+		case string:
+			anyValue = &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+		case bool:
+			anyValue = &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 {
+				anyValue = &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v)}}
+			} else {
+				anyValue = &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+			}
+		default:
+			// empty lists and null values
+			continue
+		}
+		attrs = append(attrs, &commonpb.KeyValue{Key: key, Value: anyValue})
+	}
+	return attrs, nil
+}
+
+func otlpLabels(labels []*dto.LabelPair) []*commonpb.KeyValue {
+	attrs := []*commonpb.KeyValue{}
+	for _, l := range labels {
+		attrs = append(attrs, &commonpb.KeyValue{
+			Key:   l.GetName(),
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: l.GetValue()}},
+		})
+	}
+	return attrs
+}
+
+// OTLPMetrics converts prometheus metric families to OTLP metrics,
+// counters are converted to cumulative monotonic sums.
+func OTLPMetrics(families []*dto.MetricFamily, startTime, now time.Time) []*metricspb.Metric {
+	start := uint64(startTime.UnixNano())
+	ts := uint64(now.UnixNano())
+
+	metrics := []*metricspb.Metric{}
+	for _, mf := range families {
+		metric := &metricspb.Metric{Name: mf.GetName(), Description: mf.GetHelp()}
+
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}
+			for _, m := range mf.GetMetric() {
+				sum.DataPoints = append(sum.DataPoints, &metricspb.NumberDataPoint{
+					Attributes:        otlpLabels(m.GetLabel()),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      ts,
+					Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: m.GetCounter().GetValue()},
+				})
+			}
+			metric.Data = &metricspb.Metric_Sum{Sum: sum}
+
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			gauge := &metricspb.Gauge{}
+			for _, m := range mf.GetMetric() {
+				value := m.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+				gauge.DataPoints = append(gauge.DataPoints, &metricspb.NumberDataPoint{
+					Attributes:   otlpLabels(m.GetLabel()),
+					TimeUnixNano: ts,
+					Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+				})
+			}
+			metric.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+
+		case dto.MetricType_HISTOGRAM:
+			histogram := &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}
+			for _, m := range mf.GetMetric() {
+				h := m.GetHistogram()
+				sum := h.GetSampleSum()
+				dp := &metricspb.HistogramDataPoint{
+					Attributes:        otlpLabels(m.GetLabel()),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      ts,
+					Count:             h.GetSampleCount(),
+					Sum:               &sum,
+				}
+				// prometheus buckets are cumulative, otlp ones are not
+				var previous uint64
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						continue
+					}
+					dp.ExplicitBounds = append(dp.ExplicitBounds, b.GetUpperBound())
+					dp.BucketCounts = append(dp.BucketCounts, b.GetCumulativeCount()-previous)
+					previous = b.GetCumulativeCount()
+				}
+				dp.BucketCounts = append(dp.BucketCounts, h.GetSampleCount()-previous)
+				histogram.DataPoints = append(histogram.DataPoints, dp)
+			}
+			metric.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+
+		case dto.MetricType_SUMMARY:
+			summary := &metricspb.Summary{}
+			for _, m := range mf.GetMetric() {
+				s := m.GetSummary()
+				dp := &metricspb.SummaryDataPoint{
+					Attributes:        otlpLabels(m.GetLabel()),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      ts,
+					Count:             s.GetSampleCount(),
+					Sum:               s.GetSampleSum(),
+				}
+				for _, q := range s.GetQuantile() {
+					dp.QuantileValues = append(dp.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+						Quantile: q.GetQuantile(),
+						Value:    q.GetValue(),
+					})
+				}
+				summary.DataPoints = append(summary.DataPoints, dp)
+			}
+			metric.Data = &metricspb.Metric_Summary{Summary: summary}
+
+		default:
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics
+}
+
+type OTLPClient struct {
+	stopProcess    chan bool
+	doneProcess    chan bool
+	stopRun        chan bool
+	doneRun        chan bool
+	inputChan      chan dnsutils.DNSMessage
+	outputChan     chan dnsutils.DNSMessage
+	config         *pkgconfig.Config
+	configChan     chan *pkgconfig.Config
+	logger         *logger.Logger
+	textFormat     []string
+	name           string
+	headers        map[string]string
+	resource       *resourcepb.Resource
+	httpclient     *http.Client
+	grpcConn       *grpc.ClientConn
+	metrics        *Prometheus
+	startTime      time.Time
+	RoutingHandler pkgutils.RoutingHandler
+}
+
+func NewOTLPClient(config *pkgconfig.Config, logger *logger.Logger, name string) *OTLPClient {
+	logger.Info(pkgutils.PrefixLogLogger+"[%s] otlp - enabled", name)
+	c := &OTLPClient{
+		stopProcess:    make(chan bool),
+		doneProcess:    make(chan bool),
+		stopRun:        make(chan bool),
+		doneRun:        make(chan bool),
+		inputChan:      make(chan dnsutils.DNSMessage, config.Loggers.OTLPClient.ChannelBufferSize),
+		outputChan:     make(chan dnsutils.DNSMessage, config.Loggers.OTLPClient.ChannelBufferSize),
+		logger:         logger,
+		config:         config,
+		configChan:     make(chan *pkgconfig.Config),
+		name:           name,
+		startTime:      time.Now(),
+		RoutingHandler: pkgutils.NewRoutingHandler(config, logger, name),
+	}
+	c.ReadConfig()
+
+	// the metrics are computed with the counters of the prometheus logger
+	promConfig := *config
+	promConfig.Loggers.Prometheus.PromPrefix = config.Loggers.OTLPClient.MetricsPrefix
+	promConfig.Loggers.Prometheus.TopN = config.Loggers.OTLPClient.TopN
+	c.metrics = NewPrometheusCounters(&promConfig, logger, name)
+
+	return c
+}
+
+func (c *OTLPClient) GetName() string { return c.name }
+
+func (c *OTLPClient) AddDroppedRoute(wrk pkgutils.Worker) {
+	c.RoutingHandler.AddDroppedRoute(wrk)
+}
+
+func (c *OTLPClient) AddDefaultRoute(wrk pkgutils.Worker) {
+	c.RoutingHandler.AddDefaultRoute(wrk)
+}
+
+func (c *OTLPClient) SetLoggers(loggers []pkgutils.Worker) {}
+
+func (c *OTLPClient) ReadConfig() {
+	if c.config.Loggers.OTLPClient.Transport != pkgconfig.OTLPTransportGRPC &&
+		c.config.Loggers.OTLPClient.Transport != pkgconfig.OTLPTransportHTTP {
+		c.logger.Fatal(pkgutils.PrefixLogLogger+"["+c.name+"] otlp - invalid transport: ", c.config.Loggers.OTLPClient.Transport)
+	}
+
+	if len(c.config.Loggers.OTLPClient.TextFormat) > 0 {
+		c.textFormat = strings.Fields(c.config.Loggers.OTLPClient.TextFormat)
+	} else {
+		c.textFormat = strings.Fields(c.config.Global.TextFormat)
+	}
+
+	// headers are provided as "name: value"
+	c.headers = make(map[string]string)
+	for _, header := range c.config.Loggers.OTLPClient.Headers {
+		kv := strings.SplitN(header, ":", 2)
+		if len(kv) != 2 {
+			c.logger.Fatal(pkgutils.PrefixLogLogger+"["+c.name+"] otlp - invalid header: ", header)
+		}
+		c.headers[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+
+	c.resource = &resourcepb.Resource{
+		Attributes: []*commonpb.KeyValue{
+			{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: c.config.Loggers.OTLPClient.ServiceName}}},
+			{Key: "host.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: c.config.GetServerIdentity()}}},
+		},
+	}
+}
+
+func (c *OTLPClient) ReloadConfig(config *pkgconfig.Config) {
+	c.LogInfo("reload configuration!")
+	c.configChan <- config
+}
+
+func (c *OTLPClient) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info(pkgutils.PrefixLogLogger+"["+c.name+"] otlp - "+msg, v...)
+}
+
+func (c *OTLPClient) LogError(msg string, v ...interface{}) {
+	c.logger.Error(pkgutils.PrefixLogLogger+"["+c.name+"] otlp - "+msg, v...)
+}
+
+func (c *OTLPClient) GetInputChannel() chan dnsutils.DNSMessage {
+	return c.inputChan
+}
+
+func (c *OTLPClient) Stop() {
+	c.LogInfo("stopping logger...")
+	c.RoutingHandler.Stop()
+
+	c.LogInfo("stopping to run...")
+	c.stopRun <- true
+	<-c.doneRun
+
+	c.LogInfo("stopping to process...")
+	c.stopProcess <- true
+	<-c.doneProcess
+}
+
+func (c *OTLPClient) Connect() error {
+	var tlsOptions *pkgconfig.TLSOptions
+	if c.config.Loggers.OTLPClient.TLSSupport {
+		tlsOptions = &pkgconfig.TLSOptions{
+			InsecureSkipVerify: c.config.Loggers.OTLPClient.TLSInsecure,
+			MinVersion:         c.config.Loggers.OTLPClient.TLSMinVersion,
+			CAFile:             c.config.Loggers.OTLPClient.CAFile,
+			CertFile:           c.config.Loggers.OTLPClient.CertFile,
+			KeyFile:            c.config.Loggers.OTLPClient.KeyFile,
+		}
+	}
+
+	switch c.config.Loggers.OTLPClient.Transport {
+	case pkgconfig.OTLPTransportGRPC:
+		creds := insecure.NewCredentials()
+		if tlsOptions != nil {
+			tlsConfig, err := pkgconfig.TLSClientConfig(*tlsOptions)
+			if err != nil {
+				return err
+			}
+			creds = credentials.NewTLS(tlsConfig)
+		}
+		conn, err := grpc.Dial(c.config.Loggers.OTLPClient.Endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return err
+		}
+		c.grpcConn = conn
+
+	case pkgconfig.OTLPTransportHTTP:
+		tr := &http.Transport{
+			MaxIdleConns:    10,
+			IdleConnTimeout: 30 * time.Second,
+		}
+		if tlsOptions != nil {
+			tlsConfig, err := pkgconfig.TLSClientConfig(*tlsOptions)
+			if err != nil {
+				return err
+			}
+			tr.TLSClientConfig = tlsConfig
+		}
+		c.httpclient = &http.Client{Transport: tr}
+	}
+	return nil
+}
+
+func (c *OTLPClient) Disconnect() {
+	if c.grpcConn != nil {
+		c.LogInfo("closing grpc connection")
+		c.grpcConn.Close()
+		c.grpcConn = nil
+	}
+}
+
+func (c *OTLPClient) export(path string, req proto.Message, grpcExport func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.config.Loggers.OTLPClient.Timeout)*time.Second)
+	defer cancel()
+
+	if c.config.Loggers.OTLPClient.Transport == pkgconfig.OTLPTransportGRPC {
+		if len(c.headers) > 0 {
+			ctx = metadata.NewOutgoingContext(ctx, metadata.New(c.headers))
+		}
+		return grpcExport(ctx)
+	}
+
+	// http/protobuf
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	scheme := "http"
+	if c.config.Loggers.OTLPClient.TLSSupport {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s%s", scheme, c.config.Loggers.OTLPClient.Endpoint, path)
+
+	post, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	post.Header.Set("Content-Type", "application/x-protobuf")
+	post.Header.Set("User-Agent", c.config.GetServerIdentity())
+	for k, v := range c.headers {
+		post.Header.Set(k, v)
+	}
+
+	resp, err := c.httpclient.Do(post)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		scanner := bufio.NewScanner(io.LimitReader(resp.Body, 1024))
+		line := ""
+		if scanner.Scan() {
+			line = scanner.Text()
+		}
+		return fmt.Errorf("server returned HTTP status %s: %s", resp.Status, line)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (c *OTLPClient) ExportLogs(req *collogspb.ExportLogsServiceRequest) error {
+	return c.export("/v1/logs", req, func(ctx context.Context) error {
+		_, err := collogspb.NewLogsServiceClient(c.grpcConn).Export(ctx, req)
+		return err
+	})
+}
+
+func (c *OTLPClient) ExportMetrics(req *colmetricspb.ExportMetricsServiceRequest) error {
+	return c.export("/v1/metrics", req, func(ctx context.Context) error {
+		_, err := colmetricspb.NewMetricsServiceClient(c.grpcConn).Export(ctx, req)
+		return err
+	})
+}
+
+func (c *OTLPClient) Run() {
+	c.LogInfo("running in background...")
+
+	// prepare next channels
+	defaultRoutes, defaultNames := c.RoutingHandler.GetDefaultRoutes()
+	droppedRoutes, droppedNames := c.RoutingHandler.GetDroppedRoutes()
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DNSMessage{}
+	listChannel = append(listChannel, c.outputChan)
+	subprocessors := transformers.NewTransforms(&c.config.OutgoingTransformers, c.logger, c.name, listChannel, 0)
+
+	// goroutine to process transformed dns messages
+	go c.Process()
+
+	// loop to process incoming messages
+RUN_LOOP:
+	for {
+		select {
+		case <-c.stopRun:
+			// cleanup transformers
+			subprocessors.Reset()
+
+			c.doneRun <- true
+			break RUN_LOOP
+
+		case cfg, opened := <-c.configChan:
+			if !opened {
+				return
+			}
+			c.config = cfg
+			c.ReadConfig()
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-c.inputChan:
+			if !opened {
+				c.LogInfo("input channel closed!")
+				return
+			}
+
+			// apply tranforms, init dns message with additionnals parts if necessary
+			subprocessors.InitDNSMessageFormat(&dm)
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
+				c.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
+				continue
+			}
+
+			// send to next ?
+			c.RoutingHandler.SendTo(defaultRoutes, defaultNames, dm)
+
+			// send to output channel
+			c.outputChan <- dm
+		}
+	}
+	c.LogInfo("run terminated")
+}
+
+func (c *OTLPClient) FlushLogs(buf *[]*logspb.LogRecord) {
+	req := &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: c.resource,
+			ScopeLogs: []*logspb.ScopeLogs{{
+				Scope:      &commonpb.InstrumentationScope{Name: otlpScopeName},
+				LogRecords: *buf,
+			}},
+		}},
+	}
+
+	if err := c.ExportLogs(req); err != nil {
+		c.LogError("export logs failed, %d record(s) dropped: %s", len(*buf), err)
+	}
+	*buf = nil
+}
+
+func (c *OTLPClient) FlushMetrics() {
+	families, err := c.metrics.promRegistry.Gather()
+	if err != nil {
+		c.LogError("gather metrics failed: %s", err)
+		return
+	}
+
+	req := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: c.resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: otlpScopeName},
+				Metrics: OTLPMetrics(families, c.startTime, time.Now()),
+			}},
+		}},
+	}
+
+	if err := c.ExportMetrics(req); err != nil {
+		c.LogError("export metrics failed: %s", err)
+	}
+}
+
+func (c *OTLPClient) NewLogRecord(dm *dnsutils.DNSMessage) (*logspb.LogRecord, error) {
+	attrs, err := OTLPAttributes(dm)
+	if err != nil {
+		return nil, err
+	}
+
+	body := dm.String(c.textFormat, c.config.Global.TextFormatDelimiter, c.config.Global.TextFormatBoundary)
+	return &logspb.LogRecord{
+		TimeUnixNano:         uint64(dm.DNSTap.Timestamp),
+		ObservedTimeUnixNano: uint64(time.Now().UnixNano()),
+		SeverityNumber:       logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+		SeverityText:         "INFO",
+		Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: body}},
+		Attributes:           attrs,
+	}, nil
+}
+
+// BufferMessage records the metrics of the message and adds its log record to the buffer
+func (c *OTLPClient) BufferMessage(dm dnsutils.DNSMessage, buf *[]*logspb.LogRecord) {
+	if c.config.Loggers.OTLPClient.MetricsEnabled {
+		c.metrics.Record(dm)
+	}
+
+	if !c.config.Loggers.OTLPClient.LogsEnabled {
+		return
+	}
+
+	record, err := c.NewLogRecord(&dm)
+	if err != nil {
+		c.LogError("flattening DNS message failed: %e", err)
+		return
+	}
+	*buf = append(*buf, record)
+}
+
+func (c *OTLPClient) Process() {
+	bufferLogs := []*logspb.LogRecord{}
+
+	if err := c.Connect(); err != nil {
+		c.logger.Fatal(pkgutils.PrefixLogLogger+"["+c.name+"] otlp - unable to init exporter: ", err)
+	}
+
+	// init flush timer for logs
+	flushInterval := time.Duration(c.config.Loggers.OTLPClient.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+
+	// init timer to export metrics
+	metricsInterval := time.Duration(c.config.Loggers.OTLPClient.MetricsInterval) * time.Second
+	metricsTimer := time.NewTimer(metricsInterval)
+
+	// init timer to compute qps
+	epsInterval := 1 * time.Second
+	epsTimer := time.NewTimer(epsInterval)
+
+	c.LogInfo("ready to process")
+PROCESS_LOOP:
+	for {
+		select {
+		case <-c.stopProcess:
+			// flush the messages not yet exported
+		DRAIN_LOOP:
+			for {
+				select {
+				case dm := <-c.outputChan:
+					c.BufferMessage(dm, &bufferLogs)
+				default:
+					break DRAIN_LOOP
+				}
+			}
+			if len(bufferLogs) > 0 {
+				c.FlushLogs(&bufferLogs)
+			}
+			if c.config.Loggers.OTLPClient.MetricsEnabled {
+				c.FlushMetrics()
+			}
+
+			flushTimer.Stop()
+			metricsTimer.Stop()
+			epsTimer.Stop()
+			c.Disconnect()
+			c.doneProcess <- true
+			break PROCESS_LOOP
+
+		// incoming dns message to process
+		case dm, opened := <-c.outputChan:
+			if !opened {
+				c.LogInfo("output channel closed!")
+				return
+			}
+			c.BufferMessage(dm, &bufferLogs)
+
+			// buffer is full ?
+			if len(bufferLogs) >= c.config.Loggers.OTLPClient.BatchSize {
+				c.FlushLogs(&bufferLogs)
+			}
+
+		// flush the buffer
+		case <-flushTimer.C:
+			if len(bufferLogs) > 0 {
+				c.FlushLogs(&bufferLogs)
+			}
+			flushTimer.Reset(flushInterval)
+
+		// export metrics
+		case <-metricsTimer.C:
+			if c.config.Loggers.OTLPClient.MetricsEnabled {
+				c.FlushMetrics()
+			}
+			metricsTimer.Reset(metricsInterval)
+
+		case <-epsTimer.C:
+			c.metrics.ComputeEventsPerSecond()
+			epsTimer.Reset(epsInterval)
+		}
+	}
+	c.LogInfo("processing terminated")
+}
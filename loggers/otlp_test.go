@@ -0,0 +1,219 @@
+package loggers
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+type fakeOTLPLogsServer struct {
+	collogspb.UnimplementedLogsServiceServer
+	requests chan *collogspb.ExportLogsServiceRequest
+	headers  chan metadata.MD
+}
+
+func (s *fakeOTLPLogsServer) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.headers <- md
+	s.requests <- req
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+func otlpAttribute(attrs []*commonpb.KeyValue, key string) *commonpb.AnyValue {
+	for _, kv := range attrs {
+		if kv.GetKey() == key {
+			return kv.GetValue()
+		}
+	}
+	return nil
+}
+
+func Test_OTLPClient_GRPCLogs(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeOTLPLogsServer{
+		requests: make(chan *collogspb.ExportLogsServiceRequest, 10),
+		headers:  make(chan metadata.MD, 10),
+	}
+	srv := grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(srv, fake)
+	go srv.Serve(listener)
+	defer srv.Stop()
+
+	conf := pkgconfig.GetFakeConfig()
+	conf.Loggers.OTLPClient.Endpoint = listener.Addr().String()
+	conf.Loggers.OTLPClient.Headers = []string{"X-Scope-OrgID: tenant1"}
+	conf.Loggers.OTLPClient.MetricsEnabled = false
+	conf.Loggers.OTLPClient.BatchSize = 2
+	g := NewOTLPClient(conf, logger.New(false), "test")
+
+	go g.Run()
+
+	dm := dnsutils.GetFakeDNSMessage()
+	for i := 0; i < 2; i++ {
+		g.GetInputChannel() <- dm
+	}
+
+	md := <-fake.headers
+	assert.Equal(t, []string{"tenant1"}, md.Get("x-scope-orgid"))
+
+	req := <-fake.requests
+	assert.Len(t, req.ResourceLogs, 1)
+	assert.Equal(t, "service.name", req.ResourceLogs[0].Resource.Attributes[0].Key)
+
+	records := req.ResourceLogs[0].ScopeLogs[0].LogRecords
+	assert.Len(t, records, 2)
+	assert.Equal(t, "dns.collector", otlpAttribute(records[0].Attributes, "dns.qname").GetStringValue())
+	assert.Equal(t, int64(0), otlpAttribute(records[0].Attributes, "dns.length").GetIntValue())
+	assert.Contains(t, records[0].Body.GetStringValue(), "dns.collector")
+
+	g.Stop()
+}
+
+func Test_OTLPClient_FlushOnStop(t *testing.T) {
+	logsReqs := make(chan *collogspb.ExportLogsServiceRequest, 10)
+	metricsReqs := make(chan *colmetricspb.ExportMetricsServiceRequest, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/v1/logs":
+			req := &collogspb.ExportLogsServiceRequest{}
+			assert.NoError(t, proto.Unmarshal(body, req))
+			logsReqs <- req
+		case "/v1/metrics":
+			req := &colmetricspb.ExportMetricsServiceRequest{}
+			assert.NoError(t, proto.Unmarshal(body, req))
+			metricsReqs <- req
+		}
+	}))
+	defer srv.Close()
+
+	conf := pkgconfig.GetFakeConfig()
+	conf.Loggers.OTLPClient.Transport = pkgconfig.OTLPTransportHTTP
+	conf.Loggers.OTLPClient.Endpoint = srv.Listener.Addr().String()
+	conf.Loggers.OTLPClient.BatchSize = 100
+	conf.Loggers.OTLPClient.FlushInterval = 3600
+	conf.Loggers.OTLPClient.MetricsInterval = 3600
+	g := NewOTLPClient(conf, logger.New(false), "test")
+
+	go g.Run()
+
+	dm := dnsutils.GetFakeDNSMessage()
+	for i := 0; i < 5; i++ {
+		g.GetInputChannel() <- dm
+	}
+	time.Sleep(time.Second)
+
+	// the buffer is not full, records and metrics are exported on stop
+	g.Stop()
+
+	select {
+	case logs := <-logsReqs:
+		assert.Len(t, logs.ResourceLogs[0].ScopeLogs[0].LogRecords, 5)
+	default:
+		t.Errorf("buffered records not flushed on stop")
+	}
+	select {
+	case <-metricsReqs:
+	default:
+		t.Errorf("metrics not exported on stop")
+	}
+}
+
+func Test_OTLPClient_HTTP(t *testing.T) {
+	logsReqs := make(chan *collogspb.ExportLogsServiceRequest, 10)
+	metricsReqs := make(chan *colmetricspb.ExportMetricsServiceRequest, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/v1/logs":
+			req := &collogspb.ExportLogsServiceRequest{}
+			assert.NoError(t, proto.Unmarshal(body, req))
+			logsReqs <- req
+		case "/v1/metrics":
+			req := &colmetricspb.ExportMetricsServiceRequest{}
+			assert.NoError(t, proto.Unmarshal(body, req))
+			metricsReqs <- req
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	conf := pkgconfig.GetFakeConfig()
+	conf.Loggers.OTLPClient.Transport = pkgconfig.OTLPTransportHTTP
+	conf.Loggers.OTLPClient.Endpoint = srv.Listener.Addr().String()
+	conf.Loggers.OTLPClient.BatchSize = 1
+	conf.Loggers.OTLPClient.MetricsInterval = 1
+	g := NewOTLPClient(conf, logger.New(false), "test")
+
+	go g.Run()
+
+	g.GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+
+	logs := <-logsReqs
+	assert.Len(t, logs.ResourceLogs[0].ScopeLogs[0].LogRecords, 1)
+
+	metrics := <-metricsReqs
+	found := false
+	for _, m := range metrics.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		if m.Name != "dnscollector_dnsmessages_total" {
+			continue
+		}
+		found = true
+		assert.True(t, m.GetSum().IsMonotonic)
+		assert.Equal(t, 1.0, m.GetSum().DataPoints[0].GetAsDouble())
+	}
+	assert.True(t, found)
+
+	g.Stop()
+}
+
+func Test_OTLPMetrics_Histogram(t *testing.T) {
+	histogramType := dto.MetricType_HISTOGRAM
+	families := []*dto.MetricFamily{{
+		Name: proto.String("dnscollector_queries_size_bytes"),
+		Type: &histogramType,
+		Metric: []*dto.Metric{{
+			Histogram: &dto.Histogram{
+				SampleCount: proto.Uint64(5),
+				SampleSum:   proto.Float64(600),
+				Bucket: []*dto.Bucket{
+					{UpperBound: proto.Float64(50), CumulativeCount: proto.Uint64(1)},
+					{UpperBound: proto.Float64(100), CumulativeCount: proto.Uint64(3)},
+					{UpperBound: proto.Float64(math.Inf(1)), CumulativeCount: proto.Uint64(5)},
+				},
+			},
+		}},
+	}}
+
+	metrics := OTLPMetrics(families, time.Now(), time.Now())
+	assert.Len(t, metrics, 1)
+
+	dp := metrics[0].GetHistogram().DataPoints[0]
+	assert.Equal(t, []float64{50, 100}, dp.ExplicitBounds)
+	assert.Equal(t, []uint64{1, 2, 2}, dp.BucketCounts)
+	assert.Equal(t, uint64(5), dp.Count)
+}
@@ -0,0 +1,529 @@
+package loggers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	lru "github.com/hashicorp/golang-lru/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	pdnsBucketRecords = []byte("records")
+	pdnsBucketRdata   = []byte("rdata")
+)
+
+// PassiveDNSRecord is a record in the Passive DNS Common Output Format
+type PassiveDNSRecord struct {
+	Count     uint64 `json:"count"`
+	TimeFirst int64  `json:"time_first"`
+	TimeLast  int64  `json:"time_last"`
+	RRType    string `json:"rrtype"`
+	RRName    string `json:"rrname"`
+	Rdata     string `json:"rdata"`
+}
+
+// key of a record: rrname, rrtype and rdata separated by a null byte
+func pdnsRecordKey(rrname, rrtype, rdata string) []byte {
+	return []byte(rrname + "\x00" + rrtype + "\x00" + rdata)
+}
+
+// key of the rdata index: rdata, rrname and rrtype separated by a null byte
+func pdnsRdataKey(rrname, rrtype, rdata string) []byte {
+	return []byte(rdata + "\x00" + rrname + "\x00" + rrtype)
+}
+
+// value of a record: first seen, last seen and count
+func pdnsEncodeValue(r *PassiveDNSRecord) []byte {
+	v := make([]byte, 24)
+	binary.BigEndian.PutUint64(v[0:8], uint64(r.TimeFirst))
+	binary.BigEndian.PutUint64(v[8:16], uint64(r.TimeLast))
+	binary.BigEndian.PutUint64(v[16:24], r.Count)
+	return v
+}
+
+func pdnsDecodeValue(v []byte, r *PassiveDNSRecord) bool {
+	if len(v) != 24 {
+		return false
+	}
+	r.TimeFirst = int64(binary.BigEndian.Uint64(v[0:8]))
+	r.TimeLast = int64(binary.BigEndian.Uint64(v[8:16]))
+	r.Count = binary.BigEndian.Uint64(v[16:24])
+	return true
+}
+
+type PassiveDNS struct {
+	doneAPI        chan bool
+	stopProcess    chan bool
+	doneProcess    chan bool
+	stopRun        chan bool
+	doneRun        chan bool
+	inputChan      chan dnsutils.DNSMessage
+	outputChan     chan dnsutils.DNSMessage
+	serverMutex    sync.Mutex
+	httpserver     net.Listener
+	httpmux        *http.ServeMux
+	config         *pkgconfig.Config
+	configChan     chan *pkgconfig.Config
+	logger         *logger.Logger
+	name           string
+	RoutingHandler pkgutils.RoutingHandler
+	db             *bolt.DB
+	pending        *lru.Cache[string, *PassiveDNSRecord]
+	evicted        []*PassiveDNSRecord
+}
+
+func NewPassiveDNS(config *pkgconfig.Config, logger *logger.Logger, name string) *PassiveDNS {
+	logger.Info(pkgutils.PrefixLogLogger+"[%s] passivedns - enabled", name)
+	o := &PassiveDNS{
+		doneAPI:        make(chan bool),
+		stopProcess:    make(chan bool),
+		doneProcess:    make(chan bool),
+		stopRun:        make(chan bool),
+		doneRun:        make(chan bool),
+		config:         config,
+		configChan:     make(chan *pkgconfig.Config),
+		inputChan:      make(chan dnsutils.DNSMessage, config.Loggers.PassiveDNS.ChannelBufferSize),
+		outputChan:     make(chan dnsutils.DNSMessage, config.Loggers.PassiveDNS.ChannelBufferSize),
+		logger:         logger,
+		name:           name,
+		RoutingHandler: pkgutils.NewRoutingHandler(config, logger, name),
+	}
+	o.ReadConfig()
+	o.ResetPending()
+	o.OpenDB()
+	return o
+}
+
+func (c *PassiveDNS) GetName() string { return c.name }
+
+func (c *PassiveDNS) AddDroppedRoute(wrk pkgutils.Worker) {
+	c.RoutingHandler.AddDroppedRoute(wrk)
+}
+
+func (c *PassiveDNS) AddDefaultRoute(wrk pkgutils.Worker) {
+	c.RoutingHandler.AddDefaultRoute(wrk)
+}
+
+func (c *PassiveDNS) SetLoggers(loggers []pkgutils.Worker) {}
+
+func (c *PassiveDNS) ReadConfig() {
+	if !pkgconfig.IsValidTLS(c.config.Loggers.PassiveDNS.TLSMinVersion) {
+		c.logger.Fatal(pkgutils.PrefixLogLogger + "[" + c.name + "] passivedns - invalid tls min version")
+	}
+}
+
+func (c *PassiveDNS) ReloadConfig(config *pkgconfig.Config) {
+	c.LogInfo("reload configuration!")
+	c.configChan <- config
+}
+
+func (c *PassiveDNS) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info(pkgutils.PrefixLogLogger+"["+c.name+"] passivedns - "+msg, v...)
+}
+
+func (c *PassiveDNS) LogError(msg string, v ...interface{}) {
+	c.logger.Error(pkgutils.PrefixLogLogger+"["+c.name+"] passivedns - "+msg, v...)
+}
+
+func (c *PassiveDNS) GetInputChannel() chan dnsutils.DNSMessage {
+	return c.inputChan
+}
+
+func (c *PassiveDNS) Stop() {
+	c.LogInfo("stopping logger...")
+	c.RoutingHandler.Stop()
+
+	c.LogInfo("stopping to run...")
+	c.stopRun <- true
+	<-c.doneRun
+
+	c.LogInfo("stopping to process...")
+	c.stopProcess <- true
+	<-c.doneProcess
+
+	c.serverMutex.Lock()
+	httpserver := c.httpserver
+	c.serverMutex.Unlock()
+	if httpserver != nil {
+		c.LogInfo("stopping http server...")
+		httpserver.Close()
+		<-c.doneAPI
+	}
+
+	c.LogInfo("closing database...")
+	c.db.Close()
+}
+
+func (c *PassiveDNS) OpenDB() {
+	db, err := bolt.Open(c.config.Loggers.PassiveDNS.DBFile, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		c.logger.Fatal(pkgutils.PrefixLogLogger+"["+c.name+"] passivedns - unable to open database: ", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{pdnsBucketRecords, pdnsBucketRdata} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.logger.Fatal(pkgutils.PrefixLogLogger+"["+c.name+"] passivedns - unable to init database: ", err)
+	}
+	c.db = db
+}
+
+// RecordDNSMessage aggregates the answers of the replies in memory until the next flush
+func (c *PassiveDNS) RecordDNSMessage(dm dnsutils.DNSMessage) {
+	if dm.DNS.Type != dnsutils.DNSReply {
+		return
+	}
+
+	seen := int64(dm.DNSTap.TimeSec)
+	if seen == 0 {
+		seen = time.Now().Unix()
+	}
+
+	for _, rr := range dm.DNS.DNSRRs.Answers {
+		rrname := strings.TrimSuffix(strings.ToLower(rr.Name), ".")
+		if len(rrname) == 0 || len(rr.Rdatatype) == 0 || len(rr.Rdata) == 0 {
+			continue
+		}
+
+		key := string(pdnsRecordKey(rrname, rr.Rdatatype, rr.Rdata))
+		if record, ok := c.pending.Get(key); ok {
+			record.TimeFirst = min(record.TimeFirst, seen)
+			record.TimeLast = max(record.TimeLast, seen)
+			record.Count++
+			continue
+		}
+		c.pending.Add(key, &PassiveDNSRecord{Count: 1, TimeFirst: seen, TimeLast: seen,
+			RRType: rr.Rdatatype, RRName: rrname, Rdata: rr.Rdata})
+	}
+
+	// too many records evicted from memory, flush before the interval
+	if len(c.evicted) >= c.config.Loggers.PassiveDNS.MaxPending {
+		c.FlushRecords()
+	}
+}
+
+// ResetPending creates the in memory records, the least recently seen
+// are evicted when full and kept until the next flush
+func (c *PassiveDNS) ResetPending() {
+	c.pending, _ = lru.NewWithEvict(c.config.Loggers.PassiveDNS.MaxPending, func(_ string, record *PassiveDNSRecord) {
+		c.evicted = append(c.evicted, record)
+	})
+	c.evicted = nil
+}
+
+// FlushRecords merges the pending records with the ones stored in the database
+func (c *PassiveDNS) FlushRecords() {
+	pending := append(c.evicted, c.pending.Values()...)
+	if len(pending) == 0 {
+		return
+	}
+
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(pdnsBucketRecords)
+		index := tx.Bucket(pdnsBucketRdata)
+
+		for _, pendingRecord := range pending {
+			// copy the record, the pending ones are kept on error
+			record := *pendingRecord
+			key := pdnsRecordKey(record.RRName, record.RRType, record.Rdata)
+			var stored PassiveDNSRecord
+			if pdnsDecodeValue(records.Get(key), &stored) {
+				record.TimeFirst = min(record.TimeFirst, stored.TimeFirst)
+				record.TimeLast = max(record.TimeLast, stored.TimeLast)
+				record.Count += stored.Count
+			} else if err := index.Put(pdnsRdataKey(record.RRName, record.RRType, record.Rdata), nil); err != nil {
+				return err
+			}
+			if err := records.Put(key, pdnsEncodeValue(&record)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.LogError("unable to flush records: %v", err)
+		return
+	}
+
+	c.LogInfo("%d record(s) flushed", len(pending))
+	c.ResetPending()
+}
+
+// LookupRecords searches the records by rrname and by rdata, the rdata only for ip addresses
+func (c *PassiveDNS) LookupRecords(value string, rrtype string) ([]PassiveDNSRecord, error) {
+	results := []PassiveDNSRecord{}
+	maxResults := c.config.Loggers.PassiveDNS.MaxResults
+
+	err := c.db.View(func(tx *bolt.Tx) error {
+		records := tx.Bucket(pdnsBucketRecords)
+
+		add := func(rrname, rtype, rdata string, v []byte) bool {
+			if len(rrtype) > 0 && rtype != rrtype {
+				return true
+			}
+			record := PassiveDNSRecord{RRName: rrname, RRType: rtype, Rdata: rdata}
+			if pdnsDecodeValue(v, &record) {
+				results = append(results, record)
+			}
+			return maxResults <= 0 || len(results) < maxResults
+		}
+
+		// search by rrname
+		if _, err := netip.ParseAddr(value); err != nil {
+			rrname := strings.TrimSuffix(strings.ToLower(value), ".")
+			prefix := []byte(rrname + "\x00")
+			cursor := records.Cursor()
+			for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+				parts := strings.SplitN(string(k), "\x00", 3)
+				if len(parts) != 3 {
+					continue
+				}
+				if !add(parts[0], parts[1], parts[2], v) {
+					return nil
+				}
+			}
+		}
+
+		// then by rdata
+		prefix := []byte(value + "\x00")
+		cursor := tx.Bucket(pdnsBucketRdata).Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			parts := strings.SplitN(string(k), "\x00", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			v := records.Get(pdnsRecordKey(parts[1], parts[2], parts[0]))
+			if !add(parts[1], parts[2], parts[0], v) {
+				return nil
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+func (c *PassiveDNS) BasicAuth(w http.ResponseWriter, r *http.Request) bool {
+	login, password, authOK := r.BasicAuth()
+	if !authOK {
+		return false
+	}
+
+	return (login == c.config.Loggers.PassiveDNS.BasicAuthLogin) &&
+		(password == c.config.Loggers.PassiveDNS.BasicAuthPwd)
+}
+
+func (c *PassiveDNS) GetQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.BasicAuth(w, r) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		value := strings.TrimPrefix(r.URL.Path, "/query/")
+		if len(value) == 0 || strings.Contains(value, "/") {
+			http.Error(w, "Invalid query", http.StatusBadRequest)
+			return
+		}
+
+		records, err := c.LookupRecords(value, strings.ToUpper(r.URL.Query().Get("rrtype")))
+		if err != nil {
+			c.LogError("lookup error: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if len(records) == 0 {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		// one json record per line
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		for i := range records {
+			encoder.Encode(records[i])
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Listen creates the listener of the http server, with tls if enabled
+func (c *PassiveDNS) Listen() error {
+	var err error
+	var listener net.Listener
+	addrlisten := c.config.Loggers.PassiveDNS.ListenIP + ":" + strconv.Itoa(c.config.Loggers.PassiveDNS.ListenPort)
+
+	// listening with tls enabled ?
+	if c.config.Loggers.PassiveDNS.TLSSupport {
+		c.LogInfo("tls support enabled")
+		var cer tls.Certificate
+		cer, err = tls.LoadX509KeyPair(c.config.Loggers.PassiveDNS.CertFile, c.config.Loggers.PassiveDNS.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading certificate failed: %w", err)
+		}
+
+		// prepare tls configuration
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cer},
+			MinVersion:   tls.VersionTLS12,
+		}
+
+		// update tls min version according to the user config
+		tlsConfig.MinVersion = pkgconfig.TLSVersion[c.config.Loggers.PassiveDNS.TLSMinVersion]
+
+		listener, err = tls.Listen(netlib.SocketTCP, addrlisten, tlsConfig)
+
+	} else {
+		// basic listening
+		listener, err = net.Listen(netlib.SocketTCP, addrlisten)
+	}
+
+	// something wrong ?
+	if err != nil {
+		return fmt.Errorf("listening failed: %w", err)
+	}
+
+	c.serverMutex.Lock()
+	c.httpserver = listener
+	c.serverMutex.Unlock()
+	c.LogInfo("is listening on %s", listener.Addr())
+	return nil
+}
+
+// ListenAndServe serves the query api on the listener created by Listen
+func (c *PassiveDNS) ListenAndServe() {
+	c.LogInfo("starting server...")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/query/", c.GetQueryHandler)
+	c.httpmux = mux
+
+	c.serverMutex.Lock()
+	httpserver := c.httpserver
+	c.serverMutex.Unlock()
+
+	http.Serve(httpserver, c.httpmux)
+
+	c.LogInfo("http server terminated")
+	c.doneAPI <- true
+}
+
+func (c *PassiveDNS) Run() {
+	// prepare next channels
+	defaultRoutes, defaultNames := c.RoutingHandler.GetDefaultRoutes()
+	droppedRoutes, droppedNames := c.RoutingHandler.GetDroppedRoutes()
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DNSMessage{}
+	listChannel = append(listChannel, c.outputChan)
+	subprocessors := transformers.NewTransforms(&c.config.OutgoingTransformers, c.logger, c.name, listChannel, 0)
+
+	// start http server, the listener is created before to be closed by stop
+	if err := c.Listen(); err != nil {
+		c.logger.Fatal(pkgutils.PrefixLogLogger+"["+c.name+"] passivedns - ", err)
+	}
+	go c.ListenAndServe()
+
+	// goroutine to process transformed dns messages
+	go c.Process()
+
+	// loop to process incoming messages
+	c.LogInfo("ready to process")
+RUN_LOOP:
+	for {
+		select {
+		case <-c.stopRun:
+			// cleanup transformers
+			subprocessors.Reset()
+			c.doneRun <- true
+			break RUN_LOOP
+
+		case cfg, opened := <-c.configChan:
+			if !opened {
+				return
+			}
+			c.config = cfg
+			c.ReadConfig()
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-c.inputChan:
+			if !opened {
+				c.LogInfo("input channel closed!")
+				return
+			}
+
+			// apply tranforms, init dns message with additionnals parts if necessary
+			subprocessors.InitDNSMessageFormat(&dm)
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
+				c.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
+				continue
+			}
+
+			// send to next ?
+			c.RoutingHandler.SendTo(defaultRoutes, defaultNames, dm)
+
+			// send to output channel
+			c.outputChan <- dm
+		}
+	}
+	c.LogInfo("run terminated")
+}
+
+func (c *PassiveDNS) Process() {
+	// flush the records to the database on interval
+	flushInterval := time.Duration(c.config.Loggers.PassiveDNS.FlushInterval) * time.Second
+	flushTimer := time.NewTicker(flushInterval)
+
+	c.LogInfo("processing...")
+
+PROCESS_LOOP:
+	for {
+		select {
+		case <-c.stopProcess:
+			flushTimer.Stop()
+			c.FlushRecords()
+			c.doneProcess <- true
+			break PROCESS_LOOP
+
+		case dm, opened := <-c.outputChan:
+			if !opened {
+				c.LogInfo("output channel closed!")
+				return
+			}
+			// aggregate the answers
+			c.RecordDNSMessage(dm)
+
+		case <-flushTimer.C:
+			c.FlushRecords()
+		}
+	}
+	c.LogInfo("processing terminated")
+}
@@ -0,0 +1,174 @@
+package loggers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+)
+
+func passiveDNSReply(timeSec int, answers ...dnsutils.DNSAnswer) dnsutils.DNSMessage {
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNS.Type = dnsutils.DNSReply
+	dm.DNSTap.TimeSec = timeSec
+	dm.DNS.DNSRRs.Answers = answers
+	return dm
+}
+
+func TestPassiveDNS_Aggregation(t *testing.T) {
+	// init the logger
+	config := pkgconfig.GetFakeConfig()
+	config.Loggers.PassiveDNS.DBFile = filepath.Join(t.TempDir(), "passivedns.db")
+	g := NewPassiveDNS(config, logger.New(false), "test")
+	defer g.db.Close()
+
+	// queries are ignored
+	query := passiveDNSReply(1000, dnsutils.DNSAnswer{Name: "www.dnscollector.dev", Rdatatype: "A", Rdata: "1.2.3.4"})
+	query.DNS.Type = dnsutils.DNSQuery
+	g.RecordDNSMessage(query)
+
+	// aggregate in memory then in the database
+	g.RecordDNSMessage(passiveDNSReply(2000, dnsutils.DNSAnswer{Name: "WWW.dnscollector.dev.", Rdatatype: "A", Rdata: "1.2.3.4"}))
+	g.RecordDNSMessage(passiveDNSReply(1500, dnsutils.DNSAnswer{Name: "www.dnscollector.dev", Rdatatype: "A", Rdata: "1.2.3.4"}))
+	g.FlushRecords()
+	g.RecordDNSMessage(passiveDNSReply(3000,
+		dnsutils.DNSAnswer{Name: "www.dnscollector.dev", Rdatatype: "A", Rdata: "1.2.3.4"},
+		dnsutils.DNSAnswer{Name: "www.dnscollector.dev", Rdatatype: "AAAA", Rdata: "2001:db8::1"}))
+	g.FlushRecords()
+
+	records, err := g.LookupRecords("www.dnscollector.dev", "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := PassiveDNSRecord{Count: 3, TimeFirst: 1500, TimeLast: 3000, RRType: "A", RRName: "www.dnscollector.dev", Rdata: "1.2.3.4"}
+	if len(records) != 1 || records[0] != expected {
+		t.Errorf("want %v, got %v", expected, records)
+	}
+
+	// without rrtype filter
+	if records, _ := g.LookupRecords("www.dnscollector.dev.", ""); len(records) != 2 {
+		t.Errorf("two records expected, got %v", records)
+	}
+
+	// search by rdata
+	if records, _ := g.LookupRecords("2001:db8::1", ""); len(records) != 1 || records[0].RRName != "www.dnscollector.dev" {
+		t.Errorf("invalid records: %v", records)
+	}
+
+	// no subdomains
+	if records, _ := g.LookupRecords("dnscollector.dev", ""); len(records) != 0 {
+		t.Errorf("no records expected, got %v", records)
+	}
+}
+
+func TestPassiveDNS_QueryHandler(t *testing.T) {
+	// init the logger
+	config := pkgconfig.GetFakeConfig()
+	config.Loggers.PassiveDNS.DBFile = filepath.Join(t.TempDir(), "passivedns.db")
+	config.Loggers.PassiveDNS.MaxResults = 1
+	g := NewPassiveDNS(config, logger.New(false), "test")
+	defer g.db.Close()
+
+	g.RecordDNSMessage(passiveDNSReply(1000,
+		dnsutils.DNSAnswer{Name: "www.dnscollector.dev", Rdatatype: "CNAME", Rdata: "dnscollector.dev"},
+		dnsutils.DNSAnswer{Name: "dnscollector.dev", Rdatatype: "A", Rdata: "1.2.3.4"}))
+	g.FlushRecords()
+
+	tt := []struct {
+		name       string
+		uri        string
+		method     string
+		password   string
+		statusCode int
+		rrname     string
+	}{
+		{"bad basic auth", "/query/dnscollector.dev", http.MethodGet, "badpassword", http.StatusUnauthorized, ""},
+		{"bad method", "/query/dnscollector.dev", http.MethodPost, config.Loggers.PassiveDNS.BasicAuthPwd, http.StatusMethodNotAllowed, ""},
+		{"not found", "/query/unknown.dev", http.MethodGet, config.Loggers.PassiveDNS.BasicAuthPwd, http.StatusNotFound, ""},
+		{"by rrname", "/query/dnscollector.dev?rrtype=a", http.MethodGet, config.Loggers.PassiveDNS.BasicAuthPwd, http.StatusOK, "dnscollector.dev"},
+		{"by rdata", "/query/dnscollector.dev?rrtype=CNAME", http.MethodGet, config.Loggers.PassiveDNS.BasicAuthPwd, http.StatusOK, "www.dnscollector.dev"},
+		{"max results", "/query/dnscollector.dev", http.MethodGet, config.Loggers.PassiveDNS.BasicAuthPwd, http.StatusOK, "dnscollector.dev"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// init httptest
+			request := httptest.NewRequest(tc.method, tc.uri, strings.NewReader(""))
+			request.SetBasicAuth(config.Loggers.PassiveDNS.BasicAuthLogin, tc.password)
+			responseRecorder := httptest.NewRecorder()
+
+			// call handler
+			g.GetQueryHandler(responseRecorder, request)
+
+			// checking status code
+			if responseRecorder.Code != tc.statusCode {
+				t.Fatalf("Want status '%d', got '%d'", tc.statusCode, responseRecorder.Code)
+			}
+			if tc.statusCode != http.StatusOK {
+				return
+			}
+
+			// checking the cof records, one per line
+			lines := 0
+			scanner := bufio.NewScanner(responseRecorder.Body)
+			for scanner.Scan() {
+				var record PassiveDNSRecord
+				if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+					t.Fatal(err)
+				}
+				if record.RRName != tc.rrname || record.Count != 1 || record.TimeFirst != 1000 {
+					t.Errorf("invalid record: %v", record)
+				}
+				lines++
+			}
+			if lines != 1 {
+				t.Errorf("one record expected, got %d", lines)
+			}
+		})
+	}
+}
+
+func TestPassiveDNS_MaxPending(t *testing.T) {
+	// init the logger
+	config := pkgconfig.GetFakeConfig()
+	config.Loggers.PassiveDNS.DBFile = filepath.Join(t.TempDir(), "passivedns.db")
+	config.Loggers.PassiveDNS.MaxPending = 2
+	g := NewPassiveDNS(config, logger.New(false), "test")
+	defer g.db.Close()
+
+	// the least recently seen record is evicted from memory and kept until the flush
+	g.RecordDNSMessage(passiveDNSReply(1000, dnsutils.DNSAnswer{Name: "a.dnscollector.dev", Rdatatype: "A", Rdata: "1.2.3.4"}))
+	g.RecordDNSMessage(passiveDNSReply(1000, dnsutils.DNSAnswer{Name: "b.dnscollector.dev", Rdatatype: "A", Rdata: "1.2.3.4"}))
+	g.RecordDNSMessage(passiveDNSReply(1000, dnsutils.DNSAnswer{Name: "c.dnscollector.dev", Rdatatype: "A", Rdata: "1.2.3.4"}))
+	if g.pending.Len() != 2 || len(g.evicted) != 1 {
+		t.Errorf("invalid pending records: %d in memory, %d evicted", g.pending.Len(), len(g.evicted))
+	}
+
+	// too many evicted records, flushed before the interval
+	g.RecordDNSMessage(passiveDNSReply(1000, dnsutils.DNSAnswer{Name: "d.dnscollector.dev", Rdatatype: "A", Rdata: "1.2.3.4"}))
+	if g.pending.Len() != 0 || len(g.evicted) != 0 {
+		t.Errorf("records should be flushed: %d in memory, %d evicted", g.pending.Len(), len(g.evicted))
+	}
+	if records, _ := g.LookupRecords("1.2.3.4", ""); len(records) != 4 {
+		t.Errorf("four records expected, got %v", records)
+	}
+}
+
+func TestPassiveDNS_Stop(t *testing.T) {
+	// init the logger
+	config := pkgconfig.GetFakeConfig()
+	config.Loggers.PassiveDNS.DBFile = filepath.Join(t.TempDir(), "passivedns.db")
+	config.Loggers.PassiveDNS.ListenPort = 0
+	g := NewPassiveDNS(config, logger.New(false), "test")
+
+	// the listener is created by run, stop closes it
+	go g.Run()
+	g.Stop()
+}
@@ -0,0 +1,373 @@
+package loggers
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+	powerdns_protobuf "github.com/dmachard/go-powerdns-protobuf"
+)
+
+type PdnsSender struct {
+	stopProcess        chan bool
+	doneProcess        chan bool
+	stopRun            chan bool
+	doneRun            chan bool
+	inputChan          chan dnsutils.DNSMessage
+	outputChan         chan dnsutils.DNSMessage
+	config             *pkgconfig.Config
+	configChan         chan *pkgconfig.Config
+	logger             *logger.Logger
+	name               string
+	transport          string
+	transportWriter    *bufio.Writer
+	transportConn      net.Conn
+	transportReady     chan bool
+	transportReconnect chan bool
+	writerReady        bool
+	spool              *pkgutils.SpoolHandler
+	RoutingHandler     pkgutils.RoutingHandler
+}
+
+func NewPdnsSender(config *pkgconfig.Config, logger *logger.Logger, name string) *PdnsSender {
+	logger.Info(pkgutils.PrefixLogLogger+"[%s] powerdns - enabled", name)
+	ps := &PdnsSender{
+		stopProcess:        make(chan bool),
+		doneProcess:        make(chan bool),
+		stopRun:            make(chan bool),
+		doneRun:            make(chan bool),
+		inputChan:          make(chan dnsutils.DNSMessage, config.Loggers.PowerDNSClient.ChannelBufferSize),
+		outputChan:         make(chan dnsutils.DNSMessage, config.Loggers.PowerDNSClient.ChannelBufferSize),
+		transportReady:     make(chan bool),
+		transportReconnect: make(chan bool),
+		logger:             logger,
+		config:             config,
+		configChan:         make(chan *pkgconfig.Config),
+		name:               name,
+		RoutingHandler:     pkgutils.NewRoutingHandler(config, logger, name),
+	}
+
+	ps.ReadConfig()
+
+	// init the disk spool
+	spool, err := pkgutils.NewSpoolHandler(config.Loggers.PowerDNSClient.Spool, name, logger,
+		ps.FlushBuffer, func() bool { return ps.writerReady })
+	if err != nil {
+		logger.Fatal(pkgutils.PrefixLogLogger+"["+name+"] powerdns - unable to open the spool:", err)
+	}
+	ps.spool = spool
+	return ps
+}
+
+func (ps *PdnsSender) GetName() string { return ps.name }
+
+func (ps *PdnsSender) AddDroppedRoute(wrk pkgutils.Worker) {
+	ps.RoutingHandler.AddDroppedRoute(wrk)
+}
+
+func (ps *PdnsSender) AddDefaultRoute(wrk pkgutils.Worker) {
+	ps.RoutingHandler.AddDefaultRoute(wrk)
+}
+
+func (ps *PdnsSender) SetLoggers(loggers []pkgutils.Worker) {}
+
+func (ps *PdnsSender) ReadConfig() {
+	ps.transport = ps.config.Loggers.PowerDNSClient.Transport
+
+	// get hostname or global one
+	if ps.config.Loggers.PowerDNSClient.ServerID == "" {
+		ps.config.Loggers.PowerDNSClient.ServerID = ps.config.GetServerIdentity()
+	}
+
+	if !pkgconfig.IsValidTLS(ps.config.Loggers.PowerDNSClient.TLSMinVersion) {
+		ps.logger.Fatal(pkgutils.PrefixLogLogger + "[" + ps.name + "] powerdns - invalid tls min version")
+	}
+}
+
+func (ps *PdnsSender) ReloadConfig(config *pkgconfig.Config) {
+	ps.LogInfo("reload configuration!")
+	ps.configChan <- config
+}
+
+func (ps *PdnsSender) LogInfo(msg string, v ...interface{}) {
+	ps.logger.Info(pkgutils.PrefixLogLogger+"["+ps.name+"] powerdns - "+msg, v...)
+}
+
+func (ps *PdnsSender) LogError(msg string, v ...interface{}) {
+	ps.logger.Error(pkgutils.PrefixLogLogger+"["+ps.name+"] powerdns - "+msg, v...)
+}
+
+func (ps *PdnsSender) GetInputChannel() chan dnsutils.DNSMessage {
+	return ps.inputChan
+}
+
+func (ps *PdnsSender) Stop() {
+	ps.LogInfo("stopping logger...")
+	ps.RoutingHandler.Stop()
+
+	ps.LogInfo("stopping to run...")
+	ps.stopRun <- true
+	<-ps.doneRun
+
+	ps.LogInfo("stopping to process...")
+	ps.stopProcess <- true
+	<-ps.doneProcess
+}
+
+func (ps *PdnsSender) Disconnect() {
+	if ps.transportConn != nil {
+		ps.LogInfo("closing tcp connection")
+		ps.transportConn.Close()
+	}
+}
+
+func (ps *PdnsSender) ConnectToRemote() {
+	for {
+		if ps.transportConn != nil {
+			ps.transportConn.Close()
+			ps.transportConn = nil
+		}
+
+		address := net.JoinHostPort(
+			ps.config.Loggers.PowerDNSClient.RemoteAddress,
+			strconv.Itoa(ps.config.Loggers.PowerDNSClient.RemotePort),
+		)
+		connTimeout := time.Duration(ps.config.Loggers.PowerDNSClient.ConnectTimeout) * time.Second
+
+		// make the connection
+		var conn net.Conn
+		var err error
+
+		switch ps.transport {
+		case netlib.SocketTCP:
+			ps.LogInfo("connecting to %s://%s", ps.transport, address)
+			conn, err = net.DialTimeout(ps.transport, address, connTimeout)
+
+		case netlib.SocketTLS:
+			ps.LogInfo("connecting to %s://%s", ps.transport, address)
+
+			var tlsConfig *tls.Config
+
+			tlsOptions := pkgconfig.TLSOptions{
+				InsecureSkipVerify: ps.config.Loggers.PowerDNSClient.TLSInsecure,
+				MinVersion:         ps.config.Loggers.PowerDNSClient.TLSMinVersion,
+				CAFile:             ps.config.Loggers.PowerDNSClient.CAFile,
+				CertFile:           ps.config.Loggers.PowerDNSClient.CertFile,
+				KeyFile:            ps.config.Loggers.PowerDNSClient.KeyFile,
+			}
+
+			tlsConfig, err = pkgconfig.TLSClientConfig(tlsOptions)
+			if err == nil {
+				dialer := &net.Dialer{Timeout: connTimeout}
+				conn, err = tls.DialWithDialer(dialer, netlib.SocketTCP, address, tlsConfig)
+			}
+		default:
+			ps.logger.Fatal("logger=powerdns - invalid transport:", ps.transport)
+		}
+
+		// something is wrong during connection ?
+		if err != nil {
+			ps.LogError("%s", err)
+			ps.LogInfo("retry to connect in %d seconds", ps.config.Loggers.PowerDNSClient.RetryInterval)
+			time.Sleep(time.Duration(ps.config.Loggers.PowerDNSClient.RetryInterval) * time.Second)
+			continue
+		}
+
+		ps.transportConn = conn
+
+		// block until the writer is ready
+		pkgutils.Telemetry.ConnectionOpened(ps.name)
+		ps.transportReady <- true
+
+		// block until an error occurred, need to reconnect
+		ps.transportReconnect <- true
+		pkgutils.Telemetry.ConnectionLost(ps.name)
+	}
+}
+
+// FlushBuffer sends the buffer, returns the number of messages sent
+func (ps *PdnsSender) FlushBuffer(buf *[]dnsutils.DNSMessage) int {
+	sent := len(*buf)
+	frameLen := make([]byte, 2)
+
+	for _, dm := range *buf {
+		// update identity ?
+		if ps.config.Loggers.PowerDNSClient.OverwriteIdentity {
+			dm.DNSTap.Identity = ps.config.Loggers.PowerDNSClient.ServerID
+		}
+
+		// encode dns message to powerdns protobuf binary
+		data, err := dm.ToPowerDNS()
+		if err != nil {
+			ps.LogError("failed to encode to PowerDNS protobuf: %s", err)
+			continue
+		}
+		if len(data) > powerdns_protobuf.DATA_FRAME_LENGTH_MAX {
+			ps.LogError("protobuf message too large: %d bytes", len(data))
+			continue
+		}
+
+		// each protobuf message is prefixed by its length on 2 bytes
+		binary.BigEndian.PutUint16(frameLen, uint16(len(data)))
+		ps.transportWriter.Write(frameLen)
+		ps.transportWriter.Write(data)
+	}
+
+	// flush the transport buffer
+	if err := ps.transportWriter.Flush(); err != nil {
+		ps.LogError("send frame error %s", err)
+		ps.writerReady = false
+		sent = 0
+		<-ps.transportReconnect
+	}
+
+	// reset buffer
+	*buf = nil
+	return sent
+}
+
+func (ps *PdnsSender) Run() {
+	ps.LogInfo("running in background...")
+
+	// prepare next channels
+	defaultRoutes, defaultNames := ps.RoutingHandler.GetDefaultRoutes()
+	droppedRoutes, droppedNames := ps.RoutingHandler.GetDroppedRoutes()
+
+	// prepare transforms
+	listChannel := []chan dnsutils.DNSMessage{}
+	listChannel = append(listChannel, ps.outputChan)
+	subprocessors := transformers.NewTransforms(&ps.config.OutgoingTransformers, ps.logger, ps.name, listChannel, 0)
+
+	// goroutine to process transformed dns messages
+	go ps.Process()
+
+	// init remote conn
+	go ps.ConnectToRemote()
+
+	// loop to process incoming messages
+RUN_LOOP:
+	for {
+		select {
+		case <-ps.stopRun:
+			// cleanup transformers
+			subprocessors.Reset()
+
+			ps.doneRun <- true
+			break RUN_LOOP
+
+		case cfg, opened := <-ps.configChan:
+			if !opened {
+				return
+			}
+			ps.config = cfg
+			ps.ReadConfig()
+			subprocessors.ReloadConfig(&cfg.OutgoingTransformers)
+
+		case dm, opened := <-ps.inputChan:
+			if !opened {
+				ps.LogInfo("input channel closed!")
+				return
+			}
+
+			// apply tranforms, init dns message with additionnals parts if necessary
+			subprocessors.InitDNSMessageFormat(&dm)
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
+				ps.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
+				continue
+			}
+
+			// send to next ?
+			ps.RoutingHandler.SendTo(defaultRoutes, defaultNames, dm)
+
+			// send to output channel
+			ps.outputChan <- dm
+		}
+	}
+	ps.LogInfo("run terminated")
+}
+
+func (ps *PdnsSender) Process() {
+	// init buffer
+	bufferDm := []dnsutils.DNSMessage{}
+
+	// init flust timer for buffer
+	flushInterval := time.Duration(ps.config.Loggers.PowerDNSClient.FlushInterval) * time.Second
+	flushTimer := time.NewTimer(flushInterval)
+
+	ps.LogInfo("ready to process")
+PROCESS_LOOP:
+	for {
+		select {
+		case <-ps.stopProcess:
+			// closing remote connection if exist
+			ps.Disconnect()
+
+			ps.spool.Close()
+			ps.doneProcess <- true
+			break PROCESS_LOOP
+
+		case <-ps.transportReady:
+			ps.LogInfo("transport connected with success")
+			ps.transportWriter = bufio.NewWriter(ps.transportConn)
+			ps.writerReady = true
+
+		// incoming dns message to process
+		case dm, opened := <-ps.outputChan:
+			if !opened {
+				ps.LogInfo("output channel closed!")
+				return
+			}
+
+			// keep the dns message on disk if the connection is not ready
+			// or if older messages are waiting to be replayed
+			if ps.spool.Keep(&bufferDm, dm) {
+				continue
+			}
+
+			// drop dns message if the connection is not ready to avoid memory leak or
+			// to block the channel
+			if !ps.writerReady {
+				continue
+			}
+
+			// append dns message to buffer
+			bufferDm = append(bufferDm, dm)
+
+			// buffer is full ?
+			if len(bufferDm) >= ps.config.Loggers.PowerDNSClient.BufferSize {
+				ps.spool.FlushOrSpool(&bufferDm)
+			}
+
+		// flush the buffer
+		case <-flushTimer.C:
+			if !ps.writerReady {
+				bufferDm = nil
+			}
+
+			if len(bufferDm) > 0 {
+				ps.spool.FlushOrSpool(&bufferDm)
+			}
+
+			// restart timer
+			flushTimer.Reset(flushInterval)
+
+		// replay the messages kept on disk
+		case <-ps.spool.ReplayChan():
+			ps.spool.Replay(ps.config.Loggers.PowerDNSClient.BufferSize)
+		}
+	}
+	ps.LogInfo("processing terminated")
+}
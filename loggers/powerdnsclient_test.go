@@ -0,0 +1,106 @@
+package loggers
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	powerdns_protobuf "github.com/dmachard/go-powerdns-protobuf"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_PowerDNSClient(t *testing.T) {
+	// init logger
+	cfg := pkgconfig.GetFakeConfig()
+	cfg.Loggers.PowerDNSClient.FlushInterval = 1
+	cfg.Loggers.PowerDNSClient.BufferSize = 0
+	cfg.Loggers.PowerDNSClient.RemotePort = 6001
+
+	g := NewPdnsSender(cfg, logger.New(false), "test")
+
+	// fake powerdns receiver
+	fakeRcvr, err := net.Listen(netlib.SocketTCP, ":6001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeRcvr.Close()
+
+	// start the logger
+	go g.Run()
+
+	// accept conn from logger
+	conn, err := fakeRcvr.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// wait the connection to be ready
+	time.Sleep(time.Second)
+
+	// send fake dns reply to logger
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNS.Type = dnsutils.DNSReply
+	dm.DNS.Rcode = "NXDOMAIN"
+	dm.DNS.DNSRRs.Answers = []dnsutils.DNSAnswer{
+		{Name: "dns.collector", Rdatatype: "A", Class: 1, TTL: 300, Rdata: "192.168.1.1"},
+		{Name: "dns.collector", Rdatatype: "CNAME", Class: 1, TTL: 300, Rdata: "www.dns.collector"},
+	}
+	dm.PowerDNS = &dnsutils.PowerDNS{
+		Tags:                  []string{"tag1", "tag2"},
+		OriginalRequestSubnet: "10.0.0.0",
+		AppliedPolicy:         "rpz.local",
+		AppliedPolicyKind:     "NXDOMAIN",
+		AppliedPolicyType:     "QNAME",
+		Metadata:              map[string]string{"agent": "dnscollector"},
+	}
+	g.GetInputChannel() <- dm
+
+	// receive the protobuf message on server side, timeout 5s
+	ps := powerdns_protobuf.NewProtobufStream(bufio.NewReader(conn), conn, 5*time.Second)
+	payload, err := ps.RecvPayload(true)
+	if err != nil {
+		t.Fatalf("error to receive protobuf message: %s", err)
+	}
+
+	pbdm := &powerdns_protobuf.PBDNSMessage{}
+	if err := proto.Unmarshal(payload.Data(), pbdm); err != nil {
+		t.Fatalf("error to decode protobuf message: %s", err)
+	}
+
+	if pbdm.GetType() != powerdns_protobuf.PBDNSMessage_DNSResponseType {
+		t.Errorf("invalid message type: %s", pbdm.GetType())
+	}
+	if pbdm.GetQuestion().GetQName() != "dns.collector." || pbdm.GetQuestion().GetQType() != 1 {
+		t.Errorf("invalid question: %v", pbdm.GetQuestion())
+	}
+	if net.IP(pbdm.GetFrom()).String() != dm.NetworkInfo.QueryIP || pbdm.GetFromPort() != 1234 {
+		t.Errorf("invalid query address: %v %d", pbdm.GetFrom(), pbdm.GetFromPort())
+	}
+	if net.IP(pbdm.GetOriginalRequestorSubnet()).String() != "10.0.0.0" {
+		t.Errorf("invalid original requestor subnet: %v", pbdm.GetOriginalRequestorSubnet())
+	}
+
+	resp := pbdm.GetResponse()
+	if resp.GetRcode() != 3 {
+		t.Errorf("invalid rcode: %d", resp.GetRcode())
+	}
+	if len(resp.GetRrs()) != 2 || net.IP(resp.GetRrs()[0].GetRdata()).String() != "192.168.1.1" ||
+		string(resp.GetRrs()[1].GetRdata()) != "www.dns.collector." {
+		t.Errorf("invalid answers: %v", resp.GetRrs())
+	}
+	if len(resp.GetTags()) != 2 || resp.GetAppliedPolicy() != "rpz.local" ||
+		resp.GetAppliedPolicyKind() != powerdns_protobuf.PBDNSMessage_NXDOMAIN ||
+		resp.GetAppliedPolicyType() != powerdns_protobuf.PBDNSMessage_QNAME {
+		t.Errorf("invalid policy: %v", resp)
+	}
+	if len(pbdm.GetMeta()) != 1 || pbdm.GetMeta()[0].GetKey() != "agent" ||
+		pbdm.GetMeta()[0].GetValue().GetStringVal()[0] != "dnscollector" {
+		t.Errorf("invalid metadata: %v", pbdm.GetMeta())
+	}
+}
@@ -813,6 +813,20 @@ func NewPrometheus(config *pkgconfig.Config, logger *logger.Logger, name string)
 	return o
 }
 
+// NewPrometheusCounters creates only the registry and the counters of the prometheus logger,
+// without http server. Used by loggers pushing the same metrics to a remote backend.
+func NewPrometheusCounters(config *pkgconfig.Config, logger *logger.Logger, name string) *Prometheus {
+	o := &Prometheus{
+		config:       config,
+		logger:       logger,
+		promRegistry: prometheus.NewPedanticRegistry(),
+		name:         name,
+	}
+	o.catalogueLabels, o.counters = CreateSystemCatalogue(o)
+	o.InitProm()
+	return o
+}
+
 func (c *Prometheus) GetName() string { return c.name }
 
 func (c *Prometheus) AddDroppedRoute(wrk pkgutils.Worker) {
@@ -839,6 +853,9 @@ func (c *Prometheus) InitProm() {
 	)
 	// also try collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 
+	// export size and age of the disk spools of the loggers
+	c.promRegistry.MustRegister(pkgutils.NewSpoolCollector(promPrefix))
+
 	// Metric description created in Prometheus object, but used in Describe method of PrometheusCounterSet
 	// Prometheus class itself reports signle metric - BuildInfo.
 	c.gaugeTopDomains = prometheus.NewDesc(
@@ -1274,7 +1291,11 @@ RUN_LOOP:
 
 			// apply tranforms, init dns message with additionnals parts if necessary
 			subprocessors.InitDNSMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.ReturnDrop {
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
 				c.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
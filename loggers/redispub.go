@@ -40,6 +40,7 @@ type RedisPub struct {
 	transportReady     chan bool
 	transportReconnect chan bool
 	writerReady        bool
+	spool              *pkgutils.SpoolHandler
 	RoutingHandler     pkgutils.RoutingHandler
 }
 
@@ -65,6 +66,14 @@ func NewRedisPub(config *pkgconfig.Config, logger *logger.Logger, name string) *
 
 	s.ReadConfig()
 
+	// init the disk spool
+	spool, err := pkgutils.NewSpoolHandler(config.Loggers.RedisPub.Spool, name, logger,
+		s.FlushBuffer, func() bool { return s.writerReady })
+	if err != nil {
+		logger.Fatal(pkgutils.PrefixLogLogger+"["+name+"] redispub - unable to open the spool:", err)
+	}
+	s.spool = spool
+
 	return s
 }
 
@@ -225,20 +234,24 @@ func (c *RedisPub) ConnectToRemote() {
 		c.transportConn = conn
 
 		// block until framestream is ready
+		pkgutils.Telemetry.ConnectionOpened(c.name)
 		c.transportReady <- true
 
 		// block until an error occurred, need to reconnect
 		c.transportReconnect <- true
+		pkgutils.Telemetry.ConnectionLost(c.name)
 	}
 }
 
-func (c *RedisPub) FlushBuffer(buf *[]dnsutils.DNSMessage) {
+// FlushBuffer sends the buffer, returns the number of messages sent
+func (c *RedisPub) FlushBuffer(buf *[]dnsutils.DNSMessage) int {
+	sent := len(*buf)
 	// create escaping buffer
 	escapeBuffer := new(bytes.Buffer)
 	// create a new encoder that writes to the buffer
 	encoder := json.NewEncoder(escapeBuffer)
 
-	for _, dm := range *buf {
+	for i, dm := range *buf {
 		escapeBuffer.Reset()
 
 		cmd := "PUBLISH " + strconv.Quote(c.config.Loggers.RedisPub.RedisChannel) + " "
@@ -271,6 +284,7 @@ func (c *RedisPub) FlushBuffer(buf *[]dnsutils.DNSMessage) {
 		if err != nil {
 			c.LogError("send frame error", err.Error())
 			c.writerReady = false
+			sent = i
 			<-c.transportReconnect
 			break
 		}
@@ -278,6 +292,7 @@ func (c *RedisPub) FlushBuffer(buf *[]dnsutils.DNSMessage) {
 
 	// reset buffer
 	*buf = nil
+	return sent
 }
 
 func (c *RedisPub) Run() {
@@ -322,7 +337,11 @@ RUN_LOOP:
 
 			// apply tranforms, init dns message with additionnals parts if necessary
 			subprocessors.InitDNSMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.ReturnDrop {
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
 				c.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
@@ -355,6 +374,7 @@ PROCESS_LOOP:
 		case <-c.stopProcess:
 			// closing remote connection if exist
 			c.Disconnect()
+			c.spool.Close()
 			c.doneProcess <- true
 			break PROCESS_LOOP
 
@@ -372,6 +392,12 @@ PROCESS_LOOP:
 				return
 			}
 
+			// keep the dns message on disk if the connection is not ready
+			// or if older messages are waiting to be replayed
+			if c.spool.Keep(&bufferDm, dm) {
+				continue
+			}
+
 			// drop dns message if the connection is not ready to avoid memory leak or
 			// to block the channel
 			if !c.writerReady {
@@ -383,7 +409,7 @@ PROCESS_LOOP:
 
 			// buffer is full ?
 			if len(bufferDm) >= c.config.Loggers.RedisPub.BufferSize {
-				c.FlushBuffer(&bufferDm)
+				c.spool.FlushOrSpool(&bufferDm)
 			}
 
 		// flush the buffer
@@ -393,12 +419,16 @@ PROCESS_LOOP:
 			}
 
 			if len(bufferDm) > 0 {
-				c.FlushBuffer(&bufferDm)
+				c.spool.FlushOrSpool(&bufferDm)
 			}
 
 			// restart timer
 			flushTimer.Reset(flushInterval)
 
+		// replay the messages kept on disk
+		case <-c.spool.ReplayChan():
+			c.spool.Replay(c.config.Loggers.RedisPub.BufferSize)
+
 		}
 	}
 	c.LogInfo("processing terminated")
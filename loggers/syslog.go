@@ -258,8 +258,10 @@ func (s *Syslog) ConnectToRemote() {
 
 		// notify process that the transport is ready
 		// block the loop until a reconnect is needed
+		pkgutils.Telemetry.ConnectionOpened(s.name)
 		s.transportReady <- true
 		s.transportReconnect <- true
+		pkgutils.Telemetry.ConnectionLost(s.name)
 	}
 }
 
@@ -309,7 +311,11 @@ RUN_LOOP:
 
 			// apply tranforms, init dns message with additionnals parts if necessary
 			subprocessors.InitDNSMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.ReturnDrop {
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
 				s.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
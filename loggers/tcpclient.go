@@ -39,6 +39,7 @@ type TCPClient struct {
 	transportReady     chan bool
 	transportReconnect chan bool
 	writerReady        bool
+	spool              *pkgutils.SpoolHandler
 	RoutingHandler     pkgutils.RoutingHandler
 }
 
@@ -64,6 +65,14 @@ func NewTCPClient(config *pkgconfig.Config, logger *logger.Logger, name string)
 
 	s.ReadConfig()
 
+	// init the disk spool
+	spool, err := pkgutils.NewSpoolHandler(config.Loggers.TCPClient.Spool, name, logger,
+		s.FlushBuffer, func() bool { return s.writerReady })
+	if err != nil {
+		logger.Fatal(pkgutils.PrefixLogLogger+"["+name+"] tcpclient - unable to open the spool:", err)
+	}
+	s.spool = spool
+
 	return s
 }
 
@@ -223,15 +232,19 @@ func (c *TCPClient) ConnectToRemote() {
 		c.transportConn = conn
 
 		// block until framestream is ready
+		pkgutils.Telemetry.ConnectionOpened(c.name)
 		c.transportReady <- true
 
 		// block until an error occurred, need to reconnect
 		c.transportReconnect <- true
+		pkgutils.Telemetry.ConnectionLost(c.name)
 	}
 }
 
-func (c *TCPClient) FlushBuffer(buf *[]dnsutils.DNSMessage) {
-	for _, dm := range *buf {
+// FlushBuffer sends the buffer, returns the number of messages sent
+func (c *TCPClient) FlushBuffer(buf *[]dnsutils.DNSMessage) int {
+	sent := len(*buf)
+	for i, dm := range *buf {
 		if c.config.Loggers.TCPClient.Mode == pkgconfig.ModeText {
 			c.transportWriter.Write(dm.Bytes(c.textFormat,
 				c.config.Global.TextFormatDelimiter,
@@ -259,6 +272,7 @@ func (c *TCPClient) FlushBuffer(buf *[]dnsutils.DNSMessage) {
 		if err != nil {
 			c.LogError("send frame error", err.Error())
 			c.writerReady = false
+			sent = i
 			<-c.transportReconnect
 			break
 		}
@@ -266,6 +280,7 @@ func (c *TCPClient) FlushBuffer(buf *[]dnsutils.DNSMessage) {
 
 	// reset buffer
 	*buf = nil
+	return sent
 }
 
 func (c *TCPClient) Run() {
@@ -310,7 +325,11 @@ RUN_LOOP:
 
 			// apply tranforms, init dns message with additionnals parts if necessary
 			subprocessors.InitDNSMessageFormat(&dm)
-			if subprocessors.ProcessMessage(&dm) == transformers.ReturnDrop {
+			switch subprocessors.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
 				c.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
@@ -343,6 +362,7 @@ PROCESS_LOOP:
 		case <-c.stopProcess:
 			// closing remote connection if exist
 			c.Disconnect()
+			c.spool.Close()
 			c.doneProcess <- true
 			break PROCESS_LOOP
 
@@ -361,6 +381,12 @@ PROCESS_LOOP:
 				return
 			}
 
+			// keep the dns message on disk if the connection is not ready
+			// or if older messages are waiting to be replayed
+			if c.spool.Keep(&bufferDm, dm) {
+				continue
+			}
+
 			// drop dns message if the connection is not ready to avoid memory leak or
 			// to block the channel
 			if !c.writerReady {
@@ -372,7 +398,7 @@ PROCESS_LOOP:
 
 			// buffer is full ?
 			if len(bufferDm) >= c.config.Loggers.TCPClient.BufferSize {
-				c.FlushBuffer(&bufferDm)
+				c.spool.FlushOrSpool(&bufferDm)
 			}
 
 		// flush the buffer
@@ -382,12 +408,16 @@ PROCESS_LOOP:
 			}
 
 			if len(bufferDm) > 0 {
-				c.FlushBuffer(&bufferDm)
+				c.spool.FlushOrSpool(&bufferDm)
 			}
 
 			// restart timer
 			flushTimer.Reset(flushInterval)
 
+		// replay the messages kept on disk
+		case <-c.spool.ReplayChan():
+			c.spool.Replay(c.config.Loggers.TCPClient.BufferSize)
+
 		}
 	}
 	c.LogInfo("processing terminated")
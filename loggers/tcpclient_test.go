@@ -144,3 +144,59 @@ func Test_TcpClient_ConnectionAttempt(t *testing.T) {
 	g.Stop()
 
 }
+
+func Test_TcpClient_Spool(t *testing.T) {
+	// init logger with spool, remote is down
+	cfg := pkgconfig.GetFakeConfig()
+	cfg.Loggers.TCPClient.FlushInterval = 1
+	cfg.Loggers.TCPClient.Mode = pkgconfig.ModeText
+	cfg.Loggers.TCPClient.RemoteAddress = "127.0.0.1"
+	cfg.Loggers.TCPClient.RemotePort = 9999
+	cfg.Loggers.TCPClient.ConnectTimeout = 1
+	cfg.Loggers.TCPClient.RetryInterval = 2
+	cfg.Loggers.TCPClient.Spool.Enable = true
+	cfg.Loggers.TCPClient.Spool.Directory = t.TempDir()
+
+	g := NewTCPClient(cfg, logger.New(false), "test")
+	go g.Run()
+
+	// send fake dns messages during the outage
+	for _, qname := range []string{"first.collector", "second.collector"} {
+		dm := dnsutils.GetFakeDNSMessage()
+		dm.DNS.Qname = qname
+		g.GetInputChannel() <- dm
+	}
+	time.Sleep(time.Second)
+	if g.spool.Len() != 2 {
+		t.Fatalf("2 messages expected in the spool, got %d", g.spool.Len())
+	}
+
+	// start receiver
+	fakeRcvr, err := net.Listen(netlib.SocketTCP, ":9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fakeRcvr.Close()
+
+	conn, err := fakeRcvr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// messages are replayed in order
+	reader := bufio.NewReader(conn)
+	for _, qname := range []string{"first.collector", "second.collector"} {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(" " + qname + " ").MatchString(line) {
+			t.Errorf("tcp error want %s, got: %s", qname, line)
+		}
+	}
+
+	// stop all
+	fakeRcvr.Close()
+	g.Stop()
+}
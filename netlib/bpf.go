@@ -0,0 +1,517 @@
+package netlib
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/bpf"
+)
+
+// Compiler of tcpdump-like filter expressions to classic BPF programs.
+//
+// Supported primitives on ethernet link type:
+//
+//	ip, ip6, tcp, udp, icmp, icmp6
+//	[ip|ip6] [src|dst] host <address>
+//	[ip|ip6] [src|dst] net <address>/<prefix>
+//	[tcp|udp] [src|dst] port <port>
+//	[tcp|udp] [src|dst] portrange <port>-<port>
+//
+// Primitives can be combined with the and (&&), or (||) and not (!) operators and
+// with parentheses. Like tcpdump, and/or have the same precedence and are evaluated
+// from left to right, and the qualifiers can be omitted to reuse the previous ones
+// (example: "port 53 or 853"). Port filters ignore the non-first IPv4 fragments.
+
+const (
+	BpfSnapLen     = 0xFFFF
+	bpfMaxInsns    = 4096
+	ethTypeOffset  = 12
+	ipv4Offset     = 14
+	ipv6Offset     = 14
+	ipv6HeaderSize = 40
+)
+
+// bpf expression tree
+type bpfNode interface{}
+
+type bpfAnd struct{ left, right bpfNode }
+type bpfOr struct{ left, right bpfNode }
+type bpfNot struct{ node bpfNode }
+
+// bpfTest loads a value in register A and compares it
+type bpfTest struct {
+	load []bpf.Instruction
+	cond bpf.JumpTest
+	val  uint32
+}
+
+func bpfAndAll(nodes ...bpfNode) bpfNode {
+	node := nodes[0]
+	for _, n := range nodes[1:] {
+		node = bpfAnd{node, n}
+	}
+	return node
+}
+
+func bpfOrAll(nodes ...bpfNode) bpfNode {
+	node := nodes[0]
+	for _, n := range nodes[1:] {
+		node = bpfOr{node, n}
+	}
+	return node
+}
+
+func bpfLoadTest(off uint32, size int, cond bpf.JumpTest, val uint32) bpfTest {
+	return bpfTest{load: []bpf.Instruction{bpf.LoadAbsolute{Off: off, Size: size}}, cond: cond, val: val}
+}
+
+func bpfEthType(ethType uint32) bpfNode {
+	return bpfLoadTest(ethTypeOffset, 2, bpf.JumpEqual, ethType)
+}
+
+func bpfIPv4Proto(proto uint32) bpfNode {
+	return bpfAnd{bpfEthType(0x0800), bpfLoadTest(ipv4Offset+9, 1, bpf.JumpEqual, proto)}
+}
+
+func bpfIPv6Proto(proto uint32) bpfNode {
+	return bpfAnd{bpfEthType(0x86dd), bpfLoadTest(ipv6Offset+6, 1, bpf.JumpEqual, proto)}
+}
+
+// bpfAddress compares the address at the offset with the network, word by word
+func bpfAddress(off uint32, ipnet *net.IPNet) bpfNode {
+	tests := []bpfNode{}
+	for i := 0; i < len(ipnet.IP); i += 4 {
+		mask := uint32(ipnet.Mask[i])<<24 | uint32(ipnet.Mask[i+1])<<16 | uint32(ipnet.Mask[i+2])<<8 | uint32(ipnet.Mask[i+3])
+		addr := uint32(ipnet.IP[i])<<24 | uint32(ipnet.IP[i+1])<<16 | uint32(ipnet.IP[i+2])<<8 | uint32(ipnet.IP[i+3])
+		if mask == 0 {
+			break
+		}
+		test := bpfLoadTest(off+uint32(i), 4, bpf.JumpEqual, addr&mask)
+		if mask != 0xffffffff {
+			test.load = append(test.load, bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: mask})
+		}
+		tests = append(tests, test)
+	}
+	if len(tests) == 0 {
+		// match any address
+		return bpfLoadTest(ethTypeOffset, 2, bpf.JumpGreaterOrEqual, 0)
+	}
+	return bpfAndAll(tests...)
+}
+
+// bpfPortTest compares the port of the transport layer, the ipv4 header length is read from the packet
+func bpfPortTest(ipv6 bool, dst bool, cond bpf.JumpTest, port uint32) bpfNode {
+	off := uint32(0)
+	if dst {
+		off = 2
+	}
+	if ipv6 {
+		return bpfLoadTest(ipv6Offset+ipv6HeaderSize+off, 2, cond, port)
+	}
+	return bpfTest{
+		load: []bpf.Instruction{bpf.LoadMemShift{Off: ipv4Offset}, bpf.LoadIndirect{Off: ipv4Offset + off, Size: 2}},
+		cond: cond,
+		val:  port,
+	}
+}
+
+// bpf qualifiers of a primitive
+type bpfQualifiers struct {
+	proto string
+	dir   string
+	kind  string
+}
+
+type bpfParser struct {
+	tokens []string
+	pos    int
+	last   bpfQualifiers
+}
+
+func bpfTokenize(expr string) []string {
+	tokens := []string{}
+	current := strings.Builder{}
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			flush()
+		case c == '(' || c == ')':
+			flush()
+			tokens = append(tokens, string(c))
+		case c == '!':
+			flush()
+			tokens = append(tokens, "not")
+		case (c == '&' || c == '|') && i+1 < len(expr) && expr[i+1] == c:
+			flush()
+			if c == '&' {
+				tokens = append(tokens, "and")
+			} else {
+				tokens = append(tokens, "or")
+			}
+			i++
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func (p *bpfParser) peek(n int) string {
+	if p.pos+n < len(p.tokens) {
+		return p.tokens[p.pos+n]
+	}
+	return ""
+}
+
+func (p *bpfParser) next() string {
+	token := p.peek(0)
+	if token != "" {
+		p.pos++
+	}
+	return token
+}
+
+func (p *bpfParser) parseExpr() (bpfNode, error) {
+	node, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek(0)
+		if op != "and" && op != "or" {
+			return node, nil
+		}
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "and" {
+			node = bpfAnd{node, right}
+		} else {
+			node = bpfOr{node, right}
+		}
+	}
+}
+
+func (p *bpfParser) parseUnary() (bpfNode, error) {
+	switch p.peek(0) {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "not":
+		p.next()
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return bpfNot{node}, nil
+	case "(":
+		p.next()
+		node, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return node, nil
+	}
+	return p.parsePrimitive()
+}
+
+func (p *bpfParser) parsePrimitive() (bpfNode, error) {
+	q := bpfQualifiers{}
+
+	switch p.peek(0) {
+	case "ip", "ip6", "tcp", "udp", "icmp", "icmp6":
+		q.proto = p.next()
+	}
+
+	switch p.peek(0) {
+	case "src", "dst":
+		q.dir = p.next()
+		// src or dst, src and dst
+		if (p.peek(0) == "or" || p.peek(0) == "and") && (p.peek(1) == "src" || p.peek(1) == "dst") && p.peek(1) != q.dir {
+			q.dir = "src " + p.next() + " dst"
+			p.next()
+		}
+	}
+
+	switch p.peek(0) {
+	case "host", "net", "port", "portrange":
+		q.kind = p.next()
+	}
+
+	// protocol only
+	if q.dir == "" && q.kind == "" {
+		if q.proto != "" {
+			p.last = bpfQualifiers{}
+			return bpfProtocol(q.proto), nil
+		}
+		// value only, reuse the previous qualifiers
+		if p.last.kind == "" {
+			return nil, fmt.Errorf("unexpected token %q", p.peek(0))
+		}
+		q = p.last
+	}
+	if q.kind == "" {
+		q.kind = "host"
+	}
+
+	value := p.next()
+	if value == "" || value == "(" || value == ")" || value == "and" || value == "or" || value == "not" {
+		return nil, fmt.Errorf("missing value after %s", q.kind)
+	}
+	p.last = q
+
+	switch q.kind {
+	case "host", "net":
+		return bpfAddressPrimitive(q, value)
+	default:
+		return bpfPortPrimitive(q, value)
+	}
+}
+
+func bpfProtocol(proto string) bpfNode {
+	switch proto {
+	case "ip":
+		return bpfEthType(0x0800)
+	case "ip6":
+		return bpfEthType(0x86dd)
+	case "icmp":
+		return bpfIPv4Proto(1)
+	case "icmp6":
+		return bpfIPv6Proto(58)
+	case "tcp":
+		return bpfOr{bpfIPv4Proto(6), bpfIPv6Proto(6)}
+	default:
+		return bpfOr{bpfIPv4Proto(17), bpfIPv6Proto(17)}
+	}
+}
+
+// bpfDirection combines the source and destination tests according to the direction
+func bpfDirection(dir string, src, dst bpfNode) bpfNode {
+	switch dir {
+	case "src":
+		return src
+	case "dst":
+		return dst
+	case "src and dst":
+		return bpfAnd{src, dst}
+	default:
+		return bpfOr{src, dst}
+	}
+}
+
+func bpfAddressPrimitive(q bpfQualifiers, value string) (bpfNode, error) {
+	var ipnet *net.IPNet
+	if q.kind == "net" {
+		_, n, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", value)
+		}
+		ipnet = n
+	} else {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid host %q, only ip addresses are supported", value)
+		}
+		ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+	}
+
+	if ip4 := ipnet.IP.To4(); ip4 != nil {
+		if q.proto != "" && q.proto != "ip" {
+			return nil, fmt.Errorf("%s is not valid with an ipv4 address", q.proto)
+		}
+		ipnet = &net.IPNet{IP: ip4, Mask: ipnet.Mask[len(ipnet.Mask)-4:]}
+		return bpfAnd{
+			bpfEthType(0x0800),
+			bpfDirection(q.dir, bpfAddress(ipv4Offset+12, ipnet), bpfAddress(ipv4Offset+16, ipnet)),
+		}, nil
+	}
+
+	if q.proto != "" && q.proto != "ip6" {
+		return nil, fmt.Errorf("%s is not valid with an ipv6 address", q.proto)
+	}
+	return bpfAnd{
+		bpfEthType(0x86dd),
+		bpfDirection(q.dir, bpfAddress(ipv6Offset+8, ipnet), bpfAddress(ipv6Offset+24, ipnet)),
+	}, nil
+}
+
+func bpfParsePort(value string) (uint32, error) {
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q", value)
+	}
+	return uint32(port), nil
+}
+
+func bpfPortPrimitive(q bpfQualifiers, value string) (bpfNode, error) {
+	var low, high uint32
+	var err error
+	if q.kind == "portrange" {
+		bounds := strings.SplitN(value, "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid port range %q", value)
+		}
+		if low, err = bpfParsePort(bounds[0]); err != nil {
+			return nil, err
+		}
+		if high, err = bpfParsePort(bounds[1]); err != nil {
+			return nil, err
+		}
+		if low > high {
+			low, high = high, low
+		}
+	} else {
+		if low, err = bpfParsePort(value); err != nil {
+			return nil, err
+		}
+		high = low
+	}
+
+	protos := []uint32{}
+	switch q.proto {
+	case "":
+		protos = append(protos, 6, 17)
+	case "tcp":
+		protos = append(protos, 6)
+	case "udp":
+		protos = append(protos, 17)
+	default:
+		return nil, fmt.Errorf("%s is not valid with a port", q.proto)
+	}
+
+	portTest := func(ipv6 bool, dst bool) bpfNode {
+		if low == high {
+			return bpfPortTest(ipv6, dst, bpf.JumpEqual, low)
+		}
+		return bpfAnd{bpfPortTest(ipv6, dst, bpf.JumpGreaterOrEqual, low), bpfPortTest(ipv6, dst, bpf.JumpLessOrEqual, high)}
+	}
+
+	ipv4Protos, ipv6Protos := []bpfNode{}, []bpfNode{}
+	for _, proto := range protos {
+		ipv4Protos = append(ipv4Protos, bpfLoadTest(ipv4Offset+9, 1, bpf.JumpEqual, proto))
+		ipv6Protos = append(ipv6Protos, bpfLoadTest(ipv6Offset+6, 1, bpf.JumpEqual, proto))
+	}
+
+	ipv4 := bpfAndAll(
+		bpfEthType(0x0800),
+		bpfOrAll(ipv4Protos...),
+		// ignore the non-first fragments
+		bpfLoadTest(ipv4Offset+6, 2, bpf.JumpBitsNotSet, 0x1fff),
+		bpfDirection(q.dir, portTest(false, false), portTest(false, true)),
+	)
+	ipv6 := bpfAndAll(
+		bpfEthType(0x86dd),
+		bpfOrAll(ipv6Protos...),
+		bpfDirection(q.dir, portTest(true, false), portTest(true, true)),
+	)
+	return bpfOr{ipv4, ipv6}, nil
+}
+
+// bpf code generator, the jumps are resolved when all the labels are placed
+type bpfJump struct {
+	index       int
+	trueLabel   int
+	falseLabel  int
+	instruction bpf.JumpIf
+}
+
+type bpfCompiler struct {
+	instructions []bpf.Instruction
+	labels       []int
+	jumps        []bpfJump
+}
+
+func (c *bpfCompiler) newLabel() int {
+	c.labels = append(c.labels, -1)
+	return len(c.labels) - 1
+}
+
+func (c *bpfCompiler) placeLabel(label int) {
+	c.labels[label] = len(c.instructions)
+}
+
+func (c *bpfCompiler) generate(node bpfNode, trueLabel, falseLabel int) {
+	switch n := node.(type) {
+	case bpfAnd:
+		next := c.newLabel()
+		c.generate(n.left, next, falseLabel)
+		c.placeLabel(next)
+		c.generate(n.right, trueLabel, falseLabel)
+	case bpfOr:
+		next := c.newLabel()
+		c.generate(n.left, trueLabel, next)
+		c.placeLabel(next)
+		c.generate(n.right, trueLabel, falseLabel)
+	case bpfNot:
+		c.generate(n.node, falseLabel, trueLabel)
+	case bpfTest:
+		c.instructions = append(c.instructions, n.load...)
+		c.jumps = append(c.jumps, bpfJump{
+			index:       len(c.instructions),
+			trueLabel:   trueLabel,
+			falseLabel:  falseLabel,
+			instruction: bpf.JumpIf{Cond: n.cond, Val: n.val},
+		})
+		c.instructions = append(c.instructions, nil)
+	}
+}
+
+func (c *bpfCompiler) resolve() error {
+	for _, jump := range c.jumps {
+		skipTrue := c.labels[jump.trueLabel] - jump.index - 1
+		skipFalse := c.labels[jump.falseLabel] - jump.index - 1
+		if skipTrue > 255 || skipFalse > 255 {
+			return fmt.Errorf("expression too long, jump out of range")
+		}
+		jump.instruction.SkipTrue = uint8(skipTrue)
+		jump.instruction.SkipFalse = uint8(skipFalse)
+		c.instructions[jump.index] = jump.instruction
+	}
+	if len(c.instructions) > bpfMaxInsns {
+		return fmt.Errorf("expression too long, %d instructions", len(c.instructions))
+	}
+	return nil
+}
+
+// CompileBpfFilter compiles a tcpdump-like filter expression to a classic BPF program,
+// the matching packets are kept up to BpfSnapLen bytes.
+func CompileBpfFilter(expr string) ([]bpf.Instruction, error) {
+	parser := &bpfParser{tokens: bpfTokenize(expr)}
+	if len(parser.tokens) == 0 {
+		return nil, fmt.Errorf("bpf filter: empty expression")
+	}
+
+	root, err := parser.parseExpr()
+	if err != nil {
+		return nil, fmt.Errorf("bpf filter: %w", err)
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf("bpf filter: unexpected token %q", parser.peek(0))
+	}
+
+	c := &bpfCompiler{}
+	accept, reject := c.newLabel(), c.newLabel()
+	c.generate(root, accept, reject)
+	c.placeLabel(accept)
+	c.instructions = append(c.instructions, bpf.RetConstant{Val: BpfSnapLen})
+	c.placeLabel(reject)
+	c.instructions = append(c.instructions, bpf.RetConstant{Val: 0})
+
+	if err := c.resolve(); err != nil {
+		return nil, fmt.Errorf("bpf filter: %w", err)
+	}
+	return c.instructions, nil
+}
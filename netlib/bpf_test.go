@@ -0,0 +1,122 @@
+package netlib
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+func bpfFakePacket(t *testing.T, src, dst string, udp bool, srcPort, dstPort int) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC: net.HardwareAddr{0x00, 0x0c, 0x29, 0x8a, 0x5d, 0xd7},
+		DstMAC: net.HardwareAddr{0x00, 0x86, 0x9c, 0xe7, 0x55, 0x14},
+	}
+	proto := layers.IPProtocolTCP
+	if udp {
+		proto = layers.IPProtocolUDP
+	}
+
+	var network gopacket.NetworkLayer
+	var ipLayer gopacket.SerializableLayer
+	if ip := net.ParseIP(src); ip.To4() != nil {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: proto, SrcIP: ip.To4(), DstIP: net.ParseIP(dst).To4()}
+		network, ipLayer = ip4, ip4
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: proto, SrcIP: ip, DstIP: net.ParseIP(dst)}
+		network, ipLayer = ip6, ip6
+	}
+
+	var transport gopacket.SerializableLayer
+	if udp {
+		l := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+		l.SetNetworkLayerForChecksum(network)
+		transport = l
+	} else {
+		l := &layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(dstPort), Window: 1024}
+		l.SetNetworkLayerForChecksum(network)
+		transport = l
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ipLayer, transport, gopacket.Payload([]byte{0x00, 0x01})); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBpfFilter_Compile(t *testing.T) {
+	testcases := []struct {
+		expr     string
+		src, dst string
+		udp      bool
+		sport    int
+		dport    int
+		match    bool
+	}{
+		{"port 53", "10.0.0.1", "10.0.0.2", true, 35000, 53, true},
+		{"port 53", "10.0.0.2", "10.0.0.1", true, 53, 35000, true},
+		{"port 53", "fe80::1", "fe80::2", false, 35000, 53, true},
+		{"port 53", "10.0.0.1", "10.0.0.2", true, 35000, 853, false},
+		{"udp port 53", "10.0.0.1", "10.0.0.2", false, 35000, 53, false},
+		{"tcp dst port 853", "10.0.0.1", "10.0.0.2", false, 35000, 853, true},
+		{"tcp dst port 853", "10.0.0.2", "10.0.0.1", false, 853, 35000, false},
+		{"port 53 or 5353 or 8053", "10.0.0.1", "10.0.0.2", true, 35000, 8053, true},
+		{"portrange 5000-6000", "fe80::1", "fe80::2", true, 35000, 5353, true},
+		{"portrange 5000-6000", "fe80::1", "fe80::2", true, 35000, 53, false},
+		{"host 10.0.0.1", "10.0.0.2", "10.0.0.1", true, 35000, 53, true},
+		{"src host 10.0.0.1", "10.0.0.2", "10.0.0.1", true, 35000, 53, false},
+		{"src or dst 2001:db8::1", "2001:db8::2", "2001:db8::1", true, 35000, 53, true},
+		{"net 192.168.0.0/16", "192.168.10.1", "10.0.0.1", true, 35000, 53, true},
+		{"dst net 2001:db8::/32", "fe80::1", "2001:db8:1::1", true, 35000, 53, true},
+		{"ip6", "10.0.0.1", "10.0.0.2", true, 35000, 53, false},
+		{"port 53 and not host 10.0.0.250", "10.0.0.250", "10.0.0.2", true, 35000, 53, false},
+		{"port 53 && !(src host 10.0.0.250 || src host 10.0.0.251)", "10.0.0.1", "10.0.0.2", true, 35000, 53, true},
+		{"host 10.0.0.1 or host 10.0.0.2 and port 53", "10.0.0.2", "10.0.0.3", true, 35000, 80, false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.expr, func(t *testing.T) {
+			filter, err := CompileBpfFilter(tc.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vm, err := bpf.NewVM(filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := vm.Run(bpfFakePacket(t, tc.src, tc.dst, tc.udp, tc.sport, tc.dport))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (n > 0) != tc.match {
+				t.Errorf("%s -> %s: match expected %v, got %v", tc.src, tc.dst, tc.match, n > 0)
+			}
+		})
+	}
+}
+
+func TestBpfFilter_CompileInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"port",
+		"port dns",
+		"port 53 and",
+		"(port 53",
+		"host dns.collector",
+		"ip6 host 10.0.0.1",
+		"icmp port 53",
+		"net 10.0.0.1",
+		"portrange 53",
+		"53",
+	} {
+		if _, err := CompileBpfFilter(expr); err == nil {
+			t.Errorf("error expected for %q", expr)
+		}
+	}
+}
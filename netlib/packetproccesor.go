@@ -91,6 +91,7 @@ func IPDefragger(ipInput chan gopacket.Packet, udpOutput chan gopacket.Packet, t
 	for fragment := range ipInput {
 		reassembled, err := defragger.DefragIP(fragment)
 		if err != nil {
+			reassemblyIPDefrag.Add(1)
 			break
 		}
 		if reassembled == nil {
@@ -104,3 +105,41 @@ func IPDefragger(ipInput chan gopacket.Packet, udpOutput chan gopacket.Packet, t
 		}
 	}
 }
+
+// PacketDispatcher sends a captured packet to the ip defragmenters, the udp processor or the tcp assembler
+func PacketDispatcher(packet gopacket.Packet, udpOutput, tcpOutput, fragIP4Output, fragIP6Output chan gopacket.Packet) {
+	// some security checks
+	if packet.NetworkLayer() == nil {
+		return
+	}
+	if packet.TransportLayer() == nil {
+		return
+	}
+
+	// ipv4 fragmented packet ?
+	if packet.NetworkLayer().LayerType() == layers.LayerTypeIPv4 {
+		ip4 := packet.NetworkLayer().(*layers.IPv4)
+		if ip4.Flags&layers.IPv4MoreFragments == 1 || ip4.FragOffset > 0 {
+			fragIP4Output <- packet
+			return
+		}
+	}
+
+	// ipv6 fragmented packet ?
+	if packet.NetworkLayer().LayerType() == layers.LayerTypeIPv6 {
+		v6frag := packet.Layer(layers.LayerTypeIPv6Fragment)
+		if v6frag != nil {
+			fragIP6Output <- packet
+			return
+		}
+	}
+
+	// tcp or udp packets ?
+	if packet.TransportLayer().LayerType() == layers.LayerTypeUDP {
+		udpOutput <- packet
+	}
+
+	if packet.TransportLayer().LayerType() == layers.LayerTypeTCP {
+		tcpOutput <- packet
+	}
+}
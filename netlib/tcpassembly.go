@@ -3,12 +3,35 @@ package netlib
 import (
 	"bytes"
 	"io"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/tcpassembly"
 )
 
+const (
+	ReassemblyMissingData = "missing-data"
+	ReassemblyIncomplete  = "incomplete"
+	ReassemblyIPDefrag    = "ip-defrag"
+)
+
+// reassembly errors of all the streams, read by the telemetry
+var (
+	reassemblyMissingData atomic.Uint64
+	reassemblyIncomplete  atomic.Uint64
+	reassemblyIPDefrag    atomic.Uint64
+)
+
+// GetReassemblyErrors returns the number of tcp reassembly and ip defragmentation errors by reason
+func GetReassemblyErrors() map[string]uint64 {
+	return map[string]uint64{
+		ReassemblyMissingData: reassemblyMissingData.Load(),
+		ReassemblyIncomplete:  reassemblyIncomplete.Load(),
+		ReassemblyIPDefrag:    reassemblyIPDefrag.Load(),
+	}
+}
+
 type DNSStreamFactory struct {
 	// Channel to send reassembled DNS data
 	Reassembled    chan DNSPacket
@@ -38,6 +61,7 @@ type stream struct {
 func (s *stream) Reassembled(rs []tcpassembly.Reassembly) {
 	for _, r := range rs {
 		if r.Skip > 0 {
+			reassemblyMissingData.Add(1)
 			continue
 		}
 		// Append the reassembled data to the existing data
@@ -84,4 +108,10 @@ func (s *stream) Reassembled(rs []tcpassembly.Reassembly) {
 	}
 }
 
-func (s *stream) ReassemblyComplete() {}
+// ReassemblyComplete is called when the stream is closed or flushed,
+// the remaining data is an incomplete dns message
+func (s *stream) ReassemblyComplete() {
+	if len(s.data) > 0 {
+		reassemblyIncomplete.Add(1)
+	}
+}
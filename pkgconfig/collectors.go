@@ -1,6 +1,10 @@
 package pkgconfig
 
-import "reflect"
+import (
+	"reflect"
+
+	"github.com/dmachard/go-dnscollector/netlib"
+)
 
 type ConfigCollectors struct {
 	DNSMessage struct {
@@ -44,14 +48,20 @@ type ConfigCollectors struct {
 		KeyFile       string `yaml:"key-file"`
 	} `yaml:"dnstap-relay"`
 	AfpacketLiveCapture struct {
-		Enable            bool   `yaml:"enable"`
-		Port              int    `yaml:"port"`
-		Device            string `yaml:"device"`
-		ChannelBufferSize int    `yaml:"chan-buffer-size"`
+		Enable            bool     `yaml:"enable"`
+		Port              int      `yaml:"port"`
+		Device            string   `yaml:"device"`
+		Devices           []string `yaml:"devices"`
+		BpfFilter         string   `yaml:"bpf-filter"`
+		TpacketV3         bool     `yaml:"tpacket-v3"`
+		BlockSize         int      `yaml:"block-size"`
+		BlockCount        int      `yaml:"block-count"`
+		ChannelBufferSize int      `yaml:"chan-buffer-size"`
 	} `yaml:"afpacket-sniffer"`
 	XdpLiveCapture struct {
 		Enable            bool   `yaml:"enable"`
 		Port              int    `yaml:"port"`
+		Ports             []int  `yaml:"ports"`
 		Device            string `yaml:"device"`
 		ChannelBufferSize int    `yaml:"chan-buffer-size"`
 	} `yaml:"xdp-sniffer"`
@@ -82,6 +92,59 @@ type ConfigCollectors struct {
 		ListenPort        int    `yaml:"listen-port"`
 		ChannelBufferSize int    `yaml:"chan-buffer-size"`
 	} `yaml:"tzsp"`
+	DoHProxy struct {
+		Enable            bool   `yaml:"enable"`
+		ListenIP          string `yaml:"listen-ip"`
+		DoHListenPort     int    `yaml:"doh-listen-port"`
+		DoHPath           string `yaml:"doh-path"`
+		DoTListenPort     int    `yaml:"dot-listen-port"`
+		TLSSupport        bool   `yaml:"tls-support"`
+		TLSMinVersion     string `yaml:"tls-min-version"`
+		CertFile          string `yaml:"cert-file"`
+		KeyFile           string `yaml:"key-file"`
+		UpstreamAddress   string `yaml:"upstream-address"`
+		UpstreamPort      int    `yaml:"upstream-port"`
+		UpstreamTransport string `yaml:"upstream-transport"`
+		UpstreamTimeout   int    `yaml:"upstream-timeout"`
+		ChannelBufferSize int    `yaml:"chan-buffer-size"`
+	} `yaml:"doh-proxy"`
+	KafkaConsumer struct {
+		Enable            bool   `yaml:"enable"`
+		RemoteAddress     string `yaml:"remote-address"`
+		RemotePort        int    `yaml:"remote-port"`
+		RetryInterval     int    `yaml:"retry-interval"`
+		TLSSupport        bool   `yaml:"tls-support"`
+		TLSInsecure       bool   `yaml:"tls-insecure"`
+		TLSMinVersion     string `yaml:"tls-min-version"`
+		CAFile            string `yaml:"ca-file"`
+		CertFile          string `yaml:"cert-file"`
+		KeyFile           string `yaml:"key-file"`
+		SaslSupport       bool   `yaml:"sasl-support"`
+		SaslUsername      string `yaml:"sasl-username"`
+		SaslPassword      string `yaml:"sasl-password"`
+		SaslMechanism     string `yaml:"sasl-mechanism"`
+		Mode              string `yaml:"mode"`
+		ConnectTimeout    int    `yaml:"connect-timeout"`
+		Topic             string `yaml:"topic"`
+		Partition         int    `yaml:"partition"`
+		GroupID           string `yaml:"group-id"`
+		CommitInterval    int    `yaml:"commit-interval"`
+		ChannelBufferSize int    `yaml:"chan-buffer-size"`
+	} `yaml:"kafkaconsumer"`
+	JSONListener struct {
+		Enable            bool   `yaml:"enable"`
+		ListenIP          string `yaml:"listen-ip"`
+		ListenPort        int    `yaml:"listen-port"`
+		SockPath          string `yaml:"sock-path"`
+		Transport         string `yaml:"transport"`
+		TLSMinVersion     string `yaml:"tls-min-version"`
+		CertFile          string `yaml:"cert-file"`
+		KeyFile           string `yaml:"key-file"`
+		Mode              string `yaml:"mode"`
+		MaxLineSize       int    `yaml:"max-line-size"`
+		ResetConn         bool   `yaml:"reset-conn"`
+		ChannelBufferSize int    `yaml:"chan-buffer-size"`
+	} `yaml:"json-listener"`
 }
 
 func (c *ConfigCollectors) SetDefault() {
@@ -118,12 +181,19 @@ func (c *ConfigCollectors) SetDefault() {
 	c.DnstapProxifier.KeyFile = ""
 
 	c.XdpLiveCapture.Enable = false
+	c.XdpLiveCapture.Port = 53
+	c.XdpLiveCapture.Ports = []int{}
 	c.XdpLiveCapture.Device = ""
 	c.XdpLiveCapture.ChannelBufferSize = 65535
 
 	c.AfpacketLiveCapture.Enable = false
 	c.AfpacketLiveCapture.Port = 53
 	c.AfpacketLiveCapture.Device = ""
+	c.AfpacketLiveCapture.Devices = []string{}
+	c.AfpacketLiveCapture.BpfFilter = ""
+	c.AfpacketLiveCapture.TpacketV3 = false
+	c.AfpacketLiveCapture.BlockSize = 1048576
+	c.AfpacketLiveCapture.BlockCount = 16
 	c.AfpacketLiveCapture.ChannelBufferSize = 65535
 
 	c.PowerDNS.Enable = false
@@ -149,6 +219,56 @@ func (c *ConfigCollectors) SetDefault() {
 	c.Tzsp.ListenIP = AnyIP
 	c.Tzsp.ListenPort = 10000
 	c.Tzsp.ChannelBufferSize = 65535
+
+	c.DoHProxy.Enable = false
+	c.DoHProxy.ListenIP = AnyIP
+	c.DoHProxy.DoHListenPort = 443
+	c.DoHProxy.DoHPath = "/dns-query"
+	c.DoHProxy.DoTListenPort = 853
+	c.DoHProxy.TLSSupport = true
+	c.DoHProxy.TLSMinVersion = TLSV12
+	c.DoHProxy.CertFile = ""
+	c.DoHProxy.KeyFile = ""
+	c.DoHProxy.UpstreamAddress = LocalhostIP
+	c.DoHProxy.UpstreamPort = 53
+	c.DoHProxy.UpstreamTransport = "udp"
+	c.DoHProxy.UpstreamTimeout = 5
+	c.DoHProxy.ChannelBufferSize = 65535
+
+	c.KafkaConsumer.Enable = false
+	c.KafkaConsumer.RemoteAddress = LocalhostIP
+	c.KafkaConsumer.RemotePort = 9092
+	c.KafkaConsumer.RetryInterval = 10
+	c.KafkaConsumer.TLSSupport = false
+	c.KafkaConsumer.TLSInsecure = false
+	c.KafkaConsumer.TLSMinVersion = TLSV12
+	c.KafkaConsumer.CAFile = ""
+	c.KafkaConsumer.CertFile = ""
+	c.KafkaConsumer.KeyFile = ""
+	c.KafkaConsumer.SaslSupport = false
+	c.KafkaConsumer.SaslUsername = ""
+	c.KafkaConsumer.SaslPassword = ""
+	c.KafkaConsumer.SaslMechanism = SASLMechanismPlain
+	c.KafkaConsumer.Mode = ModeFlatJSON
+	c.KafkaConsumer.ConnectTimeout = 5
+	c.KafkaConsumer.Topic = "dnscollector"
+	c.KafkaConsumer.Partition = 0
+	c.KafkaConsumer.GroupID = "dnscollector"
+	c.KafkaConsumer.CommitInterval = 1
+	c.KafkaConsumer.ChannelBufferSize = 65535
+
+	c.JSONListener.Enable = false
+	c.JSONListener.ListenIP = AnyIP
+	c.JSONListener.ListenPort = 6002
+	c.JSONListener.SockPath = ""
+	c.JSONListener.Transport = netlib.SocketTCP
+	c.JSONListener.TLSMinVersion = TLSV12
+	c.JSONListener.CertFile = ""
+	c.JSONListener.KeyFile = ""
+	c.JSONListener.Mode = ModeJSON
+	c.JSONListener.MaxLineSize = 1048576
+	c.JSONListener.ResetConn = true
+	c.JSONListener.ChannelBufferSize = 65535
 }
 
 func (c *ConfigCollectors) GetTags() (ret []string) {
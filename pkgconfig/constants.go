@@ -31,6 +31,7 @@ const (
 	ModeFlatJSON = "flat-json"
 	ModePCAP     = "pcap"
 	ModeDNSTap   = "dnstap"
+	ModeParquet  = "parquet"
 
 	SASLMechanismPlain = "PLAIN"
 	SASLMechanismScram = "SCRAM-SHA-512"
@@ -45,6 +46,13 @@ const (
 	CompressLz4    = "lz4"
 	CompressZstd   = "ztd"
 	CompressNone   = "none"
+
+	OTLPTransportGRPC = "grpc"
+	OTLPTransportHTTP = "http"
+
+	DeliveryDrop         = "drop"
+	DeliveryBlock        = "block"
+	DeliveryBlockTimeout = "block-timeout"
 )
 
 var (
@@ -12,6 +12,30 @@ type ConfigGlobal struct {
 		MaxBackups   int    `yaml:"max-backups"`
 	} `yaml:"trace"`
 	ServerIdentity string `yaml:"server-identity"`
+	Admin          struct {
+		Enable         bool   `yaml:"enable"`
+		ListenIP       string `yaml:"listen-ip"`
+		ListenPort     int    `yaml:"listen-port"`
+		BasicAuthLogin string `yaml:"basic-auth-login"`
+		BasicAuthPwd   string `yaml:"basic-auth-pwd"`
+		TLSSupport     bool   `yaml:"tls-support"`
+		TLSMinVersion  string `yaml:"tls-min-version"`
+		CertFile       string `yaml:"cert-file"`
+		KeyFile        string `yaml:"key-file"`
+	} `yaml:"admin"`
+	Telemetry struct {
+		Enable           bool   `yaml:"enable"`
+		ListenIP         string `yaml:"listen-ip"`
+		ListenPort       int    `yaml:"listen-port"`
+		WebPath          string `yaml:"web-path"`
+		BasicAuthEnabled bool   `yaml:"basic-auth-enable"`
+		BasicAuthLogin   string `yaml:"basic-auth-login"`
+		BasicAuthPwd     string `yaml:"basic-auth-pwd"`
+		TLSSupport       bool   `yaml:"tls-support"`
+		TLSMinVersion    string `yaml:"tls-min-version"`
+		CertFile         string `yaml:"cert-file"`
+		KeyFile          string `yaml:"key-file"`
+	} `yaml:"telemetry"`
 }
 
 func (c *ConfigGlobal) SetDefault() {
@@ -26,4 +50,26 @@ func (c *ConfigGlobal) SetDefault() {
 	c.Trace.MaxSize = 10
 	c.Trace.MaxBackups = 10
 	c.ServerIdentity = ""
+
+	c.Admin.Enable = false
+	c.Admin.ListenIP = LocalhostIP
+	c.Admin.ListenPort = 8090
+	c.Admin.BasicAuthLogin = "admin"
+	c.Admin.BasicAuthPwd = "changeme"
+	c.Admin.TLSSupport = false
+	c.Admin.TLSMinVersion = TLSV12
+	c.Admin.CertFile = ""
+	c.Admin.KeyFile = ""
+
+	c.Telemetry.Enable = false
+	c.Telemetry.ListenIP = LocalhostIP
+	c.Telemetry.ListenPort = 9165
+	c.Telemetry.WebPath = "/metrics"
+	c.Telemetry.BasicAuthEnabled = false
+	c.Telemetry.BasicAuthLogin = "admin"
+	c.Telemetry.BasicAuthPwd = "changeme"
+	c.Telemetry.TLSSupport = false
+	c.Telemetry.TLSMinVersion = TLSV12
+	c.Telemetry.CertFile = ""
+	c.Telemetry.KeyFile = ""
 }
@@ -7,6 +7,22 @@ import (
 	"github.com/prometheus/prometheus/model/relabel"
 )
 
+// ConfigSpool is the disk spool of the network loggers, used to keep
+// the DNS messages during the outages of the remote
+type ConfigSpool struct {
+	Enable      bool   `yaml:"enable"`
+	Directory   string `yaml:"directory"`
+	MaxSize     int    `yaml:"max-size"`
+	SegmentSize int    `yaml:"segment-size"`
+}
+
+func (c *ConfigSpool) SetDefault() {
+	c.Enable = false
+	c.Directory = "/var/lib/dnscollector/spool"
+	c.MaxSize = 1024
+	c.SegmentSize = 16
+}
+
 type ConfigLoggers struct {
 	Stdout struct {
 		Enable            bool   `yaml:"enable"`
@@ -66,8 +82,11 @@ type ConfigLoggers struct {
 	LogFile struct {
 		Enable              bool   `yaml:"enable"`
 		FilePath            string `yaml:"file-path"`
+		FileTemplate        string `yaml:"file-template"`
+		MaxOpenFiles        int    `yaml:"max-open-files"`
 		MaxSize             int    `yaml:"max-size"`
 		MaxFiles            int    `yaml:"max-files"`
+		RotateInterval      int    `yaml:"rotate-interval"`
 		FlushInterval       int    `yaml:"flush-interval"`
 		Compress            bool   `yaml:"compress"`
 		CompressInterval    int    `yaml:"compress-interval"`
@@ -80,46 +99,48 @@ type ConfigLoggers struct {
 		ExtendedSupport     bool   `yaml:"extended-support"`
 	} `yaml:"logfile"`
 	DNSTap struct {
-		Enable            bool   `yaml:"enable"`
-		RemoteAddress     string `yaml:"remote-address"`
-		RemotePort        int    `yaml:"remote-port"`
-		Transport         string `yaml:"transport"`
-		SockPath          string `yaml:"sock-path"`
-		ConnectTimeout    int    `yaml:"connect-timeout"`
-		RetryInterval     int    `yaml:"retry-interval"`
-		FlushInterval     int    `yaml:"flush-interval"`
-		TLSSupport        bool   `yaml:"tls-support"`
-		TLSInsecure       bool   `yaml:"tls-insecure"`
-		TLSMinVersion     string `yaml:"tls-min-version"`
-		CAFile            string `yaml:"ca-file"`
-		CertFile          string `yaml:"cert-file"`
-		KeyFile           string `yaml:"key-file"`
-		ServerID          string `yaml:"server-id"`
-		OverwriteIdentity bool   `yaml:"overwrite-identity"`
-		BufferSize        int    `yaml:"buffer-size"`
-		ChannelBufferSize int    `yaml:"chan-buffer-size"`
-		ExtendedSupport   bool   `yaml:"extended-support"`
+		Enable            bool        `yaml:"enable"`
+		RemoteAddress     string      `yaml:"remote-address"`
+		RemotePort        int         `yaml:"remote-port"`
+		Transport         string      `yaml:"transport"`
+		SockPath          string      `yaml:"sock-path"`
+		ConnectTimeout    int         `yaml:"connect-timeout"`
+		RetryInterval     int         `yaml:"retry-interval"`
+		FlushInterval     int         `yaml:"flush-interval"`
+		TLSSupport        bool        `yaml:"tls-support"`
+		TLSInsecure       bool        `yaml:"tls-insecure"`
+		TLSMinVersion     string      `yaml:"tls-min-version"`
+		CAFile            string      `yaml:"ca-file"`
+		CertFile          string      `yaml:"cert-file"`
+		KeyFile           string      `yaml:"key-file"`
+		ServerID          string      `yaml:"server-id"`
+		OverwriteIdentity bool        `yaml:"overwrite-identity"`
+		BufferSize        int         `yaml:"buffer-size"`
+		ChannelBufferSize int         `yaml:"chan-buffer-size"`
+		ExtendedSupport   bool        `yaml:"extended-support"`
+		Spool             ConfigSpool `yaml:"spool"`
 	} `yaml:"dnstapclient"`
 	TCPClient struct {
-		Enable            bool   `yaml:"enable"`
-		RemoteAddress     string `yaml:"remote-address"`
-		RemotePort        int    `yaml:"remote-port"`
-		SockPath          string `yaml:"sock-path"` // deprecated
-		RetryInterval     int    `yaml:"retry-interval"`
-		Transport         string `yaml:"transport"`
-		TLSSupport        bool   `yaml:"tls-support"` // deprecated
-		TLSInsecure       bool   `yaml:"tls-insecure"`
-		TLSMinVersion     string `yaml:"tls-min-version"`
-		CAFile            string `yaml:"ca-file"`
-		CertFile          string `yaml:"cert-file"`
-		KeyFile           string `yaml:"key-file"`
-		Mode              string `yaml:"mode"`
-		TextFormat        string `yaml:"text-format"`
-		PayloadDelimiter  string `yaml:"delimiter"`
-		BufferSize        int    `yaml:"buffer-size"`
-		FlushInterval     int    `yaml:"flush-interval"`
-		ConnectTimeout    int    `yaml:"connect-timeout"`
-		ChannelBufferSize int    `yaml:"chan-buffer-size"`
+		Enable            bool        `yaml:"enable"`
+		RemoteAddress     string      `yaml:"remote-address"`
+		RemotePort        int         `yaml:"remote-port"`
+		SockPath          string      `yaml:"sock-path"` // deprecated
+		RetryInterval     int         `yaml:"retry-interval"`
+		Transport         string      `yaml:"transport"`
+		TLSSupport        bool        `yaml:"tls-support"` // deprecated
+		TLSInsecure       bool        `yaml:"tls-insecure"`
+		TLSMinVersion     string      `yaml:"tls-min-version"`
+		CAFile            string      `yaml:"ca-file"`
+		CertFile          string      `yaml:"cert-file"`
+		KeyFile           string      `yaml:"key-file"`
+		Mode              string      `yaml:"mode"`
+		TextFormat        string      `yaml:"text-format"`
+		PayloadDelimiter  string      `yaml:"delimiter"`
+		BufferSize        int         `yaml:"buffer-size"`
+		FlushInterval     int         `yaml:"flush-interval"`
+		ConnectTimeout    int         `yaml:"connect-timeout"`
+		ChannelBufferSize int         `yaml:"chan-buffer-size"`
+		Spool             ConfigSpool `yaml:"spool"`
 	} `yaml:"tcpclient"`
 	Syslog struct {
 		Enable            bool   `yaml:"enable"`
@@ -146,23 +167,24 @@ type ConfigLoggers struct {
 		BufferSize        int    `yaml:"buffer-size"`
 	} `yaml:"syslog"`
 	Fluentd struct {
-		Enable            bool   `yaml:"enable"`
-		RemoteAddress     string `yaml:"remote-address"`
-		RemotePort        int    `yaml:"remote-port"`
-		SockPath          string `yaml:"sock-path"` // deprecated
-		ConnectTimeout    int    `yaml:"connect-timeout"`
-		RetryInterval     int    `yaml:"retry-interval"`
-		FlushInterval     int    `yaml:"flush-interval"`
-		Transport         string `yaml:"transport"`
-		TLSSupport        bool   `yaml:"tls-support"` // deprecated
-		TLSInsecure       bool   `yaml:"tls-insecure"`
-		TLSMinVersion     string `yaml:"tls-min-version"`
-		CAFile            string `yaml:"ca-file"`
-		CertFile          string `yaml:"cert-file"`
-		KeyFile           string `yaml:"key-file"`
-		Tag               string `yaml:"tag"`
-		BufferSize        int    `yaml:"buffer-size"`
-		ChannelBufferSize int    `yaml:"chan-buffer-size"`
+		Enable            bool        `yaml:"enable"`
+		RemoteAddress     string      `yaml:"remote-address"`
+		RemotePort        int         `yaml:"remote-port"`
+		SockPath          string      `yaml:"sock-path"` // deprecated
+		ConnectTimeout    int         `yaml:"connect-timeout"`
+		RetryInterval     int         `yaml:"retry-interval"`
+		FlushInterval     int         `yaml:"flush-interval"`
+		Transport         string      `yaml:"transport"`
+		TLSSupport        bool        `yaml:"tls-support"` // deprecated
+		TLSInsecure       bool        `yaml:"tls-insecure"`
+		TLSMinVersion     string      `yaml:"tls-min-version"`
+		CAFile            string      `yaml:"ca-file"`
+		CertFile          string      `yaml:"cert-file"`
+		KeyFile           string      `yaml:"key-file"`
+		Tag               string      `yaml:"tag"`
+		BufferSize        int         `yaml:"buffer-size"`
+		ChannelBufferSize int         `yaml:"chan-buffer-size"`
+		Spool             ConfigSpool `yaml:"spool"`
 	} `yaml:"fluentd"`
 	InfluxDB struct {
 		Enable            bool   `yaml:"enable"`
@@ -243,56 +265,138 @@ type ConfigLoggers struct {
 		ChannelBufferSize int                    `yaml:"chan-buffer-size"`
 	} `yaml:"scalyrclient"`
 	RedisPub struct {
+		Enable            bool        `yaml:"enable"`
+		RemoteAddress     string      `yaml:"remote-address"`
+		RemotePort        int         `yaml:"remote-port"`
+		SockPath          string      `yaml:"sock-path"` // deprecated
+		RetryInterval     int         `yaml:"retry-interval"`
+		Transport         string      `yaml:"transport"`
+		TLSSupport        bool        `yaml:"tls-support"` // deprecated
+		TLSInsecure       bool        `yaml:"tls-insecure"`
+		TLSMinVersion     string      `yaml:"tls-min-version"`
+		CAFile            string      `yaml:"ca-file"`
+		CertFile          string      `yaml:"cert-file"`
+		KeyFile           string      `yaml:"key-file"`
+		Mode              string      `yaml:"mode"`
+		TextFormat        string      `yaml:"text-format"`
+		PayloadDelimiter  string      `yaml:"delimiter"`
+		BufferSize        int         `yaml:"buffer-size"`
+		FlushInterval     int         `yaml:"flush-interval"`
+		ConnectTimeout    int         `yaml:"connect-timeout"`
+		RedisChannel      string      `yaml:"redis-channel"`
+		ChannelBufferSize int         `yaml:"chan-buffer-size"`
+		Spool             ConfigSpool `yaml:"spool"`
+	} `yaml:"redispub"`
+	KafkaProducer struct {
+		Enable            bool        `yaml:"enable"`
+		RemoteAddress     string      `yaml:"remote-address"`
+		RemotePort        int         `yaml:"remote-port"`
+		RetryInterval     int         `yaml:"retry-interval"`
+		TLSSupport        bool        `yaml:"tls-support"`
+		TLSInsecure       bool        `yaml:"tls-insecure"`
+		TLSMinVersion     string      `yaml:"tls-min-version"`
+		CAFile            string      `yaml:"ca-file"`
+		CertFile          string      `yaml:"cert-file"`
+		KeyFile           string      `yaml:"key-file"`
+		SaslSupport       bool        `yaml:"sasl-support"`
+		SaslUsername      string      `yaml:"sasl-username"`
+		SaslPassword      string      `yaml:"sasl-password"`
+		SaslMechanism     string      `yaml:"sasl-mechanism"`
+		Mode              string      `yaml:"mode"`
+		BufferSize        int         `yaml:"buffer-size"`
+		FlushInterval     int         `yaml:"flush-interval"`
+		ConnectTimeout    int         `yaml:"connect-timeout"`
+		Topic             string      `yaml:"topic"`
+		Partition         int         `yaml:"partition"`
+		ChannelBufferSize int         `yaml:"chan-buffer-size"`
+		Compression       string      `yaml:"compression"`
+		Spool             ConfigSpool `yaml:"spool"`
+	} `yaml:"kafkaproducer"`
+	FalcoClient struct {
 		Enable            bool   `yaml:"enable"`
-		RemoteAddress     string `yaml:"remote-address"`
-		RemotePort        int    `yaml:"remote-port"`
-		SockPath          string `yaml:"sock-path"` // deprecated
-		RetryInterval     int    `yaml:"retry-interval"`
-		Transport         string `yaml:"transport"`
-		TLSSupport        bool   `yaml:"tls-support"` // deprecated
+		URL               string `yaml:"url"`
+		ChannelBufferSize int    `yaml:"chan-buffer-size"`
+	} `yaml:"falco"`
+	ClickhouseClient struct {
+		Enable            bool   `yaml:"enable"`
+		URL               string `yaml:"url"`
+		User              string `yaml:"user"`
+		Password          string `yaml:"password"`
+		Database          string `yaml:"database"`
+		Table             string `yaml:"table"`
+		CreateTable       bool   `yaml:"create-table"`
+		TableEngine       string `yaml:"table-engine"`
+		BatchSize         int    `yaml:"batch-size"`
+		FlushInterval     int    `yaml:"flush-interval"`
+		Timeout           int    `yaml:"timeout"`
+		MaxRetries        int    `yaml:"max-retries"`
+		MinBackoff        int    `yaml:"min-backoff"`
+		MaxBackoff        int    `yaml:"max-backoff"`
 		TLSInsecure       bool   `yaml:"tls-insecure"`
 		TLSMinVersion     string `yaml:"tls-min-version"`
 		CAFile            string `yaml:"ca-file"`
 		CertFile          string `yaml:"cert-file"`
 		KeyFile           string `yaml:"key-file"`
-		Mode              string `yaml:"mode"`
-		TextFormat        string `yaml:"text-format"`
-		PayloadDelimiter  string `yaml:"delimiter"`
-		BufferSize        int    `yaml:"buffer-size"`
-		FlushInterval     int    `yaml:"flush-interval"`
-		ConnectTimeout    int    `yaml:"connect-timeout"`
-		RedisChannel      string `yaml:"redis-channel"`
 		ChannelBufferSize int    `yaml:"chan-buffer-size"`
-	} `yaml:"redispub"`
-	KafkaProducer struct {
+	} `yaml:"clickhouse"`
+	OTLPClient struct {
+		Enable            bool     `yaml:"enable"`
+		Transport         string   `yaml:"transport"`
+		Endpoint          string   `yaml:"endpoint"`
+		Headers           []string `yaml:"headers"`
+		ServiceName       string   `yaml:"service-name"`
+		LogsEnabled       bool     `yaml:"logs-enabled"`
+		MetricsEnabled    bool     `yaml:"metrics-enabled"`
+		TextFormat        string   `yaml:"text-format"`
+		BatchSize         int      `yaml:"batch-size"`
+		FlushInterval     int      `yaml:"flush-interval"`
+		MetricsInterval   int      `yaml:"metrics-interval"`
+		MetricsPrefix     string   `yaml:"metrics-prefix"`
+		TopN              int      `yaml:"top-n"`
+		Timeout           int      `yaml:"timeout"`
+		TLSSupport        bool     `yaml:"tls-support"`
+		TLSInsecure       bool     `yaml:"tls-insecure"`
+		TLSMinVersion     string   `yaml:"tls-min-version"`
+		CAFile            string   `yaml:"ca-file"`
+		CertFile          string   `yaml:"cert-file"`
+		KeyFile           string   `yaml:"key-file"`
+		ChannelBufferSize int      `yaml:"chan-buffer-size"`
+	} `yaml:"otlp"`
+	PowerDNSClient struct {
+		Enable            bool        `yaml:"enable"`
+		RemoteAddress     string      `yaml:"remote-address"`
+		RemotePort        int         `yaml:"remote-port"`
+		Transport         string      `yaml:"transport"`
+		ConnectTimeout    int         `yaml:"connect-timeout"`
+		RetryInterval     int         `yaml:"retry-interval"`
+		FlushInterval     int         `yaml:"flush-interval"`
+		TLSInsecure       bool        `yaml:"tls-insecure"`
+		TLSMinVersion     string      `yaml:"tls-min-version"`
+		CAFile            string      `yaml:"ca-file"`
+		CertFile          string      `yaml:"cert-file"`
+		KeyFile           string      `yaml:"key-file"`
+		ServerID          string      `yaml:"server-id"`
+		OverwriteIdentity bool        `yaml:"overwrite-identity"`
+		BufferSize        int         `yaml:"buffer-size"`
+		ChannelBufferSize int         `yaml:"chan-buffer-size"`
+		Spool             ConfigSpool `yaml:"spool"`
+	} `yaml:"powerdnsclient"`
+	PassiveDNS struct {
 		Enable            bool   `yaml:"enable"`
-		RemoteAddress     string `yaml:"remote-address"`
-		RemotePort        int    `yaml:"remote-port"`
-		RetryInterval     int    `yaml:"retry-interval"`
+		ListenIP          string `yaml:"listen-ip"`
+		ListenPort        int    `yaml:"listen-port"`
+		BasicAuthLogin    string `yaml:"basic-auth-login"`
+		BasicAuthPwd      string `yaml:"basic-auth-pwd"`
 		TLSSupport        bool   `yaml:"tls-support"`
-		TLSInsecure       bool   `yaml:"tls-insecure"`
 		TLSMinVersion     string `yaml:"tls-min-version"`
-		CAFile            string `yaml:"ca-file"`
 		CertFile          string `yaml:"cert-file"`
 		KeyFile           string `yaml:"key-file"`
-		SaslSupport       bool   `yaml:"sasl-support"`
-		SaslUsername      string `yaml:"sasl-username"`
-		SaslPassword      string `yaml:"sasl-password"`
-		SaslMechanism     string `yaml:"sasl-mechanism"`
-		Mode              string `yaml:"mode"`
-		BufferSize        int    `yaml:"buffer-size"`
+		DBFile            string `yaml:"db-file"`
 		FlushInterval     int    `yaml:"flush-interval"`
-		ConnectTimeout    int    `yaml:"connect-timeout"`
-		Topic             string `yaml:"topic"`
-		Partition         int    `yaml:"partition"`
-		ChannelBufferSize int    `yaml:"chan-buffer-size"`
-		Compression       string `yaml:"compression"`
-	} `yaml:"kafkaproducer"`
-	FalcoClient struct {
-		Enable            bool   `yaml:"enable"`
-		URL               string `yaml:"url"`
+		MaxResults        int    `yaml:"max-results"`
+		MaxPending        int    `yaml:"max-pending"`
 		ChannelBufferSize int    `yaml:"chan-buffer-size"`
-	} `yaml:"falco"`
+	} `yaml:"passivedns"`
 }
 
 func (c *ConfigLoggers) SetDefault() {
@@ -319,13 +423,17 @@ func (c *ConfigLoggers) SetDefault() {
 	c.DNSTap.OverwriteIdentity = false
 	c.DNSTap.BufferSize = 100
 	c.DNSTap.ChannelBufferSize = 65535
+	c.DNSTap.Spool.SetDefault()
 	c.DNSTap.ExtendedSupport = false
 
 	c.LogFile.Enable = false
 	c.LogFile.FilePath = ""
+	c.LogFile.FileTemplate = ""
+	c.LogFile.MaxOpenFiles = 256
 	c.LogFile.FlushInterval = 10
 	c.LogFile.MaxSize = 100
 	c.LogFile.MaxFiles = 10
+	c.LogFile.RotateInterval = 0
 	c.LogFile.Compress = false
 	c.LogFile.CompressInterval = 60
 	c.LogFile.CompressPostCommand = ""
@@ -401,6 +509,7 @@ func (c *ConfigLoggers) SetDefault() {
 	c.TCPClient.ConnectTimeout = 5
 	c.TCPClient.FlushInterval = 30
 	c.TCPClient.ChannelBufferSize = 65535
+	c.TCPClient.Spool.SetDefault()
 
 	c.Syslog.Enable = false
 	c.Syslog.Severity = "INFO"
@@ -442,6 +551,7 @@ func (c *ConfigLoggers) SetDefault() {
 	c.Fluentd.Tag = "dns.collector"
 	c.Fluentd.BufferSize = 100
 	c.Fluentd.ChannelBufferSize = 65535
+	c.Fluentd.Spool.SetDefault()
 
 	c.InfluxDB.Enable = false
 	c.InfluxDB.ServerURL = "http://localhost:8086"
@@ -518,6 +628,7 @@ func (c *ConfigLoggers) SetDefault() {
 	c.RedisPub.FlushInterval = 30
 	c.RedisPub.RedisChannel = "dns_collector"
 	c.RedisPub.ChannelBufferSize = 65535
+	c.RedisPub.Spool.SetDefault()
 
 	c.KafkaProducer.Enable = false
 	c.KafkaProducer.RemoteAddress = LocalhostIP
@@ -540,11 +651,88 @@ func (c *ConfigLoggers) SetDefault() {
 	c.KafkaProducer.Topic = "dnscollector"
 	c.KafkaProducer.Partition = 0
 	c.KafkaProducer.ChannelBufferSize = 65535
+	c.KafkaProducer.Spool.SetDefault()
 	c.KafkaProducer.Compression = CompressNone
 
 	c.FalcoClient.Enable = false
 	c.FalcoClient.URL = "http://127.0.0.1:9200"
 	c.FalcoClient.ChannelBufferSize = 65535
+
+	c.ClickhouseClient.Enable = false
+	c.ClickhouseClient.URL = "http://127.0.0.1:8123"
+	c.ClickhouseClient.User = "default"
+	c.ClickhouseClient.Password = ""
+	c.ClickhouseClient.Database = "default"
+	c.ClickhouseClient.Table = "dnscollector"
+	c.ClickhouseClient.CreateTable = false
+	c.ClickhouseClient.TableEngine = "MergeTree ORDER BY tuple()"
+	c.ClickhouseClient.BatchSize = 1000
+	c.ClickhouseClient.FlushInterval = 10
+	c.ClickhouseClient.Timeout = 10
+	c.ClickhouseClient.MaxRetries = 5
+	c.ClickhouseClient.MinBackoff = 1
+	c.ClickhouseClient.MaxBackoff = 60
+	c.ClickhouseClient.TLSInsecure = false
+	c.ClickhouseClient.TLSMinVersion = TLSV12
+	c.ClickhouseClient.CAFile = ""
+	c.ClickhouseClient.CertFile = ""
+	c.ClickhouseClient.KeyFile = ""
+	c.ClickhouseClient.ChannelBufferSize = 65535
+
+	c.OTLPClient.Enable = false
+	c.OTLPClient.Transport = OTLPTransportGRPC
+	c.OTLPClient.Endpoint = "127.0.0.1:4317"
+	c.OTLPClient.Headers = []string{}
+	c.OTLPClient.ServiceName = ProgName
+	c.OTLPClient.LogsEnabled = true
+	c.OTLPClient.MetricsEnabled = true
+	c.OTLPClient.TextFormat = ""
+	c.OTLPClient.BatchSize = 100
+	c.OTLPClient.FlushInterval = 10
+	c.OTLPClient.MetricsInterval = 10
+	c.OTLPClient.MetricsPrefix = ProgName
+	c.OTLPClient.TopN = 10
+	c.OTLPClient.Timeout = 10
+	c.OTLPClient.TLSSupport = false
+	c.OTLPClient.TLSInsecure = false
+	c.OTLPClient.TLSMinVersion = TLSV12
+	c.OTLPClient.CAFile = ""
+	c.OTLPClient.CertFile = ""
+	c.OTLPClient.KeyFile = ""
+	c.OTLPClient.ChannelBufferSize = 65535
+
+	c.PowerDNSClient.Enable = false
+	c.PowerDNSClient.RemoteAddress = LocalhostIP
+	c.PowerDNSClient.RemotePort = 6001
+	c.PowerDNSClient.Transport = netlib.SocketTCP
+	c.PowerDNSClient.ConnectTimeout = 5
+	c.PowerDNSClient.RetryInterval = 10
+	c.PowerDNSClient.FlushInterval = 30
+	c.PowerDNSClient.TLSInsecure = false
+	c.PowerDNSClient.TLSMinVersion = TLSV12
+	c.PowerDNSClient.CAFile = ""
+	c.PowerDNSClient.CertFile = ""
+	c.PowerDNSClient.KeyFile = ""
+	c.PowerDNSClient.ServerID = ""
+	c.PowerDNSClient.OverwriteIdentity = false
+	c.PowerDNSClient.BufferSize = 100
+	c.PowerDNSClient.ChannelBufferSize = 65535
+	c.PowerDNSClient.Spool.SetDefault()
+
+	c.PassiveDNS.Enable = false
+	c.PassiveDNS.ListenIP = LocalhostIP
+	c.PassiveDNS.ListenPort = 8082
+	c.PassiveDNS.BasicAuthLogin = "admin"
+	c.PassiveDNS.BasicAuthPwd = "changeme"
+	c.PassiveDNS.TLSSupport = false
+	c.PassiveDNS.TLSMinVersion = TLSV12
+	c.PassiveDNS.CertFile = ""
+	c.PassiveDNS.KeyFile = ""
+	c.PassiveDNS.DBFile = "/var/lib/dnscollector/passivedns.db"
+	c.PassiveDNS.FlushInterval = 10
+	c.PassiveDNS.MaxResults = 1000
+	c.PassiveDNS.MaxPending = 100000
+	c.PassiveDNS.ChannelBufferSize = 65535
 }
 
 func (c *ConfigLoggers) GetTags() (ret []string) {
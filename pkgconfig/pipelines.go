@@ -8,6 +8,26 @@ type ConfigPipelines struct {
 }
 
 type PipelinesRouting struct {
-	Default []string `yaml:"default,flow"`
-	Dropped []string `yaml:"dropped,flow"`
+	Default  []string               `yaml:"default,flow"`
+	Dropped  []string               `yaml:"dropped,flow"`
+	Rules    []PipelinesRoutingRule `yaml:"rules"`
+	Delivery []PipelinesDelivery    `yaml:"delivery"`
+}
+
+// PipelinesDelivery is the behaviour of the routes when the channel of the next stanza is full:
+// drop the message, wait, or wait until the timeout (in seconds) then drop.
+type PipelinesDelivery struct {
+	Routes  []string `yaml:"routes,flow"`
+	Mode    string   `yaml:"mode"`
+	Timeout int      `yaml:"timeout"`
+}
+
+// PipelinesRoutingRule sends the messages matching the include/exclude conditions
+// to the routes of the rule, instead of the default ones.
+type PipelinesRoutingRule struct {
+	Matching struct {
+		Include map[string]interface{} `yaml:"include"`
+		Exclude map[string]interface{} `yaml:"exclude"`
+	} `yaml:"matching"`
+	Routes []string `yaml:"routes,flow"`
 }
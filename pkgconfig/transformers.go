@@ -1,5 +1,14 @@
 package pkgconfig
 
+// ConfigIntelFeed is a threat intel feed loaded by the intel transformer,
+// from a local file or from an url
+type ConfigIntelFeed struct {
+	Name     string `yaml:"name"`
+	Source   string `yaml:"source"`
+	Format   string `yaml:"format"`
+	Category string `yaml:"category"`
+}
+
 type ConfigTransformers struct {
 	UserPrivacy struct {
 		Enable            bool   `yaml:"enable"`
@@ -23,6 +32,10 @@ type ConfigTransformers struct {
 		UnansweredQueries bool `yaml:"unanswered-queries"`
 		QueriesTimeout    int  `yaml:"queries-timeout"`
 	} `yaml:"latency"`
+	Transaction struct {
+		Enable         bool `yaml:"enable"`
+		QueriesTimeout int  `yaml:"queries-timeout"`
+	} `yaml:"transaction"`
 	Reducer struct {
 		Enable                    bool `yaml:"enable"`
 		RepetitiveTrafficDetector bool `yaml:"repetitive-traffic-detector"`
@@ -42,6 +55,7 @@ type ConfigTransformers struct {
 		LogQueries      bool     `yaml:"log-queries"`
 		LogReplies      bool     `yaml:"log-replies"`
 		Downsample      int      `yaml:"downsample"`
+		Expression      string   `yaml:"expression"`
 	} `yaml:"filtering"`
 	GeoIP struct {
 		Enable        bool   `yaml:"enable"`
@@ -71,6 +85,32 @@ type ConfigTransformers struct {
 		Enable bool     `yaml:"enable"`
 		Tags   []string `yaml:"tags,flow"`
 	} `yaml:"atags"`
+	Tunneling struct {
+		Enable                    bool     `yaml:"enable"`
+		Window                    int      `yaml:"window"`
+		MaxEntries                int      `yaml:"max-entries"`
+		MinQueries                int      `yaml:"min-queries"`
+		ThresholdUniqueSubdomains int      `yaml:"threshold-unique-subdomains"`
+		ThresholdQnameBytes       int      `yaml:"threshold-qname-bytes"`
+		ThresholdTxtNullRatio     float64  `yaml:"threshold-txt-null-ratio"`
+		ThresholdEntropy          float64  `yaml:"threshold-entropy"`
+		WhitelistDomains          []string `yaml:"whitelist-domains,flow"`
+		ModelFile                 string   `yaml:"model-file"`
+	} `yaml:"tunneling"`
+	Intel struct {
+		Enable          bool              `yaml:"enable"`
+		RefreshInterval int               `yaml:"refresh-interval"`
+		Feeds           []ConfigIntelFeed `yaml:"feeds"`
+	} `yaml:"intel"`
+	Hostnames struct {
+		Enable          bool     `yaml:"enable"`
+		MaxEntries      int      `yaml:"max-entries"`
+		MinTTL          int      `yaml:"min-ttl"`
+		MaxTTL          int      `yaml:"max-ttl"`
+		HostsFiles      []string `yaml:"hosts-files,flow"`
+		LeasesFiles     []string `yaml:"leases-files,flow"`
+		RefreshInterval int      `yaml:"refresh-interval"`
+	} `yaml:"hostnames"`
 }
 
 func (c *ConfigTransformers) SetDefault() {
@@ -103,6 +143,9 @@ func (c *ConfigTransformers) SetDefault() {
 	c.Latency.UnansweredQueries = false
 	c.Latency.QueriesTimeout = 2
 
+	c.Transaction.Enable = false
+	c.Transaction.QueriesTimeout = 2
+
 	c.Reducer.Enable = false
 	c.Reducer.RepetitiveTrafficDetector = false
 	c.Reducer.QnamePlusOne = false
@@ -118,6 +161,7 @@ func (c *ConfigTransformers) SetDefault() {
 	c.Filtering.LogQueries = true
 	c.Filtering.LogReplies = true
 	c.Filtering.Downsample = 0
+	c.Filtering.Expression = ""
 
 	c.GeoIP.Enable = false
 	c.GeoIP.DBCountryFile = ""
@@ -132,6 +176,29 @@ func (c *ConfigTransformers) SetDefault() {
 
 	c.ATags.Enable = false
 	c.ATags.Tags = []string{}
+
+	c.Tunneling.Enable = false
+	c.Tunneling.Window = 60
+	c.Tunneling.MaxEntries = 65535
+	c.Tunneling.MinQueries = 10
+	c.Tunneling.ThresholdUniqueSubdomains = 50
+	c.Tunneling.ThresholdQnameBytes = 5000
+	c.Tunneling.ThresholdTxtNullRatio = 0.5
+	c.Tunneling.ThresholdEntropy = 3.5
+	c.Tunneling.WhitelistDomains = []string{"\\.in-addr\\.arpa$", "\\.ip6\\.arpa$"}
+	c.Tunneling.ModelFile = ""
+
+	c.Intel.Enable = false
+	c.Intel.RefreshInterval = 3600
+	c.Intel.Feeds = []ConfigIntelFeed{}
+
+	c.Hostnames.Enable = false
+	c.Hostnames.MaxEntries = 65535
+	c.Hostnames.MinTTL = 60
+	c.Hostnames.MaxTTL = 86400
+	c.Hostnames.HostsFiles = []string{}
+	c.Hostnames.LeasesFiles = []string{}
+	c.Hostnames.RefreshInterval = 60
 }
 
 func GetFakeConfigTransformers() *ConfigTransformers {
@@ -0,0 +1,418 @@
+package pkglinker
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-logger"
+)
+
+const (
+	StanzaCollector = "collector"
+	StanzaLogger    = "logger"
+)
+
+var ErrStanzaNotFound = errors.New("stanza not found")
+
+type StanzaChannel struct {
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+}
+
+// StanzaInfo is the state of a running collector or logger returned by the admin api
+type StanzaInfo struct {
+	Name    string              `json:"name"`
+	Role    string              `json:"role"`
+	Type    string              `json:"type"`
+	Routes  map[string][]string `json:"routes"`
+	Channel StanzaChannel       `json:"channel"`
+	Dropped map[string]uint64   `json:"dropped"`
+}
+
+// AdminAPI is the http server used to monitor and control the running collectors and loggers.
+// The maps of workers are only updated by the reload, with the lock held.
+type AdminAPI struct {
+	sync.RWMutex
+	config        *pkgconfig.Config
+	configPath    string
+	dmRef         dnsutils.DNSMessage
+	logger        *logger.Logger
+	mapLoggers    map[string]pkgutils.Worker
+	mapCollectors map[string]pkgutils.Worker
+	mapLinks      map[string]*PipelineLinks
+	reloadFunc    func() error
+	ready         atomic.Bool
+	serverMutex   sync.Mutex
+	httpserver    net.Listener
+	doneAPI       chan bool
+}
+
+// NewAdminAPI creates the admin api, reloadFunc is the reload of the whole config also called on SIGHUP
+func NewAdminAPI(config *pkgconfig.Config, configPath string, dmRef dnsutils.DNSMessage,
+	mapLoggers map[string]pkgutils.Worker, mapCollectors map[string]pkgutils.Worker, mapLinks map[string]*PipelineLinks,
+	reloadFunc func() error, logger *logger.Logger) *AdminAPI {
+	return &AdminAPI{
+		config:        config,
+		configPath:    configPath,
+		dmRef:         dmRef,
+		logger:        logger,
+		mapLoggers:    mapLoggers,
+		mapCollectors: mapCollectors,
+		mapLinks:      mapLinks,
+		reloadFunc:    reloadFunc,
+		doneAPI:       make(chan bool),
+	}
+}
+
+func (a *AdminAPI) LogInfo(msg string, v ...interface{}) {
+	a.logger.Info(pkgutils.PrefixLogAdmin+msg, v...)
+}
+
+func (a *AdminAPI) LogError(msg string, v ...interface{}) {
+	a.logger.Error(pkgutils.PrefixLogAdmin+msg, v...)
+}
+
+// SetReady updates the state returned by the readiness probe
+func (a *AdminAPI) SetReady(ready bool) {
+	a.ready.Store(ready)
+}
+
+// Reload reloads the whole config, the admin api is not ready until the end of the reload
+func (a *AdminAPI) Reload() error {
+	a.Lock()
+	defer a.Unlock()
+
+	a.SetReady(false)
+	defer a.SetReady(true)
+	return a.reloadFunc()
+}
+
+// ReloadStanza reads the config file and pushes the new config to the stanza only,
+// the routes and the other stanzas are not updated.
+func (a *AdminAPI) ReloadStanza(name string) error {
+	a.RLock()
+	defer a.RUnlock()
+
+	worker := GetWorker(name, a.mapCollectors, a.mapLoggers)
+	if worker == nil {
+		return ErrStanzaNotFound
+	}
+
+	newConfig, err := pkgutils.LoadConfig(a.configPath, a.dmRef)
+	if err != nil {
+		return fmt.Errorf("config error: %v", err)
+	}
+
+	for _, stanza := range newConfig.Pipelines {
+		if stanza.Name != name {
+			continue
+		}
+		if links, ok := a.mapLinks[name]; ok && links.Kind != GetStanzaKind(newConfig, stanza) {
+			return fmt.Errorf("stanza=[%s] type has changed, full reload required", name)
+		}
+		a.LogInfo("reload stanza=[%s]", name)
+		worker.ReloadConfig(GetStanzaConfig(newConfig, stanza))
+		return nil
+	}
+
+	// multiplexer mode
+	for _, output := range newConfig.Multiplexer.Loggers {
+		if _, ok := a.mapLoggers[name]; ok && output.Name == name {
+			a.LogInfo("reload logger=[%s]", name)
+			worker.ReloadConfig(GetItemConfig("loggers", newConfig, output))
+			return nil
+		}
+	}
+	for _, input := range newConfig.Multiplexer.Collectors {
+		if _, ok := a.mapCollectors[name]; ok && input.Name == name {
+			a.LogInfo("reload collector=[%s]", name)
+			worker.ReloadConfig(GetItemConfig("collectors", newConfig, input))
+			return nil
+		}
+	}
+
+	return fmt.Errorf("stanza=[%s] removed from the config, full reload required", name)
+}
+
+// GetStanzaInfo returns the state of the stanza, the lock must be held
+func (a *AdminAPI) GetStanzaInfo(name string) (StanzaInfo, error) {
+	worker := GetWorker(name, a.mapCollectors, a.mapLoggers)
+	if worker == nil {
+		return StanzaInfo{}, ErrStanzaNotFound
+	}
+
+	info := StanzaInfo{
+		Name:    name,
+		Role:    StanzaLogger,
+		Routes:  make(map[string][]string),
+		Dropped: make(map[string]uint64),
+	}
+	if _, ok := a.mapCollectors[name]; ok {
+		info.Role = StanzaCollector
+	}
+	if c := worker.GetInputChannel(); c != nil {
+		info.Channel = StanzaChannel{Length: len(c), Capacity: cap(c)}
+	}
+
+	// pipelines mode, messages are dropped when the next stanzas are too slow
+	for _, stanza := range a.config.Pipelines {
+		if stanza.Name != name {
+			continue
+		}
+		info.Type = GetStanzaKind(a.config, stanza)
+		info.Routes[RoutingPolicyDefault] = GetRoutesNames(stanza, RoutingPolicyDefault)
+		info.Routes[RoutingPolicyDropped] = GetRoutesNames(stanza, RoutingPolicyDropped)
+		info.Dropped = pkgutils.Telemetry.GetDroppedCounters(name)
+	}
+
+	// multiplexer mode
+	items := a.config.Multiplexer.Loggers
+	if info.Role == StanzaCollector {
+		items = a.config.Multiplexer.Collectors
+	}
+	for _, item := range items {
+		if item.Name == name {
+			info.Type = GetItemKind(a.config, item)
+		}
+	}
+	if info.Role == StanzaCollector && IsMuxEnabled(a.config) {
+		routes := []string{}
+		for _, route := range a.config.Multiplexer.Routes {
+			for _, src := range route.Src {
+				if src == name {
+					routes = append(routes, route.Dst...)
+				}
+			}
+		}
+		info.Routes[RoutingPolicyDefault] = routes
+	}
+
+	return info, nil
+}
+
+// GetStanzasInfo returns the state of all stanzas sorted by name, the lock must be held
+func (a *AdminAPI) GetStanzasInfo() []StanzaInfo {
+	names := []string{}
+	for name := range a.mapCollectors {
+		names = append(names, name)
+	}
+	for name := range a.mapLoggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stanzas := []StanzaInfo{}
+	for _, name := range names {
+		if info, err := a.GetStanzaInfo(name); err == nil {
+			stanzas = append(stanzas, info)
+		}
+	}
+	return stanzas
+}
+
+func (a *AdminAPI) BasicAuth(w http.ResponseWriter, r *http.Request) bool {
+	a.RLock()
+	defer a.RUnlock()
+
+	login, password, authOK := r.BasicAuth()
+	if !authOK {
+		return false
+	}
+
+	return (login == a.config.Global.Admin.BasicAuthLogin) &&
+		(password == a.config.Global.Admin.BasicAuthPwd)
+}
+
+func (a *AdminAPI) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		a.LogError("json encoding error: %s", err)
+	}
+}
+
+func (a *AdminAPI) GetHealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK"))
+}
+
+func (a *AdminAPI) GetReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.ready.Load() {
+		http.Error(w, "Not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
+func (a *AdminAPI) GetStanzasHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.BasicAuth(w, r) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		a.RLock()
+		defer a.RUnlock()
+		a.writeJSON(w, a.GetStanzasInfo())
+	default:
+		http.Error(w, "{\"error\": \"Method not allowed\"}", http.StatusMethodNotAllowed)
+	}
+}
+
+// GetStanzaHandler serves /stanzas/<name> and /stanzas/<name>/reload
+func (a *AdminAPI) GetStanzaHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.BasicAuth(w, r) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/stanzas/"), "/")
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		a.RLock()
+		defer a.RUnlock()
+		info, err := a.GetStanzaInfo(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		a.writeJSON(w, info)
+
+	case action == "reload" && r.Method == http.MethodPost:
+		err := a.ReloadStanza(name)
+		if errors.Is(err, ErrStanzaNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			a.LogError("reload stanza=[%s] error: %s", name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("OK"))
+
+	case action == "" || action == "reload":
+		http.Error(w, "{\"error\": \"Method not allowed\"}", http.StatusMethodNotAllowed)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (a *AdminAPI) PostReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.BasicAuth(w, r) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		a.LogInfo("reload requested")
+		if err := a.Reload(); err != nil {
+			a.LogError("reload error: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("OK"))
+	default:
+		http.Error(w, "{\"error\": \"Method not allowed\"}", http.StatusMethodNotAllowed)
+	}
+}
+
+// Stop closes the listener and waits the end of the http server, nothing to do if not listening
+func (a *AdminAPI) Stop() {
+	a.LogInfo("stopping http server...")
+	a.SetReady(false)
+
+	a.serverMutex.Lock()
+	httpserver := a.httpserver
+	a.serverMutex.Unlock()
+	if httpserver == nil {
+		return
+	}
+	httpserver.Close()
+	<-a.doneAPI
+}
+
+// Listen creates the listener of the http server, with tls if enabled
+func (a *AdminAPI) Listen() error {
+	var err error
+	var listener net.Listener
+	cfg := a.config.Global.Admin
+	addrlisten := cfg.ListenIP + ":" + strconv.Itoa(cfg.ListenPort)
+
+	// listening with tls enabled ?
+	if cfg.TLSSupport {
+		a.LogInfo("tls support enabled")
+		var cer tls.Certificate
+		cer, err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading certificate failed: %w", err)
+		}
+
+		// prepare tls configuration
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cer},
+			MinVersion:   tls.VersionTLS12,
+		}
+
+		// update tls min version according to the user config
+		tlsConfig.MinVersion = pkgconfig.TLSVersion[cfg.TLSMinVersion]
+
+		listener, err = tls.Listen(netlib.SocketTCP, addrlisten, tlsConfig)
+
+	} else {
+		// basic listening
+		listener, err = net.Listen(netlib.SocketTCP, addrlisten)
+	}
+
+	// something wrong ?
+	if err != nil {
+		return fmt.Errorf("listening failed: %w", err)
+	}
+
+	a.serverMutex.Lock()
+	a.httpserver = listener
+	a.serverMutex.Unlock()
+	a.LogInfo("is listening on %s", listener.Addr())
+	return nil
+}
+
+// ListenAndServe serves the api, the listener is created if Listen is not called before
+func (a *AdminAPI) ListenAndServe() {
+	a.LogInfo("starting server...")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", a.GetHealthHandler)
+	mux.HandleFunc("/readyz", a.GetReadyHandler)
+	mux.HandleFunc("/stanzas", a.GetStanzasHandler)
+	mux.HandleFunc("/stanzas/", a.GetStanzaHandler)
+	mux.HandleFunc("/reload", a.PostReloadHandler)
+
+	a.serverMutex.Lock()
+	httpserver := a.httpserver
+	a.serverMutex.Unlock()
+	if httpserver == nil {
+		if err := a.Listen(); err != nil {
+			a.logger.Fatal(pkgutils.PrefixLogAdmin, err)
+		}
+		httpserver = a.httpserver
+	}
+
+	http.Serve(httpserver, mux)
+
+	a.LogInfo("http server terminated")
+	a.doneAPI <- true
+}
@@ -0,0 +1,161 @@
+package pkglinker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestAdminAPI_Handlers(t *testing.T) {
+	lg := logger.New(false)
+
+	configPath := filepath.Join(t.TempDir(), "config.yml")
+	configData := `
+global:
+  admin:
+    enable: true
+pipelines:
+  - name: filter
+    dnsmessage:
+      matching: {}
+    routing-policy:
+      default: [ out1 ]
+  - name: out1
+    stdout: {}
+`
+	if err := os.WriteFile(configPath, []byte(configData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dmRef := dnsutils.GetReferenceDNSMessage()
+	config, err := pkgutils.LoadConfig(configPath, dmRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapLoggers := make(map[string]pkgutils.Worker)
+	mapCollectors := make(map[string]pkgutils.Worker)
+	mapLinks := make(map[string]*PipelineLinks)
+	if err := InitPipelines(mapLoggers, mapCollectors, mapLinks, config, lg); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range mapLoggers {
+		go w.Run()
+	}
+	for _, w := range mapCollectors {
+		go w.Run()
+	}
+
+	reloaded := 0
+	var reloadErr error
+	reload := func() error {
+		reloaded++
+		return reloadErr
+	}
+	admin := NewAdminAPI(config, configPath, dmRef, mapLoggers, mapCollectors, mapLinks, reload, lg)
+
+	tt := []struct {
+		name       string
+		handler    http.HandlerFunc
+		uri        string
+		method     string
+		password   string
+		statusCode int
+	}{
+		{"health", admin.GetHealthHandler, "/healthz", http.MethodGet, "", http.StatusOK},
+		{"not ready", admin.GetReadyHandler, "/readyz", http.MethodGet, "", http.StatusServiceUnavailable},
+		{"bad basic auth", admin.GetStanzasHandler, "/stanzas", http.MethodGet, "badpassword", http.StatusUnauthorized},
+		{"bad method", admin.GetStanzasHandler, "/stanzas", http.MethodPost, config.Global.Admin.BasicAuthPwd, http.StatusMethodNotAllowed},
+		{"stanzas", admin.GetStanzasHandler, "/stanzas", http.MethodGet, config.Global.Admin.BasicAuthPwd, http.StatusOK},
+		{"unknown stanza", admin.GetStanzaHandler, "/stanzas/unknown", http.MethodGet, config.Global.Admin.BasicAuthPwd, http.StatusNotFound},
+		{"stanza", admin.GetStanzaHandler, "/stanzas/filter", http.MethodGet, config.Global.Admin.BasicAuthPwd, http.StatusOK},
+		{"stanza bad method", admin.GetStanzaHandler, "/stanzas/filter/reload", http.MethodGet, config.Global.Admin.BasicAuthPwd, http.StatusMethodNotAllowed},
+		{"reload unknown stanza", admin.GetStanzaHandler, "/stanzas/unknown/reload", http.MethodPost, config.Global.Admin.BasicAuthPwd, http.StatusNotFound},
+		{"reload stanza", admin.GetStanzaHandler, "/stanzas/out1/reload", http.MethodPost, config.Global.Admin.BasicAuthPwd, http.StatusOK},
+		{"reload", admin.PostReloadHandler, "/reload", http.MethodPost, config.Global.Admin.BasicAuthPwd, http.StatusOK},
+		{"ready", admin.GetReadyHandler, "/readyz", http.MethodGet, "", http.StatusOK},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(tc.method, tc.uri, nil)
+			request.SetBasicAuth(config.Global.Admin.BasicAuthLogin, tc.password)
+			responseRecorder := httptest.NewRecorder()
+
+			tc.handler(responseRecorder, request)
+
+			if responseRecorder.Code != tc.statusCode {
+				t.Fatalf("Want status '%d', got '%d'", tc.statusCode, responseRecorder.Code)
+			}
+
+			switch tc.name {
+			case "stanzas":
+				var stanzas []StanzaInfo
+				if err := json.NewDecoder(responseRecorder.Body).Decode(&stanzas); err != nil {
+					t.Fatal(err)
+				}
+				if len(stanzas) != 2 || stanzas[0].Name != "filter" || stanzas[1].Name != "out1" {
+					t.Fatalf("invalid stanzas: %v", stanzas)
+				}
+				if stanzas[1].Role != StanzaLogger || stanzas[1].Type != "stdout" || stanzas[1].Channel.Capacity != config.Loggers.Stdout.ChannelBufferSize {
+					t.Errorf("invalid logger stanza: %v", stanzas[1])
+				}
+			case "stanza":
+				var info StanzaInfo
+				if err := json.NewDecoder(responseRecorder.Body).Decode(&info); err != nil {
+					t.Fatal(err)
+				}
+				if info.Role != StanzaCollector || info.Type != "dnsmessage" {
+					t.Errorf("invalid collector stanza: %v", info)
+				}
+				if routes := info.Routes[RoutingPolicyDefault]; len(routes) != 1 || routes[0] != "out1" {
+					t.Errorf("invalid routes: %v", info.Routes)
+				}
+			}
+		})
+	}
+
+	if reloaded != 1 {
+		t.Errorf("reload function should be called once, got %d", reloaded)
+	}
+
+	// the reload error is returned
+	reloadErr = errors.New("invalid config")
+	request := httptest.NewRequest(http.MethodPost, "/reload", nil)
+	request.SetBasicAuth(config.Global.Admin.BasicAuthLogin, config.Global.Admin.BasicAuthPwd)
+	responseRecorder := httptest.NewRecorder()
+	admin.PostReloadHandler(responseRecorder, request)
+	if responseRecorder.Code != http.StatusInternalServerError {
+		t.Errorf("Want status '%d', got '%d'", http.StatusInternalServerError, responseRecorder.Code)
+	}
+}
+
+func TestAdminAPI_Stop(t *testing.T) {
+	config := pkgconfig.GetFakeConfig()
+	admin := NewAdminAPI(config, "", dnsutils.GetReferenceDNSMessage(), nil, nil, nil, nil, logger.New(false))
+
+	// nothing to stop before listening
+	admin.Stop()
+
+	// invalid certificate, the error is returned to the caller
+	config.Global.Admin.TLSSupport = true
+	if err := admin.Listen(); err == nil {
+		t.Errorf("listening error expected with tls and without certificate")
+	}
+
+	config.Global.Admin.TLSSupport = false
+	config.Global.Admin.ListenPort = 0
+	if err := admin.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go admin.ListenAndServe()
+	admin.Stop()
+}
@@ -54,6 +54,16 @@ func AreRoutesValid(config *pkgconfig.Config) (ret error) {
 	return
 }
 
+// GetItemKind returns the name of the collector or logger used by the item
+func GetItemKind(config *pkgconfig.Config, item pkgconfig.MultiplexInOut) string {
+	for k := range item.Params {
+		if config.Loggers.IsValid(k) || config.Collectors.IsValid(k) {
+			return k
+		}
+	}
+	return ""
+}
+
 func GetItemConfig(section string, config *pkgconfig.Config, item pkgconfig.MultiplexInOut) *pkgconfig.Config {
 	// load config
 	cfg := make(map[string]interface{})
@@ -144,6 +154,18 @@ func InitMultiplexer(mapLoggers map[string]pkgutils.Worker, mapCollectors map[st
 		if subcfg.Loggers.FalcoClient.Enable && IsLoggerRouted(config, output.Name) {
 			mapLoggers[output.Name] = loggers.NewFalcoClient(subcfg, logger, output.Name)
 		}
+		if subcfg.Loggers.ClickhouseClient.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewClickhouseClient(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.OTLPClient.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewOTLPClient(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.PowerDNSClient.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewPdnsSender(subcfg, logger, output.Name)
+		}
+		if subcfg.Loggers.PassiveDNS.Enable && IsLoggerRouted(config, output.Name) {
+			mapLoggers[output.Name] = loggers.NewPassiveDNS(subcfg, logger, output.Name)
+		}
 	}
 
 	// load collectors
@@ -177,6 +199,23 @@ func InitMultiplexer(mapLoggers map[string]pkgutils.Worker, mapCollectors map[st
 		if subcfg.Collectors.Tzsp.Enable && IsCollectorRouted(config, input.Name) {
 			mapCollectors[input.Name] = collectors.NewTZSP(nil, subcfg, logger, input.Name)
 		}
+		if subcfg.Collectors.DoHProxy.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewDoHProxy(nil, subcfg, logger, input.Name)
+		}
+		if subcfg.Collectors.KafkaConsumer.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewKafkaConsumer(nil, subcfg, logger, input.Name)
+		}
+		if subcfg.Collectors.JSONListener.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewJSONListener(nil, subcfg, logger, input.Name)
+		}
+	}
+
+	// export the internal metrics of each logger and collector
+	for _, wrk := range mapLoggers {
+		pkgutils.Telemetry.RegisterWorker(wrk)
+	}
+	for _, wrk := range mapCollectors {
+		pkgutils.Telemetry.RegisterWorker(wrk)
 	}
 
 	// here the multiplexer logic
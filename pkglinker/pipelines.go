@@ -51,6 +51,16 @@ func GetStanzaConfig(config *pkgconfig.Config, item pkgconfig.ConfigPipelines) *
 	// copy global config
 	subcfg.Global = config.Global
 
+	// copy the routes and their delivery, the conditional rules are applied by the routers
+	subcfg.Pipelines = []pkgconfig.ConfigPipelines{{
+		Name: item.Name,
+		RoutingPolicy: pkgconfig.PipelinesRouting{
+			Default:  item.RoutingPolicy.Default,
+			Dropped:  item.RoutingPolicy.Dropped,
+			Delivery: item.RoutingPolicy.Delivery,
+		},
+	}}
+
 	yamlcfg, _ := yaml.Marshal(cfg)
 	if err := yaml.Unmarshal(yamlcfg, subcfg); err != nil {
 		panic(fmt.Sprintf("main - yaml logger config error: %v", err))
@@ -82,44 +92,99 @@ func IsRouteExist(target string, config *pkgconfig.Config) (ret error) {
 	return fmt.Errorf("route=%s doest not exist", target)
 }
 
-func CreateRouting(stanza pkgconfig.ConfigPipelines, mapCollectors map[string]pkgutils.Worker, mapLoggers map[string]pkgutils.Worker, logger *logger.Logger) {
-	var currentStanza pkgutils.Worker
-	if collector, ok := mapCollectors[stanza.Name]; ok {
-		currentStanza = collector
+// GetStanzaKind returns the name of the collector or logger used by the stanza
+func GetStanzaKind(config *pkgconfig.Config, item pkgconfig.ConfigPipelines) string {
+	for k := range item.Params {
+		if config.Loggers.IsValid(k) || config.Collectors.IsValid(k) {
+			return k
+		}
 	}
-	if logger, ok := mapLoggers[stanza.Name]; ok {
-		currentStanza = logger
+	return ""
+}
+
+// PipelineLinks are the routers between a stanza and its next stanzas
+type PipelineLinks struct {
+	Kind    string
+	Default *Router
+	Dropped *Router
+}
+
+func (l *PipelineLinks) Run() {
+	go l.Default.Run()
+	go l.Dropped.Run()
+}
+
+func (l *PipelineLinks) Stop() {
+	l.Default.Stop()
+	l.Dropped.Stop()
+}
+
+func GetWorker(name string, mapCollectors map[string]pkgutils.Worker, mapLoggers map[string]pkgutils.Worker) pkgutils.Worker {
+	if collector, ok := mapCollectors[name]; ok {
+		return collector
 	}
+	if logger, ok := mapLoggers[name]; ok {
+		return logger
+	}
+	return nil
+}
 
-	// TODO raise error when no routes are defined
+// GetRoutesNames returns the names of the next stanzas for the routing policy,
+// routes of the conditional rules are added to the default ones
+func GetRoutesNames(stanza pkgconfig.ConfigPipelines, policy string) []string {
+	routes := stanza.RoutingPolicy.Dropped
+	if policy == RoutingPolicyDefault {
+		routes = append([]string{}, stanza.RoutingPolicy.Default...)
+		for _, rule := range stanza.RoutingPolicy.Rules {
+			routes = append(routes, rule.Routes...)
+		}
+	}
 
-	// default routing
-	for _, route := range stanza.RoutingPolicy.Default {
-		if _, ok := mapCollectors[route]; ok {
-			currentStanza.AddDefaultRoute(mapCollectors[route])
-			logger.Info("main - routing (policy=default) stanza=[%s] to stanza=[%s]", stanza.Name, route)
-		} else if _, ok := mapLoggers[route]; ok {
-			currentStanza.AddDefaultRoute(mapLoggers[route])
-			logger.Info("main - routing (policy=default) stanza=[%s] to stanza=[%s]", stanza.Name, route)
-		} else {
-			logger.Error("main - default routing error from stanza=%s to stanza=%s doest not exist", stanza.Name, route)
-			break
+	names := []string{}
+	registered := make(map[string]bool)
+	for _, route := range routes {
+		if !registered[route] {
+			registered[route] = true
+			names = append(names, route)
 		}
 	}
+	return names
+}
 
-	// dropped routing
-	for _, route := range stanza.RoutingPolicy.Dropped {
-		if _, ok := mapCollectors[route]; ok {
-			currentStanza.AddDroppedRoute(mapCollectors[route])
-			logger.Info("main - routing (policy=dropped) stanza=[%s] to stanza=[%s]", stanza.Name, route)
-		} else if _, ok := mapLoggers[route]; ok {
-			currentStanza.AddDroppedRoute(mapLoggers[route])
-			logger.Info("main - routing (policy=dropped) stanza=[%s] to stanza=[%s]", stanza.Name, route)
-		} else {
-			logger.Error("main - routing error with dropped messages from stanza=%s to stanza=%s doest not exist", stanza.Name, route)
-			break
+// GetRoutes returns the next stanzas for the routing policy
+func GetRoutes(stanza pkgconfig.ConfigPipelines, policy string, mapCollectors map[string]pkgutils.Worker, mapLoggers map[string]pkgutils.Worker, logger *logger.Logger) []pkgutils.Worker {
+	workers := []pkgutils.Worker{}
+	for _, route := range GetRoutesNames(stanza, policy) {
+		next := GetWorker(route, mapCollectors, mapLoggers)
+		if next == nil {
+			logger.Error("main - routing error (policy=%s) from stanza=%s to stanza=%s doest not exist", policy, stanza.Name, route)
+			continue
 		}
+		workers = append(workers, next)
+		logger.Info("main - routing (policy=%s) stanza=[%s] to stanza=[%s]", policy, stanza.Name, route)
 	}
+	return workers
+}
+
+// CreateRouting connects the stanza to its routers
+func CreateRouting(stanza pkgconfig.ConfigPipelines, mapCollectors map[string]pkgutils.Worker, mapLoggers map[string]pkgutils.Worker, logger *logger.Logger) *PipelineLinks {
+	currentStanza := GetWorker(stanza.Name, mapCollectors, mapLoggers)
+
+	links := &PipelineLinks{
+		Default: NewRouter(stanza, RoutingPolicyDefault, logger),
+		Dropped: NewRouter(stanza, RoutingPolicyDropped, logger),
+	}
+	currentStanza.AddDefaultRoute(links.Default)
+	currentStanza.AddDroppedRoute(links.Dropped)
+
+	// TODO raise error when no routes are defined
+	for _, next := range GetRoutes(stanza, RoutingPolicyDefault, mapCollectors, mapLoggers, logger) {
+		links.Default.AddDefaultRoute(next)
+	}
+	for _, next := range GetRoutes(stanza, RoutingPolicyDropped, mapCollectors, mapLoggers, logger) {
+		links.Dropped.AddDefaultRoute(next)
+	}
+	return links
 }
 
 func CreateStanza(stanzaName string, config *pkgconfig.Config, mapCollectors map[string]pkgutils.Worker, mapLoggers map[string]pkgutils.Worker, logger *logger.Logger) {
@@ -172,6 +237,18 @@ func CreateStanza(stanzaName string, config *pkgconfig.Config, mapCollectors map
 	if config.Loggers.FalcoClient.Enable {
 		mapLoggers[stanzaName] = loggers.NewFalcoClient(config, logger, stanzaName)
 	}
+	if config.Loggers.ClickhouseClient.Enable {
+		mapLoggers[stanzaName] = loggers.NewClickhouseClient(config, logger, stanzaName)
+	}
+	if config.Loggers.OTLPClient.Enable {
+		mapLoggers[stanzaName] = loggers.NewOTLPClient(config, logger, stanzaName)
+	}
+	if config.Loggers.PowerDNSClient.Enable {
+		mapLoggers[stanzaName] = loggers.NewPdnsSender(config, logger, stanzaName)
+	}
+	if config.Loggers.PassiveDNS.Enable {
+		mapLoggers[stanzaName] = loggers.NewPassiveDNS(config, logger, stanzaName)
+	}
 
 	// register the collector if enabled
 	if config.Collectors.DNSMessage.Enable {
@@ -201,9 +278,23 @@ func CreateStanza(stanzaName string, config *pkgconfig.Config, mapCollectors map
 	if config.Collectors.Tzsp.Enable {
 		mapCollectors[stanzaName] = collectors.NewTZSP(nil, config, logger, stanzaName)
 	}
+	if config.Collectors.DoHProxy.Enable {
+		mapCollectors[stanzaName] = collectors.NewDoHProxy(nil, config, logger, stanzaName)
+	}
+	if config.Collectors.KafkaConsumer.Enable {
+		mapCollectors[stanzaName] = collectors.NewKafkaConsumer(nil, config, logger, stanzaName)
+	}
+	if config.Collectors.JSONListener.Enable {
+		mapCollectors[stanzaName] = collectors.NewJSONListener(nil, config, logger, stanzaName)
+	}
+
+	// export the internal metrics of the stanza
+	if wrk := GetWorker(stanzaName, mapCollectors, mapLoggers); wrk != nil {
+		pkgutils.Telemetry.RegisterWorker(wrk)
+	}
 }
 
-func InitPipelines(mapLoggers map[string]pkgutils.Worker, mapCollectors map[string]pkgutils.Worker, config *pkgconfig.Config, logger *logger.Logger) error {
+func CheckPipelines(config *pkgconfig.Config) error {
 	// check if the name of each stanza is uniq
 	for _, stanza := range config.Pipelines {
 		if err := StanzaNameIsUniq(stanza.Name, config); err != nil {
@@ -223,6 +314,44 @@ func InitPipelines(mapLoggers map[string]pkgutils.Worker, mapCollectors map[stri
 				return errors.Errorf("stanza=[%s] dropped route=[%s] doest not exist", stanza.Name, route)
 			}
 		}
+		for _, rule := range stanza.RoutingPolicy.Rules {
+			for _, route := range rule.Routes {
+				if err := IsRouteExist(route, config); err != nil {
+					return errors.Errorf("stanza=[%s] rule route=[%s] doest not exist", stanza.Name, route)
+				}
+			}
+		}
+	}
+
+	// check the delivery of the routes
+	for _, stanza := range config.Pipelines {
+		routes := make(map[string]bool)
+		for _, route := range GetRoutesNames(stanza, RoutingPolicyDefault) {
+			routes[route] = true
+		}
+		for _, delivery := range stanza.RoutingPolicy.Delivery {
+			for _, route := range delivery.Routes {
+				if !routes[route] {
+					return errors.Errorf("stanza=[%s] delivery route=[%s] is not a default or rule route", stanza.Name, route)
+				}
+			}
+			switch delivery.Mode {
+			case pkgconfig.DeliveryDrop, pkgconfig.DeliveryBlock:
+			case pkgconfig.DeliveryBlockTimeout:
+				if delivery.Timeout <= 0 {
+					return errors.Errorf("stanza=[%s] delivery routes=%v invalid timeout=%d", stanza.Name, delivery.Routes, delivery.Timeout)
+				}
+			default:
+				return errors.Errorf("stanza=[%s] delivery routes=%v invalid mode=%s", stanza.Name, delivery.Routes, delivery.Mode)
+			}
+		}
+	}
+	return nil
+}
+
+func InitPipelines(mapLoggers map[string]pkgutils.Worker, mapCollectors map[string]pkgutils.Worker, mapLinks map[string]*PipelineLinks, config *pkgconfig.Config, logger *logger.Logger) error {
+	if err := CheckPipelines(config); err != nil {
+		return err
 	}
 
 	// read each stanza and init
@@ -235,7 +364,10 @@ func InitPipelines(mapLoggers map[string]pkgutils.Worker, mapCollectors map[stri
 	// create routing
 	for _, stanza := range config.Pipelines {
 		if mapCollectors[stanza.Name] != nil || mapLoggers[stanza.Name] != nil {
-			CreateRouting(stanza, mapCollectors, mapLoggers, logger)
+			links := CreateRouting(stanza, mapCollectors, mapLoggers, logger)
+			links.Kind = GetStanzaKind(config, stanza)
+			links.Run()
+			mapLinks[stanza.Name] = links
 		} else {
 			return errors.Errorf("stanza=[%v] doest not exist", stanza.Name)
 		}
@@ -243,3 +375,81 @@ func InitPipelines(mapLoggers map[string]pkgutils.Worker, mapCollectors map[stri
 
 	return nil
 }
+
+// ReloadPipelines applies the new pipelines: new stanzas are started, removed ones are stopped,
+// the routes are updated and the new config is pushed to the other stanzas.
+// Stanzas are not restarted, collectors keep their listening sockets.
+func ReloadPipelines(mapLoggers map[string]pkgutils.Worker, mapCollectors map[string]pkgutils.Worker, mapLinks map[string]*PipelineLinks, config *pkgconfig.Config, logger *logger.Logger) error {
+	// validate the whole config before to update the running pipelines
+	if err := CheckPipelines(config); err != nil {
+		return err
+	}
+	for _, stanza := range config.Pipelines {
+		if GetStanzaKind(config, stanza) == "" {
+			return errors.Errorf("stanza=[%v] without valid collector or logger", stanza.Name)
+		}
+	}
+
+	// stop the stanzas not found in the new config or with another collector/logger,
+	// before to start the new ones to release the listening sockets
+	for name, links := range mapLinks {
+		found := false
+		for _, stanza := range config.Pipelines {
+			if stanza.Name == name && GetStanzaKind(config, stanza) == links.Kind {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if worker := GetWorker(name, mapCollectors, mapLoggers); worker != nil {
+			worker.Stop()
+		}
+		links.Stop()
+		pkgutils.Telemetry.UnregisterWorker(name)
+		delete(mapCollectors, name)
+		delete(mapLoggers, name)
+		delete(mapLinks, name)
+		logger.Info("main - reload pipelines, stanza=[%s] removed", name)
+	}
+
+	// create the new stanzas, started later
+	added := []pkgconfig.ConfigPipelines{}
+	for _, stanza := range config.Pipelines {
+		if GetWorker(stanza.Name, mapCollectors, mapLoggers) != nil {
+			continue
+		}
+		CreateStanza(stanza.Name, GetStanzaConfig(config, stanza), mapCollectors, mapLoggers, logger)
+		if GetWorker(stanza.Name, mapCollectors, mapLoggers) == nil {
+			return errors.Errorf("stanza=[%v] doest not exist", stanza.Name)
+		}
+		added = append(added, stanza)
+	}
+
+	// rebuild the default and dropped routes of the existing stanzas and push the new config
+	for _, stanza := range config.Pipelines {
+		links, ok := mapLinks[stanza.Name]
+		if !ok {
+			continue
+		}
+		links.Default.ReloadConfig(GetRouterConfig(stanza, RoutingPolicyDefault))
+		links.Default.UpdateRoutes(GetRoutes(stanza, RoutingPolicyDefault, mapCollectors, mapLoggers, logger))
+		links.Dropped.ReloadConfig(GetRouterConfig(stanza, RoutingPolicyDropped))
+		links.Dropped.UpdateRoutes(GetRoutes(stanza, RoutingPolicyDropped, mapCollectors, mapLoggers, logger))
+
+		GetWorker(stanza.Name, mapCollectors, mapLoggers).ReloadConfig(GetStanzaConfig(config, stanza))
+	}
+
+	// connect and start the new stanzas
+	for _, stanza := range added {
+		links := CreateRouting(stanza, mapCollectors, mapLoggers, logger)
+		links.Kind = GetStanzaKind(config, stanza)
+		links.Run()
+		mapLinks[stanza.Name] = links
+		go GetWorker(stanza.Name, mapCollectors, mapLoggers).Run()
+		logger.Info("main - reload pipelines, stanza=[%s] added", stanza.Name)
+	}
+
+	return nil
+}
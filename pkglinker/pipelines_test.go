@@ -1,9 +1,19 @@
 package pkglinker
 
 import (
+	"bufio"
+	"net"
 	"testing"
+	"time"
 
+	"github.com/dmachard/go-dnscollector/netlib"
 	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-dnscollector/processors"
+	"github.com/dmachard/go-framestream"
+	"github.com/dmachard/go-logger"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
 )
 
 func TestPipeline_IsRouteExist(t *testing.T) {
@@ -51,3 +61,297 @@ func TestPipeline_StanzaNameIsUniq(t *testing.T) {
 		t.Errorf("For the duplicate stanza name %s, an expected error was not returned. Received error: %v", duplicateStanzaName, err)
 	}
 }
+
+func TestPipeline_ReloadPipelines(t *testing.T) {
+	lg := logger.New(false)
+
+	config := pkgconfig.GetFakeConfig()
+	pipelines := `
+- name: filter
+  dnsmessage:
+    matching: {}
+  routing-policy:
+    default: [ out1 ]
+- name: out1
+  stdout: {}
+`
+	if err := yaml.Unmarshal([]byte(pipelines), &config.Pipelines); err != nil {
+		t.Fatal(err)
+	}
+
+	mapLoggers := make(map[string]pkgutils.Worker)
+	mapCollectors := make(map[string]pkgutils.Worker)
+	mapLinks := make(map[string]*PipelineLinks)
+	if err := InitPipelines(mapLoggers, mapCollectors, mapLinks, config, lg); err != nil {
+		t.Fatal(err)
+	}
+	filter := mapCollectors["filter"]
+	for _, w := range mapLoggers {
+		go w.Run()
+	}
+	go filter.Run()
+
+	// replace out1 by out2
+	pipelines = `
+- name: filter
+  dnsmessage:
+    matching: {}
+  routing-policy:
+    default: [ out2 ]
+    dropped: [ out2 ]
+- name: out2
+  stdout: {}
+`
+	config.Pipelines = nil
+	if err := yaml.Unmarshal([]byte(pipelines), &config.Pipelines); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadPipelines(mapLoggers, mapCollectors, mapLinks, config, lg); err != nil {
+		t.Fatal(err)
+	}
+
+	if mapCollectors["filter"] != filter {
+		t.Errorf("filter stanza should not be restarted")
+	}
+	if _, ok := mapLoggers["out1"]; ok {
+		t.Errorf("out1 stanza should be removed")
+	}
+	if _, ok := mapLinks["out1"]; ok {
+		t.Errorf("out1 routers should be removed")
+	}
+	if _, ok := mapLoggers["out2"]; !ok {
+		t.Errorf("out2 stanza should be added")
+	}
+
+	// stop the routers before to read the routes
+	mapLinks["filter"].Stop()
+	if routes, _ := mapLinks["filter"].Default.RoutingHandler.GetDefaultRoutes(); len(routes) != 1 || routes[0] != mapLoggers["out2"].GetInputChannel() {
+		t.Errorf("filter stanza should be routed to out2")
+	}
+	if routes, _ := mapLinks["filter"].Dropped.RoutingHandler.GetDefaultRoutes(); len(routes) != 1 || routes[0] != mapLoggers["out2"].GetInputChannel() {
+		t.Errorf("dropped messages of the filter stanza should be routed to out2")
+	}
+
+	// invalid topology, the current one is kept
+	config.Pipelines[0].RoutingPolicy.Default = []string{"unknown"}
+	if err := ReloadPipelines(mapLoggers, mapCollectors, mapLinks, config, lg); err == nil {
+		t.Errorf("reload error expected with unknown route")
+	}
+	if len(mapLinks) != 2 {
+		t.Errorf("current pipelines should be kept on error")
+	}
+
+	// stanza without collector or logger, nothing is removed before the error
+	config.Pipelines = config.Pipelines[:1]
+	config.Pipelines[0].RoutingPolicy.Default = nil
+	config.Pipelines[0].RoutingPolicy.Dropped = nil
+	config.Pipelines = append(config.Pipelines, pkgconfig.ConfigPipelines{Name: "out3"})
+	if err := ReloadPipelines(mapLoggers, mapCollectors, mapLinks, config, lg); err == nil {
+		t.Errorf("reload error expected with an invalid stanza")
+	}
+	if _, ok := mapLoggers["out2"]; !ok || len(mapLinks) != 2 {
+		t.Errorf("out2 stanza should be kept on error")
+	}
+}
+
+// getTelemetry returns the value of the internal metric with the given labels
+func getTelemetry(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := pkgutils.Telemetry.GetRegistry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			matched := 0
+			for _, label := range metric.GetLabel() {
+				if labels[label.GetName()] == label.GetValue() {
+					matched++
+				}
+			}
+			if matched != len(labels) {
+				continue
+			}
+			if metric.GetGauge() != nil {
+				return metric.GetGauge().GetValue()
+			}
+			return metric.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+// waitTelemetry waits until the internal metric is equal to the value
+func waitTelemetry(t *testing.T, name string, labels map[string]string, value float64) bool {
+	for i := 0; i < 200; i++ {
+		if getTelemetry(t, name, labels) == value {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestPipeline_ReloadPipelines_KeepConnections(t *testing.T) {
+	lg := logger.New(false)
+	sockPath := "/tmp/dnscollector-reload.sock"
+
+	config := pkgconfig.GetFakeConfig()
+	pipelines := `
+- name: tap
+  dnstap:
+    sock-path: ` + sockPath + `
+  routing-policy:
+    default: [ out1 ]
+- name: out1
+  stdout: {}
+`
+	if err := yaml.Unmarshal([]byte(pipelines), &config.Pipelines); err != nil {
+		t.Fatal(err)
+	}
+
+	mapLoggers := make(map[string]pkgutils.Worker)
+	mapCollectors := make(map[string]pkgutils.Worker)
+	mapLinks := make(map[string]*PipelineLinks)
+	if err := InitPipelines(mapLoggers, mapCollectors, mapLinks, config, lg); err != nil {
+		t.Fatal(err)
+	}
+	tap := mapCollectors["tap"]
+	for _, w := range mapLoggers {
+		go w.Run()
+	}
+	go tap.Run()
+
+	// connect a dnstap client, the socket is created by the collector
+	var conn net.Conn
+	var err error
+	for i := 0; i < 200; i++ {
+		if conn, err = net.Dial(netlib.SocketUnix, sockPath); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+
+	fs := framestream.NewFstrm(bufio.NewReader(conn), bufio.NewWriter(conn), conn, 5*time.Second, []byte("protobuf:dnstap.Dnstap"), true)
+	if err := fs.InitSender(); err != nil {
+		t.Fatalf("framestream init error: %s", err)
+	}
+	dnsquery, err := processors.GetFakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := proto.Marshal(processors.GetFakeDNSTap(dnsquery))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendFrame := func() {
+		frame := &framestream.Frame{}
+		frame.Write(data)
+		if err := fs.SendFrame(frame); err != nil {
+			t.Fatalf("send frame error: %s", err)
+		}
+	}
+
+	sendFrame()
+	if !waitTelemetry(t, "dnscollector_messages_out_total", map[string]string{"stanza": "tap", "route": "out1"}, 1) {
+		t.Fatalf("message not routed to out1")
+	}
+
+	// add out2 and remove out1 behind the collector
+	pipelines = `
+- name: tap
+  dnstap:
+    sock-path: ` + sockPath + `
+  routing-policy:
+    default: [ out2 ]
+- name: out2
+  stdout: {}
+`
+	config.Pipelines = nil
+	if err := yaml.Unmarshal([]byte(pipelines), &config.Pipelines); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadPipelines(mapLoggers, mapCollectors, mapLinks, config, lg); err != nil {
+		t.Fatal(err)
+	}
+	if mapCollectors["tap"] != tap {
+		t.Errorf("tap collector should not be restarted")
+	}
+
+	// the connection opened before the reload is still read by the collector
+	sendFrame()
+	if !waitTelemetry(t, "dnscollector_messages_out_total", map[string]string{"stanza": "tap", "route": "out2"}, 1) {
+		t.Errorf("message from the current connection not routed to out2")
+	}
+
+	// wait the end of the connection handler before to stop the collector
+	conn.Close()
+	if !waitTelemetry(t, "dnscollector_connections", map[string]string{"stanza": "tap"}, 0) {
+		t.Errorf("connection not closed by the collector")
+	}
+	tap.Stop()
+	for _, w := range mapLoggers {
+		w.Stop()
+	}
+	for name, links := range mapLinks {
+		links.Stop()
+		pkgutils.Telemetry.UnregisterWorker(name)
+	}
+}
+
+func TestPipeline_GetStanzaConfig_RoutingPolicy(t *testing.T) {
+	config := pkgconfig.GetFakeConfig()
+	stanza := pkgconfig.ConfigPipelines{Name: "collector", Params: map[string]interface{}{"dnsmessage": nil}}
+	stanza.RoutingPolicy.Default = []string{"logger"}
+	stanza.RoutingPolicy.Delivery = []pkgconfig.PipelinesDelivery{{Routes: []string{"logger"}, Mode: pkgconfig.DeliveryBlock}}
+	stanza.RoutingPolicy.Rules = []pkgconfig.PipelinesRoutingRule{{Routes: []string{"logger"}}}
+
+	// the delivery is known by the stanza, the rules are kept by the routers
+	cfg := GetStanzaConfig(config, stanza)
+	if len(cfg.Pipelines) != 1 || cfg.Pipelines[0].Name != "collector" {
+		t.Fatalf("routing policy of the stanza expected: %v", cfg.Pipelines)
+	}
+	policy := cfg.Pipelines[0].RoutingPolicy
+	if len(policy.Default) != 1 || len(policy.Delivery) != 1 || len(policy.Rules) != 0 {
+		t.Errorf("invalid routing policy: %v", policy)
+	}
+}
+
+func TestPipeline_CheckDelivery(t *testing.T) {
+	tt := []struct {
+		name     string
+		delivery pkgconfig.PipelinesDelivery
+		route    string
+		valid    bool
+	}{
+		{"block", pkgconfig.PipelinesDelivery{Mode: pkgconfig.DeliveryBlock}, "logger", true},
+		{"block with timeout", pkgconfig.PipelinesDelivery{Mode: pkgconfig.DeliveryBlockTimeout, Timeout: 5}, "logger", true},
+		{"missing timeout", pkgconfig.PipelinesDelivery{Mode: pkgconfig.DeliveryBlockTimeout}, "logger", false},
+		{"invalid mode", pkgconfig.PipelinesDelivery{Mode: "wait"}, "logger", false},
+		{"unknown route", pkgconfig.PipelinesDelivery{Mode: pkgconfig.DeliveryDrop}, "other", false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &pkgconfig.Config{}
+			collector := pkgconfig.ConfigPipelines{Name: "collector"}
+			collector.RoutingPolicy.Default = []string{"logger"}
+			tc.delivery.Routes = []string{tc.route}
+			collector.RoutingPolicy.Delivery = []pkgconfig.PipelinesDelivery{tc.delivery}
+			config.Pipelines = []pkgconfig.ConfigPipelines{collector, {Name: "logger"}, {Name: "other"}}
+
+			err := CheckPipelines(config)
+			if tc.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("error expected")
+			}
+		})
+	}
+}
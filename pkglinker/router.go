@@ -0,0 +1,183 @@
+package pkglinker
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-logger"
+)
+
+const (
+	RoutingPolicyDefault = "default"
+	RoutingPolicyDropped = "dropped"
+
+	routerChannelBufferSize = 4096
+)
+
+// Router is added between a stanza and its next stanzas for one routing policy.
+// The next stanzas can be updated on reload without restarting the stanza.
+// The updates are stored until the run loop applies them, so the reload never waits
+// for a delivery blocked on a full stanza.
+type Router struct {
+	stopRun        chan bool
+	doneRun        chan bool
+	inputChan      chan dnsutils.DNSMessage
+	updated        chan bool
+	updateMutex    sync.Mutex
+	pendingConfig  *pkgconfig.Config
+	pendingRoutes  []pkgutils.Worker
+	routesUpdated  bool
+	logger         *logger.Logger
+	name           string
+	blocking       atomic.Bool
+	RoutingHandler pkgutils.RoutingHandler
+}
+
+// NewRouter creates the router of the stanza for the routing policy,
+// conditional routing rules are only applied with the default policy.
+func NewRouter(stanza pkgconfig.ConfigPipelines, policy string, logger *logger.Logger) *Router {
+	r := &Router{
+		stopRun:        make(chan bool),
+		doneRun:        make(chan bool),
+		inputChan:      make(chan dnsutils.DNSMessage, routerChannelBufferSize),
+		updated:        make(chan bool, 1),
+		logger:         logger,
+		name:           stanza.Name + "/" + policy,
+		RoutingHandler: pkgutils.NewRoutingHandler(GetRouterConfig(stanza, policy), logger, stanza.Name),
+	}
+	r.blocking.Store(r.RoutingHandler.IsBlocking())
+	return r
+}
+
+// GetRouterConfig returns the config used by the routing handler of the router
+func GetRouterConfig(stanza pkgconfig.ConfigPipelines, policy string) *pkgconfig.Config {
+	cfg := &pkgconfig.Config{}
+	if policy == RoutingPolicyDefault {
+		cfg.Pipelines = []pkgconfig.ConfigPipelines{{Name: stanza.Name, RoutingPolicy: stanza.RoutingPolicy}}
+	}
+	return cfg
+}
+
+func (r *Router) GetName() string { return r.name }
+
+// IsBlocking returns true if one of the next stanzas is not in drop mode,
+// the stanza must wait instead of dropping when the router is full
+func (r *Router) IsBlocking() bool { return r.blocking.Load() }
+
+func (r *Router) AddDefaultRoute(wrk pkgutils.Worker) {
+	r.RoutingHandler.AddDefaultRoute(wrk)
+}
+
+func (r *Router) AddDroppedRoute(wrk pkgutils.Worker) {}
+
+func (r *Router) SetLoggers(loggers []pkgutils.Worker) {}
+
+func (r *Router) ReadConfig() {}
+
+func (r *Router) ReloadConfig(config *pkgconfig.Config) {
+	r.updateMutex.Lock()
+	r.pendingConfig = config
+	r.updateMutex.Unlock()
+	r.notifyUpdate()
+}
+
+// UpdateRoutes replaces the next stanzas of the running router
+func (r *Router) UpdateRoutes(routes []pkgutils.Worker) {
+	r.updateMutex.Lock()
+	r.pendingRoutes = routes
+	r.routesUpdated = true
+	r.updateMutex.Unlock()
+	r.notifyUpdate()
+}
+
+// notifyUpdate wakes up the run loop, a notification is already pending if the channel is full
+func (r *Router) notifyUpdate() {
+	select {
+	case r.updated <- true:
+	default:
+	}
+}
+
+// applyUpdates applies the pending config and routes, called from the run loop
+func (r *Router) applyUpdates() {
+	r.updateMutex.Lock()
+	cfg, routes, routesUpdated := r.pendingConfig, r.pendingRoutes, r.routesUpdated
+	r.pendingConfig, r.pendingRoutes, r.routesUpdated = nil, nil, false
+	r.updateMutex.Unlock()
+
+	if cfg != nil {
+		r.RoutingHandler.ReloadConfig(cfg)
+		r.blocking.Store(r.RoutingHandler.IsBlocking())
+	}
+	if routesUpdated {
+		r.RoutingHandler.SetDefaultRoutes(routes)
+	}
+}
+
+func (r *Router) GetInputChannel() chan dnsutils.DNSMessage {
+	return r.inputChan
+}
+
+func (r *Router) LogInfo(msg string, v ...interface{}) {
+	r.logger.Info(pkgutils.PrefixLogRouting+pkgutils.GetName(r.name)+msg, v...)
+}
+
+func (r *Router) Stop() {
+	r.LogInfo("stopping to run...")
+
+	// stop the routing handler first to unblock the delivery to the next stanzas
+	r.RoutingHandler.Stop()
+
+	r.stopRun <- true
+	<-r.doneRun
+}
+
+func (r *Router) Run() {
+	routes, names := r.RoutingHandler.GetDefaultRoutes()
+
+RUN_LOOP:
+	for {
+		select {
+		case <-r.stopRun:
+			// the updates received before the stop are not lost
+			r.applyUpdates()
+			r.doneRun <- true
+			break RUN_LOOP
+
+		case <-r.updated:
+			r.applyUpdates()
+			routes, names = r.RoutingHandler.GetDefaultRoutes()
+
+		case dm := <-r.inputChan:
+			// a blocked delivery is interrupted by the updates, the message
+			// is sent again to the routes not served and still present
+			pending := r.RoutingHandler.SendToInterruptible(routes, names, dm, r.updated)
+			for len(pending) > 0 {
+				r.applyUpdates()
+				routes, names = r.RoutingHandler.GetDefaultRoutes()
+				pendingRoutes, pendingNames := filterRoutes(routes, names, pending)
+				pending = r.RoutingHandler.SendToInterruptible(pendingRoutes, pendingNames, dm, r.updated)
+			}
+		}
+	}
+	r.LogInfo("run terminated")
+}
+
+// filterRoutes returns the routes with the given names
+func filterRoutes(routes []chan dnsutils.DNSMessage, names []string, keep []string) ([]chan dnsutils.DNSMessage, []string) {
+	filteredRoutes := []chan dnsutils.DNSMessage{}
+	filteredNames := []string{}
+	for i, name := range names {
+		for _, k := range keep {
+			if name == k {
+				filteredRoutes = append(filteredRoutes, routes[i])
+				filteredNames = append(filteredNames, name)
+				break
+			}
+		}
+	}
+	return filteredRoutes, filteredNames
+}
@@ -0,0 +1,112 @@
+package pkglinker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-dnscollector/pkgutils"
+	"github.com/dmachard/go-logger"
+)
+
+func TestRouter_UpdateRoutes(t *testing.T) {
+	stanza := pkgconfig.ConfigPipelines{Name: "collector"}
+	router := NewRouter(stanza, RoutingPolicyDefault, logger.New(false))
+
+	fakeOld := pkgutils.NewFakeLogger()
+	fakeNew := pkgutils.NewFakeLogger()
+	router.AddDefaultRoute(fakeOld)
+
+	go router.Run()
+	defer router.Stop()
+
+	// message forwarded to the current route
+	router.GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+	select {
+	case <-fakeOld.GetInputChannel():
+	case <-time.After(time.Second):
+		t.Fatalf("no message forwarded to the route")
+	}
+
+	// rewire the router without restarting it
+	router.UpdateRoutes([]pkgutils.Worker{fakeNew})
+	router.GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+	select {
+	case <-fakeNew.GetInputChannel():
+	case <-time.After(time.Second):
+		t.Fatalf("no message forwarded to the new route")
+	}
+	if len(fakeOld.GetInputChannel()) != 0 {
+		t.Errorf("message forwarded to the old route")
+	}
+}
+
+func TestRouter_UpdateWhileBlocked(t *testing.T) {
+	stanza := pkgconfig.ConfigPipelines{Name: "collector"}
+	stanza.RoutingPolicy.Default = []string{"fake"}
+	stanza.RoutingPolicy.Delivery = []pkgconfig.PipelinesDelivery{{Routes: []string{"fake"}, Mode: pkgconfig.DeliveryBlock}}
+	router := NewRouter(stanza, RoutingPolicyDefault, logger.New(false))
+
+	fakeFull := pkgutils.NewFakeLoggerWithBufferSize(1)
+	fakeNew := pkgutils.NewFakeLogger()
+	router.AddDefaultRoute(fakeFull)
+
+	go router.Run()
+	defer router.Stop()
+
+	// the second message blocks the delivery
+	router.GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+	router.GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+	time.Sleep(100 * time.Millisecond)
+
+	// the updates don't wait for the blocked delivery
+	done := make(chan bool)
+	go func() {
+		router.ReloadConfig(GetRouterConfig(stanza, RoutingPolicyDefault))
+		router.UpdateRoutes([]pkgutils.Worker{fakeNew})
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("updates blocked by the delivery")
+	}
+
+	// the blocked message is sent to the new route
+	select {
+	case <-fakeNew.GetInputChannel():
+	case <-time.After(time.Second):
+		t.Fatalf("blocked message not forwarded to the new route")
+	}
+	if len(fakeFull.GetInputChannel()) != 1 {
+		t.Errorf("one message expected in the old route")
+	}
+}
+
+func TestRouter_IsBlocking(t *testing.T) {
+	stanza := pkgconfig.ConfigPipelines{Name: "collector"}
+	stanza.RoutingPolicy.Default = []string{"logger"}
+	stanza.RoutingPolicy.Delivery = []pkgconfig.PipelinesDelivery{{Routes: []string{"logger"}, Mode: pkgconfig.DeliveryBlock}}
+
+	// only the default policy applies the delivery of the routes
+	router := NewRouter(stanza, RoutingPolicyDefault, logger.New(false))
+	dropped := NewRouter(stanza, RoutingPolicyDropped, logger.New(false))
+	if !router.IsBlocking() || dropped.IsBlocking() {
+		t.Errorf("only the default router should be blocking")
+	}
+	if !pkgutils.IsBlocking([]pkgutils.Worker{dropped, router}) {
+		t.Errorf("workers should be blocking")
+	}
+
+	// updated on reload
+	go router.Run()
+	stanza.RoutingPolicy.Delivery = nil
+	router.ReloadConfig(GetRouterConfig(stanza, RoutingPolicyDefault))
+	router.Stop()
+	if router.IsBlocking() {
+		t.Errorf("router should not be blocking after reload")
+	}
+
+	dropped.RoutingHandler.Stop()
+}
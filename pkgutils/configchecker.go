@@ -79,6 +79,10 @@ func CheckConfig(userConfigPath string, dmRef dnsutils.DNSMessage) error {
 	defaultConfig.Multiplexer.Loggers = append(defaultConfig.Multiplexer.Loggers, pkgconfig.MultiplexInOut{})
 	defaultConfig.Multiplexer.Collectors = append(defaultConfig.Multiplexer.Collectors, pkgconfig.MultiplexInOut{})
 	defaultConfig.Pipelines = append(defaultConfig.Pipelines, pkgconfig.ConfigPipelines{})
+	defaultConfig.Pipelines[0].RoutingPolicy.Rules = append(defaultConfig.Pipelines[0].RoutingPolicy.Rules, pkgconfig.PipelinesRoutingRule{})
+	defaultConfig.Pipelines[0].RoutingPolicy.Delivery = append(defaultConfig.Pipelines[0].RoutingPolicy.Delivery, pkgconfig.PipelinesDelivery{})
+	defaultConfig.IngoingTransformers.Intel.Feeds = append(defaultConfig.IngoingTransformers.Intel.Feeds, pkgconfig.ConfigIntelFeed{})
+	defaultConfig.OutgoingTransformers.Intel.Feeds = append(defaultConfig.OutgoingTransformers.Intel.Feeds, pkgconfig.ConfigIntelFeed{})
 
 	// Convert default config to map
 	// And get unique YAML keys
@@ -94,6 +98,12 @@ func CheckConfig(userConfigPath string, dmRef dnsutils.DNSMessage) error {
 		defaultKeywords[k] = true
 	}
 
+	// add operators of the matching
+	for _, k := range []string{dnsutils.MatchingOpGreaterThan, dnsutils.MatchingOpLowerThan,
+		dnsutils.MatchingOpSource, dnsutils.MatchingOpSourceKind} {
+		defaultKeywords[k] = true
+	}
+
 	// Read user configuration file
 	// And get unique YAML keys from user config
 	userConfigMap, err := loadUserConfigToMap(userConfigPath)
@@ -142,6 +152,39 @@ func CheckConfig(userConfigPath string, dmRef dnsutils.DNSMessage) error {
 		return err
 	}
 
+	// check the expressions of the filtering transformer
+	err = checkFilteringExpression(userConfigMap)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func checkFilteringExpression(data map[string]interface{}) error {
+	for k, v := range data {
+		if filtering, ok := v.(map[string]interface{}); ok && k == "filtering" {
+			if str, ok := filtering["expression"].(string); ok && len(str) > 0 {
+				if _, err := dnsutils.CompileExpression(str); err != nil {
+					return errors.Errorf("invalid filtering expression `%s`: %v", str, err)
+				}
+			}
+		}
+		if nestedMap, ok := v.(map[string]interface{}); ok {
+			if err := checkFilteringExpression(nestedMap); err != nil {
+				return err
+			}
+		}
+		if nestedSlice, ok := v.([]interface{}); ok {
+			for _, item := range nestedSlice {
+				if nestedMap, ok := item.(map[string]interface{}); ok {
+					if err := checkFilteringExpression(nestedMap); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
 	return nil
 }
 
@@ -270,6 +270,78 @@ pipelines:
 }
 
 // Invalid pipeline configuration
+func TestConfig_CheckPipelinesConfig_RoutingRules(t *testing.T) {
+	userConfigFile, err := os.CreateTemp("", "user-config.yaml")
+	if err != nil {
+		t.Fatal("Error creating temporary file:", err)
+	}
+	defer os.Remove(userConfigFile.Name())
+	defer userConfigFile.Close()
+
+	userConfigContent := `
+pipelines:
+- name: filter
+  dnsmessage:
+    matching:
+      include:
+        dns.qname: "^.*\\.google\\.com$"
+  routing-policy:
+    default: [ logfile ]
+    rules:
+      - matching:
+          include:
+            suspicious.score:
+              greater-than: 0
+          exclude:
+            dns.qtype: [ "PTR" ]
+        routes: [ siem ]
+`
+	err = os.WriteFile(userConfigFile.Name(), []byte(userConfigContent), 0644)
+	if err != nil {
+		t.Fatal("Error writing to user configuration file:", err)
+	}
+
+	dm := dnsutils.GetReferenceDNSMessage()
+	if err := CheckConfig(userConfigFile.Name(), dm); err != nil {
+		t.Errorf("failed: Unexpected error: %v", err)
+	}
+}
+
+func TestConfig_CheckPipelinesConfig_IntelFeeds(t *testing.T) {
+	userConfigFile, err := os.CreateTemp("", "user-config.yaml")
+	if err != nil {
+		t.Fatal("Error creating temporary file:", err)
+	}
+	defer os.Remove(userConfigFile.Name())
+	defer userConfigFile.Close()
+
+	userConfigContent := `
+pipelines:
+- name: tap
+  dnstap:
+    listen-ip: 0.0.0.0
+  transforms:
+    intel:
+      refresh-interval: 3600
+      feeds:
+        - name: urlhaus
+          source: https://urlhaus.abuse.ch/downloads/rpz/
+          format: rpz
+          category: malware
+  routing-policy:
+    default: [ console ]
+`
+	err = os.WriteFile(userConfigFile.Name(), []byte(userConfigContent), 0644)
+	if err != nil {
+		t.Fatal("Error writing to user configuration file:", err)
+	}
+
+	dm := dnsutils.GetReferenceDNSMessage()
+	if err := CheckConfig(userConfigFile.Name(), dm); err != nil {
+		t.Errorf("failed: Unexpected error: %v", err)
+	}
+}
+
 func TestConfig_CheckPipelinesConfig_Invalid(t *testing.T) {
 	userConfigFile, err := os.CreateTemp("", "user-config.yaml")
 	if err != nil {
@@ -356,3 +428,34 @@ pipelines:
 		t.Errorf("Expected error, but got nil")
 	}
 }
+
+func TestConfig_CheckPipelines_InvalidFilteringExpression(t *testing.T) {
+	userConfigFile, err := os.CreateTemp("", "user-config.yaml")
+	if err != nil {
+		t.Fatal("Error creating temporary file:", err)
+	}
+	defer os.Remove(userConfigFile.Name())
+	defer userConfigFile.Close()
+
+	userConfigContent := `
+pipelines:
+- name: dnsdist-main
+  dnstap:
+    listen-ip: 0.0.0.0
+  transforms:
+    filtering:
+      expression: "dns.qtype == "
+  routing-policy:
+    default: [ console ]
+`
+
+	err = os.WriteFile(userConfigFile.Name(), []byte(userConfigContent), 0644)
+	if err != nil {
+		t.Fatal("Error writing to user configuration file:", err)
+	}
+
+	dm := dnsutils.GetReferenceDNSMessage()
+	if err = CheckConfig(userConfigFile.Name(), dm); err == nil {
+		t.Errorf("Expected error, but got nil")
+	}
+}
@@ -6,4 +6,7 @@ var (
 	PrefixLogLogger      = "logger - "
 	PrefixLogRouting     = "routing - "
 	PrefixLogTransformer = "transformer - "
+	PrefixLogSpool       = "spool - "
+	PrefixLogAdmin       = "admin - "
+	PrefixLogTelemetry   = "telemetry - "
 )
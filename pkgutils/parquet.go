@@ -0,0 +1,401 @@
+package pkgutils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/golang/snappy"
+)
+
+// Minimal parquet writer: flat schema with required columns only, one data page
+// per column in each row group, plain encoding and snappy compression.
+// See https://github.com/apache/parquet-format
+
+type ParquetType int
+
+const (
+	ParquetBoolean ParquetType = iota
+	ParquetInt64
+	ParquetDouble
+	ParquetString
+)
+
+type ParquetColumn struct {
+	Name string
+	Type ParquetType
+}
+
+const (
+	parquetMagic     = "PAR1"
+	parquetCreatedBy = "go-dnscollector"
+
+	// physical types, encodings and codecs from the parquet format
+	parquetTypeBoolean   = 0
+	parquetTypeInt64     = 2
+	parquetTypeDouble    = 5
+	parquetTypeByteArray = 6
+	parquetConvertedUTF8 = 0
+	parquetRequired      = 0
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+	parquetCodecSnappy   = 1
+	parquetPageData      = 0
+)
+
+// ParquetRowGroupSize is the default size of the buffered rows before to write a row group
+const ParquetRowGroupSize = 16 * 1024 * 1024
+
+var ErrParquetClosed = errors.New("parquet writer is closed")
+
+type parquetChunk struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+type parquetRowGroup struct {
+	chunks    []parquetChunk
+	numRows   int64
+	totalSize int64
+}
+
+type ParquetWriter struct {
+	w            io.Writer
+	columns      []ParquetColumn
+	values       []bytes.Buffer
+	bufferedSize int64
+	rowGroupSize int64
+	numRows      int64
+	totalRows    int64
+	offset       int64
+	rowGroups    []parquetRowGroup
+	closed       bool
+}
+
+// NewParquetWriter writes the header of the file, the rows are buffered in memory
+// and a row group is written when the buffered rows reach the row group size.
+func NewParquetWriter(w io.Writer, columns []ParquetColumn, rowGroupSize int64) (*ParquetWriter, error) {
+	pw := &ParquetWriter{
+		w:            w,
+		columns:      columns,
+		values:       make([]bytes.Buffer, len(columns)),
+		rowGroupSize: rowGroupSize,
+	}
+	if err := pw.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *ParquetWriter) write(data []byte) error {
+	n, err := pw.w.Write(data)
+	pw.offset += int64(n)
+	return err
+}
+
+// WriteRow buffers one row, the values must follow the order and the types of the columns.
+// The buffered rows are written as a new row group when the row group size is reached.
+func (pw *ParquetWriter) WriteRow(values []interface{}) error {
+	if pw.closed {
+		return ErrParquetClosed
+	}
+	if len(values) != len(pw.columns) {
+		return fmt.Errorf("parquet: %d values for %d columns", len(values), len(pw.columns))
+	}
+
+	// check all the values before to update the buffers
+	for i, col := range pw.columns {
+		var ok bool
+		switch col.Type {
+		case ParquetBoolean:
+			_, ok = values[i].(bool)
+		case ParquetInt64:
+			_, ok = values[i].(int64)
+		case ParquetDouble:
+			_, ok = values[i].(float64)
+		case ParquetString:
+			_, ok = values[i].(string)
+		}
+		if !ok {
+			return fmt.Errorf("parquet: invalid value %v for column %s", values[i], col.Name)
+		}
+	}
+
+	pw.bufferedSize = 0
+	for i, col := range pw.columns {
+		buf := &pw.values[i]
+		switch col.Type {
+		case ParquetBoolean:
+			// one byte per value, the bits are packed on flush
+			if values[i].(bool) {
+				buf.WriteByte(1)
+			} else {
+				buf.WriteByte(0)
+			}
+		case ParquetInt64:
+			binary.Write(buf, binary.LittleEndian, values[i].(int64))
+		case ParquetDouble:
+			binary.Write(buf, binary.LittleEndian, math.Float64bits(values[i].(float64)))
+		case ParquetString:
+			s := values[i].(string)
+			binary.Write(buf, binary.LittleEndian, uint32(len(s)))
+			buf.WriteString(s)
+		}
+		pw.bufferedSize += int64(buf.Len())
+	}
+	pw.numRows++
+
+	if pw.rowGroupSize > 0 && pw.bufferedSize >= pw.rowGroupSize {
+		return pw.Flush()
+	}
+	return nil
+}
+
+// Size returns the number of bytes written to the file plus the buffered rows
+func (pw *ParquetWriter) Size() int64 {
+	return pw.offset + pw.bufferedSize
+}
+
+// NumRows returns the total number of rows, buffered or not
+func (pw *ParquetWriter) NumRows() int64 {
+	return pw.totalRows + pw.numRows
+}
+
+// Flush writes the buffered rows as a new row group
+func (pw *ParquetWriter) Flush() error {
+	if pw.closed {
+		return ErrParquetClosed
+	}
+	if pw.numRows == 0 {
+		return nil
+	}
+
+	rowGroup := parquetRowGroup{numRows: pw.numRows}
+	for i, col := range pw.columns {
+		page := pw.values[i].Bytes()
+		if col.Type == ParquetBoolean {
+			page = parquetPackBooleans(page)
+		}
+		compressed := snappy.Encode(nil, page)
+
+		t := thriftCompact{}
+		t.i32(1, parquetPageData)
+		t.i32(2, int32(len(page)))
+		t.i32(3, int32(len(compressed)))
+		t.structBegin(5)
+		t.i32(1, int32(pw.numRows))
+		t.i32(2, parquetEncodingPlain)
+		t.i32(3, parquetEncodingRLE)
+		t.i32(4, parquetEncodingRLE)
+		t.structEnd()
+		t.stop()
+
+		chunk := parquetChunk{
+			offset:           pw.offset,
+			uncompressedSize: int64(t.buf.Len() + len(page)),
+			compressedSize:   int64(t.buf.Len() + len(compressed)),
+		}
+		if err := pw.write(t.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := pw.write(compressed); err != nil {
+			return err
+		}
+		rowGroup.chunks = append(rowGroup.chunks, chunk)
+		rowGroup.totalSize += chunk.uncompressedSize
+		pw.values[i].Reset()
+	}
+	pw.bufferedSize = 0
+
+	pw.rowGroups = append(pw.rowGroups, rowGroup)
+	pw.totalRows += pw.numRows
+	pw.numRows = 0
+	return nil
+}
+
+// Close flushes the buffered rows and writes the footer, the underlying writer is not closed
+func (pw *ParquetWriter) Close() error {
+	if pw.closed {
+		return nil
+	}
+	if err := pw.Flush(); err != nil {
+		return err
+	}
+	pw.closed = true
+
+	t := thriftCompact{}
+	t.i32(1, 1)
+
+	// schema: the root then one element per column
+	t.listBegin(2, thriftStruct, len(pw.columns)+1)
+	t.elemBegin()
+	t.binary(4, []byte("schema"))
+	t.i32(5, int32(len(pw.columns)))
+	t.elemEnd()
+	for _, col := range pw.columns {
+		t.elemBegin()
+		t.i32(1, parquetPhysicalType(col.Type))
+		t.i32(3, parquetRequired)
+		t.binary(4, []byte(col.Name))
+		if col.Type == ParquetString {
+			t.i32(6, parquetConvertedUTF8)
+		}
+		t.elemEnd()
+	}
+
+	t.i64(3, pw.totalRows)
+
+	t.listBegin(4, thriftStruct, len(pw.rowGroups))
+	for _, rowGroup := range pw.rowGroups {
+		t.elemBegin()
+		t.listBegin(1, thriftStruct, len(rowGroup.chunks))
+		for i, chunk := range rowGroup.chunks {
+			t.elemBegin()
+			t.i64(2, chunk.offset)
+			t.structBegin(3)
+			t.i32(1, parquetPhysicalType(pw.columns[i].Type))
+			t.listBegin(2, thriftI32, 1)
+			t.varint(parquetEncodingPlain)
+			t.listBegin(3, thriftBinary, 1)
+			t.uvarint(uint64(len(pw.columns[i].Name)))
+			t.buf.WriteString(pw.columns[i].Name)
+			t.i32(4, parquetCodecSnappy)
+			t.i64(5, rowGroup.numRows)
+			t.i64(6, chunk.uncompressedSize)
+			t.i64(7, chunk.compressedSize)
+			t.i64(9, chunk.offset)
+			t.structEnd()
+			t.elemEnd()
+		}
+		t.i64(2, rowGroup.totalSize)
+		t.i64(3, rowGroup.numRows)
+		t.elemEnd()
+	}
+
+	t.binary(6, []byte(parquetCreatedBy))
+	t.stop()
+
+	footer := t.buf.Bytes()
+	if err := pw.write(footer); err != nil {
+		return err
+	}
+	if err := binary.Write(pw.w, binary.LittleEndian, uint32(len(footer))); err != nil {
+		return err
+	}
+	pw.offset += 4
+	return pw.write([]byte(parquetMagic))
+}
+
+func parquetPhysicalType(t ParquetType) int32 {
+	switch t {
+	case ParquetBoolean:
+		return parquetTypeBoolean
+	case ParquetInt64:
+		return parquetTypeInt64
+	case ParquetDouble:
+		return parquetTypeDouble
+	}
+	return parquetTypeByteArray
+}
+
+// booleans are bit packed, the least significant bit first
+func parquetPackBooleans(values []byte) []byte {
+	packed := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v == 1 {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return packed
+}
+
+// thrift compact protocol, only what is needed for the parquet metadata
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+type thriftCompact struct {
+	buf       bytes.Buffer
+	lastField int16
+	stack     []int16
+}
+
+func (t *thriftCompact) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	t.buf.Write(b[:n])
+}
+
+// zigzag encoding for the signed integers
+func (t *thriftCompact) varint(v int64) {
+	t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftCompact) field(id int16, fieldType byte) {
+	if delta := id - t.lastField; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		t.buf.WriteByte(fieldType)
+		t.varint(int64(id))
+	}
+	t.lastField = id
+}
+
+func (t *thriftCompact) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftCompact) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftCompact) binary(id int16, v []byte) {
+	t.field(id, thriftBinary)
+	t.uvarint(uint64(len(v)))
+	t.buf.Write(v)
+}
+
+func (t *thriftCompact) listBegin(id int16, elemType byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elemType)
+	t.uvarint(uint64(size))
+}
+
+func (t *thriftCompact) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thriftCompact) structEnd() {
+	t.elemEnd()
+}
+
+// struct in a list, without field header
+func (t *thriftCompact) elemBegin() {
+	t.stack = append(t.stack, t.lastField)
+	t.lastField = 0
+}
+
+func (t *thriftCompact) elemEnd() {
+	t.stop()
+	t.lastField = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thriftCompact) stop() {
+	t.buf.WriteByte(0)
+}
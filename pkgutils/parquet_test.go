@@ -0,0 +1,203 @@
+package pkgutils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+// decodes a thrift compact struct to a map of field id to value
+func thriftDecodeStruct(t *testing.T, r *bytes.Reader) map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var lastField int16
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b == 0 {
+			return fields
+		}
+		fieldType := b & 0x0f
+		if delta := int16(b >> 4); delta != 0 {
+			lastField += delta
+		} else {
+			id, _ := binary.ReadUvarint(r)
+			lastField = int16((id >> 1) ^ -(id & 1))
+		}
+		fields[lastField] = thriftDecodeValue(t, r, fieldType)
+	}
+}
+
+func thriftDecodeValue(t *testing.T, r *bytes.Reader, valueType byte) interface{} {
+	switch valueType {
+	case thriftI32, thriftI64:
+		v, _ := binary.ReadUvarint(r)
+		return int64(v>>1) ^ -int64(v&1)
+	case thriftBinary:
+		n, _ := binary.ReadUvarint(r)
+		v := make([]byte, n)
+		r.Read(v)
+		return string(v)
+	case thriftList:
+		b, _ := r.ReadByte()
+		size := uint64(b >> 4)
+		if size == 15 {
+			size, _ = binary.ReadUvarint(r)
+		}
+		list := []interface{}{}
+		for i := uint64(0); i < size; i++ {
+			list = append(list, thriftDecodeValue(t, r, b&0x0f))
+		}
+		return list
+	case thriftStruct:
+		return thriftDecodeStruct(t, r)
+	}
+	t.Fatalf("unsupported thrift type %d", valueType)
+	return nil
+}
+
+func TestParquet_WriteAndRead(t *testing.T) {
+	columns := []ParquetColumn{
+		{Name: "dns.qname", Type: ParquetString},
+		{Name: "dns.id", Type: ParquetInt64},
+		{Name: "dns.flags.qr", Type: ParquetBoolean},
+		{Name: "latency", Type: ParquetDouble},
+	}
+
+	var buf bytes.Buffer
+	pw, err := NewParquetWriter(&buf, columns, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid rows
+	if err := pw.WriteRow([]interface{}{"dnscollector.dev"}); err == nil {
+		t.Errorf("error expected on missing values")
+	}
+	if err := pw.WriteRow([]interface{}{"dnscollector.dev", 1, true, 0.1}); err == nil {
+		t.Errorf("error expected on invalid type")
+	}
+
+	// two row groups
+	qnames := []string{"www.dnscollector.dev", "dnscollector.dev", "github.com"}
+	for i, qname := range qnames {
+		if err := pw.WriteRow([]interface{}{qname, int64(i), i%2 == 0, float64(i) / 10}); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			pw.Flush()
+		}
+	}
+	if pw.NumRows() != 3 {
+		t.Errorf("invalid number of rows: %d", pw.NumRows())
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if pw.Size() != int64(buf.Len()) {
+		t.Errorf("invalid size: %d, file is %d", pw.Size(), buf.Len())
+	}
+	if err := pw.WriteRow([]interface{}{"dnscollector.dev", int64(1), true, 0.1}); err != ErrParquetClosed {
+		t.Errorf("writer should be closed")
+	}
+
+	// check the magic and read the footer
+	data := buf.Bytes()
+	if string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		t.Fatalf("invalid magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := thriftDecodeStruct(t, bytes.NewReader(data[len(data)-8-footerLen:len(data)-8]))
+
+	if footer[3].(int64) != 3 {
+		t.Errorf("invalid number of rows in footer: %v", footer[3])
+	}
+	schema := footer[2].([]interface{})
+	if len(schema) != len(columns)+1 {
+		t.Fatalf("invalid schema: %v", schema)
+	}
+	for i, col := range columns {
+		if name := schema[i+1].(map[int16]interface{})[4]; name != col.Name {
+			t.Errorf("invalid column name: %v", name)
+		}
+	}
+
+	// read the values of each column
+	rowGroups := footer[4].([]interface{})
+	if len(rowGroups) != 2 {
+		t.Fatalf("two row groups expected, got %d", len(rowGroups))
+	}
+	var gotQnames []string
+	var gotIDs []int64
+	var gotQR []bool
+	var gotLatency []float64
+	for _, rg := range rowGroups {
+		numRows := int(rg.(map[int16]interface{})[3].(int64))
+		for i, chunk := range rg.(map[int16]interface{})[1].([]interface{}) {
+			meta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			r := bytes.NewReader(data[meta[9].(int64):])
+			header := thriftDecodeStruct(t, r)
+			compressed := make([]byte, header[3].(int64))
+			r.Read(compressed)
+			page, err := snappy.Decode(nil, compressed)
+			if err != nil || len(page) != int(header[2].(int64)) {
+				t.Fatalf("invalid page: %v", err)
+			}
+
+			for n := 0; n < numRows; n++ {
+				switch columns[i].Type {
+				case ParquetString:
+					size := binary.LittleEndian.Uint32(page)
+					gotQnames = append(gotQnames, string(page[4:4+size]))
+					page = page[4+size:]
+				case ParquetInt64:
+					gotIDs = append(gotIDs, int64(binary.LittleEndian.Uint64(page[n*8:])))
+				case ParquetBoolean:
+					gotQR = append(gotQR, page[n/8]&(1<<(n%8)) != 0)
+				case ParquetDouble:
+					gotLatency = append(gotLatency, math.Float64frombits(binary.LittleEndian.Uint64(page[n*8:])))
+				}
+			}
+		}
+	}
+
+	for i := range qnames {
+		if gotQnames[i] != qnames[i] || gotIDs[i] != int64(i) || gotQR[i] != (i%2 == 0) || gotLatency[i] != float64(i)/10 {
+			t.Errorf("invalid row %d: %s %d %v %f", i, gotQnames[i], gotIDs[i], gotQR[i], gotLatency[i])
+		}
+	}
+}
+
+func TestParquet_RowGroupSize(t *testing.T) {
+	columns := []ParquetColumn{{Name: "dns.id", Type: ParquetInt64}}
+
+	// a row group is written every two rows
+	var buf bytes.Buffer
+	pw, err := NewParquetWriter(&buf, columns, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := pw.WriteRow([]interface{}{int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pw.rowGroups) != 2 {
+		t.Errorf("2 row groups expected, got %d", len(pw.rowGroups))
+	}
+	if pw.NumRows() != 5 {
+		t.Errorf("invalid number of rows: %d", pw.NumRows())
+	}
+
+	// the remaining row is written on close
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(pw.rowGroups) != 3 {
+		t.Errorf("3 row groups expected, got %d", len(pw.rowGroups))
+	}
+}
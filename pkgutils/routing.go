@@ -1,6 +1,7 @@
 package pkgutils
 
 import (
+	"sync"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -22,37 +23,146 @@ func GetRoutes(routes []Worker) ([]chan dnsutils.DNSMessage, []string) {
 	return channels, names
 }
 
+// BlockingWorker is implemented by the workers asking the previous stanza
+// to wait instead of dropping when their input channel is full
+type BlockingWorker interface {
+	IsBlocking() bool
+}
+
+// IsBlocking returns true if one of the workers asks for backpressure
+func IsBlocking(workers []Worker) bool {
+	for _, wrk := range workers {
+		if bw, ok := wrk.(BlockingWorker); ok && bw.IsBlocking() {
+			return true
+		}
+	}
+	return false
+}
+
 func GetName(name string) string {
 	return "[" + name + "] - "
 }
 
+type RoutingRule struct {
+	include map[string]interface{}
+	exclude map[string]interface{}
+	routes  []string
+}
+
+// Match returns true if the dns message is matching the include conditions and not the exclude ones
+func (r *RoutingRule) Match(dm *dnsutils.DNSMessage) (bool, error) {
+	if len(r.include) > 0 {
+		err, matched := dm.Matching(r.include)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	if len(r.exclude) > 0 {
+		err, matched := dm.Matching(r.exclude)
+		if err != nil || matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 type RoutingHandler struct {
-	name          string
-	logger        *logger.Logger
-	config        *pkgconfig.Config
-	stopRun       chan bool
-	doneRun       chan bool
-	droppedCount  map[string]int
-	dropped       chan string
-	droppedRoutes []Worker
-	defaultRoutes []Worker
+	name           string
+	logger         *logger.Logger
+	config         *pkgconfig.Config
+	stopRun        chan bool
+	doneRun        chan bool
+	cancelDelivery chan bool
+	droppedCount   map[string]int
+	droppedTotal   map[string]uint64
+	droppedMutex   *sync.RWMutex
+	dropped        chan string
+	droppedRoutes  []Worker
+	defaultRoutes  []Worker
+	rules          []RoutingRule
+	defaultNames   []string
+	deliveries     map[string]pkgconfig.PipelinesDelivery
 }
 
 func NewRoutingHandler(config *pkgconfig.Config, console *logger.Logger, name string) RoutingHandler {
 	console.Info("routing - [%s] - initialization...", name)
 	rh := RoutingHandler{
-		name:         name,
-		logger:       console,
-		config:       config,
-		stopRun:      make(chan bool),
-		doneRun:      make(chan bool),
-		dropped:      make(chan string),
-		droppedCount: map[string]int{},
+		name:           name,
+		logger:         console,
+		config:         config,
+		stopRun:        make(chan bool),
+		doneRun:        make(chan bool),
+		cancelDelivery: make(chan bool),
+		dropped:        make(chan string),
+		droppedCount:   map[string]int{},
+		droppedTotal:   map[string]uint64{},
+		droppedMutex:   &sync.RWMutex{},
 	}
+	rh.LoadRoutingRules()
 	go rh.Run()
 	return rh
 }
 
+// LoadRoutingRules reads the conditional routing rules and the delivery of the routes,
+// only available with pipelines
+func (rh *RoutingHandler) LoadRoutingRules() {
+	rh.rules = rh.rules[:0]
+	rh.defaultNames = rh.defaultNames[:0]
+	rh.deliveries = make(map[string]pkgconfig.PipelinesDelivery)
+
+	for _, stanza := range rh.config.Pipelines {
+		if stanza.Name != rh.name {
+			continue
+		}
+		for _, rule := range stanza.RoutingPolicy.Rules {
+			rh.rules = append(rh.rules, RoutingRule{
+				include: rule.Matching.Include,
+				exclude: rule.Matching.Exclude,
+				routes:  rule.Routes,
+			})
+		}
+		rh.defaultNames = append(rh.defaultNames, stanza.RoutingPolicy.Default...)
+		for _, delivery := range stanza.RoutingPolicy.Delivery {
+			for _, route := range delivery.Routes {
+				rh.deliveries[route] = delivery
+			}
+		}
+	}
+
+	if len(rh.rules) > 0 {
+		rh.LogInfo("conditional routing enabled with %d rule(s)", len(rh.rules))
+	}
+}
+
+// ReloadConfig updates the config and the routing rules
+func (rh *RoutingHandler) ReloadConfig(config *pkgconfig.Config) {
+	rh.config = config
+	rh.LoadRoutingRules()
+}
+
+// SelectRoutes applies the routing rules on the dns message and returns, for each route
+// referenced by the rules or the default policy, if the message must be sent to it.
+// Messages not matching any rule are sent to the default routes.
+func (rh *RoutingHandler) SelectRoutes(dm *dnsutils.DNSMessage) map[string]bool {
+	selected := make(map[string]bool)
+	matched := false
+	for i := range rh.rules {
+		match, err := rh.rules[i].Match(dm)
+		if err != nil {
+			rh.LogError("routing rule #%d: %v", i, err)
+		}
+		for _, route := range rh.rules[i].routes {
+			selected[route] = selected[route] || match
+		}
+		matched = matched || match
+	}
+
+	for _, route := range rh.defaultNames {
+		selected[route] = selected[route] || !matched
+	}
+	return selected
+}
+
 func (rh *RoutingHandler) LogInfo(msg string, v ...interface{}) {
 	rh.logger.Info(PrefixLogRouting+GetName(rh.name)+msg, v...)
 }
@@ -77,6 +187,10 @@ func (rh *RoutingHandler) SetDefaultRoutes(workers []Worker) {
 	rh.defaultRoutes = workers
 }
 
+func (rh *RoutingHandler) SetDroppedRoutes(workers []Worker) {
+	rh.droppedRoutes = workers
+}
+
 func (rh *RoutingHandler) GetDefaultRoutes() ([]chan dnsutils.DNSMessage, []string) {
 	return GetRoutes(rh.defaultRoutes)
 }
@@ -85,8 +199,97 @@ func (rh *RoutingHandler) GetDroppedRoutes() ([]chan dnsutils.DNSMessage, []stri
 	return GetRoutes(rh.droppedRoutes)
 }
 
+// GetDroppedCounters returns the number of messages dropped per route since the start
+func (rh *RoutingHandler) GetDroppedCounters() map[string]uint64 {
+	rh.droppedMutex.RLock()
+	defer rh.droppedMutex.RUnlock()
+
+	counters := make(map[string]uint64, len(rh.droppedTotal))
+	for route, count := range rh.droppedTotal {
+		counters[route] = count
+	}
+	return counters
+}
+
+// IsBlocking returns true if one of the routes waits when its channel is full
+func (rh *RoutingHandler) IsBlocking() bool {
+	for _, delivery := range rh.deliveries {
+		if delivery.Mode == pkgconfig.DeliveryBlock || delivery.Mode == pkgconfig.DeliveryBlockTimeout {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDelivery returns the delivery of the route, drop by default.
+// The routers asking for backpressure are blocking, to propagate it to the stanza.
+func (rh *RoutingHandler) GetDelivery(route string) pkgconfig.PipelinesDelivery {
+	if delivery, ok := rh.deliveries[route]; ok {
+		return delivery
+	}
+	for _, routes := range [][]Worker{rh.defaultRoutes, rh.droppedRoutes} {
+		for _, wrk := range routes {
+			if wrk.GetName() == route && IsBlocking([]Worker{wrk}) {
+				return pkgconfig.PipelinesDelivery{Mode: pkgconfig.DeliveryBlock}
+			}
+		}
+	}
+	return pkgconfig.PipelinesDelivery{Mode: pkgconfig.DeliveryDrop}
+}
+
+// DeliveryCancelled is closed when the routing handler is stopped, to unblock the senders
+func (rh *RoutingHandler) DeliveryCancelled() <-chan bool {
+	return rh.cancelDelivery
+}
+
+// Deliver sends the message to the route according to its delivery,
+// returns false if the message is dropped
+func (rh *RoutingHandler) Deliver(route chan dnsutils.DNSMessage, routeName string, dm dnsutils.DNSMessage) bool {
+	delivered, _ := rh.deliver(route, routeName, dm, nil)
+	return delivered
+}
+
+// deliver waits on the blocking deliveries until the message is sent, the handler
+// is stopped or the interrupt channel is signaled. Returns true as second value
+// if interrupted, the message is then neither sent nor dropped.
+func (rh *RoutingHandler) deliver(route chan dnsutils.DNSMessage, routeName string, dm dnsutils.DNSMessage, interrupt <-chan bool) (bool, bool) {
+	select {
+	case route <- dm:
+		return true, false
+	default:
+	}
+
+	delivery := rh.GetDelivery(routeName)
+	switch delivery.Mode {
+	case pkgconfig.DeliveryBlock:
+		select {
+		case route <- dm:
+			return true, false
+		case <-rh.cancelDelivery:
+			return false, false
+		case <-interrupt:
+			return false, true
+		}
+	case pkgconfig.DeliveryBlockTimeout:
+		timer := time.NewTimer(time.Duration(delivery.Timeout) * time.Second)
+		defer timer.Stop()
+		select {
+		case route <- dm:
+			return true, false
+		case <-timer.C:
+			return false, false
+		case <-rh.cancelDelivery:
+			return false, false
+		case <-interrupt:
+			return false, true
+		}
+	}
+	return false, false
+}
+
 func (rh *RoutingHandler) Stop() {
 	rh.LogInfo("stopping to run...")
+	close(rh.cancelDelivery)
 	rh.stopRun <- true
 	<-rh.doneRun
 }
@@ -109,6 +312,9 @@ RUN_LOOP:
 			} else {
 				rh.droppedCount[stanzaName]++
 			}
+			rh.droppedMutex.Lock()
+			rh.droppedTotal[stanzaName]++
+			rh.droppedMutex.Unlock()
 		case <-nextBufferFull.C:
 			for v, k := range rh.droppedCount {
 				if k > 0 {
@@ -123,12 +329,53 @@ RUN_LOOP:
 	rh.LogInfo("run terminated")
 }
 
-func (rh *RoutingHandler) SendTo(routes []chan dnsutils.DNSMessage, routesName []string, dm dnsutils.DNSMessage) {
+// SendTo delivers the message to the selected routes, returns false if at least one route dropped it
+func (rh *RoutingHandler) SendTo(routes []chan dnsutils.DNSMessage, routesName []string, dm dnsutils.DNSMessage) bool {
+	delivered, _ := rh.sendTo(routes, routesName, dm, nil)
+	return delivered
+}
+
+// SendToInterruptible is like SendTo but a blocking delivery stops when the interrupt channel
+// is signaled. Returns the names of the routes not served yet, the message must be sent
+// again to them.
+func (rh *RoutingHandler) SendToInterruptible(routes []chan dnsutils.DNSMessage, routesName []string, dm dnsutils.DNSMessage, interrupt <-chan bool) []string {
+	_, pending := rh.sendTo(routes, routesName, dm, interrupt)
+	return pending
+}
+
+func (rh *RoutingHandler) sendTo(routes []chan dnsutils.DNSMessage, routesName []string, dm dnsutils.DNSMessage, interrupt <-chan bool) (bool, []string) {
+	// conditional routing, the next stanzas are selected according to the message
+	var selected map[string]bool
+	if len(rh.rules) > 0 {
+		selected = rh.SelectRoutes(&dm)
+	}
+
+	delivered := true
 	for i := range routes {
+		if send, ok := selected[routesName[i]]; ok && !send {
+			continue
+		}
+		sent, interrupted := rh.deliver(routes[i], routesName[i], dm, interrupt)
+		if interrupted {
+			// the current route and the next ones are not served
+			pending := []string{}
+			for _, name := range routesName[i:] {
+				if send, ok := selected[name]; !ok || send {
+					pending = append(pending, name)
+				}
+			}
+			return delivered, pending
+		}
+		if sent {
+			Telemetry.RecordRouted(rh.name, routesName[i], true)
+			continue
+		}
+		delivered = false
+		Telemetry.RecordRouted(rh.name, routesName[i], false)
 		select {
-		case routes[i] <- dm:
-		default:
-			rh.dropped <- routesName[i]
+		case rh.dropped <- routesName[i]:
+		case <-rh.cancelDelivery:
 		}
 	}
+	return delivered, nil
 }
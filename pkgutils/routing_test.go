@@ -103,6 +103,137 @@ func Test_RoutingHandler_BufferIsFull(t *testing.T) {
 		t.Errorf("invalid qname in second dns message: %s", dmOut2.DNS.Qname)
 	}
 
+	// dropped messages are also counted since the start
+	if dropped := rh.GetDroppedCounters()[nxt.GetName()]; dropped != 1534 {
+		t.Errorf("invalid number of dropped messages: %d", dropped)
+	}
+
+	// stop
+	rh.Stop()
+}
+
+func Test_RoutingHandler_ConditionalRouting(t *testing.T) {
+	// routing policy of the stanza
+	config := pkgconfig.GetFakeConfig()
+	stanza := pkgconfig.ConfigPipelines{Name: "test"}
+	stanza.RoutingPolicy.Default = []string{"logfile"}
+	rule := pkgconfig.PipelinesRoutingRule{Routes: []string{"siem"}}
+	rule.Matching.Include = map[string]interface{}{
+		"suspicious.score": map[string]interface{}{dnsutils.MatchingOpGreaterThan: 0},
+	}
+	stanza.RoutingPolicy.Rules = append(stanza.RoutingPolicy.Rules, rule)
+	config.Pipelines = append(config.Pipelines, stanza)
+
+	// create routing handler
+	rh := NewRoutingHandler(config, logger.New(false), "test")
+
+	siem := NewFakeLogger()
+	siem.name = "siem"
+	logfile := NewFakeLogger()
+	logfile.name = "logfile"
+	rh.AddDefaultRoute(logfile)
+	rh.AddDefaultRoute(siem)
+	defaultRoutes, defaultNames := rh.GetDefaultRoutes()
+
+	// not suspicious message, sent to the default route only
+	dmIn := dnsutils.GetFakeDNSMessage()
+	dmIn.Suspicious = &dnsutils.TransformSuspicious{Score: 0.0}
+	rh.SendTo(defaultRoutes, defaultNames, dmIn)
+
+	if len(siem.GetInputChannel()) != 0 || len(logfile.GetInputChannel()) != 1 {
+		t.Errorf("message should be routed to logfile only")
+	}
+	<-logfile.GetInputChannel()
+
+	// suspicious message
+	dmIn.Suspicious.Score = 1.5
+	rh.SendTo(defaultRoutes, defaultNames, dmIn)
+
+	if len(siem.GetInputChannel()) != 1 || len(logfile.GetInputChannel()) != 0 {
+		t.Errorf("message should be routed to siem only")
+	}
+
 	// stop
 	rh.Stop()
 }
+
+func Test_RoutingHandler_Delivery(t *testing.T) {
+	// delivery of the routes of the stanza
+	config := pkgconfig.GetFakeConfig()
+	stanza := pkgconfig.ConfigPipelines{Name: "test"}
+	stanza.RoutingPolicy.Default = []string{"block", "timeout", "drop"}
+	stanza.RoutingPolicy.Delivery = []pkgconfig.PipelinesDelivery{
+		{Routes: []string{"block"}, Mode: pkgconfig.DeliveryBlock},
+		{Routes: []string{"timeout"}, Mode: pkgconfig.DeliveryBlockTimeout, Timeout: 1},
+	}
+	config.Pipelines = append(config.Pipelines, stanza)
+
+	rh := NewRoutingHandler(config, logger.New(false), "test")
+	if !rh.IsBlocking() {
+		t.Errorf("routing handler should be blocking")
+	}
+
+	block := NewFakeLoggerWithBufferSize(1)
+	block.name = "block"
+	timeout := NewFakeLoggerWithBufferSize(1)
+	timeout.name = "timeout"
+	drop := NewFakeLoggerWithBufferSize(1)
+	drop.name = "drop"
+	dmIn := dnsutils.GetFakeDNSMessage()
+
+	// drop mode, the message is dropped immediately
+	rh.AddDefaultRoute(drop)
+	routes, names := rh.GetDefaultRoutes()
+	rh.SendTo(routes, names, dmIn)
+	rh.SendTo(routes, names, dmIn)
+	if len(drop.GetInputChannel()) != 1 {
+		t.Errorf("one message expected in the drop route")
+	}
+
+	// block with timeout, the message is dropped after the timeout
+	rh.SetDefaultRoutes([]Worker{timeout})
+	routes, names = rh.GetDefaultRoutes()
+	rh.SendTo(routes, names, dmIn)
+	start := time.Now()
+	rh.SendTo(routes, names, dmIn)
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("delivery should wait the timeout, elapsed %s", elapsed)
+	}
+
+	// block, the message is sent when the next stanza reads its channel
+	rh.SetDefaultRoutes([]Worker{block})
+	routes, names = rh.GetDefaultRoutes()
+	rh.SendTo(routes, names, dmIn)
+	sent := make(chan bool)
+	go func() {
+		rh.SendTo(routes, names, dmIn)
+		sent <- true
+	}()
+	select {
+	case <-sent:
+		t.Fatalf("delivery should be blocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+	<-block.GetInputChannel()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatalf("delivery should be unblocked")
+	}
+
+	// stopping the routing handler unblocks the delivery
+	go func() {
+		rh.SendTo(routes, names, dmIn)
+		sent <- true
+	}()
+	rh.Stop()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatalf("delivery should be cancelled on stop")
+	}
+
+	if dropped := rh.GetDroppedCounters(); dropped["drop"] != 1 || dropped["timeout"] != 1 {
+		t.Errorf("invalid dropped counters: %v", dropped)
+	}
+}
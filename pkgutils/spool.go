@@ -0,0 +1,597 @@
+package pkgutils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// interval and max number of messages replayed by the loggers
+	SpoolReplayInterval = 100 * time.Millisecond
+	SpoolReplayLimit    = 10000
+
+	spoolStatsInterval = 30 * time.Second
+	spoolHeaderSize    = 12 // timestamp (8 bytes) and length (4 bytes) of the record
+	spoolSegmentExt    = ".seg"
+	spoolCursorFile    = "cursor"
+)
+
+var ErrSpoolFull = errors.New("spool is full")
+
+// list of the opened spools, exported as metrics
+var spools = struct {
+	sync.Mutex
+	items map[string]*DiskSpool
+}{items: make(map[string]*DiskSpool)}
+
+type spoolSegment struct {
+	id   uint64
+	size int64
+}
+
+type spoolPosition struct {
+	segment int
+	offset  int64
+	count   int
+	next    time.Time
+}
+
+// DiskSpool is a bounded queue of DNS messages stored on disk in segments.
+// The messages are written at the end of the last segment and replayed in order
+// from the first one, fully replayed segments are removed.
+type DiskSpool struct {
+	sync.Mutex
+	name        string
+	directory   string
+	maxSize     int64
+	segmentSize int64
+	logger      *logger.Logger
+	segments    []*spoolSegment
+	writer      *os.File
+	readOffset  int64
+	size        int64
+	count       int
+	dropped     int
+	headTime    time.Time
+	lastStats   time.Time
+}
+
+// OpenDiskSpool opens the spool of the logger, messages not replayed
+// during the previous run are kept.
+func OpenDiskSpool(config pkgconfig.ConfigSpool, name string, logger *logger.Logger) (*DiskSpool, error) {
+	s := &DiskSpool{
+		name:        name,
+		directory:   filepath.Join(config.Directory, name),
+		maxSize:     int64(config.MaxSize) * 1024 * 1024,
+		segmentSize: int64(config.SegmentSize) * 1024 * 1024,
+		logger:      logger,
+	}
+
+	if err := os.MkdirAll(s.directory, 0o750); err != nil {
+		return nil, err
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	spools.Lock()
+	spools.items[name] = s
+	spools.Unlock()
+
+	s.LogInfo("opened in %s, %d message(s) to replay", s.directory, s.count)
+	return s, nil
+}
+
+func (s *DiskSpool) LogInfo(msg string, v ...interface{}) {
+	s.logger.Info(PrefixLogSpool+GetName(s.name)+msg, v...)
+}
+
+func (s *DiskSpool) LogError(msg string, v ...interface{}) {
+	s.logger.Error(PrefixLogSpool+GetName(s.name)+msg, v...)
+}
+
+func (s *DiskSpool) segmentPath(id uint64) string {
+	return filepath.Join(s.directory, fmt.Sprintf("%020d%s", id, spoolSegmentExt))
+}
+
+// load reads the segments and the cursor of the previous run
+func (s *DiskSpool) load() error {
+	files, err := os.ReadDir(s.directory)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), spoolSegmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, &spoolSegment{id: id})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].id < s.segments[j].id })
+
+	// segments before the cursor are already replayed
+	var cursorID uint64
+	var cursorOffset int64
+	if data, err := os.ReadFile(filepath.Join(s.directory, spoolCursorFile)); err == nil {
+		fmt.Sscanf(string(data), "%d %d", &cursorID, &cursorOffset)
+	}
+	for len(s.segments) > 0 && s.segments[0].id < cursorID {
+		os.Remove(s.segmentPath(s.segments[0].id))
+		s.segments = s.segments[1:]
+	}
+	if len(s.segments) > 0 && s.segments[0].id == cursorID {
+		s.readOffset = cursorOffset
+	}
+
+	// count the messages to replay, incomplete records are truncated
+	for i, seg := range s.segments {
+		offset := int64(0)
+		if i == 0 {
+			offset = s.readOffset
+		}
+		count, end, first, err := scanSegment(s.segmentPath(seg.id), offset)
+		if err != nil {
+			return err
+		}
+		if err := os.Truncate(s.segmentPath(seg.id), end); err != nil {
+			return err
+		}
+		if s.count == 0 && count > 0 {
+			s.headTime = first
+		}
+		seg.size = end
+		s.count += count
+		s.size += end - offset
+	}
+
+	if s.count == 0 {
+		s.reset()
+	}
+	return nil
+}
+
+func scanSegment(path string, offset int64) (int, int64, time.Time, error) {
+	var first time.Time
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, first, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, first, err
+	}
+
+	r := bufio.NewReader(f)
+	count := 0
+	for {
+		ts, data, err := readSpoolRecord(r)
+		if err != nil {
+			break
+		}
+		if count == 0 {
+			first = ts
+		}
+		count++
+		offset += int64(spoolHeaderSize + len(data))
+	}
+	return count, offset, first, nil
+}
+
+func readSpoolRecord(r *bufio.Reader) (time.Time, []byte, error) {
+	header := make([]byte, spoolHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return time.Time{}, nil, err
+	}
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8])))
+	data := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return time.Time{}, nil, err
+	}
+	return ts, data, nil
+}
+
+// reset removes all segments when all messages are replayed
+func (s *DiskSpool) reset() {
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	for _, seg := range s.segments {
+		os.Remove(s.segmentPath(seg.id))
+	}
+	s.segments = nil
+	s.readOffset = 0
+	s.size = 0
+	s.count = 0
+	os.Remove(filepath.Join(s.directory, spoolCursorFile))
+}
+
+func (s *DiskSpool) rotate() error {
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+
+	id := uint64(time.Now().UnixNano())
+	if n := len(s.segments); n > 0 && s.segments[n-1].id >= id {
+		id = s.segments[n-1].id + 1
+	}
+
+	f, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	s.writer = f
+	s.segments = append(s.segments, &spoolSegment{id: id})
+	return nil
+}
+
+// Push writes the messages at the end of the spool, messages are dropped when the spool is full
+func (s *DiskSpool) Push(dms ...dnsutils.DNSMessage) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var ret error
+	for _, dm := range dms {
+		data, err := json.Marshal(dm)
+		if err != nil {
+			s.LogError("unable to encode the message: %s", err)
+			continue
+		}
+
+		recordSize := int64(spoolHeaderSize + len(data))
+		if s.size+recordSize > s.maxSize {
+			s.dropped++
+			ret = ErrSpoolFull
+			continue
+		}
+
+		// new segment ?
+		n := len(s.segments)
+		if s.writer == nil || (s.segments[n-1].size > 0 && s.segments[n-1].size+recordSize > s.segmentSize) {
+			if err := s.rotate(); err != nil {
+				s.LogError("unable to create segment: %s", err)
+				s.dropped++
+				ret = err
+				continue
+			}
+		}
+
+		now := time.Now()
+		record := make([]byte, spoolHeaderSize, recordSize)
+		binary.BigEndian.PutUint64(record[0:8], uint64(now.UnixNano()))
+		binary.BigEndian.PutUint32(record[8:12], uint32(len(data)))
+		record = append(record, data...)
+
+		if _, err := s.writer.Write(record); err != nil {
+			s.LogError("unable to write segment: %s", err)
+			s.dropped++
+			ret = err
+			continue
+		}
+
+		s.segments[len(s.segments)-1].size += recordSize
+		s.size += recordSize
+		if s.count == 0 {
+			s.headTime = now
+		}
+		s.count++
+	}
+	return ret
+}
+
+// peek reads the next messages without removing them from the spool
+func (s *DiskSpool) peek(max int) ([]dnsutils.DNSMessage, spoolPosition, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	dms := []dnsutils.DNSMessage{}
+	pos := spoolPosition{offset: s.readOffset}
+
+	for pos.segment < len(s.segments) {
+		f, err := os.Open(s.segmentPath(s.segments[pos.segment].id))
+		if err != nil {
+			return dms, pos, err
+		}
+		r := bufio.NewReader(io.NewSectionReader(f, pos.offset, s.segments[pos.segment].size-pos.offset))
+
+		for {
+			ts, data, err := readSpoolRecord(r)
+			if err != nil {
+				break
+			}
+			// keep the time of the next message for the age of the spool
+			if len(dms) == max {
+				pos.next = ts
+				break
+			}
+
+			dm := dnsutils.DNSMessage{}
+			if err := json.Unmarshal(data, &dm); err != nil {
+				s.LogError("unable to decode the message: %s", err)
+			} else {
+				dms = append(dms, dm)
+			}
+			pos.offset += int64(spoolHeaderSize + len(data))
+			pos.count++
+		}
+		f.Close()
+
+		if !pos.next.IsZero() || pos.segment == len(s.segments)-1 {
+			break
+		}
+		pos.segment++
+		pos.offset = 0
+	}
+	return dms, pos, nil
+}
+
+// commit removes the messages read by peek
+func (s *DiskSpool) commit(pos spoolPosition) {
+	s.Lock()
+	defer s.Unlock()
+
+	if pos.count >= s.count {
+		s.reset()
+		return
+	}
+
+	for i := 0; i < pos.segment; i++ {
+		os.Remove(s.segmentPath(s.segments[0].id))
+		s.size -= s.segments[0].size - s.readOffset
+		s.segments = s.segments[1:]
+		s.readOffset = 0
+	}
+	s.size -= pos.offset - s.readOffset
+	s.readOffset = pos.offset
+	s.count -= pos.count
+	if !pos.next.IsZero() {
+		s.headTime = pos.next
+	}
+
+	cursor := fmt.Sprintf("%d %d", s.segments[0].id, s.readOffset)
+	if err := os.WriteFile(filepath.Join(s.directory, spoolCursorFile), []byte(cursor), 0o640); err != nil {
+		s.LogError("unable to save the cursor: %s", err)
+	}
+}
+
+// Replay sends the messages to the flush function by batch and in order, the flush function
+// returns the number of messages sent and only these messages are removed from the spool.
+// Returns the number of messages replayed.
+func (s *DiskSpool) Replay(batchSize int, flush func(batch []dnsutils.DNSMessage) int) int {
+	replayed := 0
+	for replayed < SpoolReplayLimit && s.Len() > 0 {
+		dms, pos, err := s.peek(batchSize)
+		if err != nil {
+			s.LogError("unable to read segment: %s", err)
+			break
+		}
+		if pos.count == 0 {
+			break
+		}
+		if len(dms) > 0 {
+			sent := flush(dms)
+			if sent < len(dms) {
+				// remove only the messages sent before the failure
+				if sent > 0 {
+					if _, pos, err = s.peek(sent); err == nil {
+						s.commit(pos)
+						replayed += sent
+					}
+				}
+				break
+			}
+		}
+		s.commit(pos)
+		replayed += len(dms)
+
+		if s.Len() == 0 {
+			s.LogInfo("all messages replayed")
+		}
+	}
+	return replayed
+}
+
+// Len returns the number of messages to replay
+func (s *DiskSpool) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.count
+}
+
+// Size returns the size in bytes of the messages to replay
+func (s *DiskSpool) Size() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.size
+}
+
+// Age returns the age of the oldest message to replay
+func (s *DiskSpool) Age() time.Duration {
+	s.Lock()
+	defer s.Unlock()
+	if s.count == 0 {
+		return 0
+	}
+	return time.Since(s.headTime)
+}
+
+// Dropped returns the number of messages dropped because the spool is full
+func (s *DiskSpool) Dropped() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.dropped
+}
+
+// ReportStats logs the size and the age of the spool, at most every 30 seconds
+func (s *DiskSpool) ReportStats() {
+	if s.Len() == 0 || time.Since(s.lastStats) < spoolStatsInterval {
+		return
+	}
+	s.lastStats = time.Now()
+	s.LogInfo("%d message(s) to replay, size=%d bytes, age=%s, dropped=%d",
+		s.Len(), s.Size(), s.Age().Round(time.Second), s.Dropped())
+}
+
+// Close saves the position of the replay, messages will be replayed on the next opening
+func (s *DiskSpool) Close() {
+	spools.Lock()
+	delete(spools.items, s.name)
+	spools.Unlock()
+
+	s.Lock()
+	defer s.Unlock()
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	if len(s.segments) > 0 {
+		cursor := fmt.Sprintf("%d %d", s.segments[0].id, s.readOffset)
+		os.WriteFile(filepath.Join(s.directory, spoolCursorFile), []byte(cursor), 0o640)
+	}
+	s.LogInfo("closed, %d message(s) to replay", s.count)
+}
+
+// SpoolHandler keeps the messages of a logger on disk while the remote is not reachable,
+// the messages are replayed in order once the connection is back. All methods can be
+// called when the spool is disabled, the messages are then only flushed.
+type SpoolHandler struct {
+	spool       *DiskSpool
+	flush       func(buf *[]dnsutils.DNSMessage) int
+	ready       func() bool
+	replayTimer *time.Timer
+}
+
+// NewSpoolHandler opens the spool if enabled. The flush function sends the buffer and returns
+// the number of messages sent, the ready function returns the state of the connection.
+func NewSpoolHandler(config pkgconfig.ConfigSpool, name string, logger *logger.Logger,
+	flush func(buf *[]dnsutils.DNSMessage) int, ready func() bool) (*SpoolHandler, error) {
+	h := &SpoolHandler{flush: flush, ready: ready}
+	if !config.Enable {
+		return h, nil
+	}
+
+	spool, err := OpenDiskSpool(config, name, logger)
+	if err != nil {
+		return nil, err
+	}
+	h.spool = spool
+	h.replayTimer = time.NewTimer(SpoolReplayInterval)
+	return h, nil
+}
+
+// Keep writes the buffer and the message to the spool if the connection is not ready
+// or if older messages are waiting to be replayed, returns false otherwise.
+func (h *SpoolHandler) Keep(buf *[]dnsutils.DNSMessage, dm dnsutils.DNSMessage) bool {
+	if h.spool == nil || (h.ready() && h.spool.Len() == 0) {
+		return false
+	}
+	h.spool.Push(append(*buf, dm)...)
+	*buf = nil
+	return true
+}
+
+// FlushOrSpool flushes the buffer, the messages not sent are kept in the spool
+func (h *SpoolHandler) FlushOrSpool(buf *[]dnsutils.DNSMessage) {
+	batch := *buf
+	sent := h.flush(buf)
+	if h.spool != nil && sent < len(batch) {
+		h.spool.Push(batch[sent:]...)
+	}
+}
+
+// ReplayChan returns the channel of the replay timer, nil if the spool is disabled
+func (h *SpoolHandler) ReplayChan() <-chan time.Time {
+	if h.replayTimer == nil {
+		return nil
+	}
+	return h.replayTimer.C
+}
+
+// Replay sends the messages kept on disk if the connection is ready, to call on the replay timer
+func (h *SpoolHandler) Replay(batchSize int) {
+	if h.spool == nil {
+		return
+	}
+	if h.ready() {
+		h.spool.Replay(batchSize, func(batch []dnsutils.DNSMessage) int {
+			return h.flush(&batch)
+		})
+	}
+	h.spool.ReportStats()
+	h.replayTimer.Reset(SpoolReplayInterval)
+}
+
+// Len returns the number of messages to replay
+func (h *SpoolHandler) Len() int {
+	if h.spool == nil {
+		return 0
+	}
+	return h.spool.Len()
+}
+
+// Close stops the replay timer and closes the spool
+func (h *SpoolHandler) Close() {
+	if h.spool == nil {
+		return
+	}
+	h.replayTimer.Stop()
+	h.spool.Close()
+}
+
+// SpoolCollector exports the size and the age of the opened spools as prometheus metrics
+type SpoolCollector struct {
+	messages *prometheus.Desc
+	size     *prometheus.Desc
+	age      *prometheus.Desc
+	dropped  *prometheus.Desc
+}
+
+func NewSpoolCollector(promPrefix string) *SpoolCollector {
+	return &SpoolCollector{
+		messages: prometheus.NewDesc(promPrefix+"_spool_messages", "Number of messages in the spool", []string{"logger"}, nil),
+		size:     prometheus.NewDesc(promPrefix+"_spool_size_bytes", "Size of the spool in bytes", []string{"logger"}, nil),
+		age:      prometheus.NewDesc(promPrefix+"_spool_age_seconds", "Age of the oldest message in the spool", []string{"logger"}, nil),
+		dropped:  prometheus.NewDesc(promPrefix+"_spool_dropped_total", "Number of messages dropped because the spool is full", []string{"logger"}, nil),
+	}
+}
+
+func (c *SpoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.messages
+	ch <- c.size
+	ch <- c.age
+	ch <- c.dropped
+}
+
+func (c *SpoolCollector) Collect(ch chan<- prometheus.Metric) {
+	spools.Lock()
+	defer spools.Unlock()
+
+	for name, s := range spools.items {
+		ch <- prometheus.MustNewConstMetric(c.messages, prometheus.GaugeValue, float64(s.Len()), name)
+		ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(s.Size()), name)
+		ch <- prometheus.MustNewConstMetric(c.age, prometheus.GaugeValue, s.Age().Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(s.Dropped()), name)
+	}
+}
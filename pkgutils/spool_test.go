@@ -0,0 +1,166 @@
+package pkgutils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+)
+
+func spoolFakeMessages(n int) []dnsutils.DNSMessage {
+	dms := []dnsutils.DNSMessage{}
+	for i := 0; i < n; i++ {
+		dm := dnsutils.GetFakeDNSMessage()
+		dm.DNS.Qname = fmt.Sprintf("%d.dns.collector", i)
+		dms = append(dms, dm)
+	}
+	return dms
+}
+
+func Test_DiskSpool_ReplayInOrder(t *testing.T) {
+	cfg := pkgconfig.ConfigSpool{}
+	cfg.SetDefault()
+	cfg.Directory = t.TempDir()
+	cfg.SegmentSize = 0 // one message per segment
+
+	spool, err := OpenDiskSpool(cfg, "test", logger.New(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool.Push(spoolFakeMessages(10)...)
+	if spool.Len() != 10 || spool.Size() == 0 {
+		t.Fatalf("invalid spool len=%d size=%d", spool.Len(), spool.Size())
+	}
+
+	// failed flush, messages are kept
+	spool.Replay(3, func(batch []dnsutils.DNSMessage) int { return 0 })
+	if spool.Len() != 10 {
+		t.Errorf("messages should be kept on failure, got %d", spool.Len())
+	}
+
+	// replay some messages and reopen the spool
+	received := []string{}
+	spool.Replay(3, func(batch []dnsutils.DNSMessage) int {
+		for _, dm := range batch {
+			received = append(received, dm.DNS.Qname)
+		}
+		if len(received) >= 6 {
+			return 0
+		}
+		return len(batch)
+	})
+	spool.Close()
+
+	spool, err = OpenDiskSpool(cfg, "test", logger.New(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spool.Len() != 7 {
+		t.Errorf("7 messages expected after reopening, got %d", spool.Len())
+	}
+
+	received = received[:3]
+	spool.Replay(4, func(batch []dnsutils.DNSMessage) int {
+		for _, dm := range batch {
+			received = append(received, dm.DNS.Qname)
+		}
+		return len(batch)
+	})
+	for i, qname := range received {
+		if qname != fmt.Sprintf("%d.dns.collector", i) {
+			t.Errorf("invalid order, message %d is %s", i, qname)
+		}
+	}
+	if len(received) != 10 || spool.Len() != 0 || spool.Size() != 0 || spool.Age() != 0 {
+		t.Errorf("spool should be empty, replayed=%d len=%d", len(received), spool.Len())
+	}
+	spool.Close()
+}
+
+func Test_DiskSpool_MaxSize(t *testing.T) {
+	cfg := pkgconfig.ConfigSpool{}
+	cfg.SetDefault()
+	cfg.Directory = t.TempDir()
+	cfg.MaxSize = 0
+
+	spool, err := OpenDiskSpool(cfg, "test", logger.New(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	if err := spool.Push(spoolFakeMessages(2)...); err != ErrSpoolFull {
+		t.Errorf("spool full error expected, got %v", err)
+	}
+	if spool.Len() != 0 || spool.Dropped() != 2 {
+		t.Errorf("messages should be dropped, len=%d dropped=%d", spool.Len(), spool.Dropped())
+	}
+}
+
+func Test_SpoolHandler_PartialFlush(t *testing.T) {
+	cfg := pkgconfig.ConfigSpool{}
+	cfg.SetDefault()
+	cfg.Enable = true
+	cfg.Directory = t.TempDir()
+
+	// the remote fails after two messages
+	ready := true
+	received := []string{}
+	flush := func(buf *[]dnsutils.DNSMessage) int {
+		sent := 0
+		for _, dm := range *buf {
+			if !ready || len(received) == 2 {
+				ready = false
+				break
+			}
+			received = append(received, dm.DNS.Qname)
+			sent++
+		}
+		*buf = nil
+		return sent
+	}
+
+	spool, err := NewSpoolHandler(cfg, "test", logger.New(false), flush, func() bool { return ready })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	// only the messages not sent are kept
+	buf := spoolFakeMessages(5)
+	spool.FlushOrSpool(&buf)
+	if len(received) != 2 || spool.Len() != 3 {
+		t.Fatalf("3 messages expected in the spool, sent=%d spooled=%d", len(received), spool.Len())
+	}
+
+	// the new messages are kept while older ones are waiting
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNS.Qname = "5.dns.collector"
+	if !spool.Keep(&buf, dm) || spool.Len() != 4 {
+		t.Errorf("message should be kept, spooled=%d", spool.Len())
+	}
+
+	// partial replay, the sent messages are removed
+	ready = true
+	received = received[:1]
+	spool.Replay(10)
+	if spool.Len() != 3 {
+		t.Errorf("3 messages expected after a partial replay, got %d", spool.Len())
+	}
+
+	// replay the others in order, two messages at each time
+	replayed := []string{}
+	for i := 0; i < 2; i++ {
+		ready = true
+		received = received[:0]
+		spool.Replay(10)
+		replayed = append(replayed, received...)
+	}
+	expected := []string{"3.dns.collector", "4.dns.collector", "5.dns.collector"}
+	if spool.Len() != 0 || strings.Join(replayed, " ") != strings.Join(expected, " ") {
+		t.Errorf("invalid replay, len=%d replayed=%v", spool.Len(), replayed)
+	}
+}
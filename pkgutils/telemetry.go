@@ -0,0 +1,370 @@
+package pkgutils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	telemetryNamespace = "dnscollector"
+
+	DecodingPartHeader  = "header"
+	DecodingPartUnknown = "unknown"
+)
+
+// Telemetry is the process-wide registry of the internal metrics, shared by all stanzas
+var Telemetry = NewTelemetryRegistry()
+
+type TelemetryRegistry struct {
+	sync.RWMutex
+	registry           *prometheus.Registry
+	messagesIn         *prometheus.CounterVec
+	messagesOut        *prometheus.CounterVec
+	messagesDropped    *prometheus.CounterVec
+	transformerDropped *prometheus.CounterVec
+	decoderErrors      *prometheus.CounterVec
+	connections        *prometheus.GaugeVec
+	reconnects         *prometheus.CounterVec
+	channelFillRatio   *prometheus.Desc
+	reassemblyErrors   *prometheus.Desc
+	channels           map[string]chan dnsutils.DNSMessage
+	routedMutex        sync.RWMutex
+	routed             map[routedKey]*routedCounters
+	serverMutex        sync.Mutex
+	httpserver         net.Listener
+	doneAPI            chan bool
+}
+
+// routedKey identifies a route between two stanzas
+type routedKey struct {
+	stanza string
+	route  string
+}
+
+// routedCounters are the label children of a route, cached to avoid
+// the lookup of the labels for each routed message
+type routedCounters struct {
+	out          prometheus.Counter
+	in           prometheus.Counter
+	dropped      prometheus.Counter
+	droppedTotal atomic.Uint64
+}
+
+func NewTelemetryRegistry() *TelemetryRegistry {
+	t := &TelemetryRegistry{
+		routed:   make(map[routedKey]*routedCounters),
+		registry: prometheus.NewRegistry(),
+		messagesIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: telemetryNamespace,
+			Name:      "messages_in_total",
+			Help:      "Number of messages received by the stanza",
+		}, []string{"stanza"}),
+		messagesOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: telemetryNamespace,
+			Name:      "messages_out_total",
+			Help:      "Number of messages sent by the stanza to the next stanzas",
+		}, []string{"stanza", "route"}),
+		messagesDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: telemetryNamespace,
+			Name:      "messages_dropped_total",
+			Help:      "Number of messages dropped because the channel of the next stanza is full",
+		}, []string{"stanza", "route"}),
+		transformerDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: telemetryNamespace,
+			Name:      "transformer_dropped_total",
+			Help:      "Number of messages dropped by the transformers",
+		}, []string{"stanza", "reason"}),
+		decoderErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: telemetryNamespace,
+			Name:      "decoder_errors_total",
+			Help:      "Number of dns packets which can not be decoded, by part of the packet",
+		}, []string{"stanza", "part"}),
+		connections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: telemetryNamespace,
+			Name:      "connections",
+			Help:      "Number of established connections, from the remote peers or to the remote server",
+		}, []string{"stanza"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: telemetryNamespace,
+			Name:      "reconnects_total",
+			Help:      "Number of connections lost to the remote server",
+		}, []string{"stanza"}),
+		channelFillRatio: prometheus.NewDesc(
+			prometheus.BuildFQName(telemetryNamespace, "", "channel_fill_ratio"),
+			"Number of messages in the input channel of the stanza divided by its capacity",
+			[]string{"stanza"}, nil,
+		),
+		reassemblyErrors: prometheus.NewDesc(
+			prometheus.BuildFQName(telemetryNamespace, "", "reassembly_errors_total"),
+			"Number of tcp reassembly and ip defragmentation errors",
+			[]string{"reason"}, nil,
+		),
+		channels: make(map[string]chan dnsutils.DNSMessage),
+		doneAPI:  make(chan bool),
+	}
+
+	t.registry.MustRegister(
+		t.messagesIn, t.messagesOut, t.messagesDropped,
+		t.transformerDropped, t.decoderErrors,
+		t.connections, t.reconnects, t,
+		collectors.NewGoCollector(collectors.WithGoCollectorMemStatsMetricsDisabled()),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return t
+}
+
+// Describe and Collect export the metrics computed on scrape
+func (t *TelemetryRegistry) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.channelFillRatio
+	ch <- t.reassemblyErrors
+}
+
+func (t *TelemetryRegistry) Collect(ch chan<- prometheus.Metric) {
+	t.RLock()
+	for name, c := range t.channels {
+		if cap(c) > 0 {
+			ch <- prometheus.MustNewConstMetric(t.channelFillRatio, prometheus.GaugeValue, float64(len(c))/float64(cap(c)), name)
+		}
+	}
+	t.RUnlock()
+
+	for reason, count := range netlib.GetReassemblyErrors() {
+		ch <- prometheus.MustNewConstMetric(t.reassemblyErrors, prometheus.CounterValue, float64(count), reason)
+	}
+}
+
+// RegisterWorker adds the input channel of the stanza to the telemetry
+func (t *TelemetryRegistry) RegisterWorker(wrk Worker) {
+	t.Lock()
+	defer t.Unlock()
+	if c := wrk.GetInputChannel(); c != nil {
+		t.channels[wrk.GetName()] = c
+	}
+}
+
+// UnregisterWorker removes all the metrics of the stanza
+func (t *TelemetryRegistry) UnregisterWorker(name string) {
+	t.Lock()
+	delete(t.channels, name)
+	t.Unlock()
+
+	t.routedMutex.Lock()
+	for key := range t.routed {
+		if key.stanza == name || key.route == name {
+			delete(t.routed, key)
+		}
+	}
+	t.routedMutex.Unlock()
+
+	labels := prometheus.Labels{"stanza": name}
+	t.messagesIn.DeletePartialMatch(labels)
+	t.messagesOut.DeletePartialMatch(labels)
+	t.messagesDropped.DeletePartialMatch(labels)
+	t.transformerDropped.DeletePartialMatch(labels)
+	t.decoderErrors.DeletePartialMatch(labels)
+	t.connections.DeletePartialMatch(labels)
+	t.reconnects.DeletePartialMatch(labels)
+}
+
+// RecordReceived counts a message read by a collector from its source
+func (t *TelemetryRegistry) RecordReceived(stanza string) {
+	t.messagesIn.WithLabelValues(stanza).Inc()
+}
+
+// RecordRouted counts a message sent or dropped by the stanza to the route.
+// With pipelines, the routers of the stanza are named <stanza>/<policy> and
+// count the messages sent to the next stanzas, so they are only counted on drop.
+func (t *TelemetryRegistry) RecordRouted(stanza, route string, delivered bool) {
+	counters := t.getRouted(stanza, route)
+	if !delivered {
+		counters.dropped.Inc()
+		counters.droppedTotal.Add(1)
+		return
+	}
+	if counters.out == nil {
+		return
+	}
+	counters.out.Inc()
+	counters.in.Inc()
+}
+
+// getRouted returns the counters of the route, created on the first message
+func (t *TelemetryRegistry) getRouted(stanza, route string) *routedCounters {
+	key := routedKey{stanza: stanza, route: route}
+	t.routedMutex.RLock()
+	counters, ok := t.routed[key]
+	t.routedMutex.RUnlock()
+	if ok {
+		return counters
+	}
+
+	counters = &routedCounters{dropped: t.messagesDropped.WithLabelValues(stanza, route)}
+	if !strings.HasPrefix(route, stanza+"/") {
+		counters.out = t.messagesOut.WithLabelValues(stanza, route)
+		counters.in = t.messagesIn.WithLabelValues(route)
+	}
+	t.routedMutex.Lock()
+	t.routed[key] = counters
+	t.routedMutex.Unlock()
+	return counters
+}
+
+// GetDroppedCounters returns the number of messages dropped by the stanza per route,
+// the routes of the routers are named <stanza>/<policy>
+func (t *TelemetryRegistry) GetDroppedCounters(stanza string) map[string]uint64 {
+	t.routedMutex.RLock()
+	defer t.routedMutex.RUnlock()
+
+	counters := make(map[string]uint64)
+	for key, routed := range t.routed {
+		if key.stanza == stanza {
+			counters[key.route] += routed.droppedTotal.Load()
+		}
+	}
+	return counters
+}
+
+// RecordTransformerDrop counts a message dropped by a transformer, the reason is the name of the transformer
+func (t *TelemetryRegistry) RecordTransformerDrop(stanza, reason string) {
+	t.transformerDropped.WithLabelValues(stanza, reason).Inc()
+}
+
+// RecordDecoderError counts a dns packet which can not be decoded, by part of the packet
+func (t *TelemetryRegistry) RecordDecoderError(stanza string, err error) {
+	part := dnsutils.GetDecodingErrorPart(err)
+	if len(part) == 0 {
+		part = DecodingPartUnknown
+	}
+	t.decoderErrors.WithLabelValues(stanza, part).Inc()
+}
+
+// RecordHeaderError counts a dns packet with an invalid header
+func (t *TelemetryRegistry) RecordHeaderError(stanza string) {
+	t.decoderErrors.WithLabelValues(stanza, DecodingPartHeader).Inc()
+}
+
+func (t *TelemetryRegistry) ConnectionOpened(stanza string) {
+	t.connections.WithLabelValues(stanza).Inc()
+}
+
+func (t *TelemetryRegistry) ConnectionClosed(stanza string) {
+	t.connections.WithLabelValues(stanza).Dec()
+}
+
+// ConnectionLost is called by the loggers before to reconnect to the remote server
+func (t *TelemetryRegistry) ConnectionLost(stanza string) {
+	t.connections.WithLabelValues(stanza).Dec()
+	t.reconnects.WithLabelValues(stanza).Inc()
+}
+
+func (t *TelemetryRegistry) GetRegistry() *prometheus.Registry {
+	return t.registry
+}
+
+// Stop closes the listener and waits the end of the http server, nothing to do if not listening
+func (t *TelemetryRegistry) Stop() {
+	t.serverMutex.Lock()
+	httpserver := t.httpserver
+	t.serverMutex.Unlock()
+	if httpserver == nil {
+		return
+	}
+	httpserver.Close()
+	<-t.doneAPI
+}
+
+// Listen creates the listener of the http server, with tls if enabled
+func (t *TelemetryRegistry) Listen(config *pkgconfig.Config, console *logger.Logger) error {
+	cfg := config.Global.Telemetry
+
+	var err error
+	var listener net.Listener
+	addrlisten := cfg.ListenIP + ":" + strconv.Itoa(cfg.ListenPort)
+
+	// listening with tls enabled ?
+	if cfg.TLSSupport {
+		console.Info(PrefixLogTelemetry + "tls support enabled")
+		var cer tls.Certificate
+		cer, err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading certificate failed: %w", err)
+		}
+
+		// prepare tls configuration
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cer},
+			MinVersion:   tls.VersionTLS12,
+		}
+
+		// update tls min version according to the user config
+		tlsConfig.MinVersion = pkgconfig.TLSVersion[cfg.TLSMinVersion]
+
+		listener, err = tls.Listen(netlib.SocketTCP, addrlisten, tlsConfig)
+
+	} else {
+		// basic listening
+		listener, err = net.Listen(netlib.SocketTCP, addrlisten)
+	}
+
+	// something wrong ?
+	if err != nil {
+		return fmt.Errorf("http server listening failed: %w", err)
+	}
+
+	t.serverMutex.Lock()
+	t.httpserver = listener
+	t.serverMutex.Unlock()
+	console.Info(PrefixLogTelemetry+"is listening on %s", listener.Addr())
+	return nil
+}
+
+// ListenAndServe exports the metrics in prometheus format on its own listener,
+// the listener is created if Listen is not called before
+func (t *TelemetryRegistry) ListenAndServe(config *pkgconfig.Config, console *logger.Logger) {
+	console.Info(PrefixLogTelemetry + "starting http server...")
+	cfg := config.Global.Telemetry
+
+	var handler http.Handler = promhttp.HandlerFor(t.registry, promhttp.HandlerOpts{})
+	if cfg.BasicAuthEnabled {
+		next := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			login, password, authOK := r.BasicAuth()
+			if !authOK || login != cfg.BasicAuthLogin || password != cfg.BasicAuthPwd {
+				http.Error(w, "Not authorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	mux := http.NewServeMux()
+	mux.Handle(cfg.WebPath, handler)
+
+	t.serverMutex.Lock()
+	httpserver := t.httpserver
+	t.serverMutex.Unlock()
+	if httpserver == nil {
+		if err := t.Listen(config, console); err != nil {
+			console.Fatal(PrefixLogTelemetry, err)
+		}
+		httpserver = t.httpserver
+	}
+
+	http.Serve(httpserver, mux)
+
+	console.Info(PrefixLogTelemetry + "http server terminated")
+	t.doneAPI <- true
+}
@@ -0,0 +1,137 @@
+package pkgutils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTelemetry_RecordRouted(t *testing.T) {
+	tr := NewTelemetryRegistry()
+
+	// message sent by the collector to its router, not counted
+	tr.RecordRouted("collector", "collector/default", true)
+	// message sent by the router to the next stanza
+	tr.RecordRouted("collector", "logger", true)
+	// message dropped, the channel of the next stanza is full
+	tr.RecordRouted("collector", "logger", false)
+	tr.RecordRouted("collector", "collector/default", false)
+
+	if v := testutil.ToFloat64(tr.messagesOut.WithLabelValues("collector", "logger")); v != 1 {
+		t.Errorf("invalid messages out: %v", v)
+	}
+	if v := testutil.ToFloat64(tr.messagesIn.WithLabelValues("logger")); v != 1 {
+		t.Errorf("invalid messages in: %v", v)
+	}
+	if n := testutil.CollectAndCount(tr.messagesOut); n != 1 {
+		t.Errorf("router should not be counted, got %d series", n)
+	}
+	if n := testutil.CollectAndCount(tr.messagesDropped); n != 2 {
+		t.Errorf("invalid number of dropped series: %d", n)
+	}
+	if dropped := tr.GetDroppedCounters("collector"); len(dropped) != 2 || dropped["logger"] != 1 || dropped["collector/default"] != 1 {
+		t.Errorf("invalid dropped counters: %v", dropped)
+	}
+
+	// metrics removed with the stanza
+	tr.UnregisterWorker("collector")
+	if n := testutil.CollectAndCount(tr.messagesDropped); n != 0 {
+		t.Errorf("dropped metrics should be removed, got %d series", n)
+	}
+
+	// the cached counters are removed too, the stanza is counted again once recreated
+	tr.RecordRouted("collector", "logger", true)
+	if n := testutil.CollectAndCount(tr.messagesOut); n != 1 {
+		t.Errorf("recreated stanza should be counted, got %d series", n)
+	}
+}
+
+func TestTelemetry_DecoderErrors(t *testing.T) {
+	tr := NewTelemetryRegistry()
+
+	// truncated answer
+	payload := []byte{0x9e, 0x84, 0x81, 0x80, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x07, 0x65, 0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x03, 0x63, 0x6f, 0x6d, 0x00, 0x00, 0x01, 0x00, 0x01,
+		0xc0, 0x0c}
+	dm := dnsutils.DNSMessage{}
+	dm.Init()
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+	header, err := dnsutils.DecodeDNS(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dnsutils.DecodePayload(&dm, &header, pkgconfig.GetFakeConfig())
+	if err == nil {
+		t.Fatal("decoding error expected")
+	}
+	if part := dnsutils.GetDecodingErrorPart(err); part != "answer records" {
+		t.Errorf("invalid decoding error part: %s", part)
+	}
+	tr.RecordDecoderError("collector", err)
+	tr.RecordDecoderError("collector", errors.New("other error"))
+	tr.RecordHeaderError("collector")
+
+	if v := testutil.ToFloat64(tr.decoderErrors.WithLabelValues("collector", dnsutils.GetDecodingErrorPart(err))); v != 1 {
+		t.Errorf("invalid decoder errors: %v", v)
+	}
+	if v := testutil.ToFloat64(tr.decoderErrors.WithLabelValues("collector", DecodingPartUnknown)); v != 1 {
+		t.Errorf("invalid unknown decoder errors: %v", v)
+	}
+	if v := testutil.ToFloat64(tr.decoderErrors.WithLabelValues("collector", DecodingPartHeader)); v != 1 {
+		t.Errorf("invalid header decoder errors: %v", v)
+	}
+}
+
+func TestTelemetry_Connections(t *testing.T) {
+	tr := NewTelemetryRegistry()
+
+	tr.ConnectionOpened("logger")
+	tr.ConnectionLost("logger")
+	tr.ConnectionOpened("logger")
+
+	if v := testutil.ToFloat64(tr.connections.WithLabelValues("logger")); v != 1 {
+		t.Errorf("invalid connections: %v", v)
+	}
+	if v := testutil.ToFloat64(tr.reconnects.WithLabelValues("logger")); v != 1 {
+		t.Errorf("invalid reconnects: %v", v)
+	}
+}
+
+func TestTelemetry_ChannelFillRatio(t *testing.T) {
+	tr := NewTelemetryRegistry()
+
+	wrk := NewFakeLoggerWithBufferSize(4)
+	tr.RegisterWorker(wrk)
+	wrk.GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+
+	expected := `
+# HELP dnscollector_channel_fill_ratio Number of messages in the input channel of the stanza divided by its capacity
+# TYPE dnscollector_channel_fill_ratio gauge
+dnscollector_channel_fill_ratio{stanza="fake"} 0.25
+`
+	if err := testutil.GatherAndCompare(tr.GetRegistry(), strings.NewReader(expected), "dnscollector_channel_fill_ratio"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTelemetry_Stop(t *testing.T) {
+	tr := NewTelemetryRegistry()
+	config := pkgconfig.GetFakeConfig()
+	console := logger.New(false)
+
+	// nothing to stop before listening
+	tr.Stop()
+
+	config.Global.Telemetry.ListenPort = 0
+	if err := tr.Listen(config, console); err != nil {
+		t.Fatal(err)
+	}
+	go tr.ListenAndServe(config, console)
+	tr.Stop()
+}
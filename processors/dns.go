@@ -21,32 +21,24 @@ func GetFakeDNS() ([]byte, error) {
 type DNSProcessor struct {
 	doneRun        chan bool
 	stopRun        chan bool
-	doneMonitor    chan bool
-	stopMonitor    chan bool
 	recvFrom       chan dnsutils.DNSMessage
 	logger         *logger.Logger
 	config         *pkgconfig.Config
 	ConfigChan     chan *pkgconfig.Config
 	name           string
 	RoutingHandler pkgutils.RoutingHandler
-	dropped        chan string
-	droppedCount   map[string]int
 }
 
 func NewDNSProcessor(config *pkgconfig.Config, logger *logger.Logger, name string, size int) DNSProcessor {
 	logger.Info(pkgutils.PrefixLogProcessor+"[%s] dns - initialization...", name)
 	d := DNSProcessor{
-		doneMonitor:    make(chan bool),
 		doneRun:        make(chan bool),
-		stopMonitor:    make(chan bool),
 		stopRun:        make(chan bool),
 		recvFrom:       make(chan dnsutils.DNSMessage, size),
 		logger:         logger,
 		config:         config,
 		ConfigChan:     make(chan *pkgconfig.Config),
 		name:           name,
-		dropped:        make(chan string),
-		droppedCount:   map[string]int{},
 		RoutingHandler: pkgutils.NewRoutingHandler(config, logger, name),
 	}
 	return d
@@ -77,23 +69,18 @@ func (d *DNSProcessor) Stop() {
 	d.LogInfo("stopping to process...")
 	d.stopRun <- true
 	<-d.doneRun
-
-	d.LogInfo("stopping monitor...")
-	d.stopMonitor <- true
-	<-d.doneMonitor
 }
 
 func (d *DNSProcessor) Run(defaultWorkers []pkgutils.Worker, droppedworkers []pkgutils.Worker) {
-	// prepare next channels
+	// prepare next channels, the delivery depends on the next workers
+	d.RoutingHandler.SetDefaultRoutes(defaultWorkers)
+	d.RoutingHandler.SetDroppedRoutes(droppedworkers)
 	defaultRoutes, defaultNames := pkgutils.GetRoutes(defaultWorkers)
 	droppedRoutes, droppedNames := pkgutils.GetRoutes(droppedworkers)
 
 	// prepare enabled transformers
 	transforms := transformers.NewTransforms(&d.config.IngoingTransformers, d.logger, d.name, defaultRoutes, 0)
 
-	// start goroutine to count dropped messsages
-	go d.MonitorLoggers()
-
 	// read incoming dns message
 	d.LogInfo("waiting dns message to process...")
 RUN_LOOP:
@@ -113,6 +100,7 @@ RUN_LOOP:
 				d.LogInfo("channel closed, exit")
 				return
 			}
+			pkgutils.Telemetry.RecordReceived(d.name)
 
 			// init dns message with additionnals parts
 			transforms.InitDNSMessageFormat(&dm)
@@ -126,6 +114,7 @@ RUN_LOOP:
 			dnsHeader, err := dnsutils.DecodeDNS(dm.DNS.Payload)
 			if err != nil {
 				dm.DNS.MalformedPacket = true
+				pkgutils.Telemetry.RecordHeaderError(d.name)
 				d.LogError("dns parser malformed packet: %s - %v+", err, dm)
 			}
 
@@ -145,6 +134,7 @@ RUN_LOOP:
 			}
 
 			if err = dnsutils.DecodePayload(&dm, &dnsHeader, d.config); err != nil {
+				pkgutils.Telemetry.RecordDecoderError(d.name, err)
 				d.LogError("%v - %v", err, dm)
 			}
 
@@ -155,14 +145,12 @@ RUN_LOOP:
 			}
 
 			// apply all enabled transformers
-			if transforms.ProcessMessage(&dm) == transformers.ReturnDrop {
-				for i := range droppedRoutes {
-					select {
-					case droppedRoutes[i] <- dm: // Successful send to logger channel
-					default:
-						d.dropped <- droppedNames[i]
-					}
-				}
+			switch transforms.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
+				d.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
 
@@ -170,49 +158,9 @@ RUN_LOOP:
 			dm.DNSTap.LatencySec = fmt.Sprintf("%.6f", dm.DNSTap.Latency)
 
 			// dispatch dns message to all generators
-			for i := range defaultRoutes {
-				select {
-				case defaultRoutes[i] <- dm: // Successful send to logger channel
-				default:
-					d.dropped <- defaultNames[i]
-				}
-			}
+			d.RoutingHandler.SendTo(defaultRoutes, defaultNames, dm)
 
 		}
 	}
 	d.LogInfo("processing terminated")
 }
-
-func (d *DNSProcessor) MonitorLoggers() {
-	watchInterval := 10 * time.Second
-	bufferFull := time.NewTimer(watchInterval)
-FOLLOW_LOOP:
-	for {
-		select {
-		case <-d.stopMonitor:
-			close(d.dropped)
-			bufferFull.Stop()
-			d.doneMonitor <- true
-			break FOLLOW_LOOP
-
-		case loggerName := <-d.dropped:
-			if _, ok := d.droppedCount[loggerName]; !ok {
-				d.droppedCount[loggerName] = 1
-			} else {
-				d.droppedCount[loggerName]++
-			}
-
-		case <-bufferFull.C:
-
-			for v, k := range d.droppedCount {
-				if k > 0 {
-					d.LogError("logger[%s] buffer is full, %d packet(s) dropped", v, k)
-					d.droppedCount[v] = 0
-				}
-			}
-			bufferFull.Reset(watchInterval)
-
-		}
-	}
-	d.LogInfo("monitor terminated")
-}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -55,8 +56,6 @@ type DNSTapProcessor struct {
 	ConnID         int
 	doneRun        chan bool
 	stopRun        chan bool
-	doneMonitor    chan bool
-	stopMonitor    chan bool
 	recvFrom       chan []byte
 	logger         *logger.Logger
 	config         *pkgconfig.Config
@@ -64,8 +63,9 @@ type DNSTapProcessor struct {
 	name           string
 	chanSize       int
 	RoutingHandler pkgutils.RoutingHandler
-	dropped        chan string
-	droppedCount   map[string]int
+	// Delivered receives, when set, the delivery of each payload: false if the
+	// message is dropped by the routes, invalid and held payloads are delivered
+	Delivered chan bool
 }
 
 func NewDNSTapProcessor(connID int, config *pkgconfig.Config, logger *logger.Logger, name string, size int) DNSTapProcessor {
@@ -73,9 +73,7 @@ func NewDNSTapProcessor(connID int, config *pkgconfig.Config, logger *logger.Log
 
 	d := DNSTapProcessor{
 		ConnID:         connID,
-		doneMonitor:    make(chan bool),
 		doneRun:        make(chan bool),
-		stopMonitor:    make(chan bool),
 		stopRun:        make(chan bool),
 		recvFrom:       make(chan []byte, size),
 		chanSize:       size,
@@ -83,8 +81,6 @@ func NewDNSTapProcessor(connID int, config *pkgconfig.Config, logger *logger.Log
 		config:         config,
 		ConfigChan:     make(chan *pkgconfig.Config),
 		name:           name,
-		dropped:        make(chan string),
-		droppedCount:   map[string]int{},
 		RoutingHandler: pkgutils.NewRoutingHandler(config, logger, name),
 	}
 
@@ -115,6 +111,12 @@ func (d *DNSTapProcessor) GetChannel() chan []byte {
 	return d.recvFrom
 }
 
+func (d *DNSTapProcessor) reportDelivery(delivered bool) {
+	if d.Delivered != nil {
+		d.Delivered <- delivered
+	}
+}
+
 func (d *DNSTapProcessor) Stop() {
 	d.LogInfo("stopping processor...")
 	d.RoutingHandler.Stop()
@@ -122,26 +124,21 @@ func (d *DNSTapProcessor) Stop() {
 	d.LogInfo("stopping to process...")
 	d.stopRun <- true
 	<-d.doneRun
-
-	d.LogInfo("stopping monitor...")
-	d.stopMonitor <- true
-	<-d.doneMonitor
 }
 
 func (d *DNSTapProcessor) Run(defaultWorkers []pkgutils.Worker, droppedworkers []pkgutils.Worker) {
 	dt := &dnstap.Dnstap{}
 	edt := &dnsutils.ExtendedDnstap{}
 
-	// prepare next channels
+	// prepare next channels, the delivery depends on the next workers
+	d.RoutingHandler.SetDefaultRoutes(defaultWorkers)
+	d.RoutingHandler.SetDroppedRoutes(droppedworkers)
 	defaultRoutes, defaultNames := pkgutils.GetRoutes(defaultWorkers)
 	droppedRoutes, droppedNames := pkgutils.GetRoutes(droppedworkers)
 
 	// prepare enabled transformers
 	transforms := transformers.NewTransforms(&d.config.IngoingTransformers, d.logger, d.name, defaultRoutes, d.ConnID)
 
-	// start goroutine to count dropped messsages
-	go d.MonitorLoggers()
-
 	// read incoming dns message
 	d.LogInfo("waiting dns message to process...")
 RUN_LOOP:
@@ -161,9 +158,11 @@ RUN_LOOP:
 				d.LogInfo("channel closed, exit")
 				return
 			}
+			pkgutils.Telemetry.RecordReceived(d.name)
 
 			err := proto.Unmarshal(data, dt)
 			if err != nil {
+				d.reportDelivery(true)
 				continue
 			}
 
@@ -188,6 +187,7 @@ RUN_LOOP:
 			if d.config.Collectors.Dnstap.ExtendedSupport {
 				err := proto.Unmarshal(dt.GetExtra(), edt)
 				if err != nil {
+					d.reportDelivery(true)
 					continue
 				}
 
@@ -276,6 +276,39 @@ RUN_LOOP:
 				dm.DNSTap.TimeNsec = int(dt.GetMessage().GetResponseTimeNsec())
 			}
 
+			// query and response times, both can be provided in the same message
+			if dt.GetMessage().QueryTimeSec != nil {
+				dm.DNSTap.QueryTimeSec = int(dt.GetMessage().GetQueryTimeSec())
+				dm.DNSTap.QueryTimeNsec = int(dt.GetMessage().GetQueryTimeNsec())
+				qts := time.Unix(int64(dm.DNSTap.QueryTimeSec), int64(dm.DNSTap.QueryTimeNsec))
+				dm.DNSTap.QueryTimestamp = qts.UTC().Format(time.RFC3339Nano)
+			}
+			if dt.GetMessage().ResponseTimeSec != nil {
+				dm.DNSTap.ResponseTimeSec = int(dt.GetMessage().GetResponseTimeSec())
+				dm.DNSTap.ResponseTimeNsec = int(dt.GetMessage().GetResponseTimeNsec())
+				rts := time.Unix(int64(dm.DNSTap.ResponseTimeSec), int64(dm.DNSTap.ResponseTimeNsec))
+				dm.DNSTap.ResponseTimestamp = rts.UTC().Format(time.RFC3339Nano)
+			}
+
+			// zone of the query, for AUTH and RESOLVER messages
+			queryZone := dt.GetMessage().GetQueryZone()
+			if len(queryZone) > 0 {
+				zone, _, err := dnsutils.ParseLabels(0, queryZone)
+				if err != nil {
+					d.LogError("invalid query zone: %v", err)
+				} else if len(zone) == 0 {
+					dm.DNSTap.QueryZone = "."
+				} else {
+					dm.DNSTap.QueryZone = zone
+				}
+			}
+
+			// on RESOLVER and FORWARDER messages, the query address is the address of the resolver
+			if strings.HasPrefix(dm.DNSTap.Operation, "RESOLVER_") || strings.HasPrefix(dm.DNSTap.Operation, "FORWARDER_") {
+				dm.DNSTap.ResolverIP = dm.NetworkInfo.QueryIP
+				dm.DNSTap.ResolverPort = dm.NetworkInfo.QueryPort
+			}
+
 			// policy
 			policyType := dt.GetMessage().GetPolicy().GetType()
 			if len(policyType) > 0 {
@@ -287,14 +320,13 @@ RUN_LOOP:
 				dm.DNSTap.PolicyRule = policyRule
 			}
 
-			policyAction := dt.GetMessage().GetPolicy().GetAction().String()
-			if len(policyAction) > 0 {
-				dm.DNSTap.PolicyAction = policyAction
+			// action and match are optional, the getters return a default value
+			if policy := dt.GetMessage().GetPolicy(); policy != nil && policy.Action != nil {
+				dm.DNSTap.PolicyAction = policy.GetAction().String()
 			}
 
-			policyMatch := dt.GetMessage().GetPolicy().GetMatch().String()
-			if len(policyMatch) > 0 {
-				dm.DNSTap.PolicyMatch = policyMatch
+			if policy := dt.GetMessage().GetPolicy(); policy != nil && policy.Match != nil {
+				dm.DNSTap.PolicyMatch = policy.GetMatch().String()
 			}
 
 			policyValue := string(dt.GetMessage().GetPolicy().GetValue())
@@ -314,11 +346,13 @@ RUN_LOOP:
 				if err != nil {
 					// parser error
 					dm.DNS.MalformedPacket = true
+					pkgutils.Telemetry.RecordHeaderError(d.name)
 					d.LogInfo("dns parser malformed packet: %s", err)
 				}
 
 				if err = dnsutils.DecodePayload(&dm, &dnsHeader, d.config); err != nil {
 					// decoding error
+					pkgutils.Telemetry.RecordDecoderError(d.name, err)
 					if d.config.Global.Trace.LogMalformed {
 						d.LogError("%v - %v", err, dm)
 						d.LogError("dump invalid dns payload: %v", dm.DNS.Payload)
@@ -327,14 +361,13 @@ RUN_LOOP:
 			}
 
 			// apply all enabled transformers
-			if transforms.ProcessMessage(&dm) == transformers.ReturnDrop {
-				for i := range droppedRoutes {
-					select {
-					case droppedRoutes[i] <- dm: // Successful send to logger channel
-					default:
-						d.dropped <- droppedNames[i]
-					}
-				}
+			switch transforms.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				d.reportDelivery(true)
+				continue
+			case transformers.ReturnDrop:
+				d.reportDelivery(d.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm))
 				continue
 			}
 
@@ -342,49 +375,10 @@ RUN_LOOP:
 			dm.DNSTap.LatencySec = fmt.Sprintf("%.6f", dm.DNSTap.Latency)
 
 			// dispatch dns message to connected routes
-			for i := range defaultRoutes {
-				select {
-				case defaultRoutes[i] <- dm: // Successful send to logger channel
-				default:
-					d.dropped <- defaultNames[i]
-				}
-			}
+			d.reportDelivery(d.RoutingHandler.SendTo(defaultRoutes, defaultNames, dm))
 
 		}
 	}
 
 	d.LogInfo("processing terminated")
 }
-
-func (d *DNSTapProcessor) MonitorLoggers() {
-	watchInterval := 10 * time.Second
-	bufferFull := time.NewTimer(watchInterval)
-MONITOR_LOOP:
-	for {
-		select {
-		case <-d.stopMonitor:
-			close(d.dropped)
-			bufferFull.Stop()
-			d.doneMonitor <- true
-			break MONITOR_LOOP
-
-		case loggerName := <-d.dropped:
-			if _, ok := d.droppedCount[loggerName]; !ok {
-				d.droppedCount[loggerName] = 1
-			} else {
-				d.droppedCount[loggerName]++
-			}
-
-		case <-bufferFull.C:
-			for v, k := range d.droppedCount {
-				if k > 0 {
-					d.LogError("logger[%s] buffer is full, %d packet(s) dropped", v, k)
-					d.droppedCount[v] = 0
-				}
-			}
-			bufferFull.Reset(watchInterval)
-
-		}
-	}
-	d.LogInfo("monitor terminated")
-}
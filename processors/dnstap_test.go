@@ -53,6 +53,97 @@ func Test_DnstapProcessor(t *testing.T) {
 	}
 }
 
+func Test_DnstapProcessor_Delivered(t *testing.T) {
+	// init the dnstap consumer with the delivery report
+	consumer := NewDNSTapProcessor(0, pkgconfig.GetFakeConfig(), logger.New(false), "test", 512)
+	consumer.Delivered = make(chan bool, 1)
+
+	dnsquery, _ := GetFakeDNS()
+	data, _ := proto.Marshal(GetFakeDNSTap(dnsquery))
+
+	fl := pkgutils.NewFakeLogger()
+	go consumer.Run([]pkgutils.Worker{fl}, []pkgutils.Worker{fl})
+
+	// invalid payloads are reported as delivered
+	consumer.GetChannel() <- []byte{0xff, 0xff}
+	if !<-consumer.Delivered {
+		t.Errorf("invalid payload should be reported as delivered")
+	}
+
+	consumer.GetChannel() <- data
+	if !<-consumer.Delivered {
+		t.Errorf("message should be delivered")
+	}
+
+	// the channel of the logger is full, the message is dropped
+	for len(fl.GetInputChannel()) < cap(fl.GetInputChannel()) {
+		fl.GetInputChannel() <- dnsutils.GetFakeDNSMessage()
+	}
+	consumer.GetChannel() <- data
+	if <-consumer.Delivered {
+		t.Errorf("dropped message should be reported")
+	}
+
+	consumer.Stop()
+}
+
+func Test_DnstapProcessor_ResolverFields(t *testing.T) {
+	// init the dnstap consumer
+	consumer := NewDNSTapProcessor(0, pkgconfig.GetFakeConfig(), logger.New(false), "test", 512)
+
+	// prepare dns reply
+	dnsmsg := new(dns.Msg)
+	dnsmsg.SetQuestion(ExpectedQname+".", dns.TypeA)
+	dnsmsg.Response = true
+	dnsreply, _ := dnsmsg.Pack()
+
+	// prepare dnstap
+	zone := make([]byte, 256)
+	offset, _ := dns.PackDomainName("dnscollector.dev.", zone, 0, nil, false)
+
+	dt := &dnstap.Dnstap{}
+	dt.Type = dnstap.Dnstap_Type.Enum(1)
+
+	dt.Message = &dnstap.Message{}
+	dt.Message.Type = dnstap.Message_Type.Enum(dnstap.Message_RESOLVER_RESPONSE)
+	dt.Message.ResponseMessage = dnsreply
+	dt.Message.QueryAddress = []byte{10, 0, 0, 1}
+	dt.Message.QueryPort = proto.Uint32(53000)
+	dt.Message.QueryTimeSec = proto.Uint64(1704486841)
+	dt.Message.QueryTimeNsec = proto.Uint32(216166066)
+	dt.Message.ResponseTimeSec = proto.Uint64(1704486841)
+	dt.Message.ResponseTimeNsec = proto.Uint32(227961611)
+	dt.Message.QueryZone = zone[:offset]
+	dt.Message.Policy = &dnstap.Policy{Type: proto.String("RPZ"), Match: dnstap.Policy_QNAME.Enum()}
+
+	data, _ := proto.Marshal(dt)
+
+	// run the consumer with a fake logger
+	fl := pkgutils.NewFakeLogger()
+	go consumer.Run([]pkgutils.Worker{fl}, []pkgutils.Worker{fl})
+
+	// add packet to consumer
+	consumer.GetChannel() <- data
+
+	// read dns message from dnstap consumer
+	dm := <-fl.GetInputChannel()
+	if dm.DNSTap.QueryZone != "dnscollector.dev" {
+		t.Errorf("invalid query zone: %s", dm.DNSTap.QueryZone)
+	}
+	if dm.DNSTap.QueryTimestamp != "2024-01-05T20:34:01.216166066Z" {
+		t.Errorf("invalid query timestamp: %s", dm.DNSTap.QueryTimestamp)
+	}
+	if dm.DNSTap.ResponseTimestamp != "2024-01-05T20:34:01.227961611Z" {
+		t.Errorf("invalid response timestamp: %s", dm.DNSTap.ResponseTimestamp)
+	}
+	if dm.DNSTap.ResolverIP != "10.0.0.1" || dm.DNSTap.ResolverPort != "53000" {
+		t.Errorf("invalid resolver address: %s %s", dm.DNSTap.ResolverIP, dm.DNSTap.ResolverPort)
+	}
+	if dm.DNSTap.PolicyType != "RPZ" || dm.DNSTap.PolicyMatch != "QNAME" || dm.DNSTap.PolicyAction != "-" {
+		t.Errorf("invalid policy: %s %s %s", dm.DNSTap.PolicyType, dm.DNSTap.PolicyMatch, dm.DNSTap.PolicyAction)
+	}
+}
+
 func Test_DnstapProcessor_MalformedDnsHeader(t *testing.T) {
 	logger := logger.New(true)
 	var o bytes.Buffer
@@ -31,8 +31,6 @@ type PdnsProcessor struct {
 	ConnID         int
 	doneRun        chan bool
 	stopRun        chan bool
-	doneMonitor    chan bool
-	stopMonitor    chan bool
 	recvFrom       chan []byte
 	logger         *logger.Logger
 	config         *pkgconfig.Config
@@ -40,17 +38,13 @@ type PdnsProcessor struct {
 	name           string
 	chanSize       int
 	RoutingHandler pkgutils.RoutingHandler
-	dropped        chan string
-	droppedCount   map[string]int
 }
 
 func NewPdnsProcessor(connID int, config *pkgconfig.Config, logger *logger.Logger, name string, size int) PdnsProcessor {
 	logger.Info(pkgutils.PrefixLogProcessor+"[%s] powerdns - conn #%d - initialization...", name, connID)
 	d := PdnsProcessor{
 		ConnID:         connID,
-		doneMonitor:    make(chan bool),
 		doneRun:        make(chan bool),
-		stopMonitor:    make(chan bool),
 		stopRun:        make(chan bool),
 		recvFrom:       make(chan []byte, size),
 		chanSize:       size,
@@ -59,8 +53,6 @@ func NewPdnsProcessor(connID int, config *pkgconfig.Config, logger *logger.Logge
 		ConfigChan:     make(chan *pkgconfig.Config),
 		name:           name,
 		RoutingHandler: pkgutils.NewRoutingHandler(config, logger, name),
-		dropped:        make(chan string),
-		droppedCount:   map[string]int{},
 	}
 	return d
 }
@@ -96,25 +88,20 @@ func (p *PdnsProcessor) Stop() {
 	p.LogInfo("stopping to process...")
 	p.stopRun <- true
 	<-p.doneRun
-
-	p.LogInfo("stopping to monitor loggers...")
-	p.stopMonitor <- true
-	<-p.doneMonitor
 }
 
 func (p *PdnsProcessor) Run(defaultWorkers []pkgutils.Worker, droppedworkers []pkgutils.Worker) {
 	pbdm := &powerdns_protobuf.PBDNSMessage{}
 
-	// prepare next channels
+	// prepare next channels, the delivery depends on the next workers
+	p.RoutingHandler.SetDefaultRoutes(defaultWorkers)
+	p.RoutingHandler.SetDroppedRoutes(droppedworkers)
 	defaultRoutes, defaultNames := pkgutils.GetRoutes(defaultWorkers)
 	droppedRoutes, droppedNames := pkgutils.GetRoutes(droppedworkers)
 
 	// prepare enabled transformers
 	transforms := transformers.NewTransforms(&p.config.IngoingTransformers, p.logger, p.name, defaultRoutes, p.ConnID)
 
-	// start goroutine to count dropped messsages
-	go p.MonitorLoggers()
-
 	// read incoming dns message
 	p.LogInfo("waiting dns message to process...")
 RUN_LOOP:
@@ -134,6 +121,7 @@ RUN_LOOP:
 				p.LogInfo("channel closed, exit")
 				return
 			}
+			pkgutils.Telemetry.RecordReceived(p.name)
 
 			err := proto.Unmarshal(data, pbdm)
 			if err != nil {
@@ -333,60 +321,18 @@ RUN_LOOP:
 			}
 
 			// apply all enabled transformers
-			if transforms.ProcessMessage(&dm) == transformers.ReturnDrop {
-				for i := range droppedRoutes {
-					select {
-					case droppedRoutes[i] <- dm: // Successful send to logger channel
-					default:
-						p.dropped <- droppedNames[i]
-					}
-				}
+			switch transforms.ProcessMessage(&dm) {
+			case transformers.ReturnHold:
+				// the message is kept by a transformer and emitted later
+				continue
+			case transformers.ReturnDrop:
+				p.RoutingHandler.SendTo(droppedRoutes, droppedNames, dm)
 				continue
 			}
 
 			// dispatch dns messages to connected loggers
-			for i := range defaultRoutes {
-				select {
-				case defaultRoutes[i] <- dm: // Successful send to logger channel
-				default:
-					p.dropped <- defaultNames[i]
-				}
-			}
+			p.RoutingHandler.SendTo(defaultRoutes, defaultNames, dm)
 		}
 	}
 	p.LogInfo("processing terminated")
 }
-
-func (p *PdnsProcessor) MonitorLoggers() {
-	watchInterval := 10 * time.Second
-	bufferFull := time.NewTimer(watchInterval)
-FOLLOW_LOOP:
-	for {
-		select {
-		case <-p.stopMonitor:
-			close(p.dropped)
-			bufferFull.Stop()
-			p.doneMonitor <- true
-			break FOLLOW_LOOP
-
-		case loggerName := <-p.dropped:
-			if _, ok := p.droppedCount[loggerName]; !ok {
-				p.droppedCount[loggerName] = 1
-			} else {
-				p.droppedCount[loggerName]++
-			}
-
-		case <-bufferFull.C:
-
-			for v, k := range p.droppedCount {
-				if k > 0 {
-					p.LogError("logger[%s] buffer is full, %d packet(s) dropped", v, k)
-					p.droppedCount[v] = 0
-				}
-			}
-			bufferFull.Reset(watchInterval)
-
-		}
-	}
-	p.LogInfo("monitor terminated")
-}
@@ -31,6 +31,8 @@ type FilteringProcessor struct {
 	name                 string
 	downsample           int
 	downsampleCount      int
+	expression           *dnsutils.Expression
+	invalidExpression    bool
 	activeFilters        []func(dm *dnsutils.DNSMessage) bool
 	instance             int
 	outChannels          []chan dnsutils.DNSMessage
@@ -132,6 +134,11 @@ func (p *FilteringProcessor) LoadActiveFilters() {
 		p.activeFilters = append(p.activeFilters, p.keepDomainRegexFilter)
 	}
 
+	if p.expression != nil || p.invalidExpression {
+		p.activeFilters = append(p.activeFilters, p.expressionFilter)
+		p.LogInfo("expression subprocessor is enabled")
+	}
+
 	// set downsample if desired
 	if p.config.Filtering.Downsample > 0 {
 		p.downsample = p.config.Filtering.Downsample
@@ -153,6 +160,24 @@ func (p *FilteringProcessor) LoadRcodes() {
 	}
 }
 
+func (p *FilteringProcessor) LoadExpression() {
+	p.expression = nil
+	p.invalidExpression = false
+	if len(p.config.Filtering.Expression) == 0 {
+		return
+	}
+
+	// an invalid expression drops all messages, rather than letting them pass
+	expression, err := dnsutils.CompileExpression(p.config.Filtering.Expression)
+	if err != nil {
+		p.LogError("invalid expression, all messages are dropped: %v", err)
+		p.invalidExpression = true
+		return
+	}
+	p.expression = expression
+	p.LogInfo("loaded with expression: %s", expression)
+}
+
 func (p *FilteringProcessor) LoadQueryIPList() {
 	if len(p.config.Filtering.DropQueryIPFile) > 0 {
 		read, err := p.loadQueryIPList(p.config.Filtering.DropQueryIPFile, true)
@@ -431,6 +456,14 @@ func (p *FilteringProcessor) keepDomainRegexFilter(dm *dnsutils.DNSMessage) bool
 	return true
 }
 
+// drop messages matching the expression
+func (p *FilteringProcessor) expressionFilter(dm *dnsutils.DNSMessage) bool {
+	if p.invalidExpression {
+		return true
+	}
+	return p.expression.Evaluate(dm)
+}
+
 // drop all except every nth entry
 func (p *FilteringProcessor) downsampleFilter(dm *dnsutils.DNSMessage) bool {
 	// Increment the downsampleCount for each processed DNS message.
@@ -513,3 +513,55 @@ func TestFilteringMultipleFilters(t *testing.T) {
 		t.Errorf("dns query should be dropped!")
 	}
 }
+
+func TestFilteringByExpression(t *testing.T) {
+	// config
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Filtering.Enable = true
+	config.Filtering.Expression = `dns.rcode == "NXDOMAIN" && network.query-ip in 10.0.0.0/8`
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DNSMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	filtering.LoadExpression()
+	filtering.LoadActiveFilters()
+
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNS.Rcode = "NXDOMAIN"
+	if filtering.CheckIfDrop(&dm) {
+		t.Errorf("dns query should not be dropped")
+	}
+
+	dm.NetworkInfo.QueryIP = "10.0.0.1"
+	if !filtering.CheckIfDrop(&dm) {
+		t.Errorf("dns query should be dropped")
+	}
+
+	dm.DNS.Rcode = "NOERROR"
+	if filtering.CheckIfDrop(&dm) {
+		t.Errorf("dns query should not be dropped")
+	}
+}
+
+func TestFilteringByInvalidExpression(t *testing.T) {
+	// config
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Filtering.Enable = true
+	config.Filtering.Expression = `dns.rcode ==`
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DNSMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	filtering.LoadExpression()
+	filtering.LoadActiveFilters()
+
+	// the filter is not disabled, all messages are dropped
+	dm := dnsutils.GetFakeDNSMessage()
+	if !filtering.CheckIfDrop(&dm) {
+		t.Errorf("dns query should be dropped with an invalid expression")
+	}
+}
@@ -0,0 +1,317 @@
+package transformers
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+const (
+	hostnameFromAddress = iota
+	hostnameFromPTR
+)
+
+// name learned from the traffic
+type hostnameEntry struct {
+	name   string
+	source int
+	expire time.Time
+}
+
+// hostnames processor
+type HostnamesProcessor struct {
+	config      *pkgconfig.ConfigTransformers
+	logger      *logger.Logger
+	name        string
+	instance    int
+	learned     *lru.Cache[netip.Addr, hostnameEntry]
+	static      map[netip.Addr]string
+	filesMtime  map[string]time.Time
+	lastRefresh time.Time
+	outChannels []chan dnsutils.DNSMessage
+	logInfo     func(msg string, v ...interface{})
+	logError    func(msg string, v ...interface{})
+}
+
+func NewHostnamesSubprocessor(config *pkgconfig.ConfigTransformers, logger *logger.Logger, name string,
+	instance int, outChannels []chan dnsutils.DNSMessage,
+	logInfo func(msg string, v ...interface{}), logError func(msg string, v ...interface{}),
+) *HostnamesProcessor {
+	s := HostnamesProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		instance:    instance,
+		static:      make(map[netip.Addr]string),
+		filesMtime:  make(map[string]time.Time),
+		outChannels: outChannels,
+		logInfo:     logInfo,
+		logError:    logError,
+	}
+	s.learned, _ = lru.New[netip.Addr, hostnameEntry](config.Hostnames.MaxEntries)
+
+	return &s
+}
+
+func (s *HostnamesProcessor) ReloadConfig(config *pkgconfig.ConfigTransformers) {
+	s.config = config
+	s.learned.Resize(config.Hostnames.MaxEntries)
+}
+
+func (s *HostnamesProcessor) LogInfo(msg string, v ...interface{}) {
+	log := fmt.Sprintf("hostnames#%d - ", s.instance)
+	s.logInfo(log+msg, v...)
+}
+
+func (s *HostnamesProcessor) LogError(msg string, v ...interface{}) {
+	log := fmt.Sprintf("hostnames#%d - ", s.instance)
+	s.logError(log+msg, v...)
+}
+
+// LoadStaticFiles reads the hosts and the leases files, the files are read
+// again on the refresh interval if they have been modified.
+func (s *HostnamesProcessor) LoadStaticFiles() {
+	s.lastRefresh = time.Now()
+
+	static := make(map[netip.Addr]string)
+	filesMtime := make(map[string]time.Time)
+	for _, files := range []struct {
+		paths []string
+		read  func(f *os.File, static map[netip.Addr]string) error
+	}{
+		{s.config.Hostnames.HostsFiles, readHostsFile},
+		{s.config.Hostnames.LeasesFiles, readLeasesFile},
+	} {
+		for _, path := range files.paths {
+			f, err := os.Open(path)
+			if err != nil {
+				s.LogError("unable to open file: %v", err)
+				continue
+			}
+			if info, err := f.Stat(); err == nil {
+				filesMtime[path] = info.ModTime()
+			}
+			if err := files.read(f, static); err != nil {
+				s.LogError("unable to read file %s: %v", path, err)
+			}
+			f.Close()
+		}
+	}
+
+	s.static = static
+	s.filesMtime = filesMtime
+	s.LogInfo("static files loaded with %d entries", len(static))
+}
+
+func (s *HostnamesProcessor) refreshStaticFiles() {
+	interval := time.Duration(s.config.Hostnames.RefreshInterval) * time.Second
+	if interval <= 0 || time.Since(s.lastRefresh) < interval {
+		return
+	}
+	s.lastRefresh = time.Now()
+
+	for _, path := range append(s.config.Hostnames.HostsFiles, s.config.Hostnames.LeasesFiles...) {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if mtime, ok := s.filesMtime[path]; !ok || !mtime.Equal(info.ModTime()) {
+			s.LoadStaticFiles()
+			return
+		}
+	}
+}
+
+func hostnameNormalize(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// readHostsFile loads the /etc/hosts style files, the first name is used
+func readHostsFile(f *os.File, static map[netip.Addr]string) error {
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if addr, err := netip.ParseAddr(fields[0]); err == nil {
+			static[addr.Unmap()] = hostnameNormalize(fields[1])
+		}
+	}
+	return scanner.Err()
+}
+
+// readLeasesFile loads the dnsmasq and the ISC dhcpd leases, expired or free leases are ignored
+func readLeasesFile(f *os.File, static map[netip.Addr]string) error {
+	now := time.Now().Unix()
+
+	var lease netip.Addr
+	var hostname string
+	active := true
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ";"))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch {
+		// isc dhcpd: lease 192.168.1.10 {
+		case fields[0] == "lease" && len(fields) >= 2:
+			lease, _ = netip.ParseAddr(fields[1])
+			hostname = ""
+			active = true
+		case fields[0] == "client-hostname" && len(fields) >= 2:
+			hostname = strings.Trim(fields[1], "\"")
+		case fields[0] == "binding" && len(fields) >= 3 && fields[1] == "state":
+			active = fields[2] == "active"
+		case fields[0] == "}":
+			if !lease.IsValid() {
+				continue
+			}
+			// the last lease of an address is the current one
+			delete(static, lease.Unmap())
+			if active && len(hostname) > 0 {
+				static[lease.Unmap()] = hostnameNormalize(hostname)
+			}
+			lease = netip.Addr{}
+
+		// dnsmasq: <expiry> <mac> <ip> <hostname> <client-id>
+		case len(fields) >= 4:
+			expiry, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil || (expiry > 0 && expiry < now) || fields[3] == "*" {
+				continue
+			}
+			if addr, err := netip.ParseAddr(fields[2]); err == nil {
+				static[addr.Unmap()] = hostnameNormalize(fields[3])
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// reverseAddr returns the address of a in-addr.arpa or ip6.arpa name
+func reverseAddr(qname string) (netip.Addr, bool) {
+	qname = hostnameNormalize(qname)
+
+	var ip string
+	switch {
+	case strings.HasSuffix(qname, ".in-addr.arpa"):
+		labels := strings.Split(strings.TrimSuffix(qname, ".in-addr.arpa"), ".")
+		if len(labels) != 4 {
+			return netip.Addr{}, false
+		}
+		for i := len(labels) - 1; i >= 0; i-- {
+			ip += labels[i]
+			if i > 0 {
+				ip += "."
+			}
+		}
+	case strings.HasSuffix(qname, ".ip6.arpa"):
+		nibbles := strings.Split(strings.TrimSuffix(qname, ".ip6.arpa"), ".")
+		if len(nibbles) != 32 {
+			return netip.Addr{}, false
+		}
+		for i := len(nibbles) - 1; i >= 0; i-- {
+			ip += nibbles[i]
+			if i > 0 && i%4 == 0 {
+				ip += ":"
+			}
+		}
+	default:
+		return netip.Addr{}, false
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr, true
+}
+
+func (s *HostnamesProcessor) learn(addr netip.Addr, name string, source int, ttl int) {
+	ttl = max(ttl, s.config.Hostnames.MinTTL)
+	ttl = min(ttl, s.config.Hostnames.MaxTTL)
+
+	// the names from the ptr replies are preferred
+	if entry, ok := s.learned.Peek(addr); ok && entry.source > source && time.Now().Before(entry.expire) {
+		return
+	}
+	s.learned.Add(addr, hostnameEntry{name: hostnameNormalize(name), source: source,
+		expire: time.Now().Add(time.Duration(ttl) * time.Second)})
+}
+
+func (s *HostnamesProcessor) InitDNSMessage(dm *dnsutils.DNSMessage) {
+	if dm.Hostnames == nil {
+		dm.Hostnames = &dnsutils.TransformHostnames{
+			QueryHostname:    "-",
+			ResponseHostname: "-",
+		}
+	}
+}
+
+// Learn adds the names of the PTR replies and the A/AAAA answers
+func (s *HostnamesProcessor) Learn(dm *dnsutils.DNSMessage) {
+	if dm.DNS.Type != dnsutils.DNSReply || dm.DNS.Rcode != "NOERROR" {
+		return
+	}
+
+	for _, rr := range dm.DNS.DNSRRs.Answers {
+		switch rr.Rdatatype {
+		case "PTR":
+			if addr, ok := reverseAddr(rr.Name); ok {
+				s.learn(addr, rr.Rdata, hostnameFromPTR, rr.TTL)
+			}
+		case "A", "AAAA":
+			if addr, err := netip.ParseAddr(rr.Rdata); err == nil {
+				s.learn(addr.Unmap(), rr.Name, hostnameFromAddress, rr.TTL)
+			}
+		}
+	}
+}
+
+// Lookup returns the name of the ip, the static entries are preferred
+func (s *HostnamesProcessor) Lookup(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+	addr = addr.Unmap()
+
+	if name, ok := s.static[addr]; ok {
+		return name, true
+	}
+	entry, ok := s.learned.Get(addr)
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expire) {
+		s.learned.Remove(addr)
+		return "", false
+	}
+	return entry.name, true
+}
+
+func (s *HostnamesProcessor) AddHostnames(dm *dnsutils.DNSMessage) int {
+	s.refreshStaticFiles()
+	s.Learn(dm)
+
+	if name, ok := s.Lookup(dm.NetworkInfo.QueryIP); ok {
+		dm.Hostnames.QueryHostname = name
+	}
+	if name, ok := s.Lookup(dm.NetworkInfo.ResponseIP); ok {
+		dm.Hostnames.ResponseHostname = name
+	}
+	return ReturnSuccess
+}
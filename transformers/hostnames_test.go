@@ -0,0 +1,183 @@
+package transformers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+)
+
+func TestHostnames_LearnFromReplies(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Hostnames.Enable = true
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	hostnames := NewHostnamesSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+
+	// learn from a ptr reply and from a AAAA reply
+	ptr := dnsutils.GetFakeDNSMessage()
+	ptr.DNS.Type = dnsutils.DNSReply
+	ptr.DNS.Qname = "4.3.2.1.in-addr.arpa"
+	ptr.DNS.Qtype = "PTR"
+	ptr.DNS.DNSRRs.Answers = []dnsutils.DNSAnswer{{Name: "4.3.2.1.in-addr.arpa", Rdatatype: "PTR", TTL: 3600, Rdata: "Laptop.lan."}}
+	hostnames.InitDNSMessage(&ptr)
+	hostnames.AddHostnames(&ptr)
+
+	aaaa := dnsutils.GetFakeDNSMessage()
+	aaaa.DNS.Type = dnsutils.DNSReply
+	aaaa.DNS.Qname = "resolver.lan"
+	aaaa.DNS.Qtype = "AAAA"
+	aaaa.DNS.DNSRRs.Answers = []dnsutils.DNSAnswer{{Name: "resolver.lan", Rdatatype: "AAAA", TTL: 300, Rdata: "2001:db8::53"}}
+	hostnames.InitDNSMessage(&aaaa)
+	hostnames.AddHostnames(&aaaa)
+
+	// the names are added to the next messages
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.NetworkInfo.QueryIP = "1.2.3.4"
+	dm.NetworkInfo.ResponseIP = "2001:db8::53"
+	hostnames.InitDNSMessage(&dm)
+	hostnames.AddHostnames(&dm)
+	if dm.Hostnames.QueryHostname != "laptop.lan" {
+		t.Errorf("invalid query hostname, got %s", dm.Hostnames.QueryHostname)
+	}
+	if dm.Hostnames.ResponseHostname != "resolver.lan" {
+		t.Errorf("invalid response hostname, got %s", dm.Hostnames.ResponseHostname)
+	}
+
+	// the name from the ptr reply is not replaced by a A answer
+	a := dnsutils.GetFakeDNSMessage()
+	a.DNS.Type = dnsutils.DNSReply
+	a.DNS.DNSRRs.Answers = []dnsutils.DNSAnswer{{Name: "www.dnscollector.dev", Rdatatype: "A", TTL: 300, Rdata: "1.2.3.4"}}
+	hostnames.InitDNSMessage(&a)
+	hostnames.AddHostnames(&a)
+	if name, _ := hostnames.Lookup("1.2.3.4"); name != "laptop.lan" {
+		t.Errorf("ptr name should be kept, got %s", name)
+	}
+
+	// unknown ip
+	if _, ok := hostnames.Lookup("10.0.0.1"); ok {
+		t.Errorf("no name expected")
+	}
+}
+
+func TestHostnames_Expiry(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Hostnames.Enable = true
+	config.Hostnames.MinTTL = 0
+	config.Hostnames.MaxEntries = 2
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	hostnames := NewHostnamesSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNS.Type = dnsutils.DNSReply
+	for i := 1; i <= 3; i++ {
+		dm.DNS.DNSRRs.Answers = append(dm.DNS.DNSRRs.Answers,
+			dnsutils.DNSAnswer{Name: fmt.Sprintf("host%d.lan", i), Rdatatype: "A", TTL: 1, Rdata: fmt.Sprintf("10.0.0.%d", i)})
+	}
+	hostnames.Learn(&dm)
+
+	// size cap
+	if _, ok := hostnames.Lookup("10.0.0.1"); ok {
+		t.Errorf("oldest entry should be evicted")
+	}
+	if name, _ := hostnames.Lookup("10.0.0.3"); name != "host3.lan" {
+		t.Errorf("invalid name, got %s", name)
+	}
+
+	// ttl expiry
+	time.Sleep(1100 * time.Millisecond)
+	if _, ok := hostnames.Lookup("10.0.0.3"); ok {
+		t.Errorf("entry should be expired")
+	}
+}
+
+func TestHostnames_StaticFiles(t *testing.T) {
+	dir := t.TempDir()
+	hostsFile := filepath.Join(dir, "hosts")
+	dnsmasqFile := filepath.Join(dir, "dnsmasq.leases")
+	dhcpdFile := filepath.Join(dir, "dhcpd.leases")
+
+	files := map[string]string{
+		hostsFile: "127.0.0.1 localhost\n192.168.1.1 router.lan router # gateway\n",
+		dnsmasqFile: fmt.Sprintf("%d aa:bb:cc:dd:ee:01 192.168.1.10 phone 01:aa:bb:cc:dd:ee:01\n", time.Now().Add(time.Hour).Unix()) +
+			"1 aa:bb:cc:dd:ee:02 192.168.1.11 expired *\n" +
+			"0 aa:bb:cc:dd:ee:03 192.168.1.12 nas *\n",
+		dhcpdFile: `lease 192.168.1.20 {
+  binding state active;
+  client-hostname "desktop";
+}
+lease 192.168.1.21 {
+  binding state active;
+  client-hostname "printer";
+}
+lease 192.168.1.21 {
+  binding state free;
+}
+`,
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Hostnames.Enable = true
+	config.Hostnames.HostsFiles = []string{hostsFile}
+	config.Hostnames.LeasesFiles = []string{dnsmasqFile, dhcpdFile}
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	hostnames := NewHostnamesSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+	hostnames.LoadStaticFiles()
+
+	for ip, expected := range map[string]string{
+		"192.168.1.1":  "router.lan",
+		"192.168.1.10": "phone",
+		"192.168.1.11": "",
+		"192.168.1.12": "nas",
+		"192.168.1.20": "desktop",
+		"192.168.1.21": "",
+	} {
+		if name, _ := hostnames.Lookup(ip); name != expected {
+			t.Errorf("%s: want %q, got %q", ip, expected, name)
+		}
+	}
+}
+
+func TestHostnames_ReverseAddr(t *testing.T) {
+	for qname, expected := range map[string]string{
+		"4.3.2.1.in-addr.arpa.": "1.2.3.4",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa": "2001:db8::1",
+		"3.2.1.in-addr.arpa": "",
+		"dnscollector.dev":   "",
+	} {
+		addr, ok := reverseAddr(qname)
+		if len(expected) == 0 {
+			if ok {
+				t.Errorf("%s: no address expected, got %s", qname, addr)
+			}
+			continue
+		}
+		if !ok || addr.String() != expected {
+			t.Errorf("%s: want %s, got %s", qname, expected, addr)
+		}
+	}
+}
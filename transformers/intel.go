@@ -0,0 +1,421 @@
+package transformers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+var (
+	IntelFormatRPZ   = "rpz"
+	IntelFormatHosts = "hosts"
+	IntelFormatCSV   = "csv"
+
+	IntelKindQname   = "qname"
+	IntelKindRdataIP = "rdata-ip"
+	IntelKindCNAME   = "cname"
+)
+
+type intelPrefix struct {
+	prefix   netip.Prefix
+	category string
+}
+
+// indicators of a feed
+type intelFeed struct {
+	name      string
+	category  string
+	domains   map[string]string
+	wildcards map[string]string
+	passthru  map[string]bool
+	ips       map[netip.Addr]string
+	prefixes  []intelPrefix
+}
+
+func newIntelFeed(name, category string) *intelFeed {
+	if len(category) == 0 {
+		category = "-"
+	}
+	return &intelFeed{
+		name:      name,
+		category:  category,
+		domains:   make(map[string]string),
+		wildcards: make(map[string]string),
+		passthru:  make(map[string]bool),
+		ips:       make(map[netip.Addr]string),
+	}
+}
+
+func (f *intelFeed) size() int {
+	n := len(f.domains) + len(f.ips) + len(f.prefixes)
+	for name := range f.wildcards {
+		if _, ok := f.domains[name]; !ok {
+			n++
+		}
+	}
+	return n
+}
+
+// addIP adds an ip address or a network, returns false if the value is not an ip
+func (f *intelFeed) addIP(value, category string) bool {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		f.ips[addr.Unmap()] = category
+		return true
+	}
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		if prefix.IsSingleIP() {
+			f.ips[prefix.Addr().Unmap()] = category
+		} else {
+			f.prefixes = append(f.prefixes, intelPrefix{prefix: prefix.Masked(), category: category})
+		}
+		return true
+	}
+	return false
+}
+
+func (f *intelFeed) lookupDomain(name string) (string, string, bool) {
+	if f.passthru[name] {
+		return "", "", false
+	}
+	if category, ok := f.domains[name]; ok {
+		return name, category, true
+	}
+	for i := strings.IndexByte(name, '.'); i >= 0; i = strings.IndexByte(name, '.') {
+		name = name[i+1:]
+		if category, ok := f.wildcards[name]; ok {
+			return name, category, true
+		}
+	}
+	return "", "", false
+}
+
+func (f *intelFeed) lookupIP(addr netip.Addr) (string, string, bool) {
+	if category, ok := f.ips[addr]; ok {
+		return addr.String(), category, true
+	}
+	for _, p := range f.prefixes {
+		if p.prefix.Contains(addr) {
+			return p.prefix.String(), p.category, true
+		}
+	}
+	return "", "", false
+}
+
+// intel processor
+type IntelProcessor struct {
+	config      *pkgconfig.ConfigTransformers
+	logger      *logger.Logger
+	name        string
+	instance    int
+	feeds       atomic.Pointer[[]*intelFeed]
+	loading     atomic.Bool
+	lastRefresh time.Time
+	httpClient  *http.Client
+	outChannels []chan dnsutils.DNSMessage
+	logInfo     func(msg string, v ...interface{})
+	logError    func(msg string, v ...interface{})
+}
+
+func NewIntelSubprocessor(config *pkgconfig.ConfigTransformers, logger *logger.Logger, name string,
+	instance int, outChannels []chan dnsutils.DNSMessage,
+	logInfo func(msg string, v ...interface{}), logError func(msg string, v ...interface{}),
+) *IntelProcessor {
+	s := IntelProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		instance:    instance,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		outChannels: outChannels,
+		logInfo:     logInfo,
+		logError:    logError,
+	}
+	s.feeds.Store(&[]*intelFeed{})
+
+	return &s
+}
+
+func (s *IntelProcessor) ReloadConfig(config *pkgconfig.ConfigTransformers) {
+	s.config = config
+}
+
+func (s *IntelProcessor) LogInfo(msg string, v ...interface{}) {
+	log := fmt.Sprintf("intel#%d - ", s.instance)
+	s.logInfo(log+msg, v...)
+}
+
+func (s *IntelProcessor) LogError(msg string, v ...interface{}) {
+	log := fmt.Sprintf("intel#%d - ", s.instance)
+	s.logError(log+msg, v...)
+}
+
+func (s *IntelProcessor) InitDNSMessage(dm *dnsutils.DNSMessage) {
+	if dm.Intel == nil {
+		dm.Intel = &dnsutils.TransformIntel{
+			Matches: []dnsutils.IntelMatch{},
+		}
+	}
+}
+
+// LoadFeeds reads all the feeds, the next refresh is done in background
+// after the refresh interval.
+func (s *IntelProcessor) LoadFeeds() {
+	s.lastRefresh = time.Now()
+	s.loadFeeds()
+}
+
+// the previous indicators of a feed are kept if the source can not be loaded
+func (s *IntelProcessor) loadFeeds() {
+	previous := make(map[string]*intelFeed)
+	for _, feed := range *s.feeds.Load() {
+		previous[feed.name] = feed
+	}
+
+	feeds := []*intelFeed{}
+	for _, cfg := range s.config.Intel.Feeds {
+		feed, err := s.LoadFeed(cfg)
+		if err != nil {
+			s.LogError("unable to load feed %s: %v", cfg.Name, err)
+			if old, ok := previous[cfg.Name]; ok {
+				feeds = append(feeds, old)
+			}
+			continue
+		}
+		s.LogInfo("feed %s loaded with %d indicators", cfg.Name, feed.size())
+		feeds = append(feeds, feed)
+	}
+	s.feeds.Store(&feeds)
+}
+
+func (s *IntelProcessor) openSource(source string) (io.ReadCloser, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := s.httpClient.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("invalid status code: %d", resp.StatusCode)
+		}
+		return resp.Body, nil
+	}
+
+	file, err := os.Open(strings.TrimPrefix(source, "file://"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open file: %w", err)
+	}
+	return file, nil
+}
+
+func (s *IntelProcessor) LoadFeed(cfg pkgconfig.ConfigIntelFeed) (*intelFeed, error) {
+	r, err := s.openSource(cfg.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	feed := newIntelFeed(cfg.Name, cfg.Category)
+	switch cfg.Format {
+	case IntelFormatRPZ:
+		err = feed.readRPZ(r, cfg.Source)
+	case IntelFormatHosts:
+		err = feed.readHosts(r)
+	case IntelFormatCSV:
+		err = feed.readCSV(r)
+	default:
+		err = fmt.Errorf("format not supported %s", cfg.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+// readRPZ loads the qname and response ip triggers of a RPZ zone,
+// the passthru rules are only applied on the qnames, the others triggers are ignored.
+func (f *intelFeed) readRPZ(r io.Reader, source string) error {
+	zp := dns.NewZoneParser(r, "", source)
+
+	apex := ""
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		owner := strings.ToLower(rr.Header().Name)
+		if rr.Header().Rrtype == dns.TypeSOA {
+			apex = owner
+			continue
+		}
+
+		owner = strings.TrimSuffix(strings.TrimSuffix(owner, apex), ".")
+		if cname, ok := rr.(*dns.CNAME); ok && strings.HasPrefix(cname.Target, "rpz-passthru.") {
+			f.passthru[owner] = true
+			continue
+		}
+		switch {
+		case strings.HasSuffix(owner, ".rpz-ip"):
+			if prefix, err := rpzIPTrigger(strings.TrimSuffix(owner, ".rpz-ip")); err == nil {
+				f.addIP(prefix, f.category)
+			}
+		case strings.HasSuffix(owner, ".rpz-nsdname"), strings.HasSuffix(owner, ".rpz-nsip"),
+			strings.HasSuffix(owner, ".rpz-client-ip"):
+			continue
+		case strings.HasPrefix(owner, "*."):
+			f.wildcards[strings.TrimPrefix(owner, "*.")] = f.category
+		case len(owner) > 0:
+			f.domains[owner] = f.category
+		}
+	}
+	return zp.Err()
+}
+
+// rpzIPTrigger converts the owner of a response ip trigger to a network,
+// 24.0.2.0.192 is 192.0.2.0/24 and 48.zz.db8.2001 is 2001:db8::/48
+func rpzIPTrigger(owner string) (string, error) {
+	labels := strings.Split(owner, ".")
+	if len(labels) < 2 {
+		return "", errors.New("invalid rpz-ip trigger")
+	}
+	if _, err := strconv.Atoi(labels[0]); err != nil {
+		return "", errors.New("invalid rpz-ip prefix length")
+	}
+
+	addr := labels[1:]
+	for i, j := 0, len(addr)-1; i < j; i, j = i+1, j-1 {
+		addr[i], addr[j] = addr[j], addr[i]
+	}
+	if ip := strings.Join(addr, "."); len(addr) == 4 {
+		if _, err := netip.ParseAddr(ip); err == nil {
+			return ip + "/" + labels[0], nil
+		}
+	}
+
+	// zz is the longest run of zeros
+	ip := strings.Join(addr, ":")
+	if addr[0] == "zz" || addr[len(addr)-1] == "zz" {
+		ip = strings.Replace(ip, "zz", ":", 1)
+	} else {
+		ip = strings.Replace(ip, "zz", "", 1)
+	}
+	return ip + "/" + labels[0], nil
+}
+
+// readHosts loads a hosts-style list, the ip before the names is optional
+func (f *intelFeed) readHosts(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			fields = fields[1:]
+		}
+		for _, name := range fields {
+			// ignore localhost and others names without domain
+			name = strings.TrimSuffix(strings.ToLower(name), ".")
+			if !strings.Contains(name, ".") {
+				continue
+			}
+			f.domains[name] = f.category
+			f.wildcards[name] = f.category
+		}
+	}
+	return scanner.Err()
+}
+
+// readCSV loads an indicator per line with an optional category,
+// the indicator can be a domain, an ip address or a network
+func (f *intelFeed) readCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		indicator := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(record[0])), ".")
+		if len(indicator) == 0 || indicator == "indicator" {
+			continue
+		}
+		category := f.category
+		if len(record) > 1 && len(record[1]) > 0 {
+			category = record[1]
+		}
+		if f.addIP(indicator, category) {
+			continue
+		}
+		f.domains[indicator] = category
+		f.wildcards[indicator] = category
+	}
+	return nil
+}
+
+// MatchIntel tags the message with the feeds matching the qname,
+// the ip addresses and the cname targets of the answers.
+func (s *IntelProcessor) MatchIntel(dm *dnsutils.DNSMessage) int {
+	if dm.Intel == nil {
+		s.LogError("transformer is not properly initialized")
+		return ReturnSuccess
+	}
+
+	// refresh the feeds in background, the current indicators are used until the end
+	interval := time.Duration(s.config.Intel.RefreshInterval) * time.Second
+	if interval > 0 && time.Since(s.lastRefresh) >= interval {
+		s.lastRefresh = time.Now()
+		if s.loading.CompareAndSwap(false, true) {
+			go func() {
+				defer s.loading.Store(false)
+				s.loadFeeds()
+			}()
+		}
+	}
+
+	qname := strings.TrimSuffix(strings.ToLower(dm.DNS.Qname), ".")
+	for _, feed := range *s.feeds.Load() {
+		if indicator, category, ok := feed.lookupDomain(qname); ok {
+			dm.Intel.Matches = append(dm.Intel.Matches, dnsutils.IntelMatch{Feed: feed.name, Category: category,
+				Indicator: indicator, Kind: IntelKindQname})
+		}
+
+		for _, rr := range dm.DNS.DNSRRs.Answers {
+			switch rr.Rdatatype {
+			case "A", "AAAA":
+				addr, err := netip.ParseAddr(rr.Rdata)
+				if err != nil {
+					continue
+				}
+				if indicator, category, ok := feed.lookupIP(addr.Unmap()); ok {
+					dm.Intel.Matches = append(dm.Intel.Matches, dnsutils.IntelMatch{Feed: feed.name, Category: category,
+						Indicator: indicator, Kind: IntelKindRdataIP})
+				}
+			case "CNAME":
+				target := strings.TrimSuffix(strings.ToLower(rr.Rdata), ".")
+				if indicator, category, ok := feed.lookupDomain(target); ok {
+					dm.Intel.Matches = append(dm.Intel.Matches, dnsutils.IntelMatch{Feed: feed.name, Category: category,
+						Indicator: indicator, Kind: IntelKindCNAME})
+				}
+			}
+		}
+	}
+	return ReturnSuccess
+}
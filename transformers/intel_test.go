@@ -0,0 +1,212 @@
+package transformers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+)
+
+const intelRPZ = `$TTL 300
+$ORIGIN rpz.local.
+@ SOA localhost. root.localhost. 1 43200 3600 86400 300
+  NS localhost.
+malware.example.com CNAME .
+*.phishing.example.com CNAME .
+safe.phishing.example.com CNAME rpz-passthru.
+24.0.2.0.192.rpz-ip CNAME .
+48.zz.db8.2001.rpz-ip CNAME .
+bad.ns.rpz-nsdname CNAME .
+`
+
+func intelWriteFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func intelMessage(qname string, answers ...dnsutils.DNSAnswer) dnsutils.DNSMessage {
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNS.Qname = qname
+	dm.DNS.DNSRRs.Answers = answers
+	return dm
+}
+
+func TestIntel_RPZ(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Intel.Enable = true
+	config.Intel.Feeds = []pkgconfig.ConfigIntelFeed{
+		{Name: "rpz", Source: "file://" + intelWriteFile(t, "rpz.zone", intelRPZ), Format: "rpz", Category: "malware"},
+	}
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	intel := NewIntelSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+	intel.LoadFeeds()
+
+	testcases := []struct {
+		name      string
+		dm        dnsutils.DNSMessage
+		indicator string
+		kind      string
+	}{
+		{"qname", intelMessage("MALWARE.example.com."), "malware.example.com", IntelKindQname},
+		{"qname no subdomains", intelMessage("www.malware.example.com"), "", ""},
+		{"wildcard", intelMessage("login.phishing.example.com"), "phishing.example.com", IntelKindQname},
+		{"wildcard no apex", intelMessage("phishing.example.com"), "", ""},
+		{"passthru", intelMessage("safe.phishing.example.com"), "", ""},
+		{"rdata ipv4", intelMessage("www.dnscollector.dev", dnsutils.DNSAnswer{Rdatatype: "A", Rdata: "192.0.2.10"}), "192.0.2.0/24", IntelKindRdataIP},
+		{"rdata ipv6", intelMessage("www.dnscollector.dev", dnsutils.DNSAnswer{Rdatatype: "AAAA", Rdata: "2001:db8::1"}), "2001:db8::/48", IntelKindRdataIP},
+		{"cname", intelMessage("www.dnscollector.dev", dnsutils.DNSAnswer{Rdatatype: "CNAME", Rdata: "malware.example.com"}), "malware.example.com", IntelKindCNAME},
+		{"nsdname ignored", intelMessage("bad.ns"), "", ""},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			intel.InitDNSMessage(&tc.dm)
+			intel.MatchIntel(&tc.dm)
+
+			if len(tc.indicator) == 0 {
+				if len(tc.dm.Intel.Matches) != 0 {
+					t.Errorf("no match expected, got %v", tc.dm.Intel.Matches)
+				}
+				return
+			}
+			if len(tc.dm.Intel.Matches) != 1 {
+				t.Fatalf("one match expected, got %v", tc.dm.Intel.Matches)
+			}
+			match := tc.dm.Intel.Matches[0]
+			if match.Feed != "rpz" || match.Category != "malware" || match.Indicator != tc.indicator || match.Kind != tc.kind {
+				t.Errorf("invalid match: %v", match)
+			}
+		})
+	}
+}
+
+func TestIntel_HostsAndCSV(t *testing.T) {
+	hosts := "# blocklist\n127.0.0.1 localhost\n0.0.0.0 ads.example.com tracker.example.com # comment\nspyware.example.net\n"
+
+	// csv feed served over http
+	csv := "indicator,category\nc2.example.org,botnet\n198.51.100.7,\n203.0.113.0/24,scanner\n"
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, csv)
+	}))
+	defer svr.Close()
+
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Intel.Enable = true
+	config.Intel.Feeds = []pkgconfig.ConfigIntelFeed{
+		{Name: "hosts", Source: intelWriteFile(t, "hosts", hosts), Format: "hosts", Category: "ads"},
+		{Name: "ioc", Source: svr.URL, Format: "csv", Category: "ioc"},
+	}
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	intel := NewIntelSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+	intel.LoadFeeds()
+
+	// subdomains are matched with the hosts and csv formats
+	dm := intelMessage("cdn.tracker.example.com")
+	intel.InitDNSMessage(&dm)
+	intel.MatchIntel(&dm)
+	if len(dm.Intel.Matches) != 1 || dm.Intel.Matches[0].Feed != "hosts" || dm.Intel.Matches[0].Indicator != "tracker.example.com" {
+		t.Errorf("invalid matches: %v", dm.Intel.Matches)
+	}
+
+	// localhost is ignored
+	dm = intelMessage("localhost")
+	intel.InitDNSMessage(&dm)
+	intel.MatchIntel(&dm)
+	if len(dm.Intel.Matches) != 0 {
+		t.Errorf("localhost should be ignored: %v", dm.Intel.Matches)
+	}
+
+	// all matches are added
+	dm = intelMessage("c2.example.org",
+		dnsutils.DNSAnswer{Rdatatype: "A", Rdata: "198.51.100.7"},
+		dnsutils.DNSAnswer{Rdatatype: "A", Rdata: "203.0.113.50"})
+	intel.InitDNSMessage(&dm)
+	intel.MatchIntel(&dm)
+	expected := []dnsutils.IntelMatch{
+		{Feed: "ioc", Category: "botnet", Indicator: "c2.example.org", Kind: IntelKindQname},
+		{Feed: "ioc", Category: "ioc", Indicator: "198.51.100.7", Kind: IntelKindRdataIP},
+		{Feed: "ioc", Category: "scanner", Indicator: "203.0.113.0/24", Kind: IntelKindRdataIP},
+	}
+	if len(dm.Intel.Matches) != len(expected) {
+		t.Fatalf("invalid matches: %v", dm.Intel.Matches)
+	}
+	for i := range expected {
+		if dm.Intel.Matches[i] != expected[i] {
+			t.Errorf("want %v, got %v", expected[i], dm.Intel.Matches[i])
+		}
+	}
+}
+
+func TestIntel_Refresh(t *testing.T) {
+	feed := intelWriteFile(t, "feed.csv", "old.example.com\n")
+
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Intel.Enable = true
+	config.Intel.RefreshInterval = 1
+	config.Intel.Feeds = []pkgconfig.ConfigIntelFeed{
+		{Name: "feed", Source: feed, Format: "csv", Category: "test"},
+		{Name: "missing", Source: filepath.Join(t.TempDir(), "missing.csv"), Format: "csv"},
+	}
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	intel := NewIntelSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+	intel.LoadFeeds()
+
+	// update the feed and wait the refresh in background
+	if err := os.WriteFile(feed, []byte("new.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	for i := 0; i < 50; i++ {
+		dm := intelMessage("new.example.com")
+		intel.InitDNSMessage(&dm)
+		intel.MatchIntel(&dm)
+		if len(dm.Intel.Matches) == 1 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("feed not refreshed")
+}
+
+func TestIntel_RPZIPTrigger(t *testing.T) {
+	for owner, expected := range map[string]string{
+		"32.1.2.0.192":      "192.0.2.1/32",
+		"48.zz.db8.2001":    "2001:db8::/48",
+		"128.1.zz.db8.2001": "2001:db8::1/128",
+		"128.1.zz":          "::1/128",
+	} {
+		prefix, err := rpzIPTrigger(owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prefix != expected {
+			t.Errorf("%s: want %s, got %s", owner, expected, prefix)
+		}
+	}
+}
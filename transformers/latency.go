@@ -39,6 +39,13 @@ func (mp *MapQueries) Exists(key uint64) (ok bool) {
 	return ok
 }
 
+func (mp *MapQueries) Get(key uint64) (dm dnsutils.DNSMessage, ok bool) {
+	mp.RLock()
+	defer mp.RUnlock()
+	dm, ok = mp.kv[key]
+	return dm, ok
+}
+
 func (mp *MapQueries) Set(key uint64, dm dnsutils.DNSMessage) {
 	mp.Lock()
 	defer mp.Unlock()
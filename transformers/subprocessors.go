@@ -17,6 +17,7 @@ var (
 	ReturnSuccess = 1
 	ReturnDrop    = 2
 	ReturnError   = 3
+	ReturnHold    = 4
 )
 
 type Transforms struct {
@@ -35,8 +36,18 @@ type Transforms struct {
 	ExtractProcessor         ExtractProcessor
 	MachineLearningTransform MlProcessor
 	ATagsTransform           ATagsProcessor
+	TunnelingTransform       *TunnelingProcessor
+	IntelTransform           *IntelProcessor
+	HostnamesTransform       *HostnamesProcessor
+	TransactionTransform     *TransactionProcessor
 
-	activeTransforms []func(dm *dnsutils.DNSMessage) int
+	activeTransforms []activeTransform
+}
+
+// activeTransform is an enabled transformation, the name is used as drop reason
+type activeTransform struct {
+	name    string
+	process func(dm *dnsutils.DNSMessage) int
 }
 
 func NewTransforms(config *pkgconfig.ConfigTransformers, logger *logger.Logger, name string, outChannels []chan dnsutils.DNSMessage, instance int) Transforms {
@@ -58,6 +69,10 @@ func NewTransforms(config *pkgconfig.ConfigTransformers, logger *logger.Logger,
 	d.GeoipTransform = NewDNSGeoIPProcessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
 	d.MachineLearningTransform = NewMachineLearningSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
 	d.ATagsTransform = NewATagsSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
+	d.TunnelingTransform = NewTunnelingSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
+	d.IntelTransform = NewIntelSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
+	d.HostnamesTransform = NewHostnamesSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
+	d.TransactionTransform = NewTransactionSubprocessor(config, logger, name, instance, outChannels, d.LogInfo, d.LogError)
 
 	d.Prepare()
 	return d
@@ -75,6 +90,10 @@ func (p *Transforms) ReloadConfig(config *pkgconfig.ConfigTransformers) {
 	p.ExtractProcessor.ReloadConfig(config)
 	p.MachineLearningTransform.ReloadConfig(config)
 	p.ATagsTransform.ReloadConfig(config)
+	p.TunnelingTransform.ReloadConfig(config)
+	p.IntelTransform.ReloadConfig(config)
+	p.HostnamesTransform.ReloadConfig(config)
+	p.TransactionTransform.ReloadConfig(config)
 
 	p.Prepare()
 }
@@ -97,7 +116,7 @@ func (p *Transforms) Prepare() error {
 	}
 
 	if p.config.GeoIP.Enable {
-		p.activeTransforms = append(p.activeTransforms, p.geoipTransform)
+		p.activeTransforms = append(p.activeTransforms, activeTransform{"geoip", p.geoipTransform})
 		p.LogInfo(prefixlog + "geoip subprocessor is " + enabled)
 
 		if err := p.GeoipTransform.Open(); err != nil {
@@ -108,17 +127,17 @@ func (p *Transforms) Prepare() error {
 	if p.config.UserPrivacy.Enable {
 		// Apply user privacy on qname and query ip
 		if p.config.UserPrivacy.AnonymizeIP {
-			p.activeTransforms = append(p.activeTransforms, p.anonymizeIP)
+			p.activeTransforms = append(p.activeTransforms, activeTransform{"user-privacy", p.anonymizeIP})
 			p.LogInfo(prefixlog + "ip anonymization subprocessor is enabled")
 		}
 
 		if p.config.UserPrivacy.MinimazeQname {
-			p.activeTransforms = append(p.activeTransforms, p.minimazeQname)
+			p.activeTransforms = append(p.activeTransforms, activeTransform{"user-privacy", p.minimazeQname})
 			p.LogInfo(prefixlog + "minimaze qname subprocessor is enabled")
 		}
 
 		if p.config.UserPrivacy.HashIP {
-			p.activeTransforms = append(p.activeTransforms, p.hashIP)
+			p.activeTransforms = append(p.activeTransforms, activeTransform{"user-privacy", p.hashIP})
 			p.LogInfo(prefixlog + "hash ip subprocessor is enabled")
 		}
 	}
@@ -130,23 +149,24 @@ func (p *Transforms) Prepare() error {
 		p.FilteringTransform.LoadDomainsList()
 		p.FilteringTransform.LoadQueryIPList()
 		p.FilteringTransform.LoadrDataIPList()
+		p.FilteringTransform.LoadExpression()
 
 		p.FilteringTransform.LoadActiveFilters()
 	}
 
 	if p.config.Latency.Enable {
 		if p.config.Latency.MeasureLatency {
-			p.activeTransforms = append(p.activeTransforms, p.measureLatency)
+			p.activeTransforms = append(p.activeTransforms, activeTransform{"latency", p.measureLatency})
 			p.LogInfo(prefixlog + "measure latency subprocessor is enabled")
 		}
 		if p.config.Latency.UnansweredQueries {
-			p.activeTransforms = append(p.activeTransforms, p.detectEvictedTimeout)
+			p.activeTransforms = append(p.activeTransforms, activeTransform{"latency", p.detectEvictedTimeout})
 			p.LogInfo(prefixlog + "unanswered queries subprocessor is enabled")
 		}
 	}
 
 	if p.config.Suspicious.Enable {
-		p.activeTransforms = append(p.activeTransforms, p.suspiciousTransform)
+		p.activeTransforms = append(p.activeTransforms, activeTransform{"suspicious", p.suspiciousTransform})
 		p.LogInfo(prefixlog + "suspicious subprocessor is " + enabled)
 	}
 
@@ -158,21 +178,44 @@ func (p *Transforms) Prepare() error {
 
 	if p.config.Extract.Enable {
 		if p.config.Extract.AddPayload {
-			p.activeTransforms = append(p.activeTransforms, p.addBase64Payload)
+			p.activeTransforms = append(p.activeTransforms, activeTransform{"extract", p.addBase64Payload})
 			p.LogInfo(prefixlog + "extract subprocessor is enabled")
 		}
 	}
 
 	if p.config.MachineLearning.Enable {
-		p.activeTransforms = append(p.activeTransforms, p.machineLearningTransform)
+		p.activeTransforms = append(p.activeTransforms, activeTransform{"machine-learning", p.machineLearningTransform})
 		p.LogInfo(prefixlog + "machinelearning subprocessor is" + enabled)
 	}
 
 	if p.config.ATags.Enable {
-		p.activeTransforms = append(p.activeTransforms, p.ATagsTransform.AddTags)
+		p.activeTransforms = append(p.activeTransforms, activeTransform{"atags", p.ATagsTransform.AddTags})
 		p.LogInfo(prefixlog + "atags subprocessor is enabled")
 	}
 
+	if p.config.Tunneling.Enable {
+		p.activeTransforms = append(p.activeTransforms, activeTransform{"tunneling", p.TunnelingTransform.CheckTunneling})
+		p.LogInfo(prefixlog + "tunneling subprocessor is enabled")
+	}
+
+	if p.config.Intel.Enable {
+		p.IntelTransform.LoadFeeds()
+		p.activeTransforms = append(p.activeTransforms, activeTransform{"intel", p.IntelTransform.MatchIntel})
+		p.LogInfo(prefixlog + "intel subprocessor is enabled")
+	}
+
+	if p.config.Hostnames.Enable {
+		p.HostnamesTransform.LoadStaticFiles()
+		p.activeTransforms = append(p.activeTransforms, activeTransform{"hostnames", p.HostnamesTransform.AddHostnames})
+		p.LogInfo(prefixlog + "hostnames subprocessor is enabled")
+	}
+
+	// queries are held until the reply, so merge the transaction after all others transformations
+	if p.config.Transaction.Enable {
+		p.activeTransforms = append(p.activeTransforms, activeTransform{"transaction", p.TransactionTransform.MergeTransaction})
+		p.LogInfo(prefixlog + "transaction subprocessor is enabled")
+	}
+
 	return nil
 }
 
@@ -212,6 +255,18 @@ func (p *Transforms) InitDNSMessageFormat(dm *dnsutils.DNSMessage) {
 	if p.config.ATags.Enable {
 		p.ATagsTransform.InitDNSMessage(dm)
 	}
+
+	if p.config.Tunneling.Enable {
+		p.TunnelingTransform.InitDNSMessage(dm)
+	}
+
+	if p.config.Intel.Enable {
+		p.IntelTransform.InitDNSMessage(dm)
+	}
+
+	if p.config.Hostnames.Enable {
+		p.HostnamesTransform.InitDNSMessage(dm)
+	}
 }
 
 func (p *Transforms) Reset() {
@@ -294,18 +349,23 @@ func (p *Transforms) ProcessMessage(dm *dnsutils.DNSMessage) int {
 
 	// Traffic filtering ?
 	if p.FilteringTransform.CheckIfDrop(dm) {
+		pkgutils.Telemetry.RecordTransformerDrop(p.name, "filtering")
 		return ReturnDrop
 	}
 
 	// Traffic reducer ?
 	if p.ReducerTransform.ProcessDNSMessage(dm) == ReturnDrop {
+		pkgutils.Telemetry.RecordTransformerDrop(p.name, "reducer")
 		return ReturnDrop
 	}
 
 	//  and finally apply other transformation
 	var rCode int
-	for _, fn := range p.activeTransforms {
-		rCode = fn(dm)
+	for _, transform := range p.activeTransforms {
+		rCode = transform.process(dm)
+		if rCode == ReturnDrop {
+			pkgutils.Telemetry.RecordTransformerDrop(p.name, transform.name)
+		}
 		if rCode != ReturnSuccess {
 			return rCode
 		}
@@ -311,3 +311,31 @@ func TestTransformAndFilter(t *testing.T) {
 		t.Errorf("Ipv6 anonymization failed, got %s", dm.NetworkInfo.QueryIP)
 	}
 }
+
+func TestTransformsTransactionHold(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Transaction.Enable = true
+
+	// init the processor
+	channels := []chan dnsutils.DNSMessage{}
+	subprocessors := NewTransforms(config, logger.New(false), "test", channels, 0)
+
+	// the query is held until the reply, not dropped
+	dm := dnsutils.GetFakeDNSMessage()
+	subprocessors.InitDNSMessageFormat(&dm)
+	returnCode := subprocessors.ProcessMessage(&dm)
+	if returnCode != ReturnHold {
+		t.Errorf("Return code is %v and not RETURN_HOLD (%v)", returnCode, ReturnHold)
+	}
+
+	// the reply is emitted
+	dm.DNS.Type = dnsutils.DNSReply
+	returnCode = subprocessors.ProcessMessage(&dm)
+	if returnCode != ReturnSuccess {
+		t.Errorf("Return code is %v and not RETURN_SUCCESS (%v)", returnCode, ReturnSuccess)
+	}
+	if dm.Transaction == nil || dm.Transaction.Status != TransactionAnswered {
+		t.Errorf("transaction not merged: %v", dm.Transaction)
+	}
+}
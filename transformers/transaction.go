@@ -0,0 +1,102 @@
+package transformers
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+)
+
+var (
+	TransactionAnswered  = "ANSWERED"
+	TransactionTimeout   = "TIMEOUT"
+	TransactionUnmatched = "UNMATCHED"
+)
+
+// transaction processor
+type TransactionProcessor struct {
+	config      *pkgconfig.ConfigTransformers
+	logger      *logger.Logger
+	name        string
+	instance    int
+	mapQueries  MapQueries
+	outChannels []chan dnsutils.DNSMessage
+	logInfo     func(msg string, v ...interface{})
+	logError    func(msg string, v ...interface{})
+}
+
+func NewTransactionSubprocessor(config *pkgconfig.ConfigTransformers, logger *logger.Logger, name string,
+	instance int, outChannels []chan dnsutils.DNSMessage,
+	logInfo func(msg string, v ...interface{}), logError func(msg string, v ...interface{}),
+) *TransactionProcessor {
+	s := TransactionProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		instance:    instance,
+		outChannels: outChannels,
+		logInfo:     logInfo,
+		logError:    logError,
+	}
+
+	s.mapQueries = NewMapQueries(time.Duration(config.Transaction.QueriesTimeout)*time.Second, outChannels)
+
+	return &s
+}
+
+func (s *TransactionProcessor) ReloadConfig(config *pkgconfig.ConfigTransformers) {
+	s.config = config
+
+	s.mapQueries.SetTTL(time.Duration(config.Transaction.QueriesTimeout) * time.Second)
+}
+
+// MergeTransaction holds the queries until the reply is received, the reply is
+// returned with the fields of the query. Queries without reply are emitted after the timeout.
+func (s *TransactionProcessor) MergeTransaction(dm *dnsutils.DNSMessage) int {
+	queryport, _ := strconv.Atoi(dm.NetworkInfo.QueryPort)
+	if len(dm.NetworkInfo.QueryIP) == 0 || queryport == 0 || dm.DNS.MalformedPacket {
+		return ReturnSuccess
+	}
+
+	// compute the hash of the query
+	hashData := []string{dm.NetworkInfo.QueryIP, dm.NetworkInfo.QueryPort, strconv.Itoa(dm.DNS.ID)}
+
+	hashfnv := fnv.New64a()
+	hashfnv.Write([]byte(strings.Join(hashData, "+")))
+	key := hashfnv.Sum64()
+
+	if dm.DNS.Type == dnsutils.DNSQuery || dm.DNS.Type == dnsutils.DNSQueryQuiet {
+		dm.Transaction = &dnsutils.TransformTransaction{
+			Status:         TransactionTimeout,
+			QueryTimestamp: dm.DNSTap.TimestampRFC3339,
+			QueryLength:    dm.DNS.Length,
+			QueryFlags:     dm.DNS.Flags,
+			QueryEDNS:      dm.EDNS,
+		}
+		s.mapQueries.Set(key, *dm)
+		return ReturnHold
+	}
+
+	query, ok := s.mapQueries.Get(key)
+	if !ok {
+		dm.Transaction = &dnsutils.TransformTransaction{
+			Status:         TransactionUnmatched,
+			QueryTimestamp: "-",
+			ReplyLength:    dm.DNS.Length,
+		}
+		return ReturnSuccess
+	}
+	s.mapQueries.Delete(key)
+
+	dm.Transaction = query.Transaction
+	dm.Transaction.Status = TransactionAnswered
+	dm.Transaction.ReplyLength = dm.DNS.Length
+	if dm.DNSTap.Latency == 0 && query.DNSTap.Timestamp > 0 {
+		dm.DNSTap.Latency = float64(dm.DNSTap.Timestamp-query.DNSTap.Timestamp) / float64(1000000000)
+	}
+	return ReturnSuccess
+}
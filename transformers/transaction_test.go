@@ -0,0 +1,108 @@
+package transformers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+)
+
+func TestTransaction_MergeReply(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Transaction.Enable = true
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	transaction := NewTransactionSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+
+	// the query is held
+	CQ := dnsutils.GetFakeDNSMessage()
+	CQ.DNS.Type = dnsutils.DNSQuery
+	CQ.DNS.Length = 42
+	CQ.DNS.Flags.RD = true
+	CQ.EDNS.UDPSize = 1232
+	CQ.DNSTap.Timestamp = 1704486841216166066
+	CQ.DNSTap.TimestampRFC3339 = "2024-01-05T20:34:01.216166066Z"
+	if ret := transaction.MergeTransaction(&CQ); ret != ReturnHold {
+		t.Errorf("query should be held, got return code %d", ret)
+	}
+
+	// the reply is merged with the query
+	CR := dnsutils.GetFakeDNSMessage()
+	CR.DNS.Type = dnsutils.DNSReply
+	CR.DNS.Length = 100
+	CR.DNS.Rcode = "NXDOMAIN"
+	CR.DNSTap.Timestamp = 1704486841227961611
+	if ret := transaction.MergeTransaction(&CR); ret != ReturnSuccess {
+		t.Fatalf("reply should be kept, got return code %d", ret)
+	}
+
+	if CR.Transaction == nil {
+		t.Fatalf("transaction should be added to the reply")
+	}
+	if CR.Transaction.Status != TransactionAnswered {
+		t.Errorf("invalid status, got %s", CR.Transaction.Status)
+	}
+	if CR.Transaction.QueryLength != 42 || CR.Transaction.ReplyLength != 100 {
+		t.Errorf("invalid lengths, query=%d reply=%d", CR.Transaction.QueryLength, CR.Transaction.ReplyLength)
+	}
+	if !CR.Transaction.QueryFlags.RD || CR.Transaction.QueryEDNS.UDPSize != 1232 {
+		t.Errorf("query flags and edns not merged: %v", CR.Transaction)
+	}
+	if CR.Transaction.QueryTimestamp != CQ.DNSTap.TimestampRFC3339 {
+		t.Errorf("invalid query timestamp, got %s", CR.Transaction.QueryTimestamp)
+	}
+	if CR.DNSTap.Latency == 0 {
+		t.Errorf("latency should be computed")
+	}
+
+	// a second reply is not matched
+	CR2 := dnsutils.GetFakeDNSMessage()
+	CR2.DNS.Type = dnsutils.DNSReply
+	transaction.MergeTransaction(&CR2)
+	if CR2.Transaction.Status != TransactionUnmatched {
+		t.Errorf("invalid status, got %s", CR2.Transaction.Status)
+	}
+
+	// nothing is emitted on timeout
+	select {
+	case dm := <-outChannels[0]:
+		t.Errorf("unexpected message emitted: %v", dm)
+	case <-time.After(3 * time.Second):
+	}
+}
+
+func TestTransaction_UnansweredQuery(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Transaction.Enable = true
+	config.Transaction.QueriesTimeout = 1
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	transaction := NewTransactionSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+
+	CQ := dnsutils.GetFakeDNSMessage()
+	CQ.DNS.Type = dnsutils.DNSQueryQuiet
+	CQ.DNS.Length = 42
+	transaction.MergeTransaction(&CQ)
+
+	select {
+	case dm := <-outChannels[0]:
+		if dm.DNS.Rcode != "TIMEOUT" || dm.Transaction.Status != TransactionTimeout {
+			t.Errorf("incorrect timeout, rcode=%s status=%s", dm.DNS.Rcode, dm.Transaction.Status)
+		}
+		if dm.Transaction.QueryLength != 42 || dm.Transaction.ReplyLength != 0 {
+			t.Errorf("invalid lengths, query=%d reply=%d", dm.Transaction.QueryLength, dm.Transaction.ReplyLength)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("unanswered query not emitted")
+	}
+}
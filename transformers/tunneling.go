@@ -0,0 +1,392 @@
+package transformers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	publicsuffixlist "golang.org/x/net/publicsuffix"
+)
+
+const (
+	// number of buckets of the sliding window
+	tunnelingBuckets = 6
+	// maximum of subdomains tracked per client or domain
+	tunnelingMaxSubdomains = 10000
+	// points added to the score for each heuristic triggered
+	tunnelingScoreStep = 25
+)
+
+// bucket of the sliding window
+type tunnelingBucket struct {
+	slot         int64
+	queries      int
+	txtNull      int
+	qnameBytes   int
+	entropy      float64
+	entropyCount int
+}
+
+// stats of a client or a domain over the sliding window
+type tunnelingStats struct {
+	buckets    [tunnelingBuckets]tunnelingBucket
+	subdomains map[string]int64
+	lastSlot   int64
+}
+
+func newTunnelingStats() *tunnelingStats {
+	return &tunnelingStats{subdomains: make(map[string]int64)}
+}
+
+func (s *tunnelingStats) add(slot int64, subdomain string, qnameBytes int, txtNull bool, entropy float64) {
+	b := &s.buckets[slot%tunnelingBuckets]
+	if b.slot != slot {
+		*b = tunnelingBucket{slot: slot}
+	}
+
+	// remove the subdomains out of the window on rotation
+	if slot != s.lastSlot {
+		for name, seen := range s.subdomains {
+			if seen <= slot-tunnelingBuckets {
+				delete(s.subdomains, name)
+			}
+		}
+		s.lastSlot = slot
+	}
+
+	b.queries++
+	b.qnameBytes += qnameBytes
+	if txtNull {
+		b.txtNull++
+	}
+	if len(subdomain) > 0 {
+		b.entropy += entropy
+		b.entropyCount++
+		if _, ok := s.subdomains[subdomain]; ok || len(s.subdomains) < tunnelingMaxSubdomains {
+			s.subdomains[subdomain] = slot
+		}
+	}
+}
+
+func (s *tunnelingStats) summary(slot int64) dnsutils.TunnelingStats {
+	var txtNull, entropyCount int
+	var entropy float64
+
+	stats := dnsutils.TunnelingStats{}
+	for _, b := range s.buckets {
+		if b.slot <= slot-tunnelingBuckets || b.slot > slot {
+			continue
+		}
+		stats.Queries += b.queries
+		stats.QnameBytes += b.qnameBytes
+		txtNull += b.txtNull
+		entropy += b.entropy
+		entropyCount += b.entropyCount
+	}
+	for _, seen := range s.subdomains {
+		if seen > slot-tunnelingBuckets {
+			stats.UniqueSubdomains++
+		}
+	}
+	if stats.Queries > 0 {
+		stats.TxtNullRatio = float64(txtNull) / float64(stats.Queries)
+	}
+	if entropyCount > 0 {
+		stats.Entropy = entropy / float64(entropyCount)
+	}
+	return stats
+}
+
+// logistic regression model loaded from the model file
+type tunnelingModel struct {
+	Intercept float64            `json:"intercept"`
+	Weights   map[string]float64 `json:"weights"`
+	Threshold float64            `json:"threshold"`
+}
+
+func (m *tunnelingModel) predict(features map[string]float64) float64 {
+	z := m.Intercept
+	for name, w := range m.Weights {
+		z += w * features[name]
+	}
+	return 1 / (1 + math.Exp(-z))
+}
+
+// tunneling processor
+type TunnelingProcessor struct {
+	config                *pkgconfig.ConfigTransformers
+	logger                *logger.Logger
+	name                  string
+	instance              int
+	clients               *expirable.LRU[string, *tunnelingStats]
+	domains               *expirable.LRU[string, *tunnelingStats]
+	model                 *tunnelingModel
+	features              MlProcessor
+	whitelistDomainsRegex []*regexp.Regexp
+	outChannels           []chan dnsutils.DNSMessage
+	logInfo               func(msg string, v ...interface{})
+	logError              func(msg string, v ...interface{})
+}
+
+func NewTunnelingSubprocessor(config *pkgconfig.ConfigTransformers, logger *logger.Logger, name string,
+	instance int, outChannels []chan dnsutils.DNSMessage,
+	logInfo func(msg string, v ...interface{}), logError func(msg string, v ...interface{}),
+) *TunnelingProcessor {
+	s := TunnelingProcessor{
+		config:      config,
+		logger:      logger,
+		name:        name,
+		instance:    instance,
+		outChannels: outChannels,
+		logInfo:     logInfo,
+		logError:    logError,
+	}
+
+	s.features = NewMachineLearningSubprocessor(config, logger, name, instance, outChannels, logInfo, logError)
+	s.ReloadConfig(config)
+
+	return &s
+}
+
+func (s *TunnelingProcessor) ReloadConfig(config *pkgconfig.ConfigTransformers) {
+	s.config = config
+
+	window := time.Duration(config.Tunneling.Window) * time.Second
+	s.clients = expirable.NewLRU[string, *tunnelingStats](config.Tunneling.MaxEntries, nil, window)
+	s.domains = expirable.NewLRU[string, *tunnelingStats](config.Tunneling.MaxEntries, nil, window)
+
+	s.whitelistDomainsRegex = s.whitelistDomainsRegex[:0]
+	for _, v := range config.Tunneling.WhitelistDomains {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			s.LogError("invalid whitelist domain %s: %v", v, err)
+			continue
+		}
+		s.whitelistDomainsRegex = append(s.whitelistDomainsRegex, re)
+	}
+
+	s.LoadModel()
+}
+
+func (s *TunnelingProcessor) LogInfo(msg string, v ...interface{}) {
+	log := fmt.Sprintf("tunneling#%d - ", s.instance)
+	s.logInfo(log+msg, v...)
+}
+
+func (s *TunnelingProcessor) LogError(msg string, v ...interface{}) {
+	log := fmt.Sprintf("tunneling#%d - ", s.instance)
+	s.logError(log+msg, v...)
+}
+
+// LoadModel reads the logistic regression weights, names are scored with
+// the heuristics only if the model file is invalid.
+func (s *TunnelingProcessor) LoadModel() {
+	s.model = nil
+	if len(s.config.Tunneling.ModelFile) == 0 {
+		return
+	}
+
+	data, err := os.ReadFile(s.config.Tunneling.ModelFile)
+	if err != nil {
+		s.LogError("unable to read model file: %v", err)
+		return
+	}
+
+	model := &tunnelingModel{Threshold: 0.5}
+	if err := json.Unmarshal(data, model); err != nil {
+		s.LogError("invalid model file: %v", err)
+		return
+	}
+	s.model = model
+	s.LogInfo("model loaded with %d weights", len(model.Weights))
+}
+
+func (s *TunnelingProcessor) InitDNSMessage(dm *dnsutils.DNSMessage) {
+	if dm.Tunneling == nil {
+		dm.Tunneling = &dnsutils.TransformTunneling{
+			Score:       0,
+			Reasons:     []string{},
+			EtldPlusOne: "-",
+			ModelScore:  0,
+		}
+	}
+}
+
+func (s *TunnelingProcessor) isWhitelisted(qname string) bool {
+	for _, re := range s.whitelistDomainsRegex {
+		if re.MatchString(qname) {
+			return true
+		}
+	}
+	return false
+}
+
+// Shannon entropy of the subdomain, dots excluded
+func tunnelingEntropy(subdomain string) float64 {
+	label := strings.ReplaceAll(subdomain, ".", "")
+	if len(label) == 0 {
+		return 0
+	}
+
+	counts := make(map[rune]int)
+	for _, c := range label {
+		counts[c]++
+	}
+
+	var entropy float64
+	n := float64(len(label))
+	for _, count := range counts {
+		p := float64(count) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// getStats returns the stats of the client or the domain, the entry is added
+// again on each message to refresh its expiration while the traffic goes on.
+func (s *TunnelingProcessor) getStats(cache *expirable.LRU[string, *tunnelingStats], key string) *tunnelingStats {
+	stats, ok := cache.Get(key)
+	if !ok {
+		stats = newTunnelingStats()
+	}
+	cache.Add(key, stats)
+	return stats
+}
+
+// CheckTunneling updates the client and domain stats with the query and
+// scores the message against the thresholds and the optional model.
+func (s *TunnelingProcessor) CheckTunneling(dm *dnsutils.DNSMessage) int {
+	if dm.Tunneling == nil {
+		s.LogError("transformer is not properly initialized")
+		return ReturnSuccess
+	}
+
+	qname := strings.TrimSuffix(strings.ToLower(dm.DNS.Qname), ".")
+	if len(qname) == 0 || s.isWhitelisted(qname) {
+		return ReturnSuccess
+	}
+
+	domain, err := publicsuffixlist.EffectiveTLDPlusOne(qname)
+	if err != nil {
+		domain = qname
+	}
+	subdomain := strings.TrimSuffix(strings.TrimSuffix(qname, domain), ".")
+	dm.Tunneling.EtldPlusOne = domain
+
+	// position in the sliding window
+	now := time.Now().Unix()
+	if dm.DNSTap.Timestamp > 0 {
+		now = dm.DNSTap.Timestamp / int64(time.Second)
+	}
+	width := int64(s.config.Tunneling.Window / tunnelingBuckets)
+	if width < 1 {
+		width = 1
+	}
+	slot := now / width
+
+	clientStats := s.getStats(s.clients, dm.NetworkInfo.QueryIP)
+	domainStats := s.getStats(s.domains, domain)
+
+	// only queries are counted, replies are scored with the stats of the query
+	if dm.DNS.Type == dnsutils.DNSQuery || dm.DNS.Type == dnsutils.DNSQueryQuiet {
+		txtNull := dm.DNS.Qtype == "TXT" || dm.DNS.Qtype == "NULL"
+		entropy := tunnelingEntropy(subdomain)
+		clientStats.add(slot, qname, len(qname), txtNull, entropy)
+		domainStats.add(slot, subdomain, len(qname), txtNull, entropy)
+	}
+
+	dm.Tunneling.Client = clientStats.summary(slot)
+	dm.Tunneling.Domain = domainStats.summary(slot)
+
+	// apply the heuristics
+	reasons := []string{}
+	heuristics := make(map[string]bool)
+	check := func(prefix string, stats dnsutils.TunnelingStats) {
+		if stats.UniqueSubdomains >= s.config.Tunneling.ThresholdUniqueSubdomains {
+			reasons = append(reasons, prefix+"-unique-subdomains")
+			heuristics["unique-subdomains"] = true
+		}
+		if stats.QnameBytes >= s.config.Tunneling.ThresholdQnameBytes {
+			reasons = append(reasons, prefix+"-qname-bytes")
+			heuristics["qname-bytes"] = true
+		}
+		if stats.Queries < s.config.Tunneling.MinQueries {
+			return
+		}
+		if stats.TxtNullRatio >= s.config.Tunneling.ThresholdTxtNullRatio {
+			reasons = append(reasons, prefix+"-txt-null-ratio")
+			heuristics["txt-null-ratio"] = true
+		}
+		if stats.Entropy >= s.config.Tunneling.ThresholdEntropy {
+			reasons = append(reasons, prefix+"-entropy")
+			heuristics["entropy"] = true
+		}
+	}
+	check("client", dm.Tunneling.Client)
+	check("domain", dm.Tunneling.Domain)
+
+	score := float64(len(heuristics) * tunnelingScoreStep)
+
+	// and finally score the name with the model
+	if s.model != nil {
+		dm.Tunneling.ModelScore = s.model.predict(s.Features(dm))
+		if dm.Tunneling.ModelScore >= s.model.Threshold {
+			reasons = append(reasons, "model")
+			score = math.Max(score, dm.Tunneling.ModelScore*100)
+		}
+	}
+
+	dm.Tunneling.Score = math.Min(score, 100)
+	dm.Tunneling.Reasons = reasons
+	return ReturnSuccess
+}
+
+// Features returns the inputs of the model: the machine learning features
+// of the qname and the stats of the client and the domain.
+func (s *TunnelingProcessor) Features(dm *dnsutils.DNSMessage) map[string]float64 {
+	ml := dm.MachineLearning
+	if ml == nil {
+		tmp := *dm
+		s.features.InitDNSMessage(&tmp)
+		s.features.AddFeatures(&tmp)
+		ml = tmp.MachineLearning
+	}
+
+	features := map[string]float64{
+		"entropy":                ml.Entropy,
+		"length":                 float64(ml.Length),
+		"labels":                 float64(ml.Labels),
+		"digits":                 float64(ml.Digits),
+		"lowers":                 float64(ml.Lowers),
+		"uppers":                 float64(ml.Uppers),
+		"specials":               float64(ml.Specials),
+		"others":                 float64(ml.Others),
+		"ratio-digits":           ml.RatioDigits,
+		"ratio-letters":          ml.RatioLetters,
+		"ratio-specials":         ml.RatioSpecials,
+		"ratio-others":           ml.RatioOthers,
+		"consecutive-chars":      float64(ml.ConsecutiveChars),
+		"consecutive-vowels":     float64(ml.ConsecutiveVowels),
+		"consecutive-digits":     float64(ml.ConsecutiveDigits),
+		"consecutive-consonants": float64(ml.ConsecutiveConsonants),
+		"size":                   float64(ml.Size),
+		"occurrences":            float64(ml.Occurrences),
+		"uncommon-qtypes":        float64(ml.UncommonQtypes),
+	}
+	for prefix, stats := range map[string]dnsutils.TunnelingStats{"client-": dm.Tunneling.Client, "domain-": dm.Tunneling.Domain} {
+		features[prefix+"queries"] = float64(stats.Queries)
+		features[prefix+"unique-subdomains"] = float64(stats.UniqueSubdomains)
+		features[prefix+"qname-bytes"] = float64(stats.QnameBytes)
+		features[prefix+"txt-null-ratio"] = stats.TxtNullRatio
+		features[prefix+"entropy"] = stats.Entropy
+	}
+	return features
+}
@@ -0,0 +1,191 @@
+package transformers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/pkgconfig"
+	"github.com/dmachard/go-logger"
+)
+
+func tunnelingQuery(qname, qtype string) dnsutils.DNSMessage {
+	dm := dnsutils.GetFakeDNSMessage()
+	dm.DNS.Type = dnsutils.DNSQuery
+	dm.DNS.Qname = qname
+	dm.DNS.Qtype = qtype
+	dm.DNSTap.Timestamp = 1704486841216166066
+	return dm
+}
+
+func TestTunneling_UniqueSubdomains(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Tunneling.Enable = true
+	config.Tunneling.ThresholdUniqueSubdomains = 20
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	tunneling := NewTunnelingSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+
+	var dm dnsutils.DNSMessage
+	for i := 0; i < 20; i++ {
+		dm = tunnelingQuery(fmt.Sprintf("a%dx7kq2v9.t.example.co.uk", i), "A")
+		tunneling.InitDNSMessage(&dm)
+		tunneling.CheckTunneling(&dm)
+	}
+
+	if dm.Tunneling.EtldPlusOne != "example.co.uk" {
+		t.Errorf("invalid etld+1, got %s", dm.Tunneling.EtldPlusOne)
+	}
+	if dm.Tunneling.Domain.UniqueSubdomains != 20 || dm.Tunneling.Client.Queries != 20 {
+		t.Errorf("invalid stats: %v", dm.Tunneling)
+	}
+	if dm.Tunneling.Score != 25 {
+		t.Errorf("invalid score, got %f", dm.Tunneling.Score)
+	}
+	if len(dm.Tunneling.Reasons) != 2 || dm.Tunneling.Reasons[0] != "client-unique-subdomains" || dm.Tunneling.Reasons[1] != "domain-unique-subdomains" {
+		t.Errorf("invalid reasons, got %v", dm.Tunneling.Reasons)
+	}
+
+	// out of the window, the stats are reset
+	dm = tunnelingQuery("www.example.co.uk", "A")
+	dm.DNSTap.Timestamp += int64(config.Tunneling.Window+1) * 1000000000
+	tunneling.InitDNSMessage(&dm)
+	tunneling.CheckTunneling(&dm)
+	if dm.Tunneling.Domain.Queries != 1 || dm.Tunneling.Score != 0 {
+		t.Errorf("stats should be reset: %v", dm.Tunneling)
+	}
+}
+
+func TestTunneling_TxtNullRatio(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Tunneling.Enable = true
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	tunneling := NewTunnelingSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+
+	var dm dnsutils.DNSMessage
+	for i := 0; i < config.Tunneling.MinQueries; i++ {
+		dm = tunnelingQuery("www.dnscollector.dev", "TXT")
+		tunneling.InitDNSMessage(&dm)
+		tunneling.CheckTunneling(&dm)
+	}
+
+	if dm.Tunneling.Client.TxtNullRatio != 1 {
+		t.Errorf("invalid txt/null ratio, got %f", dm.Tunneling.Client.TxtNullRatio)
+	}
+	if dm.Tunneling.Score != 25 {
+		t.Errorf("invalid score, got %f", dm.Tunneling.Score)
+	}
+
+	// replies are scored but not counted
+	dm = tunnelingQuery("www.dnscollector.dev", "TXT")
+	dm.DNS.Type = dnsutils.DNSReply
+	tunneling.InitDNSMessage(&dm)
+	tunneling.CheckTunneling(&dm)
+	if dm.Tunneling.Client.Queries != config.Tunneling.MinQueries {
+		t.Errorf("reply should not be counted, got %d queries", dm.Tunneling.Client.Queries)
+	}
+}
+
+func TestTunneling_Whitelist(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Tunneling.Enable = true
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	tunneling := NewTunnelingSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+
+	dm := tunnelingQuery("1.0.0.127.in-addr.arpa", "PTR")
+	tunneling.InitDNSMessage(&dm)
+	tunneling.CheckTunneling(&dm)
+	if dm.Tunneling.EtldPlusOne != "-" || dm.Tunneling.Client.Queries != 0 {
+		t.Errorf("whitelisted domain should be ignored: %v", dm.Tunneling)
+	}
+
+	// invalid patterns are ignored
+	config.Tunneling.WhitelistDomains = []string{"(invalid", "\\.lan$"}
+	tunneling.ReloadConfig(config)
+	if len(tunneling.whitelistDomainsRegex) != 1 {
+		t.Errorf("invalid pattern should be ignored, got %d patterns", len(tunneling.whitelistDomainsRegex))
+	}
+}
+
+func TestTunneling_SlidingExpiration(t *testing.T) {
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Tunneling.Enable = true
+	config.Tunneling.Window = 2
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	tunneling := NewTunnelingSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+
+	// the stats of an active client are kept beyond the window
+	var dm dnsutils.DNSMessage
+	for i := 0; i < 3; i++ {
+		dm = tunnelingQuery("www.dnscollector.dev", "A")
+		tunneling.InitDNSMessage(&dm)
+		tunneling.CheckTunneling(&dm)
+		time.Sleep(1200 * time.Millisecond)
+	}
+	if dm.Tunneling.Client.Queries != 3 {
+		t.Errorf("client stats should be kept, got %d queries", dm.Tunneling.Client.Queries)
+	}
+}
+
+func TestTunneling_Model(t *testing.T) {
+	model := filepath.Join(t.TempDir(), "model.json")
+	data := `{"intercept": -4, "weights": {"entropy": 1, "domain-unique-subdomains": 0.5}, "threshold": 0.5}`
+	if err := os.WriteFile(model, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// enable feature
+	config := pkgconfig.GetFakeConfigTransformers()
+	config.Tunneling.Enable = true
+	config.Tunneling.ModelFile = model
+
+	log := logger.New(false)
+	outChannels := []chan dnsutils.DNSMessage{make(chan dnsutils.DNSMessage, 1)}
+
+	// init transformer
+	tunneling := NewTunnelingSubprocessor(config, logger.New(false), "test", 0, outChannels, log.Info, log.Error)
+
+	// low entropy name
+	dm := tunnelingQuery("www.aaa.com", "A")
+	tunneling.InitDNSMessage(&dm)
+	tunneling.CheckTunneling(&dm)
+	if dm.Tunneling.ModelScore >= 0.5 || len(dm.Tunneling.Reasons) != 0 {
+		t.Errorf("name should not be detected by the model: %v", dm.Tunneling)
+	}
+
+	// high entropy name
+	dm = tunnelingQuery("x1q9zk3v7bw0m2l8p4.tunnel.com", "A")
+	tunneling.InitDNSMessage(&dm)
+	tunneling.CheckTunneling(&dm)
+	if dm.Tunneling.ModelScore < 0.5 {
+		t.Errorf("name should be detected by the model, got %f", dm.Tunneling.ModelScore)
+	}
+	if len(dm.Tunneling.Reasons) != 1 || dm.Tunneling.Reasons[0] != "model" {
+		t.Errorf("invalid reasons, got %v", dm.Tunneling.Reasons)
+	}
+	if dm.Tunneling.Score != dm.Tunneling.ModelScore*100 {
+		t.Errorf("invalid score, got %f", dm.Tunneling.Score)
+	}
+}
@@ -14,18 +14,9 @@ import (
 )
 
 type BpfPktEvent struct {
-	Timestamp     uint64
-	PktLen        uint32
-	PktOffset     uint32
-	IpVersion     uint16
-	IpProto       uint16
-	PayloadOffset uint16
-	SrcAddr       uint32
-	SrcAddr6      [4]uint32
-	SrcPort       uint16
-	DstAddr       uint32
-	DstAddr6      [4]uint32
-	DstPort       uint16
+	Timestamp uint64
+	PktLen    uint32
+	CapLen    uint32
 }
 
 // loadBpf returns the embedded CollectionSpec for bpf.
@@ -76,7 +67,9 @@ type bpfProgramSpecs struct {
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type bpfMapSpecs struct {
-	Pkts *ebpf.MapSpec `ebpf:"pkts"`
+	Buffers  *ebpf.MapSpec `ebpf:"buffers"`
+	DnsPorts *ebpf.MapSpec `ebpf:"dns_ports"`
+	Pkts     *ebpf.MapSpec `ebpf:"pkts"`
 }
 
 // bpfObjects contains all objects after they have been loaded into the kernel.
@@ -98,11 +91,15 @@ func (o *BpfObjects) Close() error {
 //
 // It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
 type bpfMaps struct {
-	Pkts *ebpf.Map `ebpf:"pkts"`
+	Buffers  *ebpf.Map `ebpf:"buffers"`
+	DnsPorts *ebpf.Map `ebpf:"dns_ports"`
+	Pkts     *ebpf.Map `ebpf:"pkts"`
 }
 
 func (m *bpfMaps) Close() error {
 	return _BpfClose(
+		m.Buffers,
+		m.DnsPorts,
 		m.Pkts,
 	)
 }
@@ -1,4 +1,3 @@
-
 //go:build exclude
 
 #include "vmlinux.h"
@@ -9,43 +8,78 @@ char __license[] SEC("license") = "Dual MIT/GPL";
 
 #define ETH_P_IP 0x0800
 #define ETH_P_IPV6 0x86DD
+#define IP_MF 0x2000
+#define IP_OFFSET 0x1FFF
+#define NEXTHDR_FRAGMENT 44
+
+// maximum number of bytes copied from a packet, enough for jumbo frames
+#define MAX_PKT_LEN 9216
 
-// packet_info
+// packet_info, followed in the ring by the captured bytes
 struct pkt_event {
   __u64 timestamp;
   __u32 pkt_len;
-  __u32 pkt_offset;
-  __u16 ip_version;
-  __u16 ip_proto;
-  __u16 payload_offset;
-  __u32 src_addr;
-  __u32 src_addr6[4];
-  __u16 src_port;
-  __u32 dst_addr;
-  __u32 dst_addr6[4];
-  __u16 dst_port;
+  __u32 cap_len;
 } __attribute__((packed));
 struct pkt_event *unused_event __attribute__((unused));
 
+struct pkt_buffer {
+  struct pkt_event hdr;
+  __u8 data[MAX_PKT_LEN];
+};
 
+// dns ports to capture, populated by the user space from the config
 struct {
-	__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
-	__uint(key_size, sizeof(__u32));
-	__uint(value_size, sizeof(__u32));
-  __uint(max_entries, 4);
+  __uint(type, BPF_MAP_TYPE_HASH);
+  __type(key, __u16);
+  __type(value, __u8);
+  __uint(max_entries, 64);
+} dns_ports SEC(".maps");
+
+struct {
+  __uint(type, BPF_MAP_TYPE_RINGBUF);
+  __uint(max_entries, 1 << 24);
 } pkts SEC(".maps");
 
+// per-cpu buffer to build the event before to write it in the ring
+struct {
+  __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+  __type(key, __u32);
+  __type(value, struct pkt_buffer);
+  __uint(max_entries, 1);
+} buffers SEC(".maps");
+
+// copy the whole packet in the ring, the user space decodes it
+static __always_inline int send_packet(struct xdp_md *ctx) {
+    __u32 key = 0;
+    struct pkt_buffer *buf = bpf_map_lookup_elem(&buffers, &key);
+    if (!buf)
+        return XDP_PASS;
+
+    __u32 pkt_len = bpf_xdp_get_buff_len(ctx);
+    __u32 cap_len = pkt_len;
+    if (cap_len > MAX_PKT_LEN)
+        cap_len = MAX_PKT_LEN;
+    if (cap_len < sizeof(struct ethhdr))
+        return XDP_PASS;
+
+    buf->hdr.timestamp = bpf_ktime_get_ns();
+    buf->hdr.pkt_len = pkt_len;
+    buf->hdr.cap_len = cap_len;
+    if (bpf_xdp_load_bytes(ctx, 0, buf->data, cap_len) < 0)
+        return XDP_PASS;
+
+    bpf_ringbuf_output(&pkts, buf, sizeof(struct pkt_event) + cap_len, 0);
+    return XDP_PASS;
+}
+
 SEC("xdp")
 int xdp_sniffer(struct xdp_md *ctx) {
     void *data_end = (void *)(long)ctx->data_end;
     void *data = (void *)(long)ctx->data;
 
     __u32 offset = sizeof(struct ethhdr);
-
-    struct pkt_event pkt = {};
-    pkt.timestamp = bpf_ktime_get_ns(); 
-    pkt.pkt_len = data_end - data;
-    pkt.pkt_offset = sizeof(struct pkt_event);
+    __u8 ip_proto;
 
     // enough data to read ethernet header ?
     if (data + offset > data_end)
@@ -53,82 +87,54 @@ int xdp_sniffer(struct xdp_md *ctx) {
 
     // handle ethernet packet
     struct ethhdr  *eth  = data;
-    pkt.ip_version = bpf_htons(eth->h_proto);
-
-    // handle only IPv4 or IPv6 traffic 
-    if (pkt.ip_version != ETH_P_IP &&  pkt.ip_version != ETH_P_IPV6)
-        return XDP_PASS;
+    __u16 eth_type = bpf_ntohs(eth->h_proto);
 
     // IPv4 - get L4 protocol
-    if (pkt.ip_version == ETH_P_IP) {
+    if (eth_type == ETH_P_IP) {
         if (data + offset + sizeof(struct iphdr) > data_end)
             return XDP_PASS;
 
         struct iphdr   *ip4h   = (data + offset);
-        pkt.ip_proto = ip4h->protocol;
-        pkt.src_addr = bpf_htonl(ip4h->saddr);
-        pkt.dst_addr = bpf_htonl(ip4h->daddr);
+        ip_proto = ip4h->protocol;
+        if (ip_proto != IPPROTO_UDP &&  ip_proto != IPPROTO_TCP)
+            return XDP_PASS;
 
-        offset += sizeof(struct iphdr);
-    }
+        // fragments are reassembled by the user space, ports are only in the first one
+        if (ip4h->frag_off & bpf_htons(IP_MF | IP_OFFSET))
+            return send_packet(ctx);
+
+        offset += ip4h->ihl * 4;
 
     // IPv6 - get L4 protocol
-    if (pkt.ip_version == ETH_P_IPV6) {
+    } else if (eth_type == ETH_P_IPV6) {
         if (data + offset + sizeof(struct ipv6hdr) > data_end)
             return XDP_PASS;
 
         struct ipv6hdr *ip6h = (data + offset) ;
-        pkt.ip_proto = ip6h->nexthdr;
+        ip_proto = ip6h->nexthdr;
+        if (ip_proto == NEXTHDR_FRAGMENT)
+            return send_packet(ctx);
+        if (ip_proto != IPPROTO_UDP &&  ip_proto != IPPROTO_TCP)
+            return XDP_PASS;
 
         offset += sizeof(struct ipv6hdr);
 
-        __builtin_memcpy(pkt.src_addr6, ip6h->saddr.in6_u.u6_addr32, sizeof(pkt.src_addr6));
-        __builtin_memcpy(pkt.dst_addr6, ip6h->daddr.in6_u.u6_addr32, sizeof(pkt.dst_addr6));
-    }
-
-    // handle only UDP or TCP traffic 
-    if (pkt.ip_proto != IPPROTO_UDP &&  pkt.ip_proto != IPPROTO_TCP) 
+    // handle only IPv4 or IPv6 traffic
+    } else {
         return XDP_PASS;
-
-    // TCP - get destination and source port
-    if (pkt.ip_proto == IPPROTO_TCP) {
-        if (data + offset + sizeof(struct tcphdr) > data_end)
-            return XDP_PASS;
-
-        struct tcphdr *tcp  = data + offset;
-        pkt.src_port = bpf_ntohs(tcp->source);
-        pkt.dst_port = bpf_ntohs(tcp->dest);
-
-        u8 tcp_flags = ((u8 *)tcp)[13];
-
-        // ignore syn and ack packet
-        if (tcp_flags != 0x18) {
-            return XDP_PASS;
-        }
-
-        offset += tcp->doff*4;
     }
 
-    // UDP - get destination and source port
-    if (pkt.ip_proto == IPPROTO_UDP) {
-        if (data + offset + sizeof(struct udphdr) > data_end)
-            return XDP_PASS;
-
-        struct udphdr *udp  = data + offset;
-        pkt.src_port = bpf_ntohs(udp->source);
-        pkt.dst_port = bpf_ntohs(udp->dest);
+    // source and destination ports are at the same place for TCP and UDP
+    struct udphdr *l4 = data + offset;
+    if ((void *)(l4 + 1) > data_end)
+        return XDP_PASS;
 
-        offset += sizeof(struct udphdr);
-    }
+    __u16 src_port = bpf_ntohs(l4->source);
+    __u16 dst_port = bpf_ntohs(l4->dest);
 
-    // handle only dns packet
-    if ( pkt.src_port != 53 && pkt.dst_port != 53)
+    // handle only dns ports, all tcp segments are sent for the reassembly
+    if (!bpf_map_lookup_elem(&dns_ports, &src_port) && !bpf_map_lookup_elem(&dns_ports, &dst_port))
         return XDP_PASS;
 
-    pkt.payload_offset = offset;
-    // write data in perf event
-    int ret = bpf_perf_event_output(ctx, &pkts, 
-                        BPF_F_CURRENT_CPU | ((__u64)pkt.pkt_len << 32), 
-                        &pkt, sizeof(pkt));
-    return XDP_PASS;
-}
\ No newline at end of file
+    return send_packet(ctx);
+}